@@ -0,0 +1,138 @@
+//Copyright 2017-2018 Baidu Inc.
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http: //www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+
+package chatrobot
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"github.com/astaxie/beego/httplib"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the message formats of the incoming webhooks of chat robots
+const (
+	Slack    = "slack"
+	Teams    = "teams"
+	Feishu   = "feishu"
+	Wecom    = "wecom"
+	DingTalk = "dingtalk"
+)
+
+type response struct {
+	// dingtalk and wecom
+	ErrCode int64  `json:"errcode"`
+	ErrMsg  string `json:"errmsg"`
+	// feishu, the old version of api responds with StatusCode
+	Code       int64  `json:"code"`
+	Msg        string `json:"msg"`
+	StatusCode int64  `json:"StatusCode"`
+}
+
+// IsSupported returns whether the message format of robot type is supported
+func IsSupported(robotType string) bool {
+	switch robotType {
+	case Slack, Teams, Feishu, Wecom, DingTalk:
+		return true
+	}
+	return false
+}
+
+// buildRequest returns the url and body to post for the message format of robot,
+// the message is signed with the secret of feishu and dingtalk robots if it is not empty
+func buildRequest(robotType string, robotUrl string, secret string, title string, text string,
+	now time.Time) (string, interface{}) {
+	content := title + "\n" + text
+	switch robotType {
+	case Slack:
+		return robotUrl, map[string]interface{}{"text": "*" + title + "*\n" + text}
+	case Teams:
+		return robotUrl, map[string]interface{}{
+			"@type":      "MessageCard",
+			"@context":   "https://schema.org/extensions",
+			"summary":    title,
+			"title":      title,
+			"themeColor": "D9534F",
+			// the markdown of teams needs an empty line to break lines
+			"text": strings.Replace(text, "\n", "\n\n", -1),
+		}
+	case Feishu:
+		body := map[string]interface{}{
+			"msg_type": "text",
+			"content":  map[string]string{"text": content},
+		}
+		if secret != "" {
+			timestamp := strconv.FormatInt(now.Unix(), 10)
+			mac := hmac.New(sha256.New, []byte(timestamp+"\n"+secret))
+			body["timestamp"] = timestamp
+			body["sign"] = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+		}
+		return robotUrl, body
+	case DingTalk:
+		if secret != "" {
+			timestamp := strconv.FormatInt(now.UnixNano()/1000000, 10)
+			mac := hmac.New(sha256.New, []byte(secret))
+			mac.Write([]byte(timestamp + "\n" + secret))
+			sign := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+			separator := "?"
+			if strings.Contains(robotUrl, "?") {
+				separator = "&"
+			}
+			robotUrl += separator + "timestamp=" + timestamp + "&sign=" + url.QueryEscape(sign)
+		}
+		return robotUrl, map[string]interface{}{
+			"msgtype": "text",
+			"text":    map[string]string{"content": content},
+		}
+	default:
+		// wecom
+		return robotUrl, map[string]interface{}{
+			"msgtype": "text",
+			"text":    map[string]string{"content": content},
+		}
+	}
+}
+
+// SendText posts the message to the robot, it returns the status code of response,
+// the error describes the failure like "status code: 500" to be appended to the log of caller
+func SendText(robotType string, robotUrl string, secret string, title string, text string) (int, error) {
+	robotUrl, body := buildRequest(robotType, robotUrl, secret, title, text, time.Now())
+	request := httplib.Post(robotUrl)
+	request.JSONBody(body)
+	request.SetTimeout(10*time.Second, 10*time.Second)
+	httpResponse, err := request.Response()
+	if err != nil {
+		return 0, errors.New("error: " + err.Error())
+	}
+	if httpResponse.StatusCode > 299 || httpResponse.StatusCode < 200 {
+		return httpResponse.StatusCode, errors.New("status code: " + strconv.Itoa(httpResponse.StatusCode))
+	}
+	switch robotType {
+	case Feishu, Wecom, DingTalk:
+		// these robots respond with 200 and the error code in json
+		var result response
+		if err = request.ToJSON(&result); err != nil {
+			return httpResponse.StatusCode, errors.New("error: " + err.Error())
+		}
+		if result.ErrCode != 0 || result.Code != 0 || result.StatusCode != 0 {
+			return httpResponse.StatusCode, errors.New("error message: " + result.ErrMsg + result.Msg)
+		}
+	}
+	return httpResponse.StatusCode, nil
+}
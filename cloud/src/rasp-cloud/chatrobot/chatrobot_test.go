@@ -0,0 +1,200 @@
+//Copyright 2017-2018 Baidu Inc.
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http: //www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+
+package chatrobot
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testTitle = "OpenRASP alarm"
+	testText  = "attack type: sql\nurl: http://127.0.0.1/login"
+)
+
+type robotRequest struct {
+	query url.Values
+	body  map[string]interface{}
+}
+
+// newRobotServer records the requests of robot and responds with the status code and body
+func newRobotServer(t *testing.T, statusCode int, response string) (*httptest.Server, *[]*robotRequest) {
+	requests := make([]*robotRequest, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+			t.Errorf("unexpected request: %s, %s", r.Method, r.Header.Get("Content-Type"))
+		}
+		request := &robotRequest{query: r.URL.Query()}
+		if err := json.Unmarshal(content, &request.body); err != nil {
+			t.Errorf("invalid json body: %s", content)
+		}
+		requests = append(requests, request)
+		w.WriteHeader(statusCode)
+		w.Write([]byte(response))
+	}))
+	return server, &requests
+}
+
+func sendTestText(t *testing.T, robotType string, secret string, query string) *robotRequest {
+	server, requests := newRobotServer(t, http.StatusOK, `{"errcode":0,"errmsg":"ok","code":0}`)
+	defer server.Close()
+	statusCode, err := SendText(robotType, server.URL+"/webhook"+query, secret, testTitle, testText)
+	if err != nil || statusCode != http.StatusOK {
+		t.Fatalf("failed to send %s text: %d, %v", robotType, statusCode, err)
+	}
+	if len(*requests) != 1 {
+		t.Fatalf("expect 1 request of %s robot, got %d", robotType, len(*requests))
+	}
+	return (*requests)[0]
+}
+
+func hmacSha256(key string, message string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(message))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestSlackText(t *testing.T) {
+	request := sendTestText(t, Slack, "", "")
+	if request.body["text"] != "*"+testTitle+"*\n"+testText || len(request.body) != 1 {
+		t.Errorf("unexpected slack body: %v", request.body)
+	}
+}
+
+func TestTeamsText(t *testing.T) {
+	request := sendTestText(t, Teams, "", "")
+	if request.body["@type"] != "MessageCard" || request.body["title"] != testTitle ||
+		request.body["summary"] != testTitle {
+		t.Errorf("unexpected teams body: %v", request.body)
+	}
+	if request.body["text"] != "attack type: sql\n\nurl: http://127.0.0.1/login" {
+		t.Errorf("the lines of teams text must be separated by empty lines: %q", request.body["text"])
+	}
+}
+
+func TestWecomText(t *testing.T) {
+	request := sendTestText(t, Wecom, "", "?key=test")
+	text, _ := request.body["text"].(map[string]interface{})
+	if request.body["msgtype"] != "text" || text["content"] != testTitle+"\n"+testText {
+		t.Errorf("unexpected wecom body: %v", request.body)
+	}
+	if request.query.Get("key") != "test" {
+		t.Errorf("the query of wecom url must be kept: %v", request.query)
+	}
+}
+
+func TestFeishuText(t *testing.T) {
+	request := sendTestText(t, Feishu, "", "")
+	content, _ := request.body["content"].(map[string]interface{})
+	if request.body["msg_type"] != "text" || content["text"] != testTitle+"\n"+testText {
+		t.Errorf("unexpected feishu body: %v", request.body)
+	}
+	if _, ok := request.body["sign"]; ok {
+		t.Errorf("the feishu text must not be signed without secret: %v", request.body)
+	}
+
+	// the sign of feishu is the hmac of an empty message keyed by timestamp and secret
+	request = sendTestText(t, Feishu, "feishu-secret", "")
+	timestamp, _ := request.body["timestamp"].(string)
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || time.Now().Unix()-seconds > 60 {
+		t.Fatalf("invalid feishu timestamp: %v", request.body["timestamp"])
+	}
+	if sign := hmacSha256(timestamp+"\nfeishu-secret", ""); request.body["sign"] != sign {
+		t.Errorf("unexpected feishu sign: %v, expected %s", request.body["sign"], sign)
+	}
+}
+
+func TestDingTalkText(t *testing.T) {
+	request := sendTestText(t, DingTalk, "", "?access_token=test")
+	text, _ := request.body["text"].(map[string]interface{})
+	if request.body["msgtype"] != "text" || text["content"] != testTitle+"\n"+testText {
+		t.Errorf("unexpected dingtalk body: %v", request.body)
+	}
+	if request.query.Get("sign") != "" {
+		t.Errorf("the dingtalk url must not be signed without secret: %v", request.query)
+	}
+
+	// the sign of dingtalk is the hmac of timestamp and secret keyed by secret, it is appended to the url
+	request = sendTestText(t, DingTalk, "SEC-dingtalk", "?access_token=test")
+	timestamp := request.query.Get("timestamp")
+	milliseconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || time.Now().UnixNano()/1000000-milliseconds > 60000 {
+		t.Fatalf("invalid dingtalk timestamp: %s", timestamp)
+	}
+	if request.query.Get("access_token") != "test" {
+		t.Errorf("the access token of dingtalk url must be kept: %v", request.query)
+	}
+	if sign := hmacSha256("SEC-dingtalk", timestamp+"\nSEC-dingtalk"); request.query.Get("sign") != sign {
+		t.Errorf("unexpected dingtalk sign: %s, expected %s", request.query.Get("sign"), sign)
+	}
+}
+
+func TestSignatureWithFixedTime(t *testing.T) {
+	now := time.Unix(1599360473, 0)
+	_, body := buildRequest(Feishu, "https://open.feishu.cn/webhook", "demo", testTitle, testText, now)
+	if sign := body.(map[string]interface{})["sign"]; sign != hmacSha256("1599360473\ndemo", "") {
+		t.Errorf("unexpected feishu sign: %v", sign)
+	}
+	robotUrl, _ := buildRequest(DingTalk, "https://oapi.dingtalk.com/robot/send", "demo", testTitle, testText, now)
+	expected := "https://oapi.dingtalk.com/robot/send?timestamp=1599360473000&sign=" +
+		url.QueryEscape(hmacSha256("demo", "1599360473000\ndemo"))
+	if robotUrl != expected {
+		t.Errorf("unexpected dingtalk url: %s, expected %s", robotUrl, expected)
+	}
+}
+
+func TestSendTextErrors(t *testing.T) {
+	cases := []struct {
+		robotType  string
+		statusCode int
+		response   string
+	}{
+		{Slack, http.StatusNotFound, "no_team"},
+		{DingTalk, http.StatusOK, `{"errcode":310000,"errmsg":"sign not match"}`},
+		{Wecom, http.StatusOK, `{"errcode":93000,"errmsg":"invalid webhook url"}`},
+		{Feishu, http.StatusOK, `{"code":19021,"msg":"sign match fail or timestamp is not within one hour"}`},
+		{Feishu, http.StatusOK, `{"StatusCode":1,"StatusMessage":"invalid"}`},
+		{Feishu, http.StatusOK, `not json`},
+	}
+	for _, c := range cases {
+		server, _ := newRobotServer(t, c.statusCode, c.response)
+		statusCode, err := SendText(c.robotType, server.URL, "", testTitle, testText)
+		server.Close()
+		if err == nil || statusCode != c.statusCode {
+			t.Errorf("expect the error of %s response %s, got %d, %v", c.robotType, c.response, statusCode, err)
+		}
+	}
+	// slack and teams respond with plain text
+	server, _ := newRobotServer(t, http.StatusOK, "ok")
+	defer server.Close()
+	if _, err := SendText(Teams, server.URL, "", testTitle, testText); err != nil {
+		t.Errorf("failed to send teams text: %v", err)
+	}
+}
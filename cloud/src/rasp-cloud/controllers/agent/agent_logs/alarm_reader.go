@@ -0,0 +1,51 @@
+//Copyright 2017-2018 Baidu Inc.
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http: //www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+
+package agent_logs
+
+import (
+	"github.com/astaxie/beego"
+	"net/http"
+	"rasp-cloud/controllers"
+	"rasp-cloud/filter"
+	"rasp-cloud/models/logs"
+	"rasp-cloud/models/logs/ingest"
+	"strconv"
+)
+
+// readAlarms reads the alarms from the request body with the app id of agent,
+// see ingest.ReadAlarms for the supported formats, the error before any alarm is accepted is responded
+// with the 4xx status, the error after that is responded with status 200 and the truncated result
+func readAlarms(o *controllers.BaseController, alarmType string,
+	add func(map[string]interface{}) error) *ingest.Result {
+	contentEncoding := o.Ctx.Input.Header("Content-Encoding")
+	appId := o.Ctx.Input.Header("X-OpenRASP-AppID")
+	result, err := logs.ReadAlarms(filter.GetStreamBody(o.Ctx), contentEncoding, alarmType, appId, add)
+	if result.Truncated {
+		beego.Warn("the " + alarmType + " request of app " + appId + " is truncated after " +
+			strconv.Itoa(result.Accepted+result.Rejected) + " alarms: " + result.Error)
+	}
+	if err != nil {
+		processed := ", accepted: " + strconv.Itoa(result.Accepted) + ", rejected: " + strconv.Itoa(result.Rejected)
+		switch err {
+		case ingest.ErrUnsupportedEncoding:
+			o.ServeError(http.StatusUnsupportedMediaType, "Unsupported Content-Encoding: "+contentEncoding)
+		case ingest.ErrBodyTooLarge:
+			o.ServeError(http.StatusRequestEntityTooLarge, "the request body exceeds the max size of "+
+				strconv.FormatInt(logs.AlarmMaxBodySize, 10)+" bytes"+processed)
+		}
+		o.ServeError(http.StatusBadRequest, "Invalid JSON request"+processed, err)
+	}
+	return result
+}
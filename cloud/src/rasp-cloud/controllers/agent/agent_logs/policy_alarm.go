@@ -15,11 +15,8 @@
 package agent_logs
 
 import (
-	"encoding/json"
-	"net/http"
 	"rasp-cloud/controllers"
 	"rasp-cloud/models/logs"
-	"time"
 )
 
 // Operations about policy alarm message
@@ -29,17 +26,5 @@ type PolicyAlarmController struct {
 
 // @router / [post]
 func (o *PolicyAlarmController) Post() {
-	var alarms []map[string]interface{}
-	if err := json.Unmarshal(o.Ctx.Input.RequestBody, &alarms); err != nil {
-		o.ServeError(http.StatusBadRequest, "Invalid JSON request", err)
-	}
-	count := 0
-	for _, alarm := range alarms {
-		alarm["@timestamp"] = time.Now().UnixNano() / 1000000
-		err := logs.AddPolicyAlarm(alarm)
-		if err == nil {
-			count++
-		}
-	}
-	o.Serve(map[string]uint64{"count": uint64(count)})
+	o.Serve(readAlarms(&o.BaseController, logs.PolicyAlarmType, logs.AddPolicyAlarm))
 }
@@ -70,29 +70,21 @@ func (o *HeartbeatController) Post() {
 	if err != nil && err != mgo.ErrNotFound {
 		o.ServeError(http.StatusBadRequest, "failed to get selected plugin", err)
 	}
+	// the expired whitelist entries increase the config time to make the agents reload the config
+	whitelistConfig, appConfigTime := models.GetWhitelistHeartbeatConfig(app)
 	if selectedPlugin != nil {
 		if pluginMd5 != selectedPlugin.Md5 {
 			isUpdate = true
 		}
-		if app.ConfigTime > 0 && app.ConfigTime > int64(configTime) {
+		if appConfigTime > 0 && appConfigTime > int64(configTime) {
 			isUpdate = true
 		}
 	}
 	if isUpdate {
-		whitelistConfig := make(map[string]interface{})
-		for _, configItem := range app.WhitelistConfig {
-			whiteHookTypes := make([]string, 0, len(configItem.Hook))
-			for hookType, isWhite := range configItem.Hook {
-				if isWhite {
-					whiteHookTypes = append(whiteHookTypes, hookType)
-				}
-			}
-			whitelistConfig[configItem.Url] = whiteHookTypes
-		}
 		//app.GeneralConfig["algorithm.config"] = selectedPlugin.AlgorithmConfig
 		app.GeneralConfig["hook.white"] = whitelistConfig
 		result["plugin"] = selectedPlugin
-		result["config_time"] = app.ConfigTime
+		result["config_time"] = appConfigTime
 		result["config"] = app.GeneralConfig
 	}
 	o.Serve(result)
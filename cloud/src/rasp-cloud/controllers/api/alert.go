@@ -0,0 +1,153 @@
+//Copyright 2017-2018 Baidu Inc.
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http: //www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"rasp-cloud/controllers"
+	"rasp-cloud/models"
+)
+
+// Operations about alert conditions
+type AlertController struct {
+	controllers.BaseController
+}
+
+// @router /condition/get [post]
+func (o *AlertController) GetConditions() {
+	var param pageParam
+	err := json.Unmarshal(o.Ctx.Input.RequestBody, &param)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "Invalid JSON request", err)
+	}
+	if param.AppId == "" {
+		o.ServeError(http.StatusBadRequest, "app_id can not be empty")
+	}
+	if param.Page <= 0 {
+		o.ServeError(http.StatusBadRequest, "page must be greater than 0")
+	}
+	if param.Perpage <= 0 {
+		o.ServeError(http.StatusBadRequest, "perpage must be greater than 0")
+	}
+	total, conditions, err := models.GetAlertConditions(param.AppId, param.Page, param.Perpage)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "failed to get alert conditions", err)
+	}
+	var result = make(map[string]interface{})
+	result["total"] = total
+	result["total_page"] = math.Ceil(float64(total) / float64(param.Perpage))
+	result["page"] = param.Page
+	result["perpage"] = param.Perpage
+	result["data"] = conditions
+	o.Serve(result)
+}
+
+// @router /condition [post]
+func (o *AlertController) PostCondition() {
+	var condition *models.AlertCondition
+	err := json.Unmarshal(o.Ctx.Input.RequestBody, &condition)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "Invalid JSON request", err)
+	}
+	if condition == nil {
+		o.ServeError(http.StatusBadRequest, "the alert condition can not be empty")
+	}
+	if err := models.ValidateAlertCondition(condition); err != nil {
+		o.ServeError(http.StatusBadRequest, err.Error())
+	}
+	if condition.Id == "" {
+		if condition.AppId == "" {
+			o.ServeError(http.StatusBadRequest, "app_id can not be empty")
+		}
+		if _, err := models.GetAppById(condition.AppId); err != nil {
+			o.ServeError(http.StatusBadRequest, "failed to get app", err)
+		}
+		condition, err = models.AddAlertCondition(condition)
+		if err != nil {
+			o.ServeError(http.StatusBadRequest, "failed to add alert condition", err)
+		}
+		models.AddOperation(condition.AppId, models.OperationTypeAddAlertCondition, o.Ctx.Input.IP(),
+			"Added alert condition "+condition.Name)
+	} else {
+		condition, err = models.UpdateAlertCondition(condition)
+		if err != nil {
+			o.ServeError(http.StatusBadRequest, "failed to update alert condition", err)
+		}
+		models.AddOperation(condition.AppId, models.OperationTypeEditAlertCondition, o.Ctx.Input.IP(),
+			"Updated alert condition "+condition.Name)
+	}
+	o.Serve(condition)
+}
+
+// @router /condition/delete [post]
+func (o *AlertController) DeleteCondition() {
+	var param struct {
+		Id string `json:"id"`
+	}
+	err := json.Unmarshal(o.Ctx.Input.RequestBody, &param)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "Invalid JSON request", err)
+	}
+	if param.Id == "" {
+		o.ServeError(http.StatusBadRequest, "the id can not be empty")
+	}
+	condition, err := models.RemoveAlertCondition(param.Id)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "failed to remove alert condition", err)
+	}
+	models.AddOperation(condition.AppId, models.OperationTypeDeleteAlertCondition, o.Ctx.Input.IP(),
+		"Deleted alert condition "+condition.Name)
+	o.ServeWithEmptyData()
+}
+
+// @router /state/get [post]
+func (o *AlertController) GetStates() {
+	var param struct {
+		AppId   string `json:"app_id"`
+		Status  string `json:"status"`
+		Page    int    `json:"page"`
+		Perpage int    `json:"perpage"`
+	}
+	err := json.Unmarshal(o.Ctx.Input.RequestBody, &param)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "Invalid JSON request", err)
+	}
+	if param.AppId == "" {
+		o.ServeError(http.StatusBadRequest, "app_id can not be empty")
+	}
+	if param.Status != "" && param.Status != models.AlertStatusFiring &&
+		param.Status != models.AlertStatusResolved && param.Status != models.AlertStatusFired {
+		o.ServeError(http.StatusBadRequest, "the status must be firing, resolved or fired")
+	}
+	if param.Page <= 0 {
+		o.ServeError(http.StatusBadRequest, "page must be greater than 0")
+	}
+	if param.Perpage <= 0 {
+		o.ServeError(http.StatusBadRequest, "perpage must be greater than 0")
+	}
+	total, states, err := models.GetAlertStates(param.AppId, param.Status, param.Page, param.Perpage)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "failed to get alert states", err)
+	}
+	var result = make(map[string]interface{})
+	result["total"] = total
+	result["total_page"] = math.Ceil(float64(total) / float64(param.Perpage))
+	result["page"] = param.Page
+	result["perpage"] = param.Perpage
+	result["data"] = states
+	o.Serve(result)
+}
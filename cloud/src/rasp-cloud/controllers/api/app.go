@@ -23,7 +23,9 @@ import (
 	"net/http"
 	"rasp-cloud/controllers"
 	"rasp-cloud/models"
+	"rasp-cloud/models/logs/redaction"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -162,8 +164,9 @@ func (o *AppController) RegenerateAppSecret() {
 // @router /general/config [post]
 func (o *AppController) UpdateAppGeneralConfig() {
 	var param struct {
-		AppId  string                 `json:"app_id"`
-		Config map[string]interface{} `json:"config"`
+		AppId   string                 `json:"app_id"`
+		Config  map[string]interface{} `json:"config"`
+		Comment string                 `json:"comment"`
 	}
 	err := json.Unmarshal(o.Ctx.Input.RequestBody, &param)
 	if err != nil {
@@ -175,21 +178,128 @@ func (o *AppController) UpdateAppGeneralConfig() {
 	if param.Config == nil {
 		o.ServeError(http.StatusBadRequest, "config can not be empty")
 	}
-	o.validateAppConfig(param.Config)
-	app, err := models.UpdateGeneralConfig(param.AppId, param.Config)
+	validateConfigComment(&o.BaseController, param.Comment)
+	app, err := models.GetAppById(param.AppId)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "failed to get app", err)
+	}
+	warnings := o.validateAppConfig(param.Config, app.Language)
+	app, err = models.UpdateGeneralConfig(param.AppId, param.Config)
 	if err != nil {
 		o.ServeError(http.StatusBadRequest, "failed to update app general config", err)
 	}
-	models.AddOperation(param.AppId, models.OperationTypeUpdateGenerateConfig,
-		o.Ctx.Input.IP(), "Updated general config of "+param.AppId)
+	addConfigVersion(&o.BaseController, param.AppId, models.ConfigTypeGeneral, "", param.Config,
+		param.Comment)
+	content := "Updated general config of " + param.AppId
+	if len(warnings) > 0 {
+		content += ", warnings: " + strings.Join(warnings, "; ")
+	}
+	models.AddOperation(param.AppId, models.OperationTypeUpdateGenerateConfig, o.Ctx.Input.IP(), content)
 	o.Serve(app)
 }
 
+// @router /general/config/schema [post]
+func (o *AppController) GetGeneralConfigSchema() {
+	o.Serve(models.GetGeneralConfigSchemas())
+}
+
+// @router /general/config/validate [post]
+func (o *AppController) ValidateGeneralConfig() {
+	var param struct {
+		AppId    string                 `json:"app_id"`
+		Language string                 `json:"language"`
+		Config   map[string]interface{} `json:"config"`
+	}
+	err := json.Unmarshal(o.Ctx.Input.RequestBody, &param)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "Invalid JSON request", err)
+	}
+	if param.Config == nil {
+		o.ServeError(http.StatusBadRequest, "config can not be empty")
+	}
+	if param.AppId != "" {
+		app, err := models.GetAppById(param.AppId)
+		if err != nil {
+			o.ServeError(http.StatusBadRequest, "failed to get app", err)
+		}
+		param.Language = app.Language
+	}
+	result := map[string]interface{}{
+		"valid":    true,
+		"error":    "",
+		"warnings": []string{},
+	}
+	warnings, err := models.ValidateGeneralConfig(param.Config, param.Language)
+	if err != nil {
+		result["valid"] = false
+		result["error"] = err.Error()
+	} else {
+		result["warnings"] = warnings
+	}
+	o.Serve(result)
+}
+
+// @router /config/preview [post]
+func (o *AppController) PreviewConfig() {
+	var param struct {
+		AppId  string          `json:"app_id"`
+		Type   string          `json:"type"`
+		Days   int             `json:"days"`
+		Config json.RawMessage `json:"config"`
+	}
+	err := json.Unmarshal(o.Ctx.Input.RequestBody, &param)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "Invalid JSON request", err)
+	}
+	if param.AppId == "" {
+		o.ServeError(http.StatusBadRequest, "app_id can not be empty")
+	}
+	if param.Days == 0 {
+		param.Days = 7
+	}
+	if param.Days < 0 || param.Days > 30 {
+		o.ServeError(http.StatusBadRequest, "days must be between [1,30]")
+	}
+	if len(param.Config) == 0 {
+		o.ServeError(http.StatusBadRequest, "config can not be empty")
+	}
+	app, err := models.GetAppById(param.AppId)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "failed to get app", err)
+	}
+	var preview *models.ConfigPreview
+	switch param.Type {
+	case models.ConfigTypeWhitelist:
+		var config []models.WhitelistConfigItem
+		if err := json.Unmarshal(param.Config, &config); err != nil || config == nil {
+			o.ServeError(http.StatusBadRequest, "the whitelist config must be an array", err)
+		}
+		o.validateWhiteListConfig(config)
+		preview, err = models.PreviewWhitelistConfig(app, config, param.Days)
+	case models.ConfigTypeAlgorithm:
+		var config map[string]interface{}
+		if err := json.Unmarshal(param.Config, &config); err != nil || config == nil {
+			o.ServeError(http.StatusBadRequest, "the algorithm config must be an object", err)
+		}
+		if err := models.ValidateSelectedAlgorithmConfig(param.AppId, config); err != nil {
+			o.ServeError(http.StatusBadRequest, "invalid algorithm config", err)
+		}
+		preview, err = models.PreviewAlgorithmConfig(app, config, param.Days)
+	default:
+		o.ServeError(http.StatusBadRequest, "the type must be whitelist or algorithm")
+	}
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "failed to preview config", err)
+	}
+	o.Serve(preview)
+}
+
 // @router /whitelist/config [post]
 func (o *AppController) UpdateAppWhiteListConfig() {
 	var param struct {
-		AppId  string                       `json:"app_id"`
-		Config []models.WhitelistConfigItem `json:"config"`
+		AppId   string                       `json:"app_id"`
+		Config  []models.WhitelistConfigItem `json:"config"`
+		Comment string                       `json:"comment"`
 	}
 	err := json.Unmarshal(o.Ctx.Input.RequestBody, &param)
 	if err != nil {
@@ -201,16 +311,182 @@ func (o *AppController) UpdateAppWhiteListConfig() {
 	if param.Config == nil {
 		o.ServeError(http.StatusBadRequest, "config can not be empty")
 	}
+	validateConfigComment(&o.BaseController, param.Comment)
 	o.validateWhiteListConfig(param.Config)
 	app, err := models.UpdateWhiteListConfig(param.AppId, param.Config)
 	if err != nil {
 		o.ServeError(http.StatusBadRequest, "failed to update app whitelist config", err)
 	}
+	addConfigVersion(&o.BaseController, param.AppId, models.ConfigTypeWhitelist, "", param.Config,
+		param.Comment)
 	models.AddOperation(param.AppId, models.OperationTypeUpdateWhitelistConfig,
 		o.Ctx.Input.IP(), "Updated whitelist config of "+param.AppId)
 	o.Serve(app)
 }
 
+// @router /whitelist/propose [post]
+func (o *AppController) ProposeWhitelist() {
+	var param struct {
+		AppId   string `json:"app_id"`
+		AlarmId string `json:"alarm_id"`
+	}
+	err := json.Unmarshal(o.Ctx.Input.RequestBody, &param)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "Invalid JSON request", err)
+	}
+	if param.AppId == "" {
+		o.ServeError(http.StatusBadRequest, "app_id can not be empty")
+	}
+	if param.AlarmId == "" {
+		o.ServeError(http.StatusBadRequest, "alarm_id can not be empty")
+	}
+	app, err := models.GetAppById(param.AppId)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "failed to get app", err)
+	}
+	proposal, err := models.ProposeWhitelist(app, param.AlarmId)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "failed to propose whitelist", err)
+	}
+	o.Serve(proposal)
+}
+
+// @router /whitelist/apply [post]
+func (o *AppController) ApplyWhitelist() {
+	var param struct {
+		AppId   string                      `json:"app_id"`
+		AlarmId string                      `json:"alarm_id"`
+		Item    *models.WhitelistConfigItem `json:"item"`
+		Note    string                      `json:"note"`
+	}
+	err := json.Unmarshal(o.Ctx.Input.RequestBody, &param)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "Invalid JSON request", err)
+	}
+	if param.AppId == "" {
+		o.ServeError(http.StatusBadRequest, "app_id can not be empty")
+	}
+	if param.AlarmId == "" {
+		o.ServeError(http.StatusBadRequest, "alarm_id can not be empty")
+	}
+	if len(param.Note) > 1024 {
+		o.ServeError(http.StatusBadRequest, "the length of note can not be greater than 1024")
+	}
+	app, err := models.GetAppById(param.AppId)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "failed to get app", err)
+	}
+	// the proposed entry is applied if the item is not edited
+	if param.Item == nil {
+		proposal, err := models.ProposeWhitelist(app, param.AlarmId)
+		if err != nil {
+			o.ServeError(http.StatusBadRequest, "failed to propose whitelist", err)
+		}
+		param.Item = &proposal.Item
+	}
+	config := []models.WhitelistConfigItem{*param.Item}
+	o.validateWhiteListConfig(config)
+	param.Item = &config[0]
+	app, err = models.ApplyWhitelist(app, param.AlarmId, *param.Item, param.Note)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "failed to apply whitelist", err)
+	}
+	addConfigVersion(&o.BaseController, param.AppId, models.ConfigTypeWhitelist, "", app.WhitelistConfig,
+		"Added whitelist entry "+param.Item.Url+" from attack alarm "+param.AlarmId)
+	content := "Added whitelist entry " + param.Item.Url + " from attack alarm " + param.AlarmId
+	if param.Note != "" {
+		content += ", note: " + param.Note
+	}
+	models.AddOperation(param.AppId, models.OperationTypeUpdateWhitelistConfig, o.Ctx.Input.IP(), content)
+	o.Serve(app)
+}
+
+// @router /redaction/config [post]
+func (o *AppController) UpdateAppRedactionConfig() {
+	var param struct {
+		AppId  string               `json:"app_id"`
+		Config []redaction.Rule `json:"config"`
+	}
+	err := json.Unmarshal(o.Ctx.Input.RequestBody, &param)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "Invalid JSON request", err)
+	}
+	if param.AppId == "" {
+		o.ServeError(http.StatusBadRequest, "app_id can not be empty")
+	}
+	if param.Config == nil {
+		o.ServeError(http.StatusBadRequest, "config can not be empty")
+	}
+	if err := redaction.Validate(param.Config); err != nil {
+		o.ServeError(http.StatusBadRequest, err.Error())
+	}
+	app, err := models.UpdateRedactionConfig(param.AppId, param.Config)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "failed to update app redaction config", err)
+	}
+	models.AddOperation(param.AppId, models.OperationTypeUpdateRedactionConfig,
+		o.Ctx.Input.IP(), "Updated redaction config of "+param.AppId)
+	o.Serve(app)
+}
+
+// @router /notification/config [post]
+func (o *AppController) UpdateAppNotificationRules() {
+	var param struct {
+		AppId  string                    `json:"app_id"`
+		Config []models.NotificationRule `json:"config"`
+	}
+	err := json.Unmarshal(o.Ctx.Input.RequestBody, &param)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "Invalid JSON request", err)
+	}
+	if param.AppId == "" {
+		o.ServeError(http.StatusBadRequest, "app_id can not be empty")
+	}
+	if param.Config == nil {
+		o.ServeError(http.StatusBadRequest, "config can not be empty")
+	}
+	if err := models.ValidateNotificationRules(param.Config); err != nil {
+		o.ServeError(http.StatusBadRequest, err.Error())
+	}
+	app, err := models.UpdateNotificationRules(param.AppId, param.Config)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "failed to update app notification rules", err)
+	}
+	models.AddOperation(param.AppId, models.OperationTypeUpdateNotificationRules,
+		o.Ctx.Input.IP(), "Updated notification rules of "+param.AppId)
+	o.Serve(app)
+}
+
+// @router /digest/config [post]
+func (o *AppController) UpdateAppDigestConfig() {
+	var param struct {
+		AppId  string               `json:"app_id"`
+		Config *models.DigestConfig `json:"config"`
+	}
+	err := json.Unmarshal(o.Ctx.Input.RequestBody, &param)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "Invalid JSON request", err)
+	}
+	if param.AppId == "" {
+		o.ServeError(http.StatusBadRequest, "app_id can not be empty")
+	}
+	if param.Config == nil {
+		o.ServeError(http.StatusBadRequest, "config can not be empty")
+	}
+	if err := models.ValidateDigestConfig(param.Config); err != nil {
+		o.ServeError(http.StatusBadRequest, err.Error())
+	}
+	var valid = validation.Validation{}
+	param.Config.Recipients = o.validAppArrayParam(param.Config.Recipients, "digest recipients", valid.Email)
+	app, err := models.UpdateDigestConfig(param.AppId, param.Config)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "failed to update app digest config", err)
+	}
+	models.AddOperation(param.AppId, models.OperationTypeUpdateDigestConfig,
+		o.Ctx.Input.IP(), "Updated digest config of "+param.AppId)
+	o.Serve(app)
+}
+
 // @router / [post]
 func (o *AppController) Post() {
 	var app = &models.App{}
@@ -256,8 +532,11 @@ func (o *AppController) Post() {
 	if app.DingAlarmConf.Enable {
 		o.validDingConf(&app.DingAlarmConf)
 	}
+	if app.ChatAlarmConf.Enable {
+		o.validChatConf(&app.ChatAlarmConf)
+	}
 	if app.GeneralConfig != nil {
-		o.validateAppConfig(app.GeneralConfig)
+		o.validateAppConfig(app.GeneralConfig, app.Language)
 		configTime := time.Now().UnixNano()
 		app.ConfigTime = configTime
 	}
@@ -269,10 +548,24 @@ func (o *AppController) Post() {
 	} else {
 		app.WhitelistConfig = make([]models.WhitelistConfigItem, 0)
 	}
+	if app.RedactionConfig != nil {
+		if err := redaction.Validate(app.RedactionConfig); err != nil {
+			o.ServeError(http.StatusBadRequest, err.Error())
+		}
+	}
+	if app.NotificationRules != nil {
+		if err := models.ValidateNotificationRules(app.NotificationRules); err != nil {
+			o.ServeError(http.StatusBadRequest, err.Error())
+		}
+	}
 	app, err = models.AddApp(app)
 	if err != nil {
 		o.ServeError(http.StatusBadRequest, "create app failed", err)
 	}
+	addConfigVersion(&o.BaseController, app.Id, models.ConfigTypeGeneral, "", app.GeneralConfig,
+		"Initial config")
+	addConfigVersion(&o.BaseController, app.Id, models.ConfigTypeWhitelist, "", app.WhitelistConfig,
+		"Initial config")
 	models.AddOperation(app.Id, models.OperationTypeAddApp, o.Ctx.Input.IP(), "New app created with name "+app.Name)
 	o.Serve(app)
 }
@@ -390,8 +683,11 @@ func (o *AppController) validDingConf(conf *models.DingAlarmConf) {
 }
 
 func (o *AppController) validHttpAlarm(conf *models.HttpAlarmConf) {
-	if len(conf.RecvAddr) == 0 {
-		o.ServeError(http.StatusBadRequest, "the http recv_addr cannot be empty")
+	if len(conf.RecvAddr) == 0 && len(conf.Receivers) == 0 {
+		o.ServeError(http.StatusBadRequest, "the http recv_addr and receivers cannot be empty at the same time")
+	}
+	if err := models.ValidateHttpReceivers(conf.Receivers); err != nil {
+		o.ServeError(http.StatusBadRequest, err.Error())
 	}
 	if len(conf.RecvAddr) > 128 {
 		o.ServeError(http.StatusBadRequest, "the count of http recv_addr cannot be greater than 128")
@@ -399,6 +695,12 @@ func (o *AppController) validHttpAlarm(conf *models.HttpAlarmConf) {
 	conf.RecvAddr = o.validAppArrayParam(conf.RecvAddr, "http recv_addr", nil)
 }
 
+func (o *AppController) validChatConf(conf *models.ChatAlarmConf) {
+	if err := models.ValidateChatAlarmConf(conf); err != nil {
+		o.ServeError(http.StatusBadRequest, err.Error())
+	}
+}
+
 // @router /delete [post]
 func (o *AppController) Delete() {
 	var app = &models.App{}
@@ -430,6 +732,38 @@ func (o *AppController) Delete() {
 	if err != nil {
 		o.ServeError(http.StatusBadRequest, "failed to remove plugin by app_id", err)
 	}
+	err = models.RemoveAlertConditionsByAppId(app.Id)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "failed to remove alert conditions by app_id", err)
+	}
+	err = models.RemoveSilencesByAppId(app.Id)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "failed to remove silences by app_id", err)
+	}
+	err = models.RemoveNotificationsByAppId(app.Id)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "failed to remove notifications by app_id", err)
+	}
+	err = models.RemoveAlarmWatermark(app.Id)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "failed to remove alarm watermark by app_id", err)
+	}
+	err = models.RemoveNotificationTemplatesByAppId(app.Id)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "failed to remove notification templates by app_id", err)
+	}
+	err = models.RemoveDigestsByAppId(app.Id)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "failed to remove digests by app_id", err)
+	}
+	err = models.RemoveAlarmCommentsByAppId(app.Id)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "failed to remove alarm comments by app_id", err)
+	}
+	err = models.RemoveConfigVersionsByAppId(app.Id)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "failed to remove config versions by app_id", err)
+	}
 	models.AddOperation(app.Id, models.OperationTypeDeleteApp, o.Ctx.Input.IP(), "Deleted app with name "+app.Name)
 	o.ServeWithEmptyData()
 }
@@ -459,21 +793,15 @@ func (o *AppController) validAppArrayParam(param []string, paramName string,
 	return param
 }
 
-func (o *AppController) validateAppConfig(config map[string]interface{}) {
+func (o *AppController) validateAppConfig(config map[string]interface{}, language string) []string {
 	if config == nil {
 		o.ServeError(http.StatusBadRequest, "the config cannot be nil")
 	}
-	for key, value := range config {
-		if value == nil {
-			o.ServeError(http.StatusBadRequest, "the value of "+key+" config cannot be nil")
-		}
-		if v, ok := value.(string); ok {
-			if len(v) >= 512 {
-				o.ServeError(http.StatusBadRequest,
-					"the length of config key "+key+" must less tha 1024")
-			}
-		}
+	warnings, err := models.ValidateGeneralConfig(config, language)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, err.Error())
 	}
+	return warnings
 }
 
 func (o *AppController) validateWhiteListConfig(config []models.WhitelistConfigItem) {
@@ -484,17 +812,8 @@ func (o *AppController) validateWhiteListConfig(config []models.WhitelistConfigI
 		o.ServeError(http.StatusBadRequest,
 			"the count of whitelist config items must be between (0,200]")
 	}
-	for _, value := range config {
-		if len(value.Url) > 200 || len(value.Url) == 0 {
-			o.ServeError(http.StatusBadRequest,
-				"the length of whitelist config url must be between [1,200]")
-		}
-		for key := range value.Hook {
-			if len(key) > 128 {
-				o.ServeError(http.StatusBadRequest,
-					"the length of hook's type can not be greater 128")
-			}
-		}
+	if err := models.NormalizeWhitelistConfig(config); err != nil {
+		o.ServeError(http.StatusBadRequest, err.Error())
 	}
 }
 
@@ -505,6 +824,7 @@ func (o *AppController) ConfigAlarm() {
 		EmailAlarmConf *models.EmailAlarmConf `json:"email_alarm_conf,omitempty"`
 		DingAlarmConf  *models.DingAlarmConf  `json:"ding_alarm_conf,omitempty"`
 		HttpAlarmConf  *models.HttpAlarmConf  `json:"http_alarm_conf,omitempty"`
+		ChatAlarmConf  *models.ChatAlarmConf  `json:"chat_alarm_conf,omitempty"`
 	}
 	err := json.Unmarshal(o.Ctx.Input.RequestBody, &param)
 	if err != nil {
@@ -525,6 +845,7 @@ func (o *AppController) ConfigAlarm() {
 		o.validEmailConf(param.EmailAlarmConf)
 	}
 	if param.HttpAlarmConf != nil {
+		models.RestoreHttpReceivers(param.HttpAlarmConf.Receivers, app.HttpAlarmConf.Receivers)
 		o.validHttpAlarm(param.HttpAlarmConf)
 	}
 	if param.DingAlarmConf != nil {
@@ -533,6 +854,19 @@ func (o *AppController) ConfigAlarm() {
 		}
 		o.validDingConf(param.DingAlarmConf)
 	}
+	if param.ChatAlarmConf != nil {
+		for i, robot := range param.ChatAlarmConf.Robots {
+			if robot.Secret == models.SecreteMask {
+				param.ChatAlarmConf.Robots[i].Secret = ""
+				for _, oldRobot := range app.ChatAlarmConf.Robots {
+					if oldRobot.Name == robot.Name {
+						param.ChatAlarmConf.Robots[i].Secret = oldRobot.Secret
+					}
+				}
+			}
+		}
+		o.validChatConf(param.ChatAlarmConf)
+	}
 	content, err := json.Marshal(param)
 	if err != nil {
 		o.ServeError(http.StatusBadRequest, "failed to encode param to json", err)
@@ -704,3 +1038,28 @@ func (o *AppController) TestHttp(config map[string]interface{}) {
 	}
 	o.ServeWithEmptyData()
 }
+
+// @router /chat/test [post]
+func (o *AppController) TestChat() {
+	var param map[string]string
+	err := json.Unmarshal(o.Ctx.Input.RequestBody, &param)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "Invalid JSON request", err)
+	}
+	appId := param["app_id"]
+	if appId == "" {
+		o.ServeError(http.StatusBadRequest, "app_id cannot be empty")
+	}
+	app, err := models.GetAppByIdWithoutMask(appId)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "can not find the app", err)
+	}
+	if !app.ChatAlarmConf.Enable {
+		o.ServeError(http.StatusBadRequest, "please enable the chat alarm first")
+	}
+	err = models.PushChatAttackAlarm(app, 0, nil, true)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "failed to test chat alarm", err)
+	}
+	o.ServeWithEmptyData()
+}
@@ -0,0 +1,172 @@
+//Copyright 2017-2018 Baidu Inc.
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http: //www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"rasp-cloud/controllers"
+	"rasp-cloud/models"
+	"strconv"
+)
+
+// Operations about config version history
+type ConfigVersionController struct {
+	controllers.BaseController
+}
+
+// @router /get [post]
+func (o *ConfigVersionController) Get() {
+	var param struct {
+		AppId   string `json:"app_id"`
+		Type    string `json:"type"`
+		Page    int    `json:"page"`
+		Perpage int    `json:"perpage"`
+	}
+	err := json.Unmarshal(o.Ctx.Input.RequestBody, &param)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "Invalid JSON request", err)
+	}
+	if param.AppId == "" {
+		o.ServeError(http.StatusBadRequest, "app_id can not be empty")
+	}
+	if param.Type != "" && !models.IsConfigType(param.Type) {
+		o.ServeError(http.StatusBadRequest, "the type must be general, whitelist or algorithm")
+	}
+	if param.Page <= 0 {
+		o.ServeError(http.StatusBadRequest, "page must be greater than 0")
+	}
+	if param.Perpage <= 0 {
+		o.ServeError(http.StatusBadRequest, "perpage must be greater than 0")
+	}
+	total, versions, err := models.GetConfigVersions(param.AppId, param.Type, param.Page, param.Perpage)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "failed to get config versions", err)
+	}
+	var result = make(map[string]interface{})
+	result["total"] = total
+	result["total_page"] = math.Ceil(float64(total) / float64(param.Perpage))
+	result["page"] = param.Page
+	result["perpage"] = param.Perpage
+	result["data"] = versions
+	o.Serve(result)
+}
+
+// @router /detail [post]
+func (o *ConfigVersionController) Detail() {
+	var param struct {
+		AppId   string `json:"app_id"`
+		Type    string `json:"type"`
+		Version int    `json:"version"`
+	}
+	err := json.Unmarshal(o.Ctx.Input.RequestBody, &param)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "Invalid JSON request", err)
+	}
+	o.Serve(o.getConfigVersion(param.AppId, param.Type, param.Version))
+}
+
+// @router /diff [post]
+func (o *ConfigVersionController) Diff() {
+	var param struct {
+		AppId       string `json:"app_id"`
+		Type        string `json:"type"`
+		FromVersion int    `json:"from_version"`
+		ToVersion   int    `json:"to_version"`
+	}
+	err := json.Unmarshal(o.Ctx.Input.RequestBody, &param)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "Invalid JSON request", err)
+	}
+	fromVersion := o.getConfigVersion(param.AppId, param.Type, param.FromVersion)
+	toVersion := o.getConfigVersion(param.AppId, param.Type, param.ToVersion)
+	diff, err := models.DiffConfigVersions(fromVersion, toVersion)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "failed to diff config versions", err)
+	}
+	o.Serve(map[string]interface{}{
+		"from_version": param.FromVersion,
+		"to_version":   param.ToVersion,
+		"data":         diff,
+	})
+}
+
+// @router /rollback [post]
+func (o *ConfigVersionController) Rollback() {
+	var param struct {
+		AppId   string `json:"app_id"`
+		Type    string `json:"type"`
+		Version int    `json:"version"`
+		Comment string `json:"comment"`
+	}
+	err := json.Unmarshal(o.Ctx.Input.RequestBody, &param)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "Invalid JSON request", err)
+	}
+	version := o.getConfigVersion(param.AppId, param.Type, param.Version)
+	if param.Comment == "" {
+		param.Comment = "Rolled back to version " + strconv.Itoa(version.Version)
+	}
+	validateConfigComment(&o.BaseController, param.Comment)
+	config, err := models.RollbackConfigVersion(version)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "failed to rollback config", err)
+	}
+	content := "Rolled back " + param.Type + " config of " + param.AppId + " to version " +
+		strconv.Itoa(version.Version)
+	newVersion, err := models.AddRollbackConfigVersion(version, config, param.Comment)
+	if err != nil {
+		o.AddWarning("the config is rolled back, but failed to store its version", err)
+	} else {
+		content += " as version " + strconv.Itoa(newVersion.Version)
+	}
+	models.AddOperation(param.AppId, models.OperationTypeRollbackConfig, o.Ctx.Input.IP(), content)
+	o.Serve(newVersion)
+}
+
+func (o *ConfigVersionController) getConfigVersion(appId string, configType string,
+	version int) *models.ConfigVersion {
+	if appId == "" {
+		o.ServeError(http.StatusBadRequest, "app_id can not be empty")
+	}
+	if !models.IsConfigType(configType) {
+		o.ServeError(http.StatusBadRequest, "the type must be general, whitelist or algorithm")
+	}
+	if version <= 0 {
+		o.ServeError(http.StatusBadRequest, "version must be greater than 0")
+	}
+	result, err := models.GetConfigVersion(appId, configType, version)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "failed to get config version "+strconv.Itoa(version), err)
+	}
+	return result
+}
+
+// addConfigVersion stores the snapshot of the config, the config has been published when it is called,
+// so that the failure is only a warning of the response
+func addConfigVersion(o *controllers.BaseController, appId string, configType string, pluginId string,
+	config interface{}, comment string) {
+	_, err := models.AddConfigVersion(appId, configType, pluginId, config, comment)
+	if err != nil {
+		o.AddWarning("the config is updated, but failed to store its version", err)
+	}
+}
+
+func validateConfigComment(o *controllers.BaseController, comment string) {
+	if err := models.ValidateConfigVersionComment(comment); err != nil {
+		o.ServeError(http.StatusBadRequest, err.Error())
+	}
+}
@@ -0,0 +1,138 @@
+//Copyright 2017-2018 Baidu Inc.
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http: //www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"rasp-cloud/controllers"
+	"rasp-cloud/models"
+	"time"
+)
+
+// Operations about digest reports
+type DigestController struct {
+	controllers.BaseController
+}
+
+// @router /get [post]
+func (o *DigestController) Get() {
+	var param struct {
+		AppId   string `json:"app_id"`
+		Page    int    `json:"page"`
+		Perpage int    `json:"perpage"`
+	}
+	err := json.Unmarshal(o.Ctx.Input.RequestBody, &param)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "Invalid JSON request", err)
+	}
+	if param.AppId == "" {
+		o.ServeError(http.StatusBadRequest, "app_id can not be empty")
+	}
+	if param.Page <= 0 {
+		o.ServeError(http.StatusBadRequest, "page must be greater than 0")
+	}
+	if param.Perpage <= 0 {
+		o.ServeError(http.StatusBadRequest, "perpage must be greater than 0")
+	}
+	total, digests, err := models.GetDigests(param.AppId, param.Page, param.Perpage)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "failed to get digests", err)
+	}
+	var result = make(map[string]interface{})
+	result["total"] = total
+	result["total_page"] = math.Ceil(float64(total) / float64(param.Perpage))
+	result["page"] = param.Page
+	result["perpage"] = param.Perpage
+	result["data"] = digests
+	o.Serve(result)
+}
+
+// @router /detail [post]
+func (o *DigestController) Detail() {
+	var param struct {
+		Id string `json:"id"`
+	}
+	err := json.Unmarshal(o.Ctx.Input.RequestBody, &param)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "Invalid JSON request", err)
+	}
+	if param.Id == "" {
+		o.ServeError(http.StatusBadRequest, "the id can not be empty")
+	}
+	digest, err := models.GetDigestById(param.Id)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "failed to get digest", err)
+	}
+	o.Serve(digest)
+}
+
+// @router /generate [post]
+func (o *DigestController) Generate() {
+	var param struct {
+		AppId     string `json:"app_id"`
+		Period    string `json:"period"`
+		Timezone  string `json:"timezone"`
+		StartTime int64  `json:"start_time"`
+		EndTime   int64  `json:"end_time"`
+	}
+	err := json.Unmarshal(o.Ctx.Input.RequestBody, &param)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "Invalid JSON request", err)
+	}
+	if param.AppId == "" {
+		o.ServeError(http.StatusBadRequest, "app_id can not be empty")
+	}
+	if param.Period == "" {
+		param.Period = models.DigestPeriodDaily
+	}
+	if param.Period != models.DigestPeriodDaily && param.Period != models.DigestPeriodWeekly {
+		o.ServeError(http.StatusBadRequest, "the period must be daily or weekly")
+	}
+	if param.Timezone == "" {
+		param.Timezone = "UTC"
+	}
+	location, err := time.LoadLocation(param.Timezone)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "invalid timezone", err)
+	}
+	endTime := time.Now().In(location)
+	if param.EndTime > 0 {
+		endTime = time.Unix(0, param.EndTime*int64(time.Millisecond)).In(location)
+	}
+	startTime := endTime.AddDate(0, 0, -1)
+	if param.Period == models.DigestPeriodWeekly {
+		startTime = endTime.AddDate(0, 0, -7)
+	}
+	if param.StartTime > 0 {
+		startTime = time.Unix(0, param.StartTime*int64(time.Millisecond)).In(location)
+	}
+	if !startTime.Before(endTime) {
+		o.ServeError(http.StatusBadRequest, "start_time must be less than end_time")
+	}
+	if endTime.Sub(startTime) > 366*24*time.Hour {
+		o.ServeError(http.StatusBadRequest, "the time range can not be greater than 366 days")
+	}
+	app, err := models.GetAppById(param.AppId)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "failed to get app", err)
+	}
+	digest, err := models.GenerateDigest(app, param.Period, startTime, endTime)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "failed to generate digest", err)
+	}
+	o.Serve(digest)
+}
@@ -22,6 +22,7 @@ import (
 	"rasp-cloud/models/logs"
 	"math"
 	"time"
+	"strconv"
 )
 
 // Operations about attack alarm message
@@ -142,6 +143,164 @@ func (o *AttackAlarmController) Search() {
 	if param.Perpage <= 0 {
 		o.ServeError(http.StatusBadRequest, "perpage must be greater than 0")
 	}
+	searchData := o.getSearchData(param)
+	total, result, err := logs.SearchLogs(param.Data.StartTime, param.Data.EndTime, searchData, "event_time",
+		param.Page, param.Perpage, false, logs.AliasAttackIndexName+"-"+param.Data.AppId)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "failed to search data from es", err)
+	}
+	o.Serve(map[string]interface{}{
+		"total":      total,
+		"total_page": math.Ceil(float64(total) / float64(param.Perpage)),
+		"page":       param.Page,
+		"perpage":    param.Perpage,
+		"data":       result,
+	})
+}
+
+// @router /triage [post]
+func (o *AttackAlarmController) Triage() {
+	var param struct {
+		logs.AttackTriage
+		AppId   string `json:"app_id"`
+		Id      string `json:"id"`
+		Comment string `json:"comment"`
+	}
+	err := json.Unmarshal(o.Ctx.Input.RequestBody, &param)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "json decode error", err)
+	}
+	if param.AppId == "" {
+		o.ServeError(http.StatusBadRequest, "app_id can not be empty")
+	}
+	if param.Id == "" {
+		o.ServeError(http.StatusBadRequest, "id can not be empty")
+	}
+	if err := logs.ValidateAttackTriage(&param.AttackTriage); err != nil {
+		o.ServeError(http.StatusBadRequest, err.Error())
+	}
+	if _, err := models.GetAppById(param.AppId); err != nil {
+		o.ServeError(http.StatusBadRequest, "cannot get the app: "+param.AppId, err)
+	}
+	err = models.TriageAttackAlarm(param.AppId, param.Id, &param.AttackTriage, param.Comment)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "failed to update the triage state of alarm", err)
+	}
+	models.AddOperation(param.AppId, models.OperationTypeUpdateAlarmTriage, o.Ctx.Input.IP(),
+		"Updated the triage state of attack alarm "+param.Id+formatTriage(&param.AttackTriage))
+	o.ServeWithEmptyData()
+}
+
+// @router /triage/search [post]
+func (o *AttackAlarmController) TriageByQuery() {
+	var param struct {
+		logs.SearchAttackParam
+		logs.AttackTriage
+	}
+	err := json.Unmarshal(o.Ctx.Input.RequestBody, &param)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "json decode error", err)
+	}
+	if param.Data == nil {
+		o.ServeError(http.StatusBadRequest, "search data can not be empty")
+	}
+	if param.Data.AppId == "" {
+		o.ServeError(http.StatusBadRequest, "app_id can not be empty")
+	}
+	if param.Data.StartTime <= 0 {
+		o.ServeError(http.StatusBadRequest, "start_time must be greater than 0")
+	}
+	if param.Data.EndTime <= 0 {
+		o.ServeError(http.StatusBadRequest, "end_time must be greater than 0")
+	}
+	if param.Data.StartTime > param.Data.EndTime {
+		o.ServeError(http.StatusBadRequest, "start_time cannot be greater than end_time")
+	}
+	duration := time.Duration(param.Data.EndTime-param.Data.StartTime) * time.Millisecond
+	if duration > 366*24*time.Hour {
+		o.ServeError(http.StatusBadRequest, "time duration can not be greater than 366 days")
+	}
+	if err := logs.ValidateAttackTriage(&param.AttackTriage); err != nil {
+		o.ServeError(http.StatusBadRequest, err.Error())
+	}
+	if _, err := models.GetAppById(param.Data.AppId); err != nil {
+		o.ServeError(http.StatusBadRequest, "cannot get the app: "+param.Data.AppId, err)
+	}
+	searchData := o.getSearchData(&param.SearchAttackParam)
+	updated, err := models.TriageAttackAlarmsByQuery(param.Data.AppId, param.Data.StartTime, param.Data.EndTime,
+		searchData, &param.AttackTriage)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "failed to update the triage state of alarms", err)
+	}
+	models.AddOperation(param.Data.AppId, models.OperationTypeUpdateAlarmTriage, o.Ctx.Input.IP(),
+		"Updated the triage state of "+strconv.FormatInt(updated, 10)+" attack alarms"+
+			formatTriage(&param.AttackTriage))
+	o.Serve(map[string]interface{}{
+		"updated": updated,
+	})
+}
+
+// @router /comment [post]
+func (o *AttackAlarmController) AddComment() {
+	var param struct {
+		AppId   string `json:"app_id"`
+		AlarmId string `json:"alarm_id"`
+		Content string `json:"content"`
+	}
+	err := json.Unmarshal(o.Ctx.Input.RequestBody, &param)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "json decode error", err)
+	}
+	if param.AppId == "" {
+		o.ServeError(http.StatusBadRequest, "app_id can not be empty")
+	}
+	if param.AlarmId == "" {
+		o.ServeError(http.StatusBadRequest, "alarm_id can not be empty")
+	}
+	if _, err := models.GetAppById(param.AppId); err != nil {
+		o.ServeError(http.StatusBadRequest, "cannot get the app: "+param.AppId, err)
+	}
+	comment, err := models.AddAlarmComment(param.AppId, param.AlarmId, param.Content)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "failed to add comment", err)
+	}
+	o.Serve(comment)
+}
+
+// @router /comment/get [post]
+func (o *AttackAlarmController) GetComments() {
+	var param struct {
+		AlarmId string `json:"alarm_id"`
+		Page    int    `json:"page"`
+		Perpage int    `json:"perpage"`
+	}
+	err := json.Unmarshal(o.Ctx.Input.RequestBody, &param)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "json decode error", err)
+	}
+	if param.AlarmId == "" {
+		o.ServeError(http.StatusBadRequest, "alarm_id can not be empty")
+	}
+	if param.Page <= 0 {
+		o.ServeError(http.StatusBadRequest, "page must be greater than 0")
+	}
+	if param.Perpage <= 0 {
+		o.ServeError(http.StatusBadRequest, "perpage must be greater than 0")
+	}
+	total, comments, err := models.GetAlarmComments(param.AlarmId, param.Page, param.Perpage)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "failed to get comments", err)
+	}
+	o.Serve(map[string]interface{}{
+		"total":      total,
+		"total_page": math.Ceil(float64(total) / float64(param.Perpage)),
+		"page":       param.Page,
+		"perpage":    param.Perpage,
+		"data":       comments,
+	})
+}
+
+func (o *AttackAlarmController) getSearchData(param *logs.SearchAttackParam) map[string]interface{} {
 	content, err := json.Marshal(param.Data)
 	if err != nil {
 		o.ServeError(http.StatusBadRequest, "failed to encode search data", err)
@@ -154,18 +313,18 @@ func (o *AttackAlarmController) Search() {
 	delete(searchData, "start_time")
 	delete(searchData, "end_time")
 	delete(searchData, "app_id")
-	total, result, err := logs.SearchLogs(param.Data.StartTime, param.Data.EndTime, searchData, "event_time",
-		param.Page, param.Perpage, false, logs.AliasAttackIndexName+"-"+param.Data.AppId)
-	if err != nil {
-		o.ServeError(http.StatusBadRequest, "failed to search data from es", err)
+	return searchData
+}
+
+func formatTriage(triage *logs.AttackTriage) string {
+	content := ""
+	if triage.Status != "" {
+		content += ", status: " + triage.Status
 	}
-	o.Serve(map[string]interface{}{
-		"total":      total,
-		"total_page": math.Ceil(float64(total) / float64(param.Perpage)),
-		"page":       param.Page,
-		"perpage":    param.Perpage,
-		"data":       result,
-	})
+	if triage.Assignee != nil {
+		content += ", assignee: " + *triage.Assignee
+	}
+	return content
 }
 
 func (o *AttackAlarmController) validFieldAggrParam(param *logs.AggrFieldParam) {
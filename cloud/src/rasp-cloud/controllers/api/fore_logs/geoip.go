@@ -0,0 +1,35 @@
+//Copyright 2017-2018 Baidu Inc.
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http: //www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+
+package fore_logs
+
+import (
+	"rasp-cloud/controllers"
+	"rasp-cloud/models/logs/enrich"
+)
+
+// Operations about the geoip databases of alarm enrichment
+type GeoipController struct {
+	controllers.BaseController
+}
+
+// @router /get [post]
+func (o *GeoipController) Get() {
+	o.Serve(enrich.GetGeoIpDatabases())
+}
+
+// @router /reload [post]
+func (o *GeoipController) Reload() {
+	o.Serve(enrich.ReloadGeoIpDatabases())
+}
@@ -0,0 +1,183 @@
+//Copyright 2017-2018 Baidu Inc.
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http: //www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"rasp-cloud/controllers"
+	"rasp-cloud/models"
+)
+
+// Operations about notification deliveries
+type NotificationController struct {
+	controllers.BaseController
+}
+
+// @router /history/get [post]
+func (o *NotificationController) GetHistory() {
+	var param struct {
+		AppId   string `json:"app_id"`
+		Channel string `json:"channel"`
+		Status  string `json:"status"`
+		Page    int    `json:"page"`
+		Perpage int    `json:"perpage"`
+	}
+	err := json.Unmarshal(o.Ctx.Input.RequestBody, &param)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "Invalid JSON request", err)
+	}
+	if param.AppId == "" {
+		o.ServeError(http.StatusBadRequest, "app_id can not be empty")
+	}
+	if param.Page <= 0 {
+		o.ServeError(http.StatusBadRequest, "page must be greater than 0")
+	}
+	if param.Perpage <= 0 {
+		o.ServeError(http.StatusBadRequest, "perpage must be greater than 0")
+	}
+	total, history, err := models.GetNotificationHistory(param.AppId, param.Channel, param.Status,
+		param.Page, param.Perpage)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "failed to get notification history", err)
+	}
+	var result = make(map[string]interface{})
+	result["total"] = total
+	result["total_page"] = math.Ceil(float64(total) / float64(param.Perpage))
+	result["page"] = param.Page
+	result["perpage"] = param.Perpage
+	result["data"] = history
+	o.Serve(result)
+}
+
+// @router /resend [post]
+func (o *NotificationController) Resend() {
+	var param struct {
+		Id string `json:"id"`
+	}
+	err := json.Unmarshal(o.Ctx.Input.RequestBody, &param)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "Invalid JSON request", err)
+	}
+	if param.Id == "" {
+		o.ServeError(http.StatusBadRequest, "the id can not be empty")
+	}
+	delivery, err := models.ResendNotification(param.Id)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "failed to resend notification", err)
+	}
+	models.AddOperation(delivery.AppId, models.OperationTypeResendNotification, o.Ctx.Input.IP(),
+		"Resent "+delivery.Channel+" notification "+param.Id)
+	o.Serve(delivery)
+}
+
+// @router /template/get [post]
+func (o *NotificationController) GetTemplates() {
+	var param struct {
+		AppId string `json:"app_id"`
+	}
+	err := json.Unmarshal(o.Ctx.Input.RequestBody, &param)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "Invalid JSON request", err)
+	}
+	if param.AppId == "" {
+		o.ServeError(http.StatusBadRequest, "app_id can not be empty")
+	}
+	templates, err := models.GetNotificationTemplates(param.AppId)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "failed to get notification templates", err)
+	}
+	o.Serve(templates)
+}
+
+// @router /template [post]
+func (o *NotificationController) UpdateTemplate() {
+	var notificationTemplate models.NotificationTemplate
+	err := json.Unmarshal(o.Ctx.Input.RequestBody, &notificationTemplate)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "Invalid JSON request", err)
+	}
+	app := o.getTemplateApp(notificationTemplate.AppId)
+	if err := models.ValidateNotificationTemplate(app, &notificationTemplate); err != nil {
+		o.ServeError(http.StatusBadRequest, err.Error())
+	}
+	result, err := models.UpdateNotificationTemplate(&notificationTemplate)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "failed to update notification template", err)
+	}
+	models.AddOperation(app.Id, models.OperationTypeUpdateNotificationTemplate, o.Ctx.Input.IP(),
+		"Updated the "+result.Channel+" notification template of "+app.Id)
+	o.Serve(result)
+}
+
+// @router /template/delete [post]
+func (o *NotificationController) ResetTemplate() {
+	var param struct {
+		AppId   string `json:"app_id"`
+		Channel string `json:"channel"`
+	}
+	err := json.Unmarshal(o.Ctx.Input.RequestBody, &param)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "Invalid JSON request", err)
+	}
+	app := o.getTemplateApp(param.AppId)
+	err = models.RemoveNotificationTemplate(app.Id, param.Channel)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "failed to reset notification template", err)
+	}
+	models.AddOperation(app.Id, models.OperationTypeResetNotificationTemplate, o.Ctx.Input.IP(),
+		"Reset the "+param.Channel+" notification template of "+app.Id+" to default")
+	o.ServeWithEmptyData()
+}
+
+// the stored template of app is previewed if the request has no language, subject and body
+// @router /template/preview [post]
+func (o *NotificationController) PreviewTemplate() {
+	var notificationTemplate *models.NotificationTemplate
+	err := json.Unmarshal(o.Ctx.Input.RequestBody, &notificationTemplate)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "Invalid JSON request", err)
+	}
+	if notificationTemplate == nil {
+		o.ServeError(http.StatusBadRequest, "the notification template can not be empty")
+	}
+	app := o.getTemplateApp(notificationTemplate.AppId)
+	if notificationTemplate.Language == "" && notificationTemplate.Subject == "" && notificationTemplate.Body == "" {
+		notificationTemplate, err = models.GetNotificationTemplate(app.Id, notificationTemplate.Channel)
+		if err != nil {
+			o.ServeError(http.StatusBadRequest, "failed to get notification template", err)
+		}
+	}
+	subject, content, err := models.PreviewNotificationTemplate(app, notificationTemplate)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "failed to render notification template", err)
+	}
+	o.Serve(map[string]interface{}{
+		"subject": subject,
+		"content": content,
+	})
+}
+
+func (o *NotificationController) getTemplateApp(appId string) *models.App {
+	if appId == "" {
+		o.ServeError(http.StatusBadRequest, "app_id can not be empty")
+	}
+	app, err := models.GetAppById(appId)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "failed to get app", err)
+	}
+	return app
+}
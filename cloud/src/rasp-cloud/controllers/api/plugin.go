@@ -103,6 +103,7 @@ func (o *PluginController) UpdateAppAlgorithmConfig() {
 	var param struct {
 		PluginId string                 `json:"id"`
 		Config   map[string]interface{} `json:"config"`
+		Comment  string                 `json:"comment"`
 	}
 	err := json.Unmarshal(o.Ctx.Input.RequestBody, &param)
 	if err != nil {
@@ -114,10 +115,13 @@ func (o *PluginController) UpdateAppAlgorithmConfig() {
 	if param.Config == nil {
 		o.ServeError(http.StatusBadRequest, "config can not be empty")
 	}
+	validateConfigComment(&o.BaseController, param.Comment)
 	appId, err := models.UpdateAlgorithmConfig(param.PluginId, param.Config)
 	if err != nil {
 		o.ServeError(http.StatusBadRequest, "failed to update algorithm config", err)
 	}
+	addConfigVersion(&o.BaseController, appId, models.ConfigTypeAlgorithm, param.PluginId, param.Config,
+		param.Comment)
 	models.AddOperation(appId, models.OperationTypeUpdateAlgorithmConfig,
 		o.Ctx.Input.IP(), "Algorithm config updated for plugin: "+param.PluginId)
 	o.ServeWithEmptyData()
@@ -138,6 +142,13 @@ func (o *PluginController) RestoreAlgorithmConfig() {
 	if err != nil {
 		o.ServeError(http.StatusBadRequest, "failed to restore the default algorithm config", err)
 	}
+	plugin, err := models.GetPluginById(pluginId, false)
+	if err != nil {
+		o.AddWarning("the config is restored, but failed to get it to store its version", err)
+	} else {
+		addConfigVersion(&o.BaseController, appId, models.ConfigTypeAlgorithm, pluginId, plugin.AlgorithmConfig,
+			"Restored the default algorithm config")
+	}
 	models.AddOperation(appId, models.OperationTypeRestorePlugin, o.Ctx.Input.IP(),
 		"Restored algorithm config for plugin: "+pluginId)
 	o.ServeWithEmptyData()
@@ -0,0 +1,128 @@
+//Copyright 2017-2018 Baidu Inc.
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http: //www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"rasp-cloud/controllers"
+	"rasp-cloud/models"
+	"time"
+)
+
+// Operations about notification silences
+type SilenceController struct {
+	controllers.BaseController
+}
+
+// @router /get [post]
+func (o *SilenceController) Get() {
+	var param struct {
+		AppId      string `json:"app_id"`
+		ActiveOnly bool   `json:"active_only"`
+		Page       int    `json:"page"`
+		Perpage    int    `json:"perpage"`
+	}
+	err := json.Unmarshal(o.Ctx.Input.RequestBody, &param)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "Invalid JSON request", err)
+	}
+	if param.AppId == "" {
+		o.ServeError(http.StatusBadRequest, "app_id can not be empty")
+	}
+	if param.Page <= 0 {
+		o.ServeError(http.StatusBadRequest, "page must be greater than 0")
+	}
+	if param.Perpage <= 0 {
+		o.ServeError(http.StatusBadRequest, "perpage must be greater than 0")
+	}
+	total, silences, err := models.GetSilences(param.AppId, param.ActiveOnly, param.Page, param.Perpage)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "failed to get silences", err)
+	}
+	var result = make(map[string]interface{})
+	result["total"] = total
+	result["total_page"] = math.Ceil(float64(total) / float64(param.Perpage))
+	result["page"] = param.Page
+	result["perpage"] = param.Perpage
+	result["data"] = silences
+	o.Serve(result)
+}
+
+// @router / [post]
+func (o *SilenceController) Post() {
+	var silence *models.Silence
+	err := json.Unmarshal(o.Ctx.Input.RequestBody, &silence)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "Invalid JSON request", err)
+	}
+	if silence == nil {
+		o.ServeError(http.StatusBadRequest, "the silence can not be empty")
+	}
+	if err := models.ValidateSilence(silence); err != nil {
+		o.ServeError(http.StatusBadRequest, err.Error())
+	}
+	if silence.Id == "" {
+		if silence.AppId == "" {
+			o.ServeError(http.StatusBadRequest, "app_id can not be empty")
+		}
+		if _, err := models.GetAppById(silence.AppId); err != nil {
+			o.ServeError(http.StatusBadRequest, "failed to get app", err)
+		}
+		silence, err = models.AddSilence(silence)
+		if err != nil {
+			o.ServeError(http.StatusBadRequest, "failed to add silence", err)
+		}
+		models.AddOperation(silence.AppId, models.OperationTypeAddSilence, o.Ctx.Input.IP(),
+			"Added silence "+silence.Id+" until "+formatSilenceEndTime(silence)+", reason: "+silence.Reason)
+	} else {
+		silence, err = models.UpdateSilence(silence)
+		if err != nil {
+			o.ServeError(http.StatusBadRequest, "failed to update silence", err)
+		}
+		models.AddOperation(silence.AppId, models.OperationTypeEditSilence, o.Ctx.Input.IP(),
+			"Updated silence "+silence.Id+" until "+formatSilenceEndTime(silence)+", reason: "+silence.Reason)
+	}
+	o.Serve(silence)
+}
+
+// @router /delete [post]
+func (o *SilenceController) Delete() {
+	var param struct {
+		Id string `json:"id"`
+	}
+	err := json.Unmarshal(o.Ctx.Input.RequestBody, &param)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "Invalid JSON request", err)
+	}
+	if param.Id == "" {
+		o.ServeError(http.StatusBadRequest, "the id can not be empty")
+	}
+	silence, err := models.RemoveSilence(param.Id)
+	if err != nil {
+		o.ServeError(http.StatusBadRequest, "failed to remove silence", err)
+	}
+	models.AddOperation(silence.AppId, models.OperationTypeDeleteSilence, o.Ctx.Input.IP(),
+		"Deleted silence "+silence.Id+" created by "+silence.Creator)
+	o.ServeWithEmptyData()
+}
+
+func formatSilenceEndTime(silence *models.Silence) string {
+	if silence.EndTime == 0 {
+		return "never"
+	}
+	return time.Unix(silence.EndTime, 0).Format(time.RFC3339)
+}
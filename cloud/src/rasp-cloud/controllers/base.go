@@ -22,16 +22,30 @@ import (
 // base controller
 type BaseController struct {
 	beego.Controller
+	warnings []string
 }
 
 func (o *BaseController) Serve(data interface{}) {
-	o.Data["json"] = map[string]interface{}{"status": 0, "description": "ok", "data": data}
+	result := map[string]interface{}{"status": 0, "description": "ok", "data": data}
+	if len(o.warnings) > 0 {
+		result["warnings"] = o.warnings
+	}
+	o.Data["json"] = result
 	o.ServeJSON()
 }
 
 func (o *BaseController) ServeWithEmptyData() {
-	o.Data["json"] = map[string]interface{}{"status": 0, "description": "ok", "data": make(map[string]interface{})}
-	o.ServeJSON()
+	o.Serve(make(map[string]interface{}))
+}
+
+// AddWarning adds a warning to the successful response, it is used when the request has taken effect
+// but one of its follow-up steps has failed
+func (o *BaseController) AddWarning(warning string, err ...error) {
+	if len(err) > 0 && err[0] != nil {
+		warning = warning + ": " + err[0].Error()
+	}
+	beego.Warn(warning)
+	o.warnings = append(o.warnings, warning)
 }
 
 func (o *BaseController) ServeError(code int, description string, err ...error) {
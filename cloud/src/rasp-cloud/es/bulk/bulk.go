@@ -0,0 +1,163 @@
+//Copyright 2017-2018 Baidu Inc.
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http: //www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+
+package bulk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/astaxie/beego"
+	"github.com/olivere/elastic"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Failure describes a doc that can not be inserted by the es bulk api
+type Failure struct {
+	Doc    map[string]interface{}
+	Status int
+	Reason string
+}
+
+type Result struct {
+	Succeeded int
+	Retried   int
+	Failures  []*Failure
+}
+
+const countUpsertScript = "ctx._source.count = (ctx._source.count == null ? 1 : ctx._source.count) + params.count;" +
+	"if (ctx._source.last_seen == null || ctx._source.last_seen < params.last_seen) " +
+	"{ ctx._source.last_seen = params.last_seen; }"
+
+// Insert inserts the docs to the indices of their apps with the es bulk api, the items failed with
+// the retryable errors are retried up to maxRetries times with an exponential backoff
+func Insert(client *elastic.Client, docType string, docs []map[string]interface{}, maxRetries int,
+	retryInterval time.Duration) (result *Result, err error) {
+	result = &Result{Failures: make([]*Failure, 0)}
+	pending := make([]map[string]interface{}, 0, len(docs))
+	for _, doc := range docs {
+		if appId, ok := doc["app_id"].(string); ok && appId != "" {
+			pending = append(pending, doc)
+		} else {
+			result.Failures = append(result.Failures, &Failure{
+				Doc:    doc,
+				Reason: "the alarm's app_id param is missing or is not a string",
+			})
+		}
+	}
+	interval := retryInterval
+	for attempt := 0; len(pending) > 0; attempt++ {
+		if attempt > 0 {
+			beego.Warning("retry es bulk insert for " + strconv.Itoa(len(pending)) + " " + docType +
+				" docs, attempt: " + strconv.Itoa(attempt))
+			time.Sleep(interval)
+			interval *= 2
+			result.Retried += len(pending)
+		}
+		pending, err = doInsert(client, docType, pending, result, attempt >= maxRetries)
+	}
+	return
+}
+
+// doInsert sends one bulk request and sorts every item of the response into succeeded,
+// failed or to be retried, the docs need to be retried are returned
+func doInsert(client *elastic.Client, docType string, docs []map[string]interface{}, result *Result,
+	isLastAttempt bool) (retryDocs []map[string]interface{}, err error) {
+	bulkService := client.Bulk()
+	for _, doc := range docs {
+		appId := doc["app_id"].(string)
+		if docType == "policy-alarm" {
+			// the existing doc is updated without first_seen, which is only set when the doc is created
+			update := make(map[string]interface{}, len(doc))
+			for key, value := range doc {
+				if key != "first_seen" {
+					update[key] = value
+				}
+			}
+			bulkService.Add(elastic.NewBulkUpdateRequest().
+				Index("real-openrasp-" + docType + "-" + appId).
+				Type(docType).
+				Id(fmt.Sprint(doc["upsert_id"])).
+				Doc(update).
+				Upsert(doc))
+		} else if upsertId, ok := doc["upsert_id"]; ok {
+			// the duplicate alarms are collapsed into one doc, only the count and last_seen are updated
+			bulkService.Add(elastic.NewBulkUpdateRequest().
+				Index("real-openrasp-" + docType + "-" + appId).
+				Type(docType).
+				Id(fmt.Sprint(upsertId)).
+				RetryOnConflict(3).
+				Script(elastic.NewScript(countUpsertScript).Lang("painless").Params(map[string]interface{}{
+					"count":     doc["count"],
+					"last_seen": doc["last_seen"],
+				})).
+				Upsert(doc))
+		} else {
+			bulkService.Add(elastic.NewBulkIndexRequest().
+				Index("real-openrasp-" + docType + "-" + appId).
+				Type(docType).
+				OpType("index").
+				Doc(doc))
+		}
+	}
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(15*time.Second))
+	defer cancel()
+	response, err := bulkService.Do(ctx)
+	if err == nil && len(response.Items) != len(docs) {
+		// the items can not be matched with the docs, so all of the docs are retried
+		err = errors.New("the item count of es bulk response is " + strconv.Itoa(len(response.Items)) +
+			", but " + strconv.Itoa(len(docs)) + " docs have been sent")
+	}
+	if err != nil {
+		if isLastAttempt {
+			for _, doc := range docs {
+				result.Failures = append(result.Failures, &Failure{Doc: doc, Reason: err.Error()})
+			}
+			return nil, err
+		}
+		return docs, err
+	}
+	for index, item := range response.Items {
+		for _, itemResult := range item {
+			if itemResult.Status >= 200 && itemResult.Status <= 299 {
+				result.Succeeded++
+			} else if !isLastAttempt && isRetryableItem(itemResult) {
+				retryDocs = append(retryDocs, docs[index])
+			} else {
+				failure := &Failure{Doc: docs[index], Status: itemResult.Status}
+				if itemResult.Error != nil {
+					failure.Reason = itemResult.Error.Type + ": " + itemResult.Error.Reason
+				} else {
+					failure.Reason = "es bulk item failed with status code: " + strconv.Itoa(itemResult.Status)
+				}
+				result.Failures = append(result.Failures, failure)
+			}
+		}
+	}
+	return retryDocs, nil
+}
+
+func isRetryableItem(item *elastic.BulkResponseItem) bool {
+	if item.Error != nil && item.Error.Type == "es_rejected_execution_exception" {
+		return true
+	}
+	switch item.Status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
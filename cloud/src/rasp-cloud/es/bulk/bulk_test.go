@@ -0,0 +1,128 @@
+//Copyright 2017-2018 Baidu Inc.
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http: //www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+
+package bulk
+
+import (
+	"encoding/json"
+	"github.com/olivere/elastic"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testMaxRetries = 2
+
+// newTestBulkServer starts an es stand-in for the bulk requests, it returns the client and the close function
+func newTestBulkServer(t *testing.T, handler func(lines []string) string) (*elastic.Client, func()) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(handler(strings.Split(strings.TrimSpace(string(body)), "\n"))))
+	}))
+	client, err := elastic.NewClient(elastic.SetURL(server.URL), elastic.SetSniff(false),
+		elastic.SetHealthcheck(false))
+	if err != nil {
+		t.Fatalf("failed to create es client: %v", err)
+	}
+	return client, server.Close
+}
+
+func insertTestDocs(client *elastic.Client, docType string, docs []map[string]interface{}) (*Result, error) {
+	return Insert(client, docType, docs, testMaxRetries, time.Millisecond)
+}
+
+func testBulkDocs() []map[string]interface{} {
+	return []map[string]interface{}{
+		{"app_id": "app-1", "attack_type": "sql"},
+		{"app_id": "app-1", "attack_type": "xxe"},
+		{"attack_type": "ssrf"},
+	}
+}
+
+func TestInsertItemFailures(t *testing.T) {
+	requests := 0
+	client, closeServer := newTestBulkServer(t, func(lines []string) string {
+		requests++
+		if requests == 1 {
+			return `{"took":1,"errors":true,"items":[{"index":{"status":201}},` +
+				`{"index":{"status":429,"error":{"type":"es_rejected_execution_exception","reason":"busy"}}}]}`
+		}
+		return `{"took":1,"errors":false,"items":[{"index":{"status":201}}]}`
+	})
+	defer closeServer()
+	result, err := insertTestDocs(client, "attack-alarm", testBulkDocs())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if requests != 2 || result.Succeeded != 2 || result.Retried != 1 || len(result.Failures) != 1 {
+		t.Fatalf("unexpected result after %d requests: %+v", requests, result)
+	}
+	if result.Failures[0].Doc["attack_type"] != "ssrf" {
+		t.Errorf("the doc without app_id must fail, got %v", result.Failures[0].Doc)
+	}
+}
+
+func TestInsertItemCountMismatch(t *testing.T) {
+	requests := 0
+	client, closeServer := newTestBulkServer(t, func(lines []string) string {
+		requests++
+		return `{"took":1,"errors":false,"items":[{"index":{"status":201}}]}`
+	})
+	defer closeServer()
+	docs := testBulkDocs()[:2]
+	result, err := insertTestDocs(client, "attack-alarm", docs)
+	if err == nil {
+		t.Fatal("expect the error of mismatched item count")
+	}
+	// every doc is retried, and fails at the last attempt
+	if requests != testMaxRetries+1 || result.Succeeded != 0 || len(result.Failures) != len(docs) {
+		t.Fatalf("unexpected result after %d requests: %+v", requests, result)
+	}
+}
+
+func TestInsertPolicyFirstSeen(t *testing.T) {
+	var lines []string
+	client, closeServer := newTestBulkServer(t, func(requestLines []string) string {
+		lines = requestLines
+		return `{"took":1,"errors":false,"items":[{"update":{"status":201}}]}`
+	})
+	defer closeServer()
+	_, err := insertTestDocs(client, "policy-alarm", []map[string]interface{}{
+		{"app_id": "app-1", "upsert_id": "policy-1", "policy_id": "3006", "first_seen": 1533891423000},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(lines) != 2 || !strings.Contains(lines[0], `"_id":"policy-1"`) {
+		t.Fatalf("unexpected bulk request: %v", lines)
+	}
+	// the first_seen is only in the upsert doc, so that the existing doc keeps it
+	var body struct {
+		Doc    map[string]interface{} `json:"doc"`
+		Upsert map[string]interface{} `json:"upsert"`
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &body); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := body.Doc["first_seen"]; ok || body.Doc["policy_id"] != "3006" {
+		t.Errorf("unexpected update doc: %v", body.Doc)
+	}
+	if body.Upsert["first_seen"] != float64(1533891423000) {
+		t.Errorf("unexpected upsert doc: %v", body.Upsert)
+	}
+}
@@ -23,19 +23,25 @@ import (
 	"github.com/astaxie/beego"
 	"rasp-cloud/tools"
 	"encoding/json"
-	"fmt"
 	"rasp-cloud/environment"
+	"rasp-cloud/mongo"
 	"strings"
+	"errors"
+	"rasp-cloud/es/bulk"
 )
 
 var (
 	ElasticClient *elastic.Client
 	ttlIndexes    = make(chan map[string]time.Duration, 1)
 	minEsVersion  = "5.6.0"
+	// bulkMaxRetries is the max retry times of the retryable failed items of a bulk insert
+	bulkMaxRetries    int
+	bulkRetryInterval time.Duration
 )
 
 func init() {
 	ttlIndexes <- make(map[string]time.Duration)
+	initBulkRetryConfig()
 	if *environment.StartFlag.StartType != environment.StartTypeReset {
 		esAddr := beego.AppConfig.String("EsAddr")
 		if esAddr == "" {
@@ -64,12 +70,27 @@ func init() {
 	}
 }
 
+func initBulkRetryConfig() {
+	bulkMaxRetries = beego.AppConfig.DefaultInt("EsBulkMaxRetries", 3)
+	if bulkMaxRetries < 0 {
+		tools.Panic(tools.ErrCodeConfigInitFailed, "the 'EsBulkMaxRetries' config can not be less than 0", nil)
+	}
+	retryInterval := beego.AppConfig.DefaultInt64("EsBulkRetryInterval", 500)
+	if retryInterval <= 0 {
+		tools.Panic(tools.ErrCodeConfigInitFailed, "the 'EsBulkRetryInterval' config must be greater than 0", nil)
+	}
+	bulkRetryInterval = time.Duration(retryInterval) * time.Millisecond
+}
+
 func startTTL(duration time.Duration) {
 	ticker := time.NewTicker(duration)
 	for {
 		select {
 		case <-ticker.C:
-			deleteExpiredData()
+			// the expired data is only deleted by the leader among the panel instances
+			if mongo.IsLeader() {
+				deleteExpiredData()
+			}
 		}
 	}
 }
@@ -116,6 +137,26 @@ func RegisterTTL(duration time.Duration, index string) {
 	ttls[index] = duration
 }
 
+// UpdateEsMapping puts the type mapping of the index body to the existing indices,
+// so that the new fields are mapped with the right types rather than the dynamic mapping
+func UpdateEsMapping(index string, docType string, mapping string) error {
+	var body struct {
+		Mappings map[string]map[string]interface{} `json:"mappings"`
+	}
+	if err := json.Unmarshal([]byte(mapping), &body); err != nil {
+		return err
+	}
+	typeMapping, ok := body.Mappings[docType]
+	if !ok {
+		return errors.New("can not find the mapping of type " + docType)
+	}
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(15*time.Second))
+	defer cancel()
+	_, err := ElasticClient.PutMapping().Index(index).Type(docType).
+		AllowNoIndices(true).BodyJson(typeMapping).Do(ctx)
+	return err
+}
+
 func CreateEsIndex(index string, aliasIndex string, mapping string) error {
 	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(15*time.Second))
 	defer cancel()
@@ -154,36 +195,6 @@ func Insert(index string, docType string, doc interface{}) (err error) {
 	return
 }
 
-func BulkInsert(docType string, docs []map[string]interface{}) (err error) {
-	bulkService := ElasticClient.Bulk()
-	for _, doc := range docs {
-		if doc["app_id"] == nil {
-			beego.Error("failed to get app_id param from alarm: " + fmt.Sprintf("%+v", doc))
-		}
-		if appId, ok := doc["app_id"].(string); ok {
-			if docType == "policy-alarm" {
-
-				bulkService.Add(elastic.NewBulkUpdateRequest().
-					Index("real-openrasp-" + docType + "-" + appId).
-					Type(docType).
-					Id(fmt.Sprint(doc["upsert_id"])).
-					DocAsUpsert(true).
-					Doc(doc))
-			} else {
-				if appId, ok := doc["app_id"].(string); ok {
-					bulkService.Add(elastic.NewBulkIndexRequest().
-						Index("real-openrasp-" + docType + "-" + appId).
-						Type(docType).
-						OpType("index").
-						Doc(doc))
-				}
-			}
-		} else {
-			beego.Error("the type of alarm's app_id param is not string: " + fmt.Sprintf("%+v", doc))
-		}
-	}
-	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(15*time.Second))
-	defer cancel()
-	_, err = bulkService.Do(ctx)
-	return err
+func BulkInsert(docType string, docs []map[string]interface{}) (*bulk.Result, error) {
+	return bulk.Insert(ElasticClient, docType, docs, bulkMaxRetries, bulkRetryInterval)
 }
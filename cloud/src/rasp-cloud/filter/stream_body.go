@@ -0,0 +1,47 @@
+//Copyright 2017-2018 Baidu Inc.
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http: //www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+
+package filter
+
+import (
+	"bytes"
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/context"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+const streamBodyDataKey = "stream_body"
+
+func init() {
+	// the body must be detached before beego copies it into memory with the 'copyrequestbody' config
+	beego.InsertFilter("/v1/agent/log/*", beego.BeforeStatic, detachRequestBody)
+}
+
+func detachRequestBody(ctx *context.Context) {
+	if ctx.Request.Method == http.MethodPost && ctx.Request.Body != nil {
+		ctx.Input.SetData(streamBodyDataKey, ctx.Request.Body)
+		ctx.Request.Body = ioutil.NopCloser(bytes.NewReader(nil))
+	}
+}
+
+// GetStreamBody returns the original request body which has not been read by beego,
+// it returns the copied body for the requests not handled by the detachRequestBody filter
+func GetStreamBody(ctx *context.Context) io.Reader {
+	if body, ok := ctx.Input.GetData(streamBodyDataKey).(io.ReadCloser); ok {
+		return body
+	}
+	return bytes.NewReader(ctx.Input.RequestBody)
+}
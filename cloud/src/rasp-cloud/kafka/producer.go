@@ -0,0 +1,504 @@
+//Copyright 2017-2018 Baidu Inc.
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http: //www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+
+package kafka
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type Config struct {
+	Brokers []string
+	// RequiredAcks is the acks of the produce request: -1 waits for all in-sync replicas,
+	// 1 waits for the leader only and 0 does not wait for any response
+	RequiredAcks  int16
+	MaxRetries    int
+	RetryInterval time.Duration
+	Timeout       time.Duration
+	// MaxBatchBytes limits the size of one record batch, it must be less than the message.max.bytes of the broker
+	MaxBatchBytes int
+	ClientId      string
+	TlsEnable     bool
+	TlsSkipVerify bool
+	TlsCaFile     string
+	// SaslUser enables the SASL/PLAIN authentication when it is not empty
+	SaslUser     string
+	SaslPassword string
+}
+
+type Message struct {
+	Topic string
+	Key   []byte
+	Value []byte
+}
+
+// Producer sends messages to kafka synchronously, it is safe for concurrent use
+type Producer struct {
+	config    *Config
+	tlsConfig *tls.Config
+	mutex     sync.Mutex
+	// brokers maps broker id to its address
+	brokers map[int32]string
+	// leaders maps topic to the leader broker id of every partition
+	leaders       map[string][]int32
+	conns         map[string]*brokerConn
+	roundRobin    uint32
+	correlationId int32
+}
+
+type brokerConn struct {
+	conn net.Conn
+	addr string
+}
+
+type partitionKey struct {
+	topic     string
+	partition int32
+}
+
+var retryableErrCodes = map[int16]bool{
+	3:  true, // UNKNOWN_TOPIC_OR_PARTITION
+	5:  true, // LEADER_NOT_AVAILABLE
+	6:  true, // NOT_LEADER_FOR_PARTITION
+	7:  true, // REQUEST_TIMED_OUT
+	13: true, // NETWORK_EXCEPTION
+	14: true, // COORDINATOR_LOAD_IN_PROGRESS
+	19: true, // NOT_ENOUGH_REPLICAS
+	20: true, // NOT_ENOUGH_REPLICAS_AFTER_APPEND
+}
+
+func NewProducer(config *Config) (*Producer, error) {
+	if len(config.Brokers) == 0 {
+		return nil, errors.New("kafka brokers can not be empty")
+	}
+	if config.RequiredAcks < -1 || config.RequiredAcks > 1 {
+		return nil, errors.New("kafka required acks must be -1, 0 or 1")
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 10 * time.Second
+	}
+	if config.MaxBatchBytes <= 0 {
+		config.MaxBatchBytes = 1000000
+	}
+	if config.ClientId == "" {
+		config.ClientId = "rasp-cloud"
+	}
+	producer := &Producer{
+		config:  config,
+		brokers: make(map[int32]string),
+		leaders: make(map[string][]int32),
+		conns:   make(map[string]*brokerConn),
+	}
+	if config.TlsEnable {
+		producer.tlsConfig = &tls.Config{InsecureSkipVerify: config.TlsSkipVerify}
+		if config.TlsCaFile != "" {
+			content, err := ioutil.ReadFile(config.TlsCaFile)
+			if err != nil {
+				return nil, errors.New("failed to read kafka tls ca file: " + err.Error())
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(content) {
+				return nil, errors.New("failed to parse kafka tls ca file: " + config.TlsCaFile)
+			}
+			producer.tlsConfig.RootCAs = pool
+		}
+	}
+	return producer, nil
+}
+
+// SendMessages sends the messages and retries the retryable failures,
+// it returns the count of messages which have been sent successfully
+func (p *Producer) SendMessages(messages []*Message) (int, error) {
+	pending := messages
+	interval := p.config.RetryInterval
+	var lastErr error
+	for attempt := 0; ; attempt++ {
+		retry, fatal, err := p.sendAttempt(pending, attempt > 0)
+		if err != nil {
+			lastErr = err
+		}
+		if len(retry) == 0 || attempt >= p.config.MaxRetries {
+			failed := len(retry) + fatal
+			if failed == 0 {
+				return len(messages), nil
+			}
+			if lastErr == nil {
+				lastErr = errors.New("kafka: failed to send messages")
+			}
+			return len(messages) - failed, errors.New("failed to send " + strconv.Itoa(failed) +
+				" messages to kafka, the last error: " + lastErr.Error())
+		}
+		// the lock is not held while waiting, so that the other senders are not blocked by the backoff
+		time.Sleep(interval)
+		interval *= 2
+		pending = retry
+	}
+}
+
+// sendAttempt sends the messages once with the lock held, the cached leaders are refreshed before a retry
+func (p *Producer) sendAttempt(messages []*Message, isRetry bool) ([]*Message, int, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if isRetry {
+		p.leaders = make(map[string][]int32)
+	}
+	return p.sendOnce(messages)
+}
+
+// Close closes all connections to the brokers
+func (p *Producer) Close() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for addr := range p.conns {
+		p.closeConn(addr)
+	}
+}
+
+// sendOnce sends every message once, it returns the messages to be retried
+// and the count of messages failed with an unretryable error
+func (p *Producer) sendOnce(messages []*Message) (retry []*Message, fatal int, lastErr error) {
+	partitions := make(map[partitionKey][]*Message)
+	for _, message := range messages {
+		leaders, err := p.getLeaders(message.Topic)
+		if err != nil {
+			lastErr = err
+			retry = append(retry, message)
+			continue
+		}
+		key := partitionKey{topic: message.Topic, partition: p.partition(message, len(leaders))}
+		partitions[key] = append(partitions[key], message)
+	}
+
+	// group the record batches by the leader broker, one produce request can only contain
+	// one record batch for every partition, so that the oversized batch is split into rounds
+	rounds := make(map[int32][]map[partitionKey][]*Message)
+	for key, partitionMessages := range partitions {
+		leader := p.leaders[key.topic][key.partition]
+		for round, chunk := range p.splitBatch(partitionMessages) {
+			for len(rounds[leader]) <= round {
+				rounds[leader] = append(rounds[leader], make(map[partitionKey][]*Message))
+			}
+			rounds[leader][round][key] = chunk
+		}
+	}
+	for leader, leaderRounds := range rounds {
+		for _, round := range leaderRounds {
+			roundRetry, roundFatal, err := p.produce(leader, round)
+			if err != nil {
+				lastErr = err
+			}
+			retry = append(retry, roundRetry...)
+			fatal += roundFatal
+		}
+	}
+	return
+}
+
+func (p *Producer) splitBatch(messages []*Message) (chunks [][]*Message) {
+	start, size := 0, 0
+	for index, message := range messages {
+		messageSize := len(message.Key) + len(message.Value) + 32
+		if index > start && size+messageSize > p.config.MaxBatchBytes {
+			chunks = append(chunks, messages[start:index])
+			start, size = index, 0
+		}
+		size += messageSize
+	}
+	return append(chunks, messages[start:])
+}
+
+func (p *Producer) produce(leader int32, batches map[partitionKey][]*Message) (retry []*Message, fatal int, err error) {
+	request := &produceRequest{
+		acks:    p.config.RequiredAcks,
+		timeout: p.config.Timeout,
+		batches: make(map[string]map[int32][]byte),
+	}
+	now := time.Now()
+	for key, messages := range batches {
+		if _, ok := request.batches[key.topic]; !ok {
+			request.batches[key.topic] = make(map[int32][]byte)
+		}
+		request.batches[key.topic][key.partition] = encodeRecordBatch(messages, now)
+	}
+	allMessages := func() (result []*Message) {
+		for _, messages := range batches {
+			result = append(result, messages...)
+		}
+		return
+	}
+	addr, ok := p.brokers[leader]
+	if !ok {
+		return allMessages(), 0, errors.New("kafka: unknown leader broker id: " + strconv.Itoa(int(leader)))
+	}
+	body := &encoder{}
+	encodeProduceRequest(body, request)
+	d, err := p.request(addr, apiKeyProduce, produceVersion, body, p.config.RequiredAcks != 0)
+	if err != nil {
+		return allMessages(), 0, err
+	}
+	if p.config.RequiredAcks == 0 {
+		return nil, 0, nil
+	}
+	errCodes, err := decodeProduceResponse(d)
+	if err != nil {
+		p.closeConn(addr)
+		return allMessages(), 0, err
+	}
+	for key, messages := range batches {
+		errCode, ok := errCodes[key.topic][key.partition]
+		if !ok {
+			errCode = 13
+		}
+		if errCode == 0 {
+			continue
+		}
+		err = errors.New("kafka: failed to produce to topic " + key.topic + ", partition " +
+			strconv.Itoa(int(key.partition)) + ", error code: " + strconv.Itoa(int(errCode)))
+		if retryableErrCodes[errCode] {
+			retry = append(retry, messages...)
+		} else {
+			fatal += len(messages)
+		}
+	}
+	return retry, fatal, err
+}
+
+// partition chooses the partition by the murmur2 hash of the key like the java client does,
+// the messages without key are distributed by round robin
+func (p *Producer) partition(message *Message, count int) int32 {
+	if len(message.Key) == 0 {
+		p.roundRobin++
+		return int32(p.roundRobin % uint32(count))
+	}
+	return int32((murmur2(message.Key) & 0x7fffffff) % uint32(count))
+}
+
+func (p *Producer) getLeaders(topic string) ([]int32, error) {
+	if leaders, ok := p.leaders[topic]; ok {
+		return leaders, nil
+	}
+	if err := p.refreshMetadata(topic); err != nil {
+		return nil, err
+	}
+	if leaders, ok := p.leaders[topic]; ok {
+		return leaders, nil
+	}
+	return nil, errors.New("kafka: the leaders of topic " + topic + " are not available")
+}
+
+func (p *Producer) refreshMetadata(topic string) (err error) {
+	addrs := append([]string{}, p.config.Brokers...)
+	for _, addr := range p.brokers {
+		addrs = append(addrs, addr)
+	}
+	for _, addr := range addrs {
+		body := &encoder{}
+		encodeMetadataRequest(body, []string{topic})
+		var d *decoder
+		d, err = p.request(addr, apiKeyMetadata, metadataVersion, body, true)
+		if err != nil {
+			continue
+		}
+		var response *metadataResponse
+		response, err = decodeMetadataResponse(d)
+		if err != nil {
+			p.closeConn(addr)
+			continue
+		}
+		for _, broker := range response.brokers {
+			p.brokers[broker.id] = broker.addr
+		}
+		for _, topicMeta := range response.topics {
+			if topicMeta.errCode != 0 || len(topicMeta.partitions) == 0 {
+				return errors.New("kafka: failed to get the metadata of topic " + topicMeta.name +
+					", error code: " + strconv.Itoa(int(topicMeta.errCode)))
+			}
+			leaders := make([]int32, len(topicMeta.partitions))
+			for _, partition := range topicMeta.partitions {
+				if partition.errCode != 0 || partition.id < 0 || int(partition.id) >= len(leaders) {
+					return errors.New("kafka: the partition " + strconv.Itoa(int(partition.id)) + " of topic " +
+						topicMeta.name + " is not available, error code: " + strconv.Itoa(int(partition.errCode)))
+				}
+				leaders[partition.id] = partition.leader
+			}
+			p.leaders[topicMeta.name] = leaders
+		}
+		return nil
+	}
+	return
+}
+
+// request sends a request to the broker and reads its response if needed
+func (p *Producer) request(addr string, apiKey int16, apiVersion int16, body *encoder,
+	hasResponse bool) (*decoder, error) {
+	conn, err := p.getConn(addr)
+	if err != nil {
+		return nil, err
+	}
+	d, err := p.roundTrip(conn, apiKey, apiVersion, body, hasResponse)
+	if err != nil {
+		p.closeConn(addr)
+		return nil, err
+	}
+	return d, nil
+}
+
+func (p *Producer) roundTrip(conn *brokerConn, apiKey int16, apiVersion int16, body *encoder,
+	hasResponse bool) (*decoder, error) {
+	p.correlationId++
+	request := &encoder{}
+	encodeRequestHeader(request, apiKey, apiVersion, p.correlationId, p.config.ClientId)
+	request.buf.Write(body.buf.Bytes())
+	packet := &encoder{}
+	packet.putBytes(request.buf.Bytes())
+
+	conn.conn.SetDeadline(time.Now().Add(p.config.Timeout + 5*time.Second))
+	if _, err := conn.conn.Write(packet.buf.Bytes()); err != nil {
+		return nil, err
+	}
+	if !hasResponse {
+		return nil, nil
+	}
+	var sizeBuf [4]byte
+	if _, err := io.ReadFull(conn.conn, sizeBuf[:]); err != nil {
+		return nil, err
+	}
+	size := int32(binary.BigEndian.Uint32(sizeBuf[:]))
+	if size < 4 || size > 100*1024*1024 {
+		return nil, errors.New("kafka: invalid response size: " + strconv.Itoa(int(size)))
+	}
+	response := make([]byte, size)
+	if _, err := io.ReadFull(conn.conn, response); err != nil {
+		return nil, err
+	}
+	d := &decoder{data: response}
+	if correlationId := d.getInt32(); correlationId != p.correlationId {
+		return nil, errors.New("kafka: correlation id mismatch, expected " + strconv.Itoa(int(p.correlationId)) +
+			", got " + strconv.Itoa(int(correlationId)))
+	}
+	return d, nil
+}
+
+func (p *Producer) getConn(addr string) (*brokerConn, error) {
+	if conn, ok := p.conns[addr]; ok {
+		return conn, nil
+	}
+	dialer := &net.Dialer{Timeout: p.config.Timeout}
+	var netConn net.Conn
+	var err error
+	if p.tlsConfig != nil {
+		tlsConfig := p.tlsConfig.Clone()
+		if host, _, err := net.SplitHostPort(addr); err == nil && tlsConfig.ServerName == "" {
+			tlsConfig.ServerName = host
+		}
+		netConn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		netConn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn := &brokerConn{conn: netConn, addr: addr}
+	if p.config.SaslUser != "" {
+		if err := p.saslAuthenticate(conn); err != nil {
+			netConn.Close()
+			return nil, err
+		}
+	}
+	p.conns[addr] = conn
+	return conn, nil
+}
+
+func (p *Producer) closeConn(addr string) {
+	if conn, ok := p.conns[addr]; ok {
+		conn.conn.Close()
+		delete(p.conns, addr)
+	}
+}
+
+// saslAuthenticate authenticates the connection with the SASL/PLAIN mechanism
+func (p *Producer) saslAuthenticate(conn *brokerConn) error {
+	body := &encoder{}
+	body.putString("PLAIN")
+	d, err := p.roundTrip(conn, apiKeySaslHandshake, saslHandshakeVersion, body, true)
+	if err != nil {
+		return err
+	}
+	if errCode := d.getInt16(); d.err != nil || errCode != 0 {
+		return errors.New("kafka: the SASL/PLAIN mechanism is not enabled on broker " + conn.addr)
+	}
+	body = &encoder{}
+	body.putBytes([]byte("\x00" + p.config.SaslUser + "\x00" + p.config.SaslPassword))
+	d, err = p.roundTrip(conn, apiKeySaslAuthenticate, saslAuthenticateVersion, body, true)
+	if err != nil {
+		return err
+	}
+	errCode := d.getInt16()
+	errMsg := d.getString()
+	if d.err != nil {
+		return d.err
+	}
+	if errCode != 0 {
+		return errors.New("kafka: failed to authenticate with broker " + conn.addr + ": " + errMsg)
+	}
+	return nil
+}
+
+func joinHostPort(host string, port int32) string {
+	return net.JoinHostPort(host, strconv.Itoa(int(port)))
+}
+
+// murmur2 is the hash function used by the default partitioner of the java client
+func murmur2(data []byte) uint32 {
+	length := len(data)
+	const (
+		seed = uint32(0x9747b28c)
+		m    = uint32(0x5bd1e995)
+		r    = 24
+	)
+	h := seed ^ uint32(length)
+	for i := 0; i+4 <= length; i += 4 {
+		k := binary.LittleEndian.Uint32(data[i:])
+		k *= m
+		k ^= k >> r
+		k *= m
+		h *= m
+		h ^= k
+	}
+	tail := length &^ 3
+	switch length & 3 {
+	case 3:
+		h ^= uint32(data[tail+2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(data[tail+1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(data[tail])
+		h *= m
+	}
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return h
+}
@@ -0,0 +1,372 @@
+//Copyright 2017-2018 Baidu Inc.
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http: //www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+
+package kafka
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeBroker is a single broker cluster, it is the leader of every partition of the topics
+type fakeBroker struct {
+	t          *testing.T
+	listener   net.Listener
+	partitions int32
+	mutex      sync.Mutex
+	// errCodes are the error codes of the produce requests in turn, the rest of requests succeed
+	errCodes         []int16
+	metadataRequests int
+	records          map[int32][]*testRecord
+	clientIds        map[string]bool
+}
+
+func newFakeBroker(t *testing.T, partitions int32, errCodes ...int16) *fakeBroker {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	broker := &fakeBroker{
+		t:          t,
+		listener:   listener,
+		partitions: partitions,
+		errCodes:   errCodes,
+		records:    make(map[int32][]*testRecord),
+		clientIds:  make(map[string]bool),
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go broker.serve(conn)
+		}
+	}()
+	return broker
+}
+
+func (broker *fakeBroker) addr() string {
+	return broker.listener.Addr().String()
+}
+
+func (broker *fakeBroker) close() {
+	broker.listener.Close()
+}
+
+func (broker *fakeBroker) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		var sizeBuf [4]byte
+		if _, err := io.ReadFull(conn, sizeBuf[:]); err != nil {
+			return
+		}
+		request := make([]byte, binary.BigEndian.Uint32(sizeBuf[:]))
+		if _, err := io.ReadFull(conn, request); err != nil {
+			return
+		}
+		d := &decoder{data: request}
+		apiKey, _, correlationId, clientId := d.getInt16(), d.getInt16(), d.getInt32(), d.getString()
+		response := &encoder{}
+		response.putInt32(correlationId)
+		broker.mutex.Lock()
+		broker.clientIds[clientId] = true
+		switch apiKey {
+		case apiKeyMetadata:
+			broker.metadataRequests++
+			topics := make([]*topicMetadata, 0)
+			for i, count := 0, d.getArrayLength(); i < count; i++ {
+				topic := &topicMetadata{name: d.getString()}
+				for partition := int32(0); partition < broker.partitions; partition++ {
+					topic.partitions = append(topic.partitions, &partitionMetadata{id: partition, leader: 1})
+				}
+				topics = append(topics, topic)
+			}
+			encodeTestMetadataResponse(response, []*brokerMetadata{{id: 1, addr: broker.addr()}}, topics)
+		case apiKeyProduce:
+			produce := decodeTestProduceRequest(broker.t, d)
+			var errCode int16
+			if len(broker.errCodes) > 0 {
+				errCode, broker.errCodes = broker.errCodes[0], broker.errCodes[1:]
+			}
+			errCodes := make(map[string]map[int32]int16)
+			for topic, batches := range produce.batches {
+				errCodes[topic] = make(map[int32]int16)
+				for partition, batch := range batches {
+					errCodes[topic][partition] = errCode
+					if errCode == 0 {
+						records, _ := decodeTestRecordBatch(broker.t, batch)
+						broker.records[partition] = append(broker.records[partition], records...)
+					}
+				}
+			}
+			if produce.acks == 0 {
+				broker.mutex.Unlock()
+				continue
+			}
+			encodeTestProduceResponse(response, errCodes)
+		}
+		broker.mutex.Unlock()
+		packet := &encoder{}
+		packet.putBytes(response.buf.Bytes())
+		if _, err := conn.Write(packet.buf.Bytes()); err != nil {
+			return
+		}
+	}
+}
+
+func (broker *fakeBroker) receivedValues() map[string]int32 {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	values := make(map[string]int32)
+	for partition, records := range broker.records {
+		for _, record := range records {
+			values[string(record.value)] = partition
+		}
+	}
+	return values
+}
+
+func encodeTestMetadataResponse(e *encoder, brokers []*brokerMetadata, topics []*topicMetadata) {
+	e.putInt32(int32(len(brokers)))
+	for _, broker := range brokers {
+		host, port, _ := net.SplitHostPort(broker.addr)
+		portNumber, _ := strconv.Atoi(port)
+		e.putInt32(broker.id)
+		e.putString(host)
+		e.putInt32(int32(portNumber))
+		e.putNullString()
+	}
+	e.putInt32(1)
+	e.putInt32(int32(len(topics)))
+	for _, topic := range topics {
+		e.putInt16(topic.errCode)
+		e.putString(topic.name)
+		e.putInt8(0)
+		e.putInt32(int32(len(topic.partitions)))
+		for _, partition := range topic.partitions {
+			e.putInt16(partition.errCode)
+			e.putInt32(partition.id)
+			e.putInt32(partition.leader)
+			e.putInt32(1)
+			e.putInt32(partition.leader)
+			e.putInt32(1)
+			e.putInt32(partition.leader)
+		}
+	}
+}
+
+func decodeTestProduceRequest(t *testing.T, d *decoder) *produceRequest {
+	request := &produceRequest{batches: make(map[string]map[int32][]byte)}
+	if transactionalId := d.getInt16(); transactionalId != -1 {
+		t.Errorf("unexpected transactional id length: %d", transactionalId)
+	}
+	request.acks = d.getInt16()
+	request.timeout = time.Duration(d.getInt32()) * time.Millisecond
+	for i, topicCount := 0, d.getArrayLength(); i < topicCount; i++ {
+		topic := d.getString()
+		request.batches[topic] = make(map[int32][]byte)
+		for j, partitionCount := 0, d.getArrayLength(); j < partitionCount; j++ {
+			partition := d.getInt32()
+			request.batches[topic][partition] = d.getBytes()
+		}
+	}
+	if d.err != nil || d.off != len(d.data) {
+		t.Fatalf("failed to decode produce request: %v", d.err)
+	}
+	return request
+}
+
+func encodeTestProduceResponse(e *encoder, errCodes map[string]map[int32]int16) {
+	e.putInt32(int32(len(errCodes)))
+	for topic, partitions := range errCodes {
+		e.putString(topic)
+		e.putInt32(int32(len(partitions)))
+		for partition, errCode := range partitions {
+			e.putInt32(partition)
+			e.putInt16(errCode)
+			e.putInt64(0)
+			e.putInt64(-1)
+		}
+	}
+	e.putInt32(0)
+}
+
+func newTestProducer(t *testing.T, broker *fakeBroker, acks int16) *Producer {
+	producer, err := NewProducer(&Config{
+		Brokers:       []string{broker.addr()},
+		RequiredAcks:  acks,
+		MaxRetries:    2,
+		RetryInterval: time.Millisecond,
+		Timeout:       time.Second,
+		MaxBatchBytes: 100,
+		ClientId:      "rasp-cloud-test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return producer
+}
+
+func testMessages(keys ...string) []*Message {
+	messages := make([]*Message, 0, len(keys))
+	for index, key := range keys {
+		message := &Message{Topic: "alarm", Value: []byte("alarm-" + strconv.Itoa(index))}
+		if key != "" {
+			message.Key = []byte(key)
+		}
+		messages = append(messages, message)
+	}
+	return messages
+}
+
+func TestProducerSendMessages(t *testing.T) {
+	broker := newFakeBroker(t, 3)
+	defer broker.close()
+	producer := newTestProducer(t, broker, -1)
+	defer producer.Close()
+
+	// the batches are split by MaxBatchBytes, so that the produce requests are sent in several rounds
+	messages := testMessages("app1", "app1", "app1", "app2", "", "", "")
+	sent, err := producer.SendMessages(messages)
+	if err != nil || sent != len(messages) {
+		t.Fatalf("expect %d messages to be sent, got %d, %v", len(messages), sent, err)
+	}
+	values := broker.receivedValues()
+	if len(values) != len(messages) {
+		t.Fatalf("expect %d messages to be received, got %v", len(messages), values)
+	}
+	for index, message := range messages {
+		if message.Key == nil {
+			continue
+		}
+		expected := int32((murmur2(message.Key) & 0x7fffffff) % 3)
+		if partition := values[string(message.Value)]; partition != expected {
+			t.Errorf("expect message %d to be sent to partition %d, got %d", index, expected, partition)
+		}
+	}
+	if !broker.clientIds["rasp-cloud-test"] || broker.metadataRequests != 1 {
+		t.Errorf("unexpected client ids or metadata requests: %v, %d", broker.clientIds, broker.metadataRequests)
+	}
+}
+
+func TestProducerRetry(t *testing.T) {
+	// NOT_LEADER_FOR_PARTITION is retried with the refreshed metadata
+	broker := newFakeBroker(t, 1, 6, 6)
+	defer broker.close()
+	producer := newTestProducer(t, broker, 1)
+	defer producer.Close()
+	sent, err := producer.SendMessages(testMessages("app1"))
+	if err != nil || sent != 1 || len(broker.receivedValues()) != 1 {
+		t.Fatalf("expect the message to be sent after retries, got %d, %v", sent, err)
+	}
+	if broker.metadataRequests != 3 {
+		t.Errorf("expect the metadata to be refreshed before every retry, got %d requests", broker.metadataRequests)
+	}
+}
+
+func TestProducerBackoffUnlocked(t *testing.T) {
+	broker := newFakeBroker(t, 1, 6)
+	defer broker.close()
+	producer := newTestProducer(t, broker, 1)
+	defer producer.Close()
+	producer.config.RetryInterval = 500 * time.Millisecond
+	done := make(chan error, 1)
+	go func() {
+		_, err := producer.SendMessages(testMessages("app1"))
+		done <- err
+	}()
+	// the first request fails, the other sender must not wait for the backoff of the retry
+	time.Sleep(50 * time.Millisecond)
+	start := time.Now()
+	if sent, err := producer.SendMessages(testMessages("app2")); err != nil || sent != 1 {
+		t.Fatalf("expect the message to be sent, got %d, %v", sent, err)
+	}
+	if elapsed := time.Since(start); elapsed > 250*time.Millisecond {
+		t.Errorf("the sender is blocked by the backoff of another sender for %v", elapsed)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("expect the message to be sent after retry, got %v", err)
+	}
+}
+
+func TestProducerFailure(t *testing.T) {
+	// MESSAGE_TOO_LARGE is not retried
+	broker := newFakeBroker(t, 1, 10)
+	defer broker.close()
+	producer := newTestProducer(t, broker, -1)
+	defer producer.Close()
+	if sent, err := producer.SendMessages(testMessages("app1")); err == nil || sent != 0 {
+		t.Errorf("expect the message to be failed, got %d, %v", sent, err)
+	}
+	if broker.metadataRequests != 1 {
+		t.Errorf("expect the message not to be retried, got %d metadata requests", broker.metadataRequests)
+	}
+
+	// the retryable errors are failed after MaxRetries
+	broker = newFakeBroker(t, 1, 6, 6, 6)
+	defer broker.close()
+	producer = newTestProducer(t, broker, -1)
+	defer producer.Close()
+	if sent, err := producer.SendMessages(testMessages("app1", "app2")); err == nil || sent != 0 {
+		t.Errorf("expect the messages to be failed after retries, got %d, %v", sent, err)
+	}
+
+	broker.close()
+	producer = newTestProducer(t, broker, -1)
+	if sent, err := producer.SendMessages(testMessages("app1")); err == nil || sent != 0 {
+		t.Errorf("expect the error of unavailable broker, got %d, %v", sent, err)
+	}
+}
+
+func TestProducerWithoutAcks(t *testing.T) {
+	broker := newFakeBroker(t, 1)
+	defer broker.close()
+	producer := newTestProducer(t, broker, 0)
+	defer producer.Close()
+	if sent, err := producer.SendMessages(testMessages("app1", "app2")); err != nil || sent != 2 {
+		t.Fatalf("expect 2 messages to be sent, got %d, %v", sent, err)
+	}
+	// the produce requests have no response, the metadata request of next topic is served after them
+	if sent, err := producer.SendMessages([]*Message{{Topic: "other", Value: []byte("other")}}); err != nil ||
+		sent != 1 {
+		t.Fatalf("expect the message to be sent, got %d, %v", sent, err)
+	}
+	// the last produce request is not acknowledged, so that it is waited for
+	deadline := time.Now().Add(time.Second)
+	for len(broker.receivedValues()) < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if values := broker.receivedValues(); len(values) != 3 {
+		t.Errorf("expect 3 messages to be received, got %v", values)
+	}
+}
+
+func TestNewProducer(t *testing.T) {
+	if _, err := NewProducer(&Config{}); err == nil {
+		t.Error("expect the error of empty brokers")
+	}
+	if _, err := NewProducer(&Config{Brokers: []string{"127.0.0.1:9092"}, RequiredAcks: 2}); err == nil {
+		t.Error("expect the error of invalid required acks")
+	}
+	if _, err := NewProducer(&Config{Brokers: []string{"127.0.0.1:9092"}, TlsEnable: true,
+		TlsCaFile: "not-exist.pem"}); err == nil {
+		t.Error("expect the error of missing ca file")
+	}
+}
@@ -0,0 +1,319 @@
+//Copyright 2017-2018 Baidu Inc.
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http: //www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+
+package kafka
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"time"
+)
+
+// the subset of the kafka protocol used by the producer, it requires kafka 1.0 or later
+const (
+	apiKeyProduce          = 0
+	apiKeyMetadata         = 3
+	apiKeySaslHandshake    = 17
+	apiKeySaslAuthenticate = 36
+
+	produceVersion          = 3
+	metadataVersion         = 1
+	saslHandshakeVersion    = 1
+	saslAuthenticateVersion = 0
+
+	recordBatchMagic = 2
+)
+
+var (
+	errShortBuffer = errors.New("kafka: insufficient data to decode the response")
+	crc32cTable    = crc32.MakeTable(crc32.Castagnoli)
+)
+
+type encoder struct {
+	buf bytes.Buffer
+}
+
+func (e *encoder) putInt8(v int8) {
+	e.buf.WriteByte(byte(v))
+}
+
+func (e *encoder) putInt16(v int16) {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], uint16(v))
+	e.buf.Write(b[:])
+}
+
+func (e *encoder) putInt32(v int32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(v))
+	e.buf.Write(b[:])
+}
+
+func (e *encoder) putInt64(v int64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(v))
+	e.buf.Write(b[:])
+}
+
+func (e *encoder) putVarint(v int64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(b[:], v)
+	e.buf.Write(b[:n])
+}
+
+func (e *encoder) putString(v string) {
+	e.putInt16(int16(len(v)))
+	e.buf.WriteString(v)
+}
+
+func (e *encoder) putNullString() {
+	e.putInt16(-1)
+}
+
+func (e *encoder) putBytes(v []byte) {
+	e.putInt32(int32(len(v)))
+	e.buf.Write(v)
+}
+
+func (e *encoder) putVarintBytes(v []byte) {
+	if v == nil {
+		e.putVarint(-1)
+		return
+	}
+	e.putVarint(int64(len(v)))
+	e.buf.Write(v)
+}
+
+type decoder struct {
+	data []byte
+	off  int
+	err  error
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || d.off+n > len(d.data) {
+		d.err = errShortBuffer
+		return nil
+	}
+	b := d.data[d.off : d.off+n]
+	d.off += n
+	return b
+}
+
+func (d *decoder) getInt8() int8 {
+	if b := d.next(1); b != nil {
+		return int8(b[0])
+	}
+	return 0
+}
+
+func (d *decoder) getInt16() int16 {
+	if b := d.next(2); b != nil {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (d *decoder) getInt32() int32 {
+	if b := d.next(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (d *decoder) getInt64() int64 {
+	if b := d.next(8); b != nil {
+		return int64(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+func (d *decoder) getString() string {
+	length := d.getInt16()
+	if length < 0 {
+		return ""
+	}
+	return string(d.next(int(length)))
+}
+
+func (d *decoder) getBytes() []byte {
+	length := d.getInt32()
+	if length < 0 {
+		return nil
+	}
+	return d.next(int(length))
+}
+
+func (d *decoder) getArrayLength() int {
+	length := d.getInt32()
+	if length < 0 {
+		return 0
+	}
+	if int(length) > len(d.data)-d.off {
+		d.err = errShortBuffer
+		return 0
+	}
+	return int(length)
+}
+
+func encodeRequestHeader(e *encoder, apiKey int16, apiVersion int16, correlationId int32, clientId string) {
+	e.putInt16(apiKey)
+	e.putInt16(apiVersion)
+	e.putInt32(correlationId)
+	e.putString(clientId)
+}
+
+// encodeRecordBatch encodes the messages to a v2 record batch without compression
+func encodeRecordBatch(messages []*Message, now time.Time) []byte {
+	timestamp := now.UnixNano() / int64(time.Millisecond)
+	records := &encoder{}
+	for index, message := range messages {
+		record := &encoder{}
+		record.putInt8(0)
+		record.putVarint(0)
+		record.putVarint(int64(index))
+		record.putVarintBytes(message.Key)
+		record.putVarintBytes(message.Value)
+		record.putVarint(0)
+		records.putVarint(int64(record.buf.Len()))
+		records.buf.Write(record.buf.Bytes())
+	}
+
+	body := &encoder{}
+	body.putInt16(0)
+	body.putInt32(int32(len(messages) - 1))
+	body.putInt64(timestamp)
+	body.putInt64(timestamp)
+	body.putInt64(-1)
+	body.putInt16(-1)
+	body.putInt32(-1)
+	body.putInt32(int32(len(messages)))
+	body.buf.Write(records.buf.Bytes())
+
+	batch := &encoder{}
+	batch.putInt64(0)
+	batch.putInt32(int32(4 + 1 + 4 + body.buf.Len()))
+	batch.putInt32(-1)
+	batch.putInt8(recordBatchMagic)
+	batch.putInt32(int32(crc32.Checksum(body.buf.Bytes(), crc32cTable)))
+	batch.buf.Write(body.buf.Bytes())
+	return batch.buf.Bytes()
+}
+
+type brokerMetadata struct {
+	id   int32
+	addr string
+}
+
+type partitionMetadata struct {
+	errCode int16
+	id      int32
+	leader  int32
+}
+
+type topicMetadata struct {
+	errCode    int16
+	name       string
+	partitions []*partitionMetadata
+}
+
+type metadataResponse struct {
+	brokers []*brokerMetadata
+	topics  []*topicMetadata
+}
+
+func encodeMetadataRequest(e *encoder, topics []string) {
+	e.putInt32(int32(len(topics)))
+	for _, topic := range topics {
+		e.putString(topic)
+	}
+}
+
+func decodeMetadataResponse(d *decoder) (*metadataResponse, error) {
+	response := &metadataResponse{}
+	brokerCount := d.getArrayLength()
+	for i := 0; i < brokerCount; i++ {
+		broker := &brokerMetadata{id: d.getInt32()}
+		host := d.getString()
+		port := d.getInt32()
+		d.getString()
+		broker.addr = joinHostPort(host, port)
+		response.brokers = append(response.brokers, broker)
+	}
+	d.getInt32()
+	topicCount := d.getArrayLength()
+	for i := 0; i < topicCount; i++ {
+		topic := &topicMetadata{errCode: d.getInt16(), name: d.getString()}
+		d.getInt8()
+		partitionCount := d.getArrayLength()
+		for j := 0; j < partitionCount; j++ {
+			partition := &partitionMetadata{errCode: d.getInt16(), id: d.getInt32(), leader: d.getInt32()}
+			for k, replicaCount := 0, d.getArrayLength(); k < replicaCount; k++ {
+				d.getInt32()
+			}
+			for k, isrCount := 0, d.getArrayLength(); k < isrCount; k++ {
+				d.getInt32()
+			}
+			topic.partitions = append(topic.partitions, partition)
+		}
+		response.topics = append(response.topics, topic)
+	}
+	return response, d.err
+}
+
+// produceRequest contains at most one record batch for every partition
+type produceRequest struct {
+	acks    int16
+	timeout time.Duration
+	batches map[string]map[int32][]byte
+}
+
+func encodeProduceRequest(e *encoder, request *produceRequest) {
+	e.putNullString()
+	e.putInt16(request.acks)
+	e.putInt32(int32(request.timeout / time.Millisecond))
+	e.putInt32(int32(len(request.batches)))
+	for topic, partitions := range request.batches {
+		e.putString(topic)
+		e.putInt32(int32(len(partitions)))
+		for partition, batch := range partitions {
+			e.putInt32(partition)
+			e.putBytes(batch)
+		}
+	}
+}
+
+// decodeProduceResponse returns the error code of every partition
+func decodeProduceResponse(d *decoder) (map[string]map[int32]int16, error) {
+	result := make(map[string]map[int32]int16)
+	topicCount := d.getArrayLength()
+	for i := 0; i < topicCount; i++ {
+		topic := d.getString()
+		result[topic] = make(map[int32]int16)
+		partitionCount := d.getArrayLength()
+		for j := 0; j < partitionCount; j++ {
+			partition := d.getInt32()
+			result[topic][partition] = d.getInt16()
+			d.getInt64()
+			d.getInt64()
+		}
+	}
+	d.getInt32()
+	return result, d.err
+}
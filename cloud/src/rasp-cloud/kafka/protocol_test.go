@@ -0,0 +1,260 @@
+//Copyright 2017-2018 Baidu Inc.
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http: //www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+
+package kafka
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+	"time"
+)
+
+type testRecord struct {
+	key   []byte
+	value []byte
+}
+
+// getVarint is only required to decode the record batches sent by producer in tests
+func (d *decoder) getVarint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.data[d.off:])
+	if n <= 0 {
+		d.err = errShortBuffer
+		return 0
+	}
+	d.off += n
+	return v
+}
+
+func (d *decoder) getVarintBytes() []byte {
+	length := d.getVarint()
+	if length < 0 {
+		return nil
+	}
+	return d.next(int(length))
+}
+
+// decodeTestRecordBatch decodes a record batch and validates its header and crc
+func decodeTestRecordBatch(t *testing.T, batch []byte) (records []*testRecord, timestamp int64) {
+	d := &decoder{data: batch}
+	if baseOffset := d.getInt64(); baseOffset != 0 {
+		t.Errorf("unexpected base offset: %d", baseOffset)
+	}
+	if length := d.getInt32(); int(length) != len(batch)-12 {
+		t.Errorf("unexpected batch length: %d, the size of batch is %d", length, len(batch))
+	}
+	d.getInt32()
+	if magic := d.getInt8(); magic != recordBatchMagic {
+		t.Errorf("unexpected magic: %d", magic)
+	}
+	crc := uint32(d.getInt32())
+	if d.err == nil && crc != crc32.Checksum(d.data[d.off:], crc32cTable) {
+		t.Errorf("the crc of record batch mismatches")
+	}
+	if attributes := d.getInt16(); attributes != 0 {
+		t.Errorf("unexpected attributes: %d", attributes)
+	}
+	lastOffsetDelta := d.getInt32()
+	timestamp = d.getInt64()
+	if maxTimestamp := d.getInt64(); maxTimestamp != timestamp {
+		t.Errorf("unexpected max timestamp: %d", maxTimestamp)
+	}
+	if producerId, epoch, sequence := d.getInt64(), d.getInt16(), d.getInt32(); producerId != -1 ||
+		epoch != -1 || sequence != -1 {
+		t.Errorf("unexpected idempotent fields: %d, %d, %d", producerId, epoch, sequence)
+	}
+	count := d.getInt32()
+	if lastOffsetDelta != count-1 {
+		t.Errorf("unexpected last offset delta: %d, the count of records is %d", lastOffsetDelta, count)
+	}
+	for i := int32(0); i < count && d.err == nil; i++ {
+		length := d.getVarint()
+		start := d.off
+		d.getInt8()
+		if timestampDelta := d.getVarint(); timestampDelta != 0 {
+			t.Errorf("unexpected timestamp delta: %d", timestampDelta)
+		}
+		if offsetDelta := d.getVarint(); offsetDelta != int64(i) {
+			t.Errorf("unexpected offset delta: %d", offsetDelta)
+		}
+		record := &testRecord{key: d.getVarintBytes(), value: d.getVarintBytes()}
+		if headers := d.getVarint(); headers != 0 {
+			t.Errorf("unexpected header count: %d", headers)
+		}
+		if int64(d.off-start) != length {
+			t.Errorf("unexpected record length: %d", length)
+		}
+		records = append(records, record)
+	}
+	if d.err != nil || d.off != len(batch) {
+		t.Fatalf("failed to decode record batch: %v", d.err)
+	}
+	return
+}
+
+func TestEncoderDecoder(t *testing.T) {
+	e := &encoder{}
+	e.putInt8(-2)
+	e.putInt16(-300)
+	e.putInt32(70000)
+	e.putInt64(-1 << 40)
+	e.putString("openrasp")
+	e.putNullString()
+	e.putBytes([]byte("value"))
+	e.putVarint(-12345)
+	e.putVarintBytes(nil)
+	e.putVarintBytes([]byte("key"))
+
+	d := &decoder{data: e.buf.Bytes()}
+	if v := d.getInt8(); v != -2 {
+		t.Errorf("unexpected int8: %d", v)
+	}
+	if v := d.getInt16(); v != -300 {
+		t.Errorf("unexpected int16: %d", v)
+	}
+	if v := d.getInt32(); v != 70000 {
+		t.Errorf("unexpected int32: %d", v)
+	}
+	if v := d.getInt64(); v != -1<<40 {
+		t.Errorf("unexpected int64: %d", v)
+	}
+	if v := d.getString(); v != "openrasp" {
+		t.Errorf("unexpected string: %s", v)
+	}
+	if v := d.getString(); v != "" {
+		t.Errorf("unexpected null string: %s", v)
+	}
+	if v := d.getBytes(); string(v) != "value" {
+		t.Errorf("unexpected bytes: %s", v)
+	}
+	if v := d.getVarint(); v != -12345 {
+		t.Errorf("unexpected varint: %d", v)
+	}
+	if v := d.getVarintBytes(); v != nil {
+		t.Errorf("unexpected null bytes: %s", v)
+	}
+	if v := d.getVarintBytes(); string(v) != "key" {
+		t.Errorf("unexpected varint bytes: %s", v)
+	}
+	if d.err != nil || d.off != len(d.data) {
+		t.Errorf("failed to decode all of the data: %v", d.err)
+	}
+	// the decoder stops at the first error
+	if d.getInt32(); d.err != errShortBuffer {
+		t.Errorf("expect the short buffer error, got %v", d.err)
+	}
+	d = &decoder{data: []byte{0, 0, 0, 100}}
+	if length := d.getArrayLength(); length != 0 || d.err != errShortBuffer {
+		t.Errorf("expect the oversized array length to be rejected, got %d, %v", length, d.err)
+	}
+}
+
+func TestEncodeRecordBatch(t *testing.T) {
+	now := time.Unix(1533891423, 123000000)
+	messages := []*Message{
+		{Topic: "alarm", Key: []byte("app1"), Value: []byte(`{"attack_type":"sql"}`)},
+		{Topic: "alarm", Value: []byte(`{"attack_type":"xss"}`)},
+		{Topic: "alarm", Key: []byte{}, Value: bytes.Repeat([]byte("a"), 300)},
+	}
+	records, timestamp := decodeTestRecordBatch(t, encodeRecordBatch(messages, now))
+	if timestamp != 1533891423123 {
+		t.Errorf("unexpected timestamp: %d", timestamp)
+	}
+	if len(records) != len(messages) {
+		t.Fatalf("expect %d records, got %d", len(messages), len(records))
+	}
+	for index, record := range records {
+		if !bytes.Equal(record.key, messages[index].Key) || (record.key == nil) != (messages[index].Key == nil) ||
+			!bytes.Equal(record.value, messages[index].Value) {
+			t.Errorf("unexpected record %d: %q, %q", index, record.key, record.value)
+		}
+	}
+}
+
+func TestMetadataRoundTrip(t *testing.T) {
+	e := &encoder{}
+	encodeMetadataRequest(e, []string{"alarm"})
+	d := &decoder{data: e.buf.Bytes()}
+	if count, topic := d.getArrayLength(), d.getString(); count != 1 || topic != "alarm" {
+		t.Errorf("unexpected metadata request: %d, %s", count, topic)
+	}
+
+	e = &encoder{}
+	encodeTestMetadataResponse(e, []*brokerMetadata{{id: 1, addr: "10.0.0.1:9092"}, {id: 2, addr: "10.0.0.2:9093"}},
+		[]*topicMetadata{{name: "alarm", partitions: []*partitionMetadata{{id: 0, leader: 2}, {id: 1, leader: 1}}},
+			{name: "unknown", errCode: 3}})
+	response, err := decodeMetadataResponse(&decoder{data: e.buf.Bytes()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(response.brokers) != 2 || response.brokers[1].id != 2 || response.brokers[1].addr != "10.0.0.2:9093" {
+		t.Errorf("unexpected brokers: %+v", response.brokers)
+	}
+	if len(response.topics) != 2 || response.topics[0].name != "alarm" || len(response.topics[0].partitions) != 2 ||
+		response.topics[0].partitions[0].leader != 2 || response.topics[1].errCode != 3 {
+		t.Errorf("unexpected topics: %+v", response.topics)
+	}
+	truncated := e.buf.Bytes()[:e.buf.Len()-10]
+	if _, err := decodeMetadataResponse(&decoder{data: truncated}); err != errShortBuffer {
+		t.Errorf("expect the short buffer error, got %v", err)
+	}
+}
+
+func TestProduceRoundTrip(t *testing.T) {
+	batch := encodeRecordBatch([]*Message{{Topic: "alarm", Value: []byte("value")}}, time.Now())
+	e := &encoder{}
+	encodeProduceRequest(e, &produceRequest{
+		acks:    -1,
+		timeout: 3 * time.Second,
+		batches: map[string]map[int32][]byte{"alarm": {1: batch}},
+	})
+	request := decodeTestProduceRequest(t, &decoder{data: e.buf.Bytes()})
+	if request.acks != -1 || request.timeout != 3*time.Second || !bytes.Equal(request.batches["alarm"][1], batch) {
+		t.Errorf("unexpected produce request: %+v", request)
+	}
+
+	e = &encoder{}
+	encodeTestProduceResponse(e, map[string]map[int32]int16{"alarm": {0: 0, 1: 6}})
+	errCodes, err := decodeProduceResponse(&decoder{data: e.buf.Bytes()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errCodes["alarm"]) != 2 || errCodes["alarm"][0] != 0 || errCodes["alarm"][1] != 6 {
+		t.Errorf("unexpected error codes: %v", errCodes)
+	}
+	if _, err := decodeProduceResponse(&decoder{data: e.buf.Bytes()[:8]}); err != errShortBuffer {
+		t.Errorf("expect the short buffer error, got %v", err)
+	}
+}
+
+func TestMurmur2(t *testing.T) {
+	// the hashes are the same as the Utils.murmur2 of the java client
+	cases := map[string]int32{
+		"21":                         -973932308,
+		"foobar":                     -790332482,
+		"a-little-bit-long-string":   -985981536,
+		"a-little-bit-longer-string": -1486304829,
+		"lkjh234lh9fiuh90y23oiuhsafujhadof229phr9h19h89h8": -58897971,
+		"abc": 479470107,
+	}
+	for key, hash := range cases {
+		if result := int32(murmur2([]byte(key))); result != hash {
+			t.Errorf("unexpected murmur2 hash of %s: %d, expected %d", key, result, hash)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+//Copyright 2017-2018 Baidu Inc.
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http: //www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+
+package models
+
+import (
+	"errors"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"rasp-cloud/models/logs"
+	"rasp-cloud/mongo"
+	"rasp-cloud/tools"
+	"time"
+)
+
+// AlarmComment is a comment in the triage thread of an attack alarm,
+// the status and assignee record the triage change made along with the comment
+type AlarmComment struct {
+	Id         string  `json:"id" bson:"_id"`
+	AppId      string  `json:"app_id" bson:"app_id"`
+	AlarmId    string  `json:"alarm_id" bson:"alarm_id"`
+	User       string  `json:"user" bson:"user"`
+	Content    string  `json:"content" bson:"content"`
+	Status     string  `json:"status,omitempty" bson:"status,omitempty"`
+	Assignee   *string `json:"assignee,omitempty" bson:"assignee,omitempty"`
+	CreateTime int64   `json:"create_time" bson:"create_time"`
+}
+
+const (
+	alarmCommentCollectionName = "alarm_comment"
+	maxAlarmCommentLength      = 4096
+)
+
+func init() {
+	err := mongo.CreateIndex(alarmCommentCollectionName, &mgo.Index{
+		Key:        []string{"alarm_id", "create_time"},
+		Unique:     false,
+		Background: true,
+		Name:       "alarm_id_create_time",
+	})
+	if err != nil {
+		tools.Panic(tools.ErrCodeMongoInitFailed, "failed to create alarm_id index for alarm_comment collection", err)
+	}
+	err = mongo.CreateIndex(alarmCommentCollectionName, &mgo.Index{
+		Key:        []string{"app_id"},
+		Unique:     false,
+		Background: true,
+		Name:       "app_id",
+	})
+	if err != nil {
+		tools.Panic(tools.ErrCodeMongoInitFailed, "failed to create app_id index for alarm_comment collection", err)
+	}
+}
+
+// TriageAttackAlarm updates the triage state of the alarm, and records the change with the comment in its thread
+func TriageAttackAlarm(appId string, alarmId string, triage *logs.AttackTriage, comment string) error {
+	if len(comment) > maxAlarmCommentLength {
+		return errors.New("the length of comment can not be greater than 4096")
+	}
+	user, err := GetLoginUserName()
+	if err != nil {
+		return errors.New("failed to get the login user: " + err.Error())
+	}
+	updated, err := logs.UpdateAttackTriageById(appId, alarmId, triage, user)
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return errors.New("can not find the attack alarm: " + alarmId)
+	}
+	return mongo.Insert(alarmCommentCollectionName, &AlarmComment{
+		Id:         mongo.GenerateObjectId(),
+		AppId:      appId,
+		AlarmId:    alarmId,
+		User:       user,
+		Content:    comment,
+		Status:     triage.Status,
+		Assignee:   triage.Assignee,
+		CreateTime: time.Now().Unix(),
+	})
+}
+
+// TriageAttackAlarmsByQuery updates the triage state of all the alarms matching the search data
+func TriageAttackAlarmsByQuery(appId string, startTime int64, endTime int64, query map[string]interface{},
+	triage *logs.AttackTriage) (int64, error) {
+	user, err := GetLoginUserName()
+	if err != nil {
+		return 0, errors.New("failed to get the login user: " + err.Error())
+	}
+	return logs.UpdateAttackTriageByQuery(appId, startTime, endTime, query, triage, user)
+}
+
+func AddAlarmComment(appId string, alarmId string, content string) (*AlarmComment, error) {
+	if content == "" {
+		return nil, errors.New("the comment can not be empty")
+	}
+	if len(content) > maxAlarmCommentLength {
+		return nil, errors.New("the length of comment can not be greater than 4096")
+	}
+	user, err := GetLoginUserName()
+	if err != nil {
+		return nil, errors.New("failed to get the login user: " + err.Error())
+	}
+	comment := &AlarmComment{
+		Id:         mongo.GenerateObjectId(),
+		AppId:      appId,
+		AlarmId:    alarmId,
+		User:       user,
+		Content:    content,
+		CreateTime: time.Now().Unix(),
+	}
+	err = mongo.Insert(alarmCommentCollectionName, comment)
+	if err != nil {
+		return nil, err
+	}
+	return comment, nil
+}
+
+func GetAlarmComments(alarmId string, page int, perpage int) (count int, result []*AlarmComment, err error) {
+	count, err = mongo.FindAll(alarmCommentCollectionName, bson.M{"alarm_id": alarmId}, &result,
+		perpage*(page-1), perpage, "create_time")
+	if err == nil && result == nil {
+		result = make([]*AlarmComment, 0)
+	}
+	return
+}
+
+func RemoveAlarmCommentsByAppId(appId string) error {
+	return mongo.RemoveAll(alarmCommentCollectionName, bson.M{"app_id": appId})
+}
@@ -0,0 +1,57 @@
+//Copyright 2017-2018 Baidu Inc.
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http: //www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+
+package models
+
+import (
+	"gopkg.in/mgo.v2/bson"
+	"rasp-cloud/mongo"
+	"time"
+)
+
+// alarmWatermark is the time before which the attack alarms of app have been notified,
+// it is persisted so that a restarted or newly elected instance continues from it
+type alarmWatermark struct {
+	AppId         string `bson:"_id"`
+	LastAlarmTime int64  `bson:"last_alarm_time"`
+	UpdateTime    int64  `bson:"update_time"`
+}
+
+const alarmWatermarkCollectionName = "alarm_watermark"
+
+// getAlarmWatermarks returns the last alarm time in milliseconds of each app
+func getAlarmWatermarks() (map[string]int64, error) {
+	var watermarks []alarmWatermark
+	_, err := mongo.FindAll(alarmWatermarkCollectionName, nil, &watermarks, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]int64, len(watermarks))
+	for _, watermark := range watermarks {
+		result[watermark.AppId] = watermark.LastAlarmTime
+	}
+	return result, nil
+}
+
+func setAlarmWatermark(appId string, lastAlarmTime int64) error {
+	return mongo.UpsertId(alarmWatermarkCollectionName, appId, &alarmWatermark{
+		AppId:         appId,
+		LastAlarmTime: lastAlarmTime,
+		UpdateTime:    time.Now().Unix(),
+	})
+}
+
+func RemoveAlarmWatermark(appId string) error {
+	return mongo.RemoveAll(alarmWatermarkCollectionName, bson.M{"_id": appId})
+}
@@ -0,0 +1,139 @@
+//Copyright 2017-2018 Baidu Inc.
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http: //www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+
+package models
+
+import (
+	"errors"
+	"net/url"
+	"rasp-cloud/models/logs"
+	"time"
+)
+
+// WhitelistProposal is the whitelist entry proposed for a false positive attack alarm,
+// the entry merges the hooks of the existing one with the same matchers
+type WhitelistProposal struct {
+	AlarmId string              `json:"alarm_id"`
+	Item    WhitelistConfigItem `json:"item"`
+	// whether an entry with the same matchers exists in the whitelist
+	Exists bool `json:"exists"`
+}
+
+// getWhitelistHostPath returns the host and path of the alarm url
+func getWhitelistHostPath(alarmUrl string) (string, string, error) {
+	u, err := url.Parse(alarmUrl)
+	if err != nil {
+		return "", "", err
+	}
+	if u.Host == "" {
+		return "", "", errors.New("the alarm url has no host: " + alarmUrl)
+	}
+	if len(u.Host+u.Path) > 200 {
+		return "", "", errors.New("the length of whitelist url can not be greater than 200")
+	}
+	return u.Host, u.Path, nil
+}
+
+// ProposeWhitelist proposes the whitelist entry of app for the attack alarm
+func ProposeWhitelist(app *App, alarmId string) (*WhitelistProposal, error) {
+	alarm, err := logs.GetAttackAlarmById(app.Id, alarmId)
+	if err != nil {
+		return nil, err
+	}
+	alarmUrl, _ := alarm["url"].(string)
+	host, path, err := getWhitelistHostPath(alarmUrl)
+	if err != nil {
+		return nil, err
+	}
+	attackType, _ := alarm["attack_type"].(string)
+	if attackType == "" {
+		return nil, errors.New("the attack_type of alarm can not be empty")
+	}
+	owner, err := GetLoginUserName()
+	if err != nil {
+		return nil, errors.New("failed to get the login user: " + err.Error())
+	}
+	// the agents use the attack types as the names of whitelist hooks
+	proposal := &WhitelistProposal{
+		AlarmId: alarmId,
+		Item: WhitelistConfigItem{
+			Url:    host + path,
+			Host:   host,
+			Path:   path,
+			Hook:   map[string]bool{attackType: true},
+			Owner:  owner,
+			Reason: "false positive of attack alarm " + alarmId,
+		},
+	}
+	for _, item := range app.WhitelistConfig {
+		if item.Host == "" && item.Path == "" {
+			normalizeLegacyWhitelistItem(&item)
+		}
+		if sameWhitelistMatcher(&item, &proposal.Item) {
+			proposal.Exists = true
+			for hook, enable := range item.Hook {
+				if enable {
+					proposal.Item.Hook[hook] = true
+				}
+			}
+			break
+		}
+	}
+	return proposal, nil
+}
+
+// ApplyWhitelist adds or replaces the whitelist entry with the same matchers, and marks the alarm as false positive
+func ApplyWhitelist(app *App, alarmId string, item WhitelistConfigItem, note string) (*App, error) {
+	if err := normalizeWhitelistItem(&item, time.Now().Unix()); err != nil {
+		return nil, err
+	}
+	config := make([]WhitelistConfigItem, 0, len(app.WhitelistConfig)+1)
+	replaced := false
+	for _, old := range app.WhitelistConfig {
+		if old.Host == "" && old.Path == "" {
+			normalizeLegacyWhitelistItem(&old)
+		}
+		if sameWhitelistMatcher(&old, &item) {
+			if !replaced {
+				config = append(config, item)
+				replaced = true
+			}
+			continue
+		}
+		config = append(config, old)
+	}
+	if !replaced {
+		config = append(config, item)
+	}
+	if len(config) > 200 {
+		return nil, errors.New("the count of whitelist config items can not be greater than 200")
+	}
+	if err := NormalizeWhitelistConfig(config); err != nil {
+		return nil, err
+	}
+	app, err := UpdateWhiteListConfig(app.Id, config)
+	if err != nil {
+		return nil, err
+	}
+	comment := "Added whitelist entry " + item.Url
+	if note != "" {
+		comment += ": " + note
+	}
+	err = TriageAttackAlarm(app.Id, alarmId, &logs.AttackTriage{Status: logs.TriageStatusFalsePositive}, comment)
+	if err != nil {
+		return app, errors.New("the whitelist is updated, but failed to mark the alarm as false positive: " +
+			err.Error())
+	}
+	return app, nil
+}
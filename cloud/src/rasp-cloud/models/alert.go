@@ -0,0 +1,428 @@
+//Copyright 2017-2018 Baidu Inc.
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http: //www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+
+package models
+
+import (
+	"crypto/md5"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/astaxie/beego"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"html"
+	"rasp-cloud/models/logs"
+	"rasp-cloud/mongo"
+	"rasp-cloud/tools"
+	"strconv"
+	"time"
+)
+
+// AlertCondition is evaluated over the window of attack alarms in every alarm check,
+//   threshold: fires for every value of field whose alarm count in the window is greater than the threshold,
+//              the alarms are counted as a whole if the field is empty
+//   new_value: fires for every value of field that is first seen in the window, such as a new attack_type
+//              or the first block on an url with the intercept_state filter
+// A threshold alert is sent when the condition starts firing and a recovery is sent when it is resolved,
+// a new_value alert is one-shot, it is sent once with the fired status and never resolved.
+type AlertCondition struct {
+	Id             string   `json:"id" bson:"_id"`
+	AppId          string   `json:"app_id" bson:"app_id"`
+	Name           string   `json:"name" bson:"name"`
+	Enable         bool     `json:"enable" bson:"enable"`
+	Type           string   `json:"type" bson:"type"`
+	Field          string   `json:"field" bson:"field"`
+	Threshold      int64    `json:"threshold" bson:"threshold"`
+	Window         int64    `json:"window" bson:"window"`
+	AttackType     []string `json:"attack_type" bson:"attack_type"`
+	InterceptState []string `json:"intercept_state" bson:"intercept_state"`
+	// the channels to push the alerts, all of the enabled channels are used if it is empty
+	Channels   []string `json:"channels" bson:"channels"`
+	CreateTime int64    `json:"create_time" bson:"create_time"`
+}
+
+// AlertState is the state of an alert, an alert is identified by the condition and the value of field
+type AlertState struct {
+	Id            string `json:"id" bson:"_id"`
+	AppId         string `json:"app_id" bson:"app_id"`
+	ConditionId   string `json:"condition_id" bson:"condition_id"`
+	ConditionName string `json:"condition_name" bson:"condition_name"`
+	Key           string `json:"key" bson:"key"`
+	Status        string `json:"status" bson:"status"`
+	// the alarm count for threshold and the first seen time for new_value
+	Value        int64 `json:"value" bson:"value"`
+	FiredTime    int64 `json:"fired_time" bson:"fired_time"`
+	ResolvedTime int64 `json:"resolved_time" bson:"resolved_time"`
+	UpdateTime   int64 `json:"update_time" bson:"update_time"`
+}
+
+const (
+	alertConditionCollectionName = "alert_condition"
+	alertStateCollectionName     = "alert_state"
+	AlertTypeThreshold           = "threshold"
+	AlertTypeNewValue            = "new_value"
+	AlertStatusFiring            = "firing"
+	AlertStatusResolved          = "resolved"
+	AlertStatusFired             = "fired"
+	// the max count of values of field that an alert condition fires for in an evaluation
+	maxAlertGroups     = 100
+	maxAlertConditions = 50
+	minAlertWindow     = 60
+	maxAlertWindow     = 7 * 24 * 3600
+)
+
+var alertFields = []string{"attack_source", "client_ip", "attack_type", "intercept_state", "url", "path",
+	"target", "server_hostname", "server_ip", "plugin_name", "plugin_algorithm", "rasp_id", "user_agent"}
+
+func init() {
+	for _, item := range []struct {
+		collection string
+		keys       []string
+		name       string
+	}{
+		{alertConditionCollectionName, []string{"app_id"}, "app_id"},
+		{alertStateCollectionName, []string{"app_id", "status"}, "app_id_status"},
+		{alertStateCollectionName, []string{"condition_id", "status"}, "condition_id_status"},
+	} {
+		err := mongo.CreateIndex(item.collection, &mgo.Index{
+			Key:        item.keys,
+			Unique:     false,
+			Background: true,
+			Name:       item.name,
+		})
+		if err != nil {
+			tools.Panic(tools.ErrCodeMongoInitFailed,
+				"failed to create "+item.name+" index for "+item.collection+" collection", err)
+		}
+	}
+}
+
+func ValidateAlertCondition(condition *AlertCondition) error {
+	if condition.Name == "" || len(condition.Name) > 128 {
+		return errors.New("the length of alert condition name must be between [1,128]")
+	}
+	switch condition.Type {
+	case AlertTypeThreshold:
+		if condition.Threshold < 0 {
+			return errors.New("the threshold of alert condition can not be less than 0")
+		}
+	case AlertTypeNewValue:
+		if condition.Field == "" {
+			return errors.New("the field of new_value alert condition can not be empty")
+		}
+	default:
+		return errors.New("the type of alert condition must be threshold or new_value")
+	}
+	if condition.Field != "" && !containsString(alertFields, condition.Field) {
+		return errors.New("unsupported alert condition field: " + condition.Field)
+	}
+	if condition.Window < minAlertWindow || condition.Window > maxAlertWindow {
+		return errors.New("the window of alert condition must be between [" + strconv.Itoa(minAlertWindow) +
+			"," + strconv.Itoa(maxAlertWindow) + "] seconds")
+	}
+	if len(condition.AttackType) > 128 || len(condition.InterceptState) > 128 {
+		return errors.New("the count of attack_type or intercept_state filters can not be greater than 128")
+	}
+	for _, channel := range condition.Channels {
+		switch channel {
+		case NotificationChannelEmail, NotificationChannelDing, NotificationChannelHttp, NotificationChannelChat:
+		default:
+			return errors.New("unsupported notification channel: " + channel)
+		}
+	}
+	return nil
+}
+
+func GetAlertConditions(appId string, page int, perpage int) (count int, result []*AlertCondition, err error) {
+	count, err = mongo.FindAll(alertConditionCollectionName, bson.M{"app_id": appId}, &result,
+		perpage*(page-1), perpage, "create_time")
+	if err == nil && result == nil {
+		result = make([]*AlertCondition, 0)
+	}
+	return
+}
+
+func GetAlertConditionById(id string) (condition *AlertCondition, err error) {
+	err = mongo.FindId(alertConditionCollectionName, id, &condition)
+	return
+}
+
+func AddAlertCondition(condition *AlertCondition) (*AlertCondition, error) {
+	count, err := mongo.FindAll(alertConditionCollectionName, bson.M{"app_id": condition.AppId},
+		&[]*AlertCondition{}, 0, 1)
+	if err != nil {
+		return nil, err
+	}
+	if count >= maxAlertConditions {
+		return nil, errors.New("the count of alert conditions of an app can not be greater than " +
+			strconv.Itoa(maxAlertConditions))
+	}
+	condition.Id = mongo.GenerateObjectId()
+	condition.CreateTime = time.Now().Unix()
+	err = mongo.Insert(alertConditionCollectionName, condition)
+	if err != nil {
+		return nil, err
+	}
+	return condition, nil
+}
+
+// UpdateAlertCondition updates the condition, the states of condition are reset since the condition is changed
+func UpdateAlertCondition(condition *AlertCondition) (*AlertCondition, error) {
+	oldCondition, err := GetAlertConditionById(condition.Id)
+	if err != nil {
+		return nil, err
+	}
+	condition.AppId = oldCondition.AppId
+	condition.CreateTime = oldCondition.CreateTime
+	err = mongo.UpsertId(alertConditionCollectionName, condition.Id, condition)
+	if err != nil {
+		return nil, err
+	}
+	return condition, mongo.RemoveAll(alertStateCollectionName, bson.M{"condition_id": condition.Id})
+}
+
+func RemoveAlertCondition(id string) (condition *AlertCondition, err error) {
+	condition, err = GetAlertConditionById(id)
+	if err != nil {
+		return
+	}
+	err = mongo.RemoveId(alertConditionCollectionName, id)
+	if err != nil {
+		return
+	}
+	return condition, mongo.RemoveAll(alertStateCollectionName, bson.M{"condition_id": id})
+}
+
+func GetAlertStates(appId string, status string, page int, perpage int) (count int, result []*AlertState,
+	err error) {
+	query := bson.M{"app_id": appId}
+	if status != "" {
+		query["status"] = status
+	}
+	count, err = mongo.FindAll(alertStateCollectionName, query, &result, perpage*(page-1), perpage,
+		"-update_time")
+	if err == nil && result == nil {
+		result = make([]*AlertState, 0)
+	}
+	return
+}
+
+func RemoveAlertConditionsByAppId(appId string) error {
+	err := mongo.RemoveAll(alertConditionCollectionName, bson.M{"app_id": appId})
+	if err != nil {
+		return err
+	}
+	return mongo.RemoveAll(alertStateCollectionName, bson.M{"app_id": appId})
+}
+
+func (condition *AlertCondition) filter() map[string][]string {
+	return map[string][]string{
+		"attack_type":     condition.AttackType,
+		"intercept_state": condition.InterceptState,
+	}
+}
+
+func evaluateAlertConditions(app *App, now int64, silences []*compiledSilence) {
+	var conditions []*AlertCondition
+	_, err := mongo.FindAll(alertConditionCollectionName, bson.M{"app_id": app.Id, "enable": true},
+		&conditions, 0, 0)
+	if err != nil {
+		beego.Error("failed to get alert conditions of app " + app.Id + ": " + err.Error())
+		return
+	}
+	for _, condition := range conditions {
+		if err := evaluateAlertCondition(app, condition, now, silences); err != nil {
+			beego.Error("failed to evaluate alert condition " + condition.Name + " of app " + app.Id +
+				": " + err.Error())
+		}
+	}
+}
+
+func evaluateAlertCondition(app *App, condition *AlertCondition, now int64, silences []*compiledSilence) error {
+	startTime := now - condition.Window*1000
+	var (
+		values map[string]int64
+		err    error
+	)
+	if condition.Type == AlertTypeThreshold {
+		values, err = logs.AggregationAttackWithTerms(startTime, now, condition.Field, condition.filter(),
+			condition.Threshold+1, maxAlertGroups, app.Id)
+	} else {
+		values, err = logs.AggregationAttackFirstSeen(startTime, now, condition.Field, condition.filter(),
+			maxAlertGroups, app.Id)
+	}
+	if err != nil {
+		return err
+	}
+	// the new_value alerts are one-shot, so that any state of the values means they are already sent
+	query := bson.M{"condition_id": condition.Id, "status": AlertStatusFiring}
+	status := AlertStatusFiring
+	if condition.Type == AlertTypeNewValue {
+		keys := make([]string, 0, len(values))
+		for key := range values {
+			keys = append(keys, key)
+		}
+		query = bson.M{"condition_id": condition.Id, "key": bson.M{"$in": keys}}
+		status = AlertStatusFired
+	}
+	var states []*AlertState
+	if len(values) > 0 || condition.Type == AlertTypeThreshold {
+		_, err = mongo.FindAll(alertStateCollectionName, query, &states, 0, 0)
+		if err != nil {
+			return err
+		}
+	}
+	existing := make(map[string]*AlertState, len(states))
+	for _, state := range states {
+		existing[state.Key] = state
+	}
+	nowSecond := now / 1000
+	for key, value := range values {
+		if state, ok := existing[key]; ok {
+			if condition.Type == AlertTypeNewValue {
+				continue
+			}
+			err = mongo.UpdateId(alertStateCollectionName, state.Id,
+				bson.M{"value": value, "update_time": nowSecond})
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		state := &AlertState{
+			Id:            fmt.Sprintf("%x", md5.Sum([]byte(condition.Id+"|"+key))),
+			AppId:         app.Id,
+			ConditionId:   condition.Id,
+			ConditionName: condition.Name,
+			Key:           key,
+			Status:        status,
+			Value:         value,
+			FiredTime:     nowSecond,
+			UpdateTime:    nowSecond,
+		}
+		if err = mongo.UpsertId(alertStateCollectionName, state.Id, state); err != nil {
+			return err
+		}
+		pushAlert(app, condition, state, silences)
+	}
+	if condition.Type != AlertTypeThreshold {
+		return nil
+	}
+	for key, state := range existing {
+		if _, ok := values[key]; ok {
+			continue
+		}
+		state.Status = AlertStatusResolved
+		state.ResolvedTime = nowSecond
+		state.UpdateTime = nowSecond
+		err = mongo.UpdateId(alertStateCollectionName, state.Id, bson.M{"status": state.Status,
+			"resolved_time": state.ResolvedTime, "update_time": state.UpdateTime})
+		if err != nil {
+			return err
+		}
+		pushAlert(app, condition, state, silences)
+	}
+	return nil
+}
+
+func getAlertMessage(app *App, condition *AlertCondition, state *AlertState) (subject string, content string) {
+	subject = "[OpenRASP] [" + state.Status + "] " + condition.Name
+	field := condition.Field
+	if field == "" {
+		field = "all"
+	}
+	if condition.Type == AlertTypeThreshold {
+		content = "app: " + app.Name + ", " + field + ": " + state.Key + ", " +
+			strconv.FormatInt(state.Value, 10) + " attack alarms in " + strconv.FormatInt(condition.Window, 10) +
+			" seconds, threshold: " + strconv.FormatInt(condition.Threshold, 10)
+	} else {
+		content = "app: " + app.Name + ", new " + field + ": " + state.Key + ", first seen at " +
+			time.Unix(state.Value/1000, 0).Format(time.RFC3339)
+	}
+	if state.Status == AlertStatusResolved {
+		content += ", resolved at " + time.Unix(state.ResolvedTime, 0).Format(time.RFC3339)
+	} else {
+		content += ", fired at " + time.Unix(state.FiredTime, 0).Format(time.RFC3339)
+	}
+	content += ", detail: " + panelServerURL + "/#/events/" + app.Id
+	return
+}
+
+// isAlertSilenced checks the silences with the fields known by the alert,
+// they are the value of field and the only attack_type of filter
+func isAlertSilenced(condition *AlertCondition, state *AlertState, silences []*compiledSilence) bool {
+	if len(silences) == 0 {
+		return false
+	}
+	fields := make(map[string]interface{})
+	if condition.Field != "" {
+		fields[condition.Field] = state.Key
+	}
+	if len(condition.AttackType) == 1 {
+		fields["attack_type"] = condition.AttackType[0]
+	}
+	return isSilenced(silences, fields)
+}
+
+func pushAlert(app *App, condition *AlertCondition, state *AlertState, silences []*compiledSilence) {
+	if isAlertSilenced(condition, state, silences) {
+		beego.Debug("the " + state.Status + " alert " + condition.Name + " of app " + app.Id + " is silenced")
+		return
+	}
+	subject, content := getAlertMessage(app, condition, state)
+	channels := condition.Channels
+	if len(channels) == 0 {
+		channels = []string{NotificationChannelEmail, NotificationChannelDing, NotificationChannelHttp,
+			NotificationChannelChat}
+	}
+	var deliveries []*NotificationDelivery
+	for _, channel := range channels {
+		switch channel {
+		case NotificationChannelEmail:
+			if app.EmailAlarmConf.Enable {
+				deliveries = append(deliveries, newNotificationDelivery(app, NotificationSourceAlert, channel,
+					nil, subject, "<p>"+html.EscapeString(content)+"</p>"))
+			}
+		case NotificationChannelDing:
+			if app.DingAlarmConf.Enable {
+				deliveries = append(deliveries, newNotificationDelivery(app, NotificationSourceAlert, channel,
+					nil, subject, subject+"\n"+content))
+			}
+		case NotificationChannelHttp:
+			if app.HttpAlarmConf.Enable {
+				body, err := json.Marshal(map[string]interface{}{
+					"app_id":    app.Id,
+					"alert":     state,
+					"condition": condition,
+					"message":   content,
+				})
+				if err != nil {
+					beego.Error("failed to marshal http alert " + condition.Name + ": " + err.Error())
+					continue
+				}
+				deliveries = append(deliveries,
+					newHttpNotificationDeliveries(app, NotificationSourceAlert, nil, string(body))...)
+			}
+		case NotificationChannelChat:
+			if app.ChatAlarmConf.Enable {
+				deliveries = append(deliveries,
+					newChatNotificationDeliveries(app, NotificationSourceAlert, nil, subject, content)...)
+			}
+		}
+	}
+	for _, delivery := range deliveries {
+		queueNotification(delivery)
+	}
+}
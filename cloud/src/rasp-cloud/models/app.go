@@ -23,15 +23,16 @@ import (
 	"rasp-cloud/tools"
 	"gopkg.in/mgo.v2"
 	"crypto/sha1"
+	cryptorand "crypto/rand"
+	"encoding/hex"
 	"gopkg.in/mgo.v2/bson"
 	"rasp-cloud/models/logs"
+	"rasp-cloud/models/logs/redaction"
 	"github.com/astaxie/beego"
 	"net/smtp"
 	"os"
 	"net/mail"
 	"strings"
-	"html/template"
-	"bytes"
 	"github.com/astaxie/beego/httplib"
 	"errors"
 	"crypto/sha256"
@@ -41,6 +42,7 @@ import (
 	"rasp-cloud/environment"
 	"crypto/tls"
 	"net"
+	"net/http"
 )
 
 type App struct {
@@ -57,11 +59,27 @@ type App struct {
 	EmailAlarmConf   EmailAlarmConf         `json:"email_alarm_conf" bson:"email_alarm_conf"`
 	DingAlarmConf    DingAlarmConf          `json:"ding_alarm_conf" bson:"ding_alarm_conf"`
 	HttpAlarmConf    HttpAlarmConf          `json:"http_alarm_conf" bson:"http_alarm_conf"`
+	ChatAlarmConf    ChatAlarmConf          `json:"chat_alarm_conf" bson:"chat_alarm_conf"`
+	RedactionConfig  []redaction.Rule   `json:"redaction_config" bson:"redaction_config"`
+	// RedactionHashKey is the random key of the hash redaction action, it is never sent to the agents
+	RedactionHashKey string `json:"-" bson:"redaction_hash_key,omitempty"`
+	// NotificationRules route the attack alarms to channels, see notification.go
+	NotificationRules []NotificationRule `json:"notification_rules" bson:"notification_rules"`
+	// DigestConfig schedules the daily or weekly report, see digest.go
+	DigestConfig *DigestConfig `json:"digest_config" bson:"digest_config"`
 }
 
 type WhitelistConfigItem struct {
+	// the host and path of entry, the legacy entries only have the url as the prefix
 	Url  string          `json:"url" bson:"url"`
 	Hook map[string]bool `json:"hook" bson:"hook"`
+	// the matchers of entry, see whitelist.go, the host * matches all of the hosts
+	Host string `json:"host" bson:"host"`
+	Path string `json:"path" bson:"path"`
+	// the unix time when the entry expires, 0 means never
+	ExpireTime int64  `json:"expire_time" bson:"expire_time"`
+	Owner      string `json:"owner" bson:"owner"`
+	Reason     string `json:"reason" bson:"reason"`
 }
 
 type EmailAlarmConf struct {
@@ -86,13 +104,8 @@ type DingAlarmConf struct {
 type HttpAlarmConf struct {
 	Enable   bool     `json:"enable" bson:"enable"`
 	RecvAddr []string `json:"recv_addr" bson:"recv_addr"`
-}
-
-type emailTemplateParam struct {
-	Total        int64
-	Alarms       []map[string]interface{}
-	DetailedLink string
-	AppName      string
+	// the receivers with signature, headers and tls settings, see http_alarm.go
+	Receivers []HttpReceiver `json:"receivers" bson:"receivers"`
 }
 
 type dingResponse struct {
@@ -108,9 +121,9 @@ const (
 )
 
 var (
-	panelServerURL string
-	lastAlarmTime  = time.Now().UnixNano() / 1000000
-	TestAlarmData  = []map[string]interface{}{
+	panelServerURL     string
+	alarmCheckInterval int64
+	TestAlarmData      = []map[string]interface{}{
 		{
 			"event_time":      time.Now().Format("2006-01-01 15:04:05"),
 			"attack_source":   "220.181.57.191",
@@ -158,7 +171,7 @@ func init() {
 			tools.Panic(tools.ErrCodeMongoInitFailed, "failed to create index for app collection", err)
 		}
 	}
-	alarmCheckInterval := beego.AppConfig.DefaultInt64("AlarmCheckInterval", 120)
+	alarmCheckInterval = beego.AppConfig.DefaultInt64("AlarmCheckInterval", 120)
 	if alarmCheckInterval <= 0 {
 		tools.Panic(tools.ErrCodeMongoInitFailed, "the 'AlarmCheckInterval' config must be greater than 0", nil)
 	} else if alarmCheckInterval < 10 {
@@ -177,6 +190,7 @@ func init() {
 		}
 		go startAlarmTicker(time.Second * time.Duration(alarmCheckInterval))
 	}
+	redaction.RulesLoader = GetRedactionConfig
 }
 
 func createDefaultApp() {
@@ -195,8 +209,11 @@ func startAlarmTicker(interval time.Duration) {
 	for {
 		select {
 		case <-ticker.C:
-			handleAttackAlarm()
-			handleRaspExpiredAlarm()
+			// the alarms are only checked by the leader among the panel instances
+			if mongo.IsLeader() {
+				handleAttackAlarm()
+				handleRaspExpiredAlarm()
+			}
 		}
 	}
 }
@@ -213,19 +230,47 @@ func handleAttackAlarm() {
 		beego.Error("failed to get apps for the alarm: " + err.Error())
 		return
 	}
+	watermarks, err := getAlarmWatermarks()
+	if err != nil {
+		beego.Error("failed to get alarm watermarks: " + err.Error())
+		return
+	}
 	now := time.Now().UnixNano() / 1000000
 	for _, app := range apps {
-		total, result, err := logs.SearchLogs(lastAlarmTime, now, nil, "event_time",
-			1, 10, false, logs.AliasAttackIndexName+"-"+app.Id)
+		lastAlarmTime, ok := watermarks[app.Id]
+		if !ok {
+			lastAlarmTime = now - alarmCheckInterval*1000
+		}
+		silences := getActiveSilences(app.Id, time.Now())
+		var total int64
+		var result []map[string]interface{}
+		watermark := now + 1
+		if len(app.NotificationRules) > 0 || len(silences) > 0 {
+			// the alarms are matched one by one with the notification rules and silences
+			result, watermark, err = fetchNotificationAlarms(app.Id, lastAlarmTime, now)
+			total = int64(len(result))
+		} else {
+			total, result, err = logs.SearchLogs(lastAlarmTime, now, nil, "event_time",
+				1, maxNotificationAlarms, false, logs.AliasAttackIndexName+"-"+app.Id)
+		}
 		if err != nil {
+			// the watermark is kept to search the alarms again in the next check
 			beego.Error("failed to get alarm from es: " + err.Error())
-			continue
-		}
-		if total > 0 {
-			PushAttackAlarm(&app, total, result, false)
+		} else {
+			if total > 0 {
+				var silenced int64
+				result, silenced = filterSilencedAlarms(silences, result)
+				total -= silenced
+				if total > 0 && len(result) > 0 {
+					routeAttackAlarm(&app, total, result)
+				}
+			}
+			if err := setAlarmWatermark(app.Id, watermark); err != nil {
+				beego.Error("failed to set alarm watermark for app " + app.Id + ": " + err.Error())
+			}
 		}
+		evaluateAlertConditions(&app, now, silences)
 	}
-	lastAlarmTime = now + 1
 }
 
 func handleRaspExpiredAlarm() {
@@ -371,6 +416,12 @@ func HandleApp(app *App, isCreate bool) {
 	if app.HttpAlarmConf.RecvAddr == nil {
 		app.HttpAlarmConf.RecvAddr = make([]string, 0)
 	}
+	if app.HttpAlarmConf.Receivers == nil {
+		app.HttpAlarmConf.Receivers = make([]HttpReceiver, 0)
+	}
+	if app.ChatAlarmConf.Robots == nil {
+		app.ChatAlarmConf.Robots = make([]ChatRobot, 0)
+	}
 	if !isCreate {
 		if app.EmailAlarmConf.Password != "" {
 			app.EmailAlarmConf.Password = SecreteMask
@@ -378,6 +429,12 @@ func HandleApp(app *App, isCreate bool) {
 		if app.DingAlarmConf.CorpSecret != "" {
 			app.DingAlarmConf.CorpSecret = SecreteMask
 		}
+		maskHttpReceivers(app.HttpAlarmConf.Receivers)
+		for i := range app.ChatAlarmConf.Robots {
+			if app.ChatAlarmConf.Robots[i].Secret != "" {
+				app.ChatAlarmConf.Robots[i].Secret = SecreteMask
+			}
+		}
 	} else {
 		if app.GeneralConfig == nil {
 			app.GeneralConfig = DefaultGeneralConfig
@@ -386,6 +443,12 @@ func HandleApp(app *App, isCreate bool) {
 	if app.WhitelistConfig == nil {
 		app.WhitelistConfig = make([]WhitelistConfigItem, 0)
 	}
+	if app.RedactionConfig == nil {
+		app.RedactionConfig = make([]redaction.Rule, 0)
+	}
+	if app.NotificationRules == nil {
+		app.NotificationRules = make([]NotificationRule, 0)
+	}
 	if app.GeneralConfig == nil {
 		app.GeneralConfig = make(map[string]interface{})
 	}
@@ -407,6 +470,49 @@ func UpdateWhiteListConfig(appId string, config []WhitelistConfigItem) (app *App
 	return UpdateAppById(appId, bson.M{"whitelist_config": config, "config_time": time.Now().UnixNano()})
 }
 
+func UpdateRedactionConfig(appId string, config []redaction.Rule) (app *App, err error) {
+	app, err = UpdateAppById(appId, bson.M{"redaction_config": config})
+	if err == nil {
+		redaction.Invalidate(appId)
+	}
+	return
+}
+
+func UpdateNotificationRules(appId string, rules []NotificationRule) (app *App, err error) {
+	return UpdateAppById(appId, bson.M{"notification_rules": rules})
+}
+
+// GetRedactionConfig returns the redaction rules and the hash key of app, the hash key is generated
+// at the first time, only one of the concurrent generated keys is stored
+func GetRedactionConfig(appId string) (config []redaction.Rule, hashKey string, err error) {
+	newSession := mongo.NewSession()
+	defer newSession.Close()
+	collection := newSession.DB(mongo.DbName).C(appCollectionName)
+	var result *App
+	err = collection.FindId(appId).Select(bson.M{"redaction_config": 1, "redaction_hash_key": 1}).One(&result)
+	if err != nil {
+		return
+	}
+	config = result.RedactionConfig
+	if result.RedactionHashKey != "" {
+		return config, result.RedactionHashKey, nil
+	}
+	key := make([]byte, 32)
+	if _, err = cryptorand.Read(key); err != nil {
+		return
+	}
+	err = collection.Update(bson.M{"_id": appId, "redaction_hash_key": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"redaction_hash_key": hex.EncodeToString(key)}})
+	if err != nil && err != mgo.ErrNotFound {
+		return
+	}
+	err = collection.FindId(appId).Select(bson.M{"redaction_hash_key": 1}).One(&result)
+	if err == nil && result.RedactionHashKey == "" {
+		err = errors.New("failed to generate the redaction hash key")
+	}
+	return config, result.RedactionHashKey, err
+}
+
 func RemoveAppById(id string) (app *App, err error) {
 	err = mongo.FindId(appCollectionName, id, &app)
 	if err != nil {
@@ -419,16 +525,20 @@ func GetAppCount() (count int, err error) {
 	return mongo.Count(appCollectionName)
 }
 
+// PushAttackAlarm queues the attack alarms to the enabled channels of app, see notification_queue.go
 func PushAttackAlarm(app *App, total int64, alarms []map[string]interface{}, isTest bool) {
 	if app != nil {
 		if app.DingAlarmConf.Enable {
-			PushDingAttackAlarm(app, total, alarms, isTest)
+			queueAttackAlarm(app, NotificationChannelDing, nil, total, alarms, isTest)
 		}
 		if app.EmailAlarmConf.Enable {
-			PushEmailAttackAlarm(app, total, alarms, isTest)
+			queueAttackAlarm(app, NotificationChannelEmail, nil, total, alarms, isTest)
 		}
 		if app.HttpAlarmConf.Enable {
-			PushHttpAttackAlarm(app, total, alarms, isTest)
+			queueAttackAlarm(app, NotificationChannelHttp, nil, total, alarms, isTest)
+		}
+		if app.ChatAlarmConf.Enable {
+			queueAttackAlarm(app, NotificationChannelChat, nil, total, alarms, isTest)
 		}
 	}
 }
@@ -436,69 +546,14 @@ func PushAttackAlarm(app *App, total int64, alarms []map[string]interface{}, isT
 func PushEmailAttackAlarm(app *App, total int64, alarms []map[string]interface{}, isTest bool) error {
 	var emailConf = app.EmailAlarmConf
 	if len(emailConf.RecvAddr) > 0 && emailConf.ServerAddr != "" {
-		var (
-			subject   string
-			msg       string
-			emailAddr = &mail.Address{Address: emailConf.UserName}
-		)
-		hostName, err := os.Hostname()
-		if err == nil {
-			emailAddr.Name = hostName
-		} else {
-			emailAddr.Name = "OpenRASP"
-		}
-		if emailConf.Subject == "" {
-			subject = "OpenRASP alarm"
-		} else {
-			subject = emailConf.Subject
-		}
-		if isTest {
-			subject = "【测试邮件】" + subject
-			alarms = TestAlarmData
-			total = int64(len(TestAlarmData))
-		}
-		head := map[string]string{
-			"from":         emailAddr.String(),
-			"To":           strings.Join(emailConf.RecvAddr, ","),
-			"Content-Type": "text/html; charset=UTF-8",
-			"Subject":      subject,
-		}
-		t, err := template.ParseFiles("views/email.tpl")
+		subject, content, err := renderAttackAlarm(app, NotificationChannelEmail, total, alarms, isTest)
 		if err != nil {
-			beego.Error("failed to render email template: " + err.Error())
 			return err
 		}
-		alarmData := new(bytes.Buffer)
-		err = t.Execute(alarmData, &emailTemplateParam{
-			Total:        total - int64(len(alarms)),
-			Alarms:       alarms,
-			AppName:      app.Name,
-			DetailedLink: panelServerURL + "/#/events/" + app.Id,
-		})
+		err = sendEmail(emailConf, subject, content)
 		if err != nil {
-			beego.Error("failed to execute email template: " + err.Error())
 			return err
 		}
-		for k, v := range head {
-			msg += fmt.Sprintf("%s: %s\r\n", k, v)
-		}
-		msg += "\r\n" + alarmData.String()
-		host, _, err := net.SplitHostPort(emailConf.ServerAddr)
-		if err != nil {
-			errMsg := "failed to get email serve host: " + err.Error()
-			beego.Error(errMsg)
-			return errors.New(errMsg)
-		}
-		auth := smtp.PlainAuth("", emailConf.UserName, emailConf.Password, host)
-		if emailConf.Password == "" {
-			auth = nil
-		}
-
-		if emailConf.TlsEnable {
-			return sendEmailWithTls(emailConf, auth, msg)
-		} else {
-			return sendNormalEmail(emailConf, auth, msg)
-		}
 	} else {
 		beego.Error(
 			"failed to send email alarm: the email receiving address and email server address can not be empty", emailConf)
@@ -508,6 +563,46 @@ func PushEmailAttackAlarm(app *App, total int64, alarms []map[string]interface{}
 	return nil
 }
 
+// sendEmail sends the html email with the email alarm config of app
+func sendEmail(emailConf EmailAlarmConf, subject string, content string) error {
+	var (
+		msg       string
+		emailAddr = &mail.Address{Address: emailConf.UserName}
+	)
+	hostName, err := os.Hostname()
+	if err == nil {
+		emailAddr.Name = hostName
+	} else {
+		emailAddr.Name = "OpenRASP"
+	}
+	head := map[string]string{
+		"from":         emailAddr.String(),
+		"To":           strings.Join(emailConf.RecvAddr, ","),
+		"Content-Type": "text/html; charset=UTF-8",
+		"Subject":      subject,
+	}
+	for k, v := range head {
+		msg += fmt.Sprintf("%s: %s\r\n", k, v)
+	}
+	msg += "\r\n" + content
+	host, _, err := net.SplitHostPort(emailConf.ServerAddr)
+	if err != nil {
+		errMsg := "failed to get email serve host: " + err.Error()
+		beego.Error(errMsg)
+		return errors.New(errMsg)
+	}
+	auth := smtp.PlainAuth("", emailConf.UserName, emailConf.Password, host)
+	if emailConf.Password == "" {
+		auth = nil
+	}
+
+	if emailConf.TlsEnable {
+		return sendEmailWithTls(emailConf, auth, msg)
+	} else {
+		return sendNormalEmail(emailConf, auth, msg)
+	}
+}
+
 func sendNormalEmail(emailConf EmailAlarmConf, auth smtp.Auth, msg string) (err error) {
 	err = smtp.SendMail(emailConf.ServerAddr, auth, emailConf.UserName, emailConf.RecvAddr, []byte(msg))
 	if err != nil {
@@ -585,41 +680,47 @@ func smtpTlsDial(addr string) (*smtp.Client, error) {
 
 func PushHttpAttackAlarm(app *App, total int64, alarms []map[string]interface{}, isTest bool) error {
 	var httpConf = app.HttpAlarmConf
-	if len(httpConf.RecvAddr) != 0 {
-		body := make(map[string]interface{})
-		body["app_id"] = app.Id
-		if isTest {
-			body["data"] = TestAlarmData
-		} else {
-			body["data"] = alarms
-		}
-		for _, addr := range httpConf.RecvAddr {
-			request := httplib.Post(addr)
-			request.JSONBody(body)
-			request.SetTimeout(10*time.Second, 10*time.Second)
-			response, err := request.Response()
-			if err != nil {
-				beego.Error("failed to push http alarms to: " + addr + ", with error: " + err.Error())
-				return err
-			}
-			if response.StatusCode > 299 || response.StatusCode < 200 {
-				err := errors.New("failed to push http alarms to: " + addr + ", with status code: " +
-					strconv.Itoa(response.StatusCode))
-				beego.Error(err.Error())
-				return err
+	receivers := getHttpReceivers(&httpConf)
+	if len(receivers) != 0 {
+		_, body, err := renderAttackAlarm(app, NotificationChannelHttp, total, alarms, isTest)
+		if err != nil {
+			return err
+		}
+		// the failure of one receiver should not stop pushing to the others
+		var failedAddr []string
+		for i := range receivers {
+			if _, err := postHttpReceiver(&receivers[i], []byte(body), mongo.GenerateObjectId()); err != nil {
+				failedAddr = append(failedAddr, receivers[i].Url)
 			}
 		}
+		if len(failedAddr) > 0 {
+			return errors.New("failed to push http alarms to: " + strings.Join(failedAddr, ","))
+		}
 	} else {
 		beego.Error("failed to send http alarm: the http receiving address can not be empty", httpConf)
 		return errors.New("the http receiving address can not be empty")
 	}
 	beego.Debug("succeed in pushing http alarm for app: " + app.Name + " ,with urls: " +
-		fmt.Sprintf("%v", httpConf.RecvAddr))
+		fmt.Sprintf("%v", receivers))
 	return nil
 }
 
 func PushDingAttackAlarm(app *App, total int64, alarms []map[string]interface{}, isTest bool) error {
-	var dingCong = app.DingAlarmConf
+	_, dingText, err := renderAttackAlarm(app, NotificationChannelDing, total, alarms, isTest)
+	if err != nil {
+		return err
+	}
+	_, err = sendDingText(app.DingAlarmConf, dingText)
+	if err != nil {
+		return err
+	}
+	beego.Debug("succeed in pushing ding ding alarm for app: " + app.Name + " ,with corp id: " + app.DingAlarmConf.CorpId)
+	return nil
+}
+
+// sendDingText sends the text message to the receivers of ding ding alarm config,
+// it returns the status code of the last response
+func sendDingText(dingCong DingAlarmConf, dingText string) (int, error) {
 	if dingCong.CorpId != "" && dingCong.CorpSecret != "" && dingCong.AgentId != "" &&
 		!(len(dingCong.RecvParty) == 0 && len(dingCong.RecvUser) == 0) {
 
@@ -631,33 +732,26 @@ func PushDingAttackAlarm(app *App, total int64, alarms []map[string]interface{},
 		errMsg := "failed to get ding ding token with corp id: " + dingCong.CorpId
 		if err != nil {
 			beego.Error(errMsg + ", with error: " + err.Error())
-			return err
+			return 0, err
 		}
 		if response.StatusCode != 200 {
 			err := errors.New(errMsg + ", with status code: " + strconv.Itoa(response.StatusCode))
 			beego.Error(err.Error())
-			return err
+			return response.StatusCode, err
 		}
 		var result dingResponse
 		err = request.ToJSON(&result)
 		if err != nil {
 			beego.Error(errMsg + ", with error: " + err.Error())
-			return err
+			return response.StatusCode, err
 		}
 		if result.ErrCode != 0 {
 			err := errors.New(errMsg + ", with errmsg: " + result.ErrMsg)
 			beego.Error(err.Error())
-			return err
+			return response.StatusCode, err
 		}
 		token := result.AccessToken
 		body := make(map[string]interface{})
-		dingText := ""
-		if isTest {
-			dingText = "OpenRASP test message from app: " + app.Name + ", time: " + time.Now().Format(time.RFC3339)
-		} else {
-			dingText = "时间：" + time.Now().Format(time.RFC3339) + "， 来自 OpenRAS 的报警\n共有 " +
-				strconv.FormatInt(total, 10) + " 条报警信息来自 APP：" + app.Name + "，详细信息：" + panelServerURL + "/#/events/" + app.Id
-		}
 		if len(dingCong.RecvUser) > 0 {
 			body["touser"] = strings.Join(dingCong.RecvUser, "|")
 		}
@@ -674,27 +768,26 @@ func PushDingAttackAlarm(app *App, total int64, alarms []map[string]interface{},
 		errMsg = "failed to push ding ding alarms with corp id: " + dingCong.CorpId
 		if err != nil {
 			beego.Error(errMsg + ", with error: " + err.Error())
-			return err
+			return 0, err
 		}
 		if response.StatusCode != 200 {
 			err := errors.New(errMsg + ", with status code: " + strconv.Itoa(response.StatusCode))
 			beego.Error(err.Error())
-			return err
+			return response.StatusCode, err
 		}
 		err = request.ToJSON(&result)
 		if err != nil {
 			beego.Error(errMsg + ", with error: " + err.Error())
-			return err
+			return response.StatusCode, err
 		}
 		if result.ErrCode != 0 {
 			err := errors.New(errMsg + ", with errmsg: " + result.ErrMsg)
 			beego.Error(err.Error())
-			return err
+			return response.StatusCode, err
 		}
 	} else {
 		beego.Error("failed to send ding ding alarm: invalid ding ding alarm conf", dingCong)
-		return errors.New("invalid ding ding alarm conf")
+		return 0, errors.New("invalid ding ding alarm conf")
 	}
-	beego.Debug("succeed in pushing ding ding alarm for app: " + app.Name + " ,with corp id: " + dingCong.CorpId)
-	return nil
+	return http.StatusOK, nil
 }
@@ -0,0 +1,128 @@
+//Copyright 2017-2018 Baidu Inc.
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http: //www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+
+package models
+
+import (
+	"errors"
+	"github.com/astaxie/beego"
+	"net/url"
+	"rasp-cloud/chatrobot"
+	"strconv"
+	"strings"
+)
+
+// ChatAlarmConf pushes the alarms to the incoming webhooks of chat robots
+type ChatAlarmConf struct {
+	Enable bool        `json:"enable" bson:"enable"`
+	Robots []ChatRobot `json:"robots" bson:"robots"`
+}
+
+type ChatRobot struct {
+	// the unique name of robot, the recipients of notification rules select the robots by name
+	Name string `json:"name" bson:"name"`
+	Type string `json:"type" bson:"type"`
+	Url  string `json:"url" bson:"url"`
+	// the signing secret of feishu and dingtalk robots, it is not signed if the secret is empty
+	Secret string `json:"secret" bson:"secret"`
+}
+
+const (
+	ChatRobotSlack    = chatrobot.Slack
+	ChatRobotTeams    = chatrobot.Teams
+	ChatRobotFeishu   = chatrobot.Feishu
+	ChatRobotWecom    = chatrobot.Wecom
+	ChatRobotDingTalk = chatrobot.DingTalk
+
+	maxChatRobots = 32
+)
+
+func ValidateChatAlarmConf(conf *ChatAlarmConf) error {
+	if len(conf.Robots) == 0 {
+		return errors.New("the chat robots can not be empty")
+	}
+	if len(conf.Robots) > maxChatRobots {
+		return errors.New("the count of chat robots can not be greater than " + strconv.Itoa(maxChatRobots))
+	}
+	names := make(map[string]bool)
+	for _, robot := range conf.Robots {
+		if robot.Name == "" || len(robot.Name) > 128 {
+			return errors.New("the length of chat robot name must be between [1,128]")
+		}
+		if names[robot.Name] {
+			return errors.New("duplicate chat robot name: " + robot.Name)
+		}
+		names[robot.Name] = true
+		if !chatrobot.IsSupported(robot.Type) {
+			return errors.New("unsupported type of chat robot " + robot.Name + ": " + robot.Type)
+		}
+		if len(robot.Url) > 1024 {
+			return errors.New("the length of chat robot url can not be greater than 1024")
+		}
+		robotUrl, err := url.Parse(robot.Url)
+		if err != nil || (robotUrl.Scheme != "http" && robotUrl.Scheme != "https") || robotUrl.Host == "" {
+			return errors.New("invalid url of chat robot " + robot.Name)
+		}
+		if len(robot.Secret) > 256 {
+			return errors.New("the length of chat robot secret can not be greater than 256")
+		}
+	}
+	return nil
+}
+
+// sendChatRobotText posts the message to the robot, it returns the status code of response
+func sendChatRobotText(robot *ChatRobot, title string, text string) (int, error) {
+	statusCode, err := chatrobot.SendText(robot.Type, robot.Url, robot.Secret, title, text)
+	if err != nil {
+		err = errors.New("failed to push " + robot.Type + " chat alarm to robot " + robot.Name + ", with " +
+			err.Error())
+		beego.Error(err.Error())
+	}
+	return statusCode, err
+}
+
+// getChatRobots returns the robots of app selected by names, all of the robots are returned if names are empty
+func getChatRobots(app *App, names []string) []*ChatRobot {
+	robots := make([]*ChatRobot, 0, len(app.ChatAlarmConf.Robots))
+	for i := range app.ChatAlarmConf.Robots {
+		robot := &app.ChatAlarmConf.Robots[i]
+		if len(names) == 0 || containsString(names, robot.Name) {
+			robots = append(robots, robot)
+		}
+	}
+	return robots
+}
+
+func PushChatAttackAlarm(app *App, total int64, alarms []map[string]interface{}, isTest bool) error {
+	robots := getChatRobots(app, nil)
+	if len(robots) == 0 {
+		beego.Error("failed to send chat alarm: the chat robots can not be empty")
+		return errors.New("the chat robots can not be empty")
+	}
+	title, text, err := renderAttackAlarm(app, NotificationChannelChat, total, alarms, isTest)
+	if err != nil {
+		return err
+	}
+	var failedRobots []string
+	for _, robot := range robots {
+		if _, err := sendChatRobotText(robot, title, text); err != nil {
+			failedRobots = append(failedRobots, robot.Name)
+		}
+	}
+	if len(failedRobots) > 0 {
+		return errors.New("failed to push chat alarms to robots: " + strings.Join(failedRobots, ","))
+	}
+	beego.Debug("succeed in pushing chat alarm for app: " + app.Name)
+	return nil
+}
@@ -0,0 +1,241 @@
+//Copyright 2017-2018 Baidu Inc.
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http: //www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+
+package models
+
+import (
+	"errors"
+	"github.com/astaxie/beego"
+	"net/url"
+	"rasp-cloud/models/logs"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ConfigPreview is the impact of the proposed whitelist or algorithm config
+// on the attack alarms stored in the last days
+type ConfigPreview struct {
+	Type      string `json:"type"`
+	StartTime int64  `json:"start_time"`
+	EndTime   int64  `json:"end_time"`
+	// the count of alarms in the time range and the count of the checked ones
+	Total   int64 `json:"total"`
+	Scanned int64 `json:"scanned"`
+	// the alarms would not be reported, because they are whitelisted or their algorithms are ignored,
+	// the counts are weighted by the count of the deduplicated alarms
+	Suppressed       int64                 `json:"suppressed"`
+	BlockToLog       int64                 `json:"block_to_log"`
+	LogToBlock       int64                 `json:"log_to_block"`
+	SuppressedByUrl  []*ConfigPreviewGroup `json:"suppressed_by_url"`
+	SuppressedByType []*ConfigPreviewGroup `json:"suppressed_by_type"`
+	BlockToLogByUrl  []*ConfigPreviewGroup `json:"block_to_log_by_url"`
+	BlockToLogByType []*ConfigPreviewGroup `json:"block_to_log_by_type"`
+}
+
+type ConfigPreviewGroup struct {
+	Key   string `json:"key"`
+	Count int64  `json:"count"`
+}
+
+type configPreviewCounter struct {
+	byUrl  map[string]int64
+	byType map[string]int64
+}
+
+const (
+	configPreviewPageSize = 500
+	configPreviewTopSize  = 50
+
+	algorithmActionBlock  = "block"
+	algorithmActionLog    = "log"
+	algorithmActionIgnore = "ignore"
+)
+
+var configPreviewMaxAlarms int64
+
+func init() {
+	// the max alarms is limited by the max result window of es
+	configPreviewMaxAlarms = beego.AppConfig.DefaultInt64("ConfigPreviewMaxAlarms", 10000)
+	if configPreviewMaxAlarms <= 0 {
+		configPreviewMaxAlarms = 10000
+	}
+}
+
+// PreviewWhitelistConfig counts the alarms of the last days matched by the whitelist entries,
+// the alarms are matched with the unexpired entries in the same way as the agents
+func PreviewWhitelistConfig(app *App, config []WhitelistConfigItem, days int) (*ConfigPreview, error) {
+	white := getActiveWhitelist(config, time.Now().Unix())
+	suppressed := newConfigPreviewCounter()
+	preview, err := scanAttackAlarms(app.Id, ConfigTypeWhitelist, days, func(alarm map[string]interface{}) {
+		if matchWhitelist(white, alarm) {
+			suppressed.add(alarm)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	preview.Suppressed, preview.SuppressedByUrl, preview.SuppressedByType = suppressed.result()
+	return preview, nil
+}
+
+// PreviewAlgorithmConfig counts the alarms of the last days whose actions would be changed by the algorithm config
+func PreviewAlgorithmConfig(app *App, config map[string]interface{}, days int) (*ConfigPreview, error) {
+	allLog := false
+	if meta, ok := config["meta"].(map[string]interface{}); ok {
+		allLog, _ = meta["all_log"].(bool)
+	}
+	suppressed := newConfigPreviewCounter()
+	blockToLog := newConfigPreviewCounter()
+	var logToBlock int64
+	preview, err := scanAttackAlarms(app.Id, ConfigTypeAlgorithm, days, func(alarm map[string]interface{}) {
+		algorithm, _ := alarm["plugin_algorithm"].(string)
+		item, ok := config[algorithm].(map[string]interface{})
+		if !ok {
+			return
+		}
+		action, _ := item["action"].(string)
+		if allLog && action == algorithmActionBlock {
+			action = algorithmActionLog
+		}
+		state, _ := alarm["intercept_state"].(string)
+		switch {
+		case action == algorithmActionIgnore:
+			suppressed.add(alarm)
+		case state == algorithmActionBlock && action == algorithmActionLog:
+			blockToLog.add(alarm)
+		case state == algorithmActionLog && action == algorithmActionBlock:
+			logToBlock += getAlarmCount(alarm)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	preview.Suppressed, preview.SuppressedByUrl, preview.SuppressedByType = suppressed.result()
+	preview.BlockToLog, preview.BlockToLogByUrl, preview.BlockToLogByType = blockToLog.result()
+	preview.LogToBlock = logToBlock
+	return preview, nil
+}
+
+// scanAttackAlarms passes the attack alarms of app in the last days to the handler, at most ConfigPreviewMaxAlarms
+func scanAttackAlarms(appId string, configType string, days int,
+	handler func(alarm map[string]interface{})) (*ConfigPreview, error) {
+	endTime := time.Now().UnixNano() / 1000000
+	startTime := endTime - int64(days)*24*3600*1000
+	preview := &ConfigPreview{
+		Type:      configType,
+		StartTime: startTime,
+		EndTime:   endTime,
+	}
+	for page := 1; preview.Scanned < configPreviewMaxAlarms; page++ {
+		total, alarms, err := logs.SearchLogs(startTime, endTime, nil, "event_time", page,
+			configPreviewPageSize, false, logs.AliasAttackIndexName+"-"+appId)
+		if err != nil {
+			return nil, errors.New("failed to search attack alarms: " + err.Error())
+		}
+		preview.Total = total
+		for _, alarm := range alarms {
+			if preview.Scanned >= configPreviewMaxAlarms {
+				break
+			}
+			handler(alarm)
+			preview.Scanned++
+		}
+		if len(alarms) < configPreviewPageSize {
+			break
+		}
+	}
+	return preview, nil
+}
+
+// matchWhitelist checks whether the alarm is whitelisted by the hook.white config, the agents match
+// the url prefixes with the request url without scheme and query, and * matches all of the urls
+func matchWhitelist(white map[string][]string, alarm map[string]interface{}) bool {
+	alarmUrl, _ := alarm["url"].(string)
+	u, err := url.Parse(alarmUrl)
+	if err != nil {
+		return false
+	}
+	requestUrl := u.Host + u.Path
+	attackType, _ := alarm["attack_type"].(string)
+	for prefix, hooks := range white {
+		if prefix != whitelistHostAll && !strings.HasPrefix(requestUrl, prefix) {
+			continue
+		}
+		if containsString(hooks, whitelistHookAll) || containsString(hooks, attackType) {
+			return true
+		}
+	}
+	return false
+}
+
+func newConfigPreviewCounter() *configPreviewCounter {
+	return &configPreviewCounter{
+		byUrl:  make(map[string]int64),
+		byType: make(map[string]int64),
+	}
+}
+
+func (counter *configPreviewCounter) add(alarm map[string]interface{}) {
+	alarmUrl, _ := alarm["url"].(string)
+	if u, err := url.Parse(alarmUrl); err == nil {
+		alarmUrl = u.Host + u.Path
+	}
+	attackType, _ := alarm["attack_type"].(string)
+	count := getAlarmCount(alarm)
+	counter.byUrl[alarmUrl] += count
+	counter.byType[attackType] += count
+}
+
+// getAlarmCount returns the count of the occurrences merged into the deduplicated alarm
+func getAlarmCount(alarm map[string]interface{}) int64 {
+	if count, ok := alarm["count"].(float64); ok && count > 1 {
+		return int64(count)
+	}
+	return 1
+}
+
+func (counter *configPreviewCounter) result() (total int64, byUrl []*ConfigPreviewGroup,
+	byType []*ConfigPreviewGroup) {
+	for _, count := range counter.byType {
+		total += count
+	}
+	return total, getConfigPreviewGroups(counter.byUrl), getConfigPreviewGroups(counter.byType)
+}
+
+func getConfigPreviewGroups(counts map[string]int64) []*ConfigPreviewGroup {
+	groups := make([]*ConfigPreviewGroup, 0, len(counts))
+	for key, count := range counts {
+		groups = append(groups, &ConfigPreviewGroup{Key: key, Count: count})
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Count == groups[j].Count {
+			return groups[i].Key < groups[j].Key
+		}
+		return groups[i].Count > groups[j].Count
+	})
+	if len(groups) > configPreviewTopSize {
+		groups = groups[:configPreviewTopSize]
+	}
+	return groups
+}
+
+// ValidateSelectedAlgorithmConfig checks the algorithm config with the default one of the selected plugin of app
+func ValidateSelectedAlgorithmConfig(appId string, config map[string]interface{}) error {
+	plugin, err := GetSelectedPlugin(appId, false)
+	if err != nil {
+		return errors.New("failed to get the selected plugin: " + err.Error())
+	}
+	return validAlgorithmConfig(plugin, config)
+}
@@ -0,0 +1,319 @@
+//Copyright 2017-2018 Baidu Inc.
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http: //www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"rasp-cloud/mongo"
+	"rasp-cloud/tools"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// ConfigVersion is an immutable snapshot of the general, whitelist or algorithm config of app,
+// the version increases from 1 for each type of config of app
+type ConfigVersion struct {
+	Id      string `json:"id" bson:"_id"`
+	AppId   string `json:"app_id" bson:"app_id"`
+	Type    string `json:"type" bson:"type"`
+	Version int    `json:"version" bson:"version"`
+	// the plugin of the algorithm config
+	PluginId string      `json:"plugin_id,omitempty" bson:"plugin_id,omitempty"`
+	Config   interface{} `json:"config,omitempty" bson:"config"`
+	Author   string      `json:"author" bson:"author"`
+	Comment  string      `json:"comment" bson:"comment"`
+	// the version rolled back to, 0 means the snapshot is not created by rollback
+	RollbackFrom int   `json:"rollback_from,omitempty" bson:"rollback_from,omitempty"`
+	CreateTime   int64 `json:"create_time" bson:"create_time"`
+}
+
+type ConfigDiffItem struct {
+	Key string `json:"key"`
+	// added, removed or changed
+	Op       string      `json:"op"`
+	OldValue interface{} `json:"old_value,omitempty"`
+	NewValue interface{} `json:"new_value,omitempty"`
+}
+
+const (
+	configVersionCollectionName = "config_version"
+
+	ConfigTypeGeneral   = "general"
+	ConfigTypeWhitelist = "whitelist"
+	ConfigTypeAlgorithm = "algorithm"
+
+	ConfigDiffOpAdded   = "added"
+	ConfigDiffOpRemoved = "removed"
+	ConfigDiffOpChanged = "changed"
+
+	maxConfigVersionRetries = 5
+)
+
+func init() {
+	err := mongo.CreateIndex(configVersionCollectionName, &mgo.Index{
+		Key:        []string{"app_id", "type", "-version"},
+		Unique:     true,
+		Background: true,
+		Name:       "app_id_type_version",
+	})
+	if err != nil {
+		tools.Panic(tools.ErrCodeMongoInitFailed, "failed to create index for config_version collection", err)
+	}
+}
+
+func IsConfigType(configType string) bool {
+	return configType == ConfigTypeGeneral || configType == ConfigTypeWhitelist || configType == ConfigTypeAlgorithm
+}
+
+// AddConfigVersion stores the snapshot of the config with the next version
+func AddConfigVersion(appId string, configType string, pluginId string, config interface{},
+	comment string) (*ConfigVersion, error) {
+	return addConfigVersion(appId, configType, pluginId, config, comment, 0)
+}
+
+// ValidateConfigVersionComment validates the comment before the config is published,
+// so that the version can be stored after that
+func ValidateConfigVersionComment(comment string) error {
+	if len(comment) > 1024 {
+		return errors.New("the length of comment can not be greater than 1024")
+	}
+	return nil
+}
+
+func addConfigVersion(appId string, configType string, pluginId string, config interface{},
+	comment string, rollbackFrom int) (*ConfigVersion, error) {
+	if err := ValidateConfigVersionComment(comment); err != nil {
+		return nil, err
+	}
+	author, err := GetLoginUserName()
+	if err != nil {
+		return nil, errors.New("failed to get the login user: " + err.Error())
+	}
+	version := &ConfigVersion{
+		AppId:        appId,
+		Type:         configType,
+		PluginId:     pluginId,
+		Config:       config,
+		Author:       author,
+		Comment:      comment,
+		RollbackFrom: rollbackFrom,
+		CreateTime:   time.Now().Unix(),
+	}
+	// the unique index makes the concurrent changes retry with the next version
+	for i := 0; i < maxConfigVersionRetries; i++ {
+		var last ConfigVersion
+		err = mongo.FindOneBySort(configVersionCollectionName, bson.M{"app_id": appId, "type": configType},
+			&last, "-version")
+		if err != nil && err != mgo.ErrNotFound {
+			return nil, err
+		}
+		version.Version = last.Version + 1
+		version.Id = fmt.Sprintf("%s-%s-%d", appId, configType, version.Version)
+		err = mongo.Insert(configVersionCollectionName, version)
+		if !mgo.IsDup(err) {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return version, nil
+}
+
+func GetConfigVersions(appId string, configType string, page int, perpage int) (count int,
+	result []*ConfigVersion, err error) {
+	query := bson.M{"app_id": appId}
+	if configType != "" {
+		query["type"] = configType
+	}
+	newSession := mongo.NewSession()
+	defer newSession.Close()
+	count, err = newSession.DB(mongo.DbName).C(configVersionCollectionName).Find(query).Count()
+	if err != nil {
+		return
+	}
+	// the config is only returned by the detail api
+	err = newSession.DB(mongo.DbName).C(configVersionCollectionName).Find(query).Select(bson.M{"config": 0}).
+		Sort("-create_time", "-version").Skip(perpage * (page - 1)).Limit(perpage).All(&result)
+	if result == nil {
+		result = make([]*ConfigVersion, 0)
+	}
+	return
+}
+
+func GetConfigVersion(appId string, configType string, version int) (result *ConfigVersion, err error) {
+	err = mongo.FindOne(configVersionCollectionName,
+		bson.M{"app_id": appId, "type": configType, "version": version}, &result)
+	return
+}
+
+// DiffConfigVersions returns the changes from the old version to the new version
+func DiffConfigVersions(oldVersion *ConfigVersion, newVersion *ConfigVersion) ([]*ConfigDiffItem, error) {
+	oldValues, err := flattenConfig(oldVersion.Type, oldVersion.Config)
+	if err != nil {
+		return nil, err
+	}
+	newValues, err := flattenConfig(newVersion.Type, newVersion.Config)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*ConfigDiffItem, 0)
+	for key, oldValue := range oldValues {
+		if newValue, ok := newValues[key]; !ok {
+			result = append(result, &ConfigDiffItem{Key: key, Op: ConfigDiffOpRemoved, OldValue: oldValue})
+		} else if !reflect.DeepEqual(oldValue, newValue) {
+			result = append(result,
+				&ConfigDiffItem{Key: key, Op: ConfigDiffOpChanged, OldValue: oldValue, NewValue: newValue})
+		}
+	}
+	for key, newValue := range newValues {
+		if _, ok := oldValues[key]; !ok {
+			result = append(result, &ConfigDiffItem{Key: key, Op: ConfigDiffOpAdded, NewValue: newValue})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Key < result[j].Key
+	})
+	return result, nil
+}
+
+// flattenConfig converts the config to the values with the dotted keys, the whitelist entries are
+// keyed by their matchers so that the reordered entries are not regarded as changed
+func flattenConfig(configType string, config interface{}) (map[string]interface{}, error) {
+	content, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]interface{})
+	if configType == ConfigTypeWhitelist {
+		var items []WhitelistConfigItem
+		if err := json.Unmarshal(content, &items); err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			if item.Host == "" && item.Path == "" {
+				normalizeLegacyWhitelistItem(&item)
+			}
+			key := getWhitelistMatcherKey(&item)
+			itemContent, err := json.Marshal(item)
+			if err != nil {
+				return nil, err
+			}
+			var value interface{}
+			if err := json.Unmarshal(itemContent, &value); err != nil {
+				return nil, err
+			}
+			flattenConfigValue(key, value, result)
+		}
+		return result, nil
+	}
+	var value interface{}
+	if err := json.Unmarshal(content, &value); err != nil {
+		return nil, err
+	}
+	flattenConfigValue("", value, result)
+	return result, nil
+}
+
+func flattenConfigValue(prefix string, value interface{}, result map[string]interface{}) {
+	if m, ok := value.(map[string]interface{}); ok && len(m) > 0 {
+		for key, subValue := range m {
+			if prefix != "" {
+				key = prefix + "." + key
+			}
+			flattenConfigValue(key, subValue, result)
+		}
+		return
+	}
+	result[prefix] = value
+}
+
+func getWhitelistMatcherKey(item *WhitelistConfigItem) string {
+	return "[" + item.Host + item.Path + "]"
+}
+
+// RollbackConfigVersion republishes the config of the version to the agents, it returns the published config
+// to be stored with AddRollbackConfigVersion
+func RollbackConfigVersion(version *ConfigVersion) (interface{}, error) {
+	content, err := json.Marshal(version.Config)
+	if err != nil {
+		return nil, err
+	}
+	var config interface{}
+	switch version.Type {
+	case ConfigTypeGeneral:
+		var generalConfig map[string]interface{}
+		if err := json.Unmarshal(content, &generalConfig); err != nil {
+			return nil, err
+		}
+		// the version may be stored before the schemas are checked, only the errors reject the rollback
+		if _, err := ValidateGeneralConfig(generalConfig, ""); err != nil {
+			return nil, errors.New("the config of version " + strconv.Itoa(version.Version) +
+				" is invalid: " + err.Error())
+		}
+		if _, err := UpdateGeneralConfig(version.AppId, generalConfig); err != nil {
+			return nil, err
+		}
+		config = generalConfig
+	case ConfigTypeWhitelist:
+		var items []WhitelistConfigItem
+		if err := json.Unmarshal(content, &items); err != nil {
+			return nil, err
+		}
+		// the entries expired since the version are not restored
+		now := time.Now().Unix()
+		whitelistConfig := make([]WhitelistConfigItem, 0, len(items))
+		for _, item := range items {
+			if item.ExpireTime == 0 || item.ExpireTime > now {
+				whitelistConfig = append(whitelistConfig, item)
+			}
+		}
+		if err := NormalizeWhitelistConfig(whitelistConfig); err != nil {
+			return nil, err
+		}
+		if _, err := UpdateWhiteListConfig(version.AppId, whitelistConfig); err != nil {
+			return nil, err
+		}
+		config = whitelistConfig
+	case ConfigTypeAlgorithm:
+		var algorithmConfig map[string]interface{}
+		if err := json.Unmarshal(content, &algorithmConfig); err != nil {
+			return nil, err
+		}
+		if _, err := UpdateAlgorithmConfig(version.PluginId, algorithmConfig); err != nil {
+			return nil, errors.New("failed to update the algorithm config of plugin " + version.PluginId +
+				": " + err.Error())
+		}
+		config = algorithmConfig
+	default:
+		return nil, errors.New("unknown config type: " + version.Type)
+	}
+	return config, nil
+}
+
+// AddRollbackConfigVersion stores the config rolled back to the version as a new version
+func AddRollbackConfigVersion(version *ConfigVersion, config interface{}, comment string) (*ConfigVersion, error) {
+	return addConfigVersion(version.AppId, version.Type, version.PluginId, config, comment, version.Version)
+}
+
+func RemoveConfigVersionsByAppId(appId string) error {
+	return mongo.RemoveAll(configVersionCollectionName, bson.M{"app_id": appId})
+}
@@ -0,0 +1,433 @@
+//Copyright 2017-2018 Baidu Inc.
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http: //www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+
+package models
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/astaxie/beego"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"html/template"
+	"rasp-cloud/environment"
+	"rasp-cloud/models/logs"
+	"rasp-cloud/mongo"
+	"rasp-cloud/tools"
+	"sort"
+	"time"
+)
+
+// DigestConfig schedules the security digest report of app, the report of the last day or week
+// is generated at the hour of the timezone, and it is pushed to the channels if they are enabled
+type DigestConfig struct {
+	Enable bool   `json:"enable" bson:"enable"`
+	Period string `json:"period" bson:"period"`
+	// the weekday (0 is sunday) to generate the weekly report
+	Weekday  int    `json:"weekday" bson:"weekday"`
+	Hour     int    `json:"hour" bson:"hour"`
+	Timezone string `json:"timezone" bson:"timezone"`
+	// email or http
+	Channels []string `json:"channels" bson:"channels"`
+	// the email receivers overriding the email alarm config
+	Recipients []string `json:"recipients" bson:"recipients"`
+}
+
+type Digest struct {
+	Id     string `json:"id" bson:"_id"`
+	AppId  string `json:"app_id" bson:"app_id"`
+	Period string `json:"period" bson:"period"`
+	// whether the report is generated by the schedule rather than manually
+	Scheduled bool `json:"scheduled" bson:"scheduled"`
+	// the time range of report in milliseconds
+	StartTime  int64          `json:"start_time" bson:"start_time"`
+	EndTime    int64          `json:"end_time" bson:"end_time"`
+	Summary    *DigestSummary `json:"summary" bson:"summary"`
+	Html       string         `json:"html,omitempty" bson:"html"`
+	CreateTime int64          `json:"create_time" bson:"create_time"`
+}
+
+// DigestSummary counts the attack alarms by the occurrences rather than the documents,
+// a deduplicated attack alarm is counted as the count of occurrences merged into it
+type DigestSummary struct {
+	AttackTotal            int64         `json:"attack_total" bson:"attack_total"`
+	AttackByType           []*DigestItem `json:"attack_by_type" bson:"attack_by_type"`
+	AttackByInterceptState []*DigestItem `json:"attack_by_intercept_state" bson:"attack_by_intercept_state"`
+	TopAttackSources       []*DigestItem `json:"top_attack_sources" bson:"top_attack_sources"`
+	TopCountries           []*DigestItem `json:"top_countries" bson:"top_countries"`
+	TopUrls                []*DigestItem `json:"top_urls" bson:"top_urls"`
+	PolicyTotal            int64         `json:"policy_total" bson:"policy_total"`
+	PolicyById             []*DigestItem `json:"policy_by_id" bson:"policy_by_id"`
+	NewPolicyById          []*DigestItem `json:"new_policy_by_id" bson:"new_policy_by_id"`
+	RaspOnline             int           `json:"rasp_online" bson:"rasp_online"`
+	RaspOffline            int           `json:"rasp_offline" bson:"rasp_offline"`
+	RequestSum             int64         `json:"request_sum" bson:"request_sum"`
+}
+
+type DigestItem struct {
+	Key   string `json:"key" bson:"key"`
+	Count int64  `json:"count" bson:"count"`
+}
+
+type digestTemplateParam struct {
+	App       NotificationTemplateApp
+	Digest    *Digest
+	StartTime string
+	EndTime   string
+	Links     NotificationTemplateLinks
+}
+
+const (
+	digestCollectionName = "digest"
+
+	DigestPeriodDaily  = "daily"
+	DigestPeriodWeekly = "weekly"
+
+	NotificationSourceDigest = "digest"
+
+	digestTopSize = 10
+)
+
+var digestTemplateFiles = map[string]string{
+	NotificationTemplateLanguageZh: "views/digest.tpl",
+	NotificationTemplateLanguageEn: "views/digest_en.tpl",
+}
+
+func init() {
+	err := mongo.CreateIndex(digestCollectionName, &mgo.Index{
+		Key:        []string{"app_id", "-end_time"},
+		Unique:     false,
+		Background: true,
+		Name:       "app_id_end_time",
+	})
+	if err != nil {
+		tools.Panic(tools.ErrCodeMongoInitFailed, "failed to create app_id index for digest collection", err)
+	}
+	checkInterval := beego.AppConfig.DefaultInt64("DigestCheckInterval", 60)
+	if checkInterval <= 0 {
+		tools.Panic(tools.ErrCodeConfigInitFailed, "the 'DigestCheckInterval' config must be greater than 0", nil)
+	}
+	if *environment.StartFlag.StartType == environment.StartTypeDefault ||
+		*environment.StartFlag.StartType == environment.StartTypeForeground {
+		go startDigestTicker(time.Second * time.Duration(checkInterval))
+	}
+}
+
+func ValidateDigestConfig(config *DigestConfig) error {
+	if config.Period != DigestPeriodDaily && config.Period != DigestPeriodWeekly {
+		return errors.New("the period of digest must be daily or weekly")
+	}
+	if config.Weekday < 0 || config.Weekday > 6 {
+		return errors.New("the weekday of digest must be between [0,6]")
+	}
+	if config.Hour < 0 || config.Hour > 23 {
+		return errors.New("the hour of digest must be between [0,23]")
+	}
+	if _, err := time.LoadLocation(config.Timezone); err != nil {
+		return errors.New("invalid timezone of digest: " + config.Timezone)
+	}
+	for _, channel := range config.Channels {
+		if channel != NotificationChannelEmail && channel != NotificationChannelHttp {
+			return errors.New("the channel of digest must be email or http")
+		}
+	}
+	if len(config.Recipients) > 128 {
+		return errors.New("the count of digest recipients can not be greater than 128")
+	}
+	return nil
+}
+
+func UpdateDigestConfig(appId string, config *DigestConfig) (app *App, err error) {
+	return UpdateAppById(appId, bson.M{"digest_config": config})
+}
+
+// getDigestTimeRange returns the range of the latest report that should be generated before now
+func getDigestTimeRange(config *DigestConfig, now time.Time) (startTime time.Time, endTime time.Time, err error) {
+	location, err := time.LoadLocation(config.Timezone)
+	if err != nil {
+		return
+	}
+	local := now.In(location)
+	endTime = time.Date(local.Year(), local.Month(), local.Day(), config.Hour, 0, 0, 0, location)
+	days := 1
+	if config.Period == DigestPeriodWeekly {
+		days = 7
+		endTime = endTime.AddDate(0, 0, -((int(local.Weekday()) - config.Weekday + 7) % 7))
+	}
+	if endTime.After(now) {
+		endTime = endTime.AddDate(0, 0, -days)
+	}
+	return endTime.AddDate(0, 0, -days), endTime, nil
+}
+
+func startDigestTicker(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	for {
+		select {
+		case <-ticker.C:
+			// the reports are only generated by the leader among the panel instances
+			if mongo.IsLeader() {
+				handleDigests()
+			}
+		}
+	}
+}
+
+func handleDigests() {
+	defer func() {
+		if r := recover(); r != nil {
+			beego.Error("failed to handle digest: ", r)
+		}
+	}()
+	var apps []App
+	_, err := mongo.FindAllWithSelect(appCollectionName, bson.M{"digest_config.enable": true}, &apps,
+		bson.M{"plugin": 0}, 0, 0)
+	if err != nil {
+		beego.Error("failed to get apps for the digest: " + err.Error())
+		return
+	}
+	for _, app := range apps {
+		if app.DigestConfig == nil {
+			continue
+		}
+		startTime, endTime, err := getDigestTimeRange(app.DigestConfig, time.Now())
+		if err != nil {
+			beego.Error("invalid digest config of app " + app.Id + ": " + err.Error())
+			continue
+		}
+		var last Digest
+		err = mongo.FindOneBySort(digestCollectionName, bson.M{"app_id": app.Id, "scheduled": true},
+			&last, "-end_time")
+		if err != nil && err != mgo.ErrNotFound {
+			beego.Error("failed to get the last digest of app " + app.Id + ": " + err.Error())
+			continue
+		}
+		if err == nil && last.EndTime >= endTime.UnixNano()/1000000 {
+			continue
+		}
+		digest, err := generateDigest(&app, app.DigestConfig.Period, startTime, endTime, true)
+		if err != nil {
+			beego.Error("failed to generate the digest of app " + app.Id + ": " + err.Error())
+			continue
+		}
+		pushDigest(&app, digest)
+	}
+}
+
+// GenerateDigest generates and stores the report of app in the time range
+func GenerateDigest(app *App, period string, startTime time.Time, endTime time.Time) (*Digest, error) {
+	return generateDigest(app, period, startTime, endTime, false)
+}
+
+func generateDigest(app *App, period string, startTime time.Time, endTime time.Time,
+	scheduled bool) (*Digest, error) {
+	digest := &Digest{
+		Id:         mongo.GenerateObjectId(),
+		AppId:      app.Id,
+		Period:     period,
+		Scheduled:  scheduled,
+		StartTime:  startTime.UnixNano() / 1000000,
+		EndTime:    endTime.UnixNano() / 1000000,
+		CreateTime: time.Now().Unix(),
+	}
+	if scheduled {
+		// the same id makes sure that the scheduled report is only generated once
+		digest.Id = fmt.Sprintf("%x", md5.Sum([]byte(app.Id+"|"+period+"|"+fmt.Sprint(digest.EndTime))))
+	}
+	summary, err := getDigestSummary(app, digest.StartTime, digest.EndTime-1)
+	if err != nil {
+		return nil, err
+	}
+	digest.Summary = summary
+	digest.Html, err = renderDigest(app, digest, startTime.Location())
+	if err != nil {
+		return nil, err
+	}
+	err = mongo.Insert(digestCollectionName, digest)
+	if err != nil {
+		return nil, err
+	}
+	return digest, nil
+}
+
+func getDigestSummary(app *App, startTime int64, endTime int64) (*DigestSummary, error) {
+	summary := &DigestSummary{}
+	attackTerms := []struct {
+		field  string
+		size   int
+		result *[]*DigestItem
+	}{
+		{"attack_type", 100, &summary.AttackByType},
+		{"intercept_state", 10, &summary.AttackByInterceptState},
+		{"attack_source", digestTopSize, &summary.TopAttackSources},
+		{"attack_location.country_" + getDigestLanguageSuffix(), digestTopSize, &summary.TopCountries},
+		{"url", digestTopSize, &summary.TopUrls},
+	}
+	// the attack aggregations sum the count of deduplicated alarms
+	for _, terms := range attackTerms {
+		result, err := logs.AggregationAttackWithTerms(startTime, endTime, terms.field, nil, 1, terms.size, app.Id)
+		if err != nil {
+			return nil, errors.New("failed to aggregate attack alarms by " + terms.field + ": " + err.Error())
+		}
+		*terms.result = getDigestItems(result)
+	}
+	total, err := logs.AggregationAttackWithTerms(startTime, endTime, "", nil, 0, 0, app.Id)
+	if err != nil {
+		return nil, errors.New("failed to count attack alarms: " + err.Error())
+	}
+	summary.AttackTotal = total[""]
+	policies, err := logs.AggregationPolicyWithTerms(startTime, endTime, "policy_id", nil, 1, 100, app.Id)
+	if err != nil {
+		return nil, errors.New("failed to aggregate policy alarms: " + err.Error())
+	}
+	summary.PolicyById = getDigestItems(policies)
+	for _, item := range summary.PolicyById {
+		summary.PolicyTotal += item.Count
+	}
+	firstSeen, err := logs.AggregationPolicyFirstSeen(startTime, endTime, "policy_id", 100, app.Id)
+	if err != nil {
+		return nil, errors.New("failed to aggregate new policy alarms: " + err.Error())
+	}
+	newPolicies := make(map[string]int64)
+	for policyId := range firstSeen {
+		if count := policies[policyId]; count > 0 {
+			newPolicies[policyId] = count
+		}
+	}
+	summary.NewPolicyById = getDigestItems(newPolicies)
+	summary.RaspOnline, summary.RaspOffline, err = CountRaspByAppId(app.Id)
+	if err != nil {
+		return nil, errors.New("failed to count rasps: " + err.Error())
+	}
+	err, requestSums := GetHistoryRequestSum(startTime, endTime, "day", "UTC", app.Id)
+	if err != nil {
+		return nil, errors.New("failed to get request sum: " + err.Error())
+	}
+	for _, item := range requestSums {
+		if sum, ok := item["request_sum"].(*float64); ok && sum != nil {
+			summary.RequestSum += int64(*sum)
+		}
+	}
+	return summary, nil
+}
+
+func getDigestLanguageSuffix() string {
+	if defaultNotificationTemplateLanguage == NotificationTemplateLanguageEn {
+		return "en"
+	}
+	return "zh_cn"
+}
+
+func getDigestItems(result map[string]int64) []*DigestItem {
+	items := make([]*DigestItem, 0, len(result))
+	for key, count := range result {
+		items = append(items, &DigestItem{Key: key, Count: count})
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Count == items[j].Count {
+			return items[i].Key < items[j].Key
+		}
+		return items[i].Count > items[j].Count
+	})
+	return items
+}
+
+func renderDigest(app *App, digest *Digest, location *time.Location) (string, error) {
+	t, err := template.ParseFiles(digestTemplateFiles[defaultNotificationTemplateLanguage])
+	if err != nil {
+		return "", errors.New("failed to parse digest template: " + err.Error())
+	}
+	content := new(bytes.Buffer)
+	err = t.Execute(content, &digestTemplateParam{
+		App: NotificationTemplateApp{
+			Id:          app.Id,
+			Name:        app.Name,
+			Language:    app.Language,
+			Description: app.Description,
+		},
+		Digest:    digest,
+		StartTime: time.Unix(0, digest.StartTime*1000000).In(location).Format("2006-01-02 15:04 MST"),
+		EndTime:   time.Unix(0, digest.EndTime*1000000).In(location).Format("2006-01-02 15:04 MST"),
+		Links: NotificationTemplateLinks{
+			Panel:  panelServerURL,
+			Events: panelServerURL + "/#/events/" + app.Id,
+		},
+	})
+	if err != nil {
+		return "", errors.New("failed to execute digest template: " + err.Error())
+	}
+	return content.String(), nil
+}
+
+// pushDigest queues the report to the channels of the digest config
+func pushDigest(app *App, digest *Digest) {
+	var deliveries []*NotificationDelivery
+	for _, channel := range app.DigestConfig.Channels {
+		switch channel {
+		case NotificationChannelEmail:
+			if app.EmailAlarmConf.Enable {
+				subject := "OpenRASP " + digest.Period + " report: " + app.Name
+				deliveries = append(deliveries, newNotificationDelivery(app, NotificationSourceDigest, channel,
+					app.DigestConfig.Recipients, subject, digest.Html))
+			}
+		case NotificationChannelHttp:
+			if app.HttpAlarmConf.Enable {
+				body, err := json.Marshal(map[string]interface{}{
+					"app_id":     app.Id,
+					"digest_id":  digest.Id,
+					"period":     digest.Period,
+					"start_time": digest.StartTime,
+					"end_time":   digest.EndTime,
+					"summary":    digest.Summary,
+				})
+				if err != nil {
+					beego.Error("failed to marshal the digest of app " + app.Id + ": " + err.Error())
+					continue
+				}
+				deliveries = append(deliveries,
+					newHttpNotificationDeliveries(app, NotificationSourceDigest, nil, string(body))...)
+			}
+		}
+	}
+	for _, delivery := range deliveries {
+		queueNotification(delivery)
+	}
+}
+
+func GetDigests(appId string, page int, perpage int) (count int, result []*Digest, err error) {
+	count, err = mongo.FindAll(digestCollectionName, bson.M{"app_id": appId}, &result,
+		perpage*(page-1), perpage, "-end_time")
+	if err == nil {
+		if result == nil {
+			result = make([]*Digest, 0)
+		}
+		// the html is only returned by the detail api
+		for _, digest := range result {
+			digest.Html = ""
+		}
+	}
+	return
+}
+
+func GetDigestById(id string) (digest *Digest, err error) {
+	err = mongo.FindId(digestCollectionName, id, &digest)
+	return
+}
+
+func RemoveDigestsByAppId(appId string) error {
+	return mongo.RemoveAll(digestCollectionName, bson.M{"app_id": appId})
+}
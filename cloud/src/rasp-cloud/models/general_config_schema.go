@@ -0,0 +1,247 @@
+//Copyright 2017-2018 Baidu Inc.
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http: //www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+
+package models
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// GeneralConfigSchema describes the type and the valid values of a general config key,
+// and the languages of the agents supporting it
+type GeneralConfigSchema struct {
+	Key     string      `json:"key"`
+	Type    string      `json:"type"`
+	Default interface{} `json:"default"`
+	// the range of the int values
+	Min *int64 `json:"min,omitempty"`
+	Max *int64 `json:"max,omitempty"`
+	// the max length of the string values
+	MaxLength int           `json:"max_length,omitempty"`
+	Enum      []interface{} `json:"enum,omitempty"`
+	// url or syslog_url
+	Format      string   `json:"format,omitempty"`
+	Languages   []string `json:"languages"`
+	Description string   `json:"description"`
+}
+
+const (
+	GeneralConfigTypeString = "string"
+	GeneralConfigTypeInt    = "int"
+	GeneralConfigTypeBool   = "bool"
+
+	generalConfigFormatUrl       = "url"
+	generalConfigFormatSyslogUrl = "syslog_url"
+
+	maxGeneralConfigStringLength = 512
+)
+
+var (
+	allAgentLanguages = []string{"java", "php"}
+	javaAgentLanguage = []string{"java"}
+	phpAgentLanguage  = []string{"php"}
+
+	generalConfigSchemas = []*GeneralConfigSchema{
+		{Key: "clientip.header", Type: GeneralConfigTypeString, MaxLength: 256, Languages: allAgentLanguages,
+			Description: "the http header of the real client ip behind the proxy"},
+		{Key: "block.status_code", Type: GeneralConfigTypeInt, Min: int64Ptr(100), Max: int64Ptr(999),
+			Languages: allAgentLanguages, Description: "the http status code of the blocked request"},
+		{Key: "block.redirect_url", Type: GeneralConfigTypeString, Format: generalConfigFormatUrl,
+			Languages: allAgentLanguages, Description: "the url to redirect the blocked request to"},
+		{Key: "block.content_xml", Type: GeneralConfigTypeString, Languages: allAgentLanguages,
+			Description: "the response of the blocked xml request"},
+		{Key: "block.content_html", Type: GeneralConfigTypeString, Languages: allAgentLanguages,
+			Description: "the response of the blocked html request"},
+		{Key: "block.content_json", Type: GeneralConfigTypeString, Languages: allAgentLanguages,
+			Description: "the response of the blocked json request"},
+		{Key: "plugin.timeout.millis", Type: GeneralConfigTypeInt, Min: int64Ptr(1), Languages: allAgentLanguages,
+			Description: "the timeout of the plugin check in milliseconds"},
+		{Key: "plugin.filter", Type: GeneralConfigTypeBool, Languages: allAgentLanguages,
+			Description: "whether to skip the plugin check of the files that do not exist"},
+		{Key: "plugin.maxstack", Type: GeneralConfigTypeInt, Min: int64Ptr(1), Languages: allAgentLanguages,
+			Description: "the max depth of the stack passed to the plugin"},
+		{Key: "body.maxbytes", Type: GeneralConfigTypeInt, Min: int64Ptr(1), Languages: allAgentLanguages,
+			Description: "the max bytes of the request body to read"},
+		{Key: "ognl.expression.minlength", Type: GeneralConfigTypeInt, Min: int64Ptr(1),
+			Languages: javaAgentLanguage, Description: "the min length of the ognl expression to check"},
+		{Key: "log.maxstack", Type: GeneralConfigTypeInt, Min: int64Ptr(1), Languages: allAgentLanguages,
+			Description: "the max depth of the stack in the alarm"},
+		{Key: "log.maxburst", Type: GeneralConfigTypeInt, Min: int64Ptr(1), Languages: allAgentLanguages,
+			Description: "the max count of the alarms per minute"},
+		{Key: "syslog.enable", Type: GeneralConfigTypeBool, Languages: allAgentLanguages,
+			Description: "whether to send the alarms to the syslog server"},
+		{Key: "syslog.url", Type: GeneralConfigTypeString, Format: generalConfigFormatSyslogUrl,
+			Languages: allAgentLanguages, Description: "the syslog server, such as tcp://1.1.1.1:514"},
+		{Key: "syslog.tag", Type: GeneralConfigTypeString, MaxLength: 128, Languages: allAgentLanguages,
+			Description: "the tag of the syslog message"},
+		{Key: "syslog.facility", Type: GeneralConfigTypeInt, Min: int64Ptr(0), Max: int64Ptr(23),
+			Languages: allAgentLanguages, Description: "the facility of the syslog message"},
+		{Key: "syslog.reconnect_interval", Type: GeneralConfigTypeInt, Min: int64Ptr(1),
+			Languages: allAgentLanguages, Description: "the seconds to wait before reconnecting the syslog server"},
+		{Key: "syslog.connection_timeout", Type: GeneralConfigTypeInt, Min: int64Ptr(1),
+			Languages: phpAgentLanguage, Description: "the connection timeout of the syslog server in milliseconds"},
+		{Key: "syslog.read_timeout", Type: GeneralConfigTypeInt, Min: int64Ptr(1),
+			Languages: phpAgentLanguage, Description: "the read timeout of the syslog server in milliseconds"},
+		{Key: "inject.urlprefix", Type: GeneralConfigTypeString, Languages: allAgentLanguages,
+			Description: "the url prefix of the pages to inject the html"},
+		{Key: "hooks.ignore", Type: GeneralConfigTypeString, Languages: javaAgentLanguage,
+			Description: "the comma separated hooks to disable"},
+		{Key: "request.param_encoding", Type: GeneralConfigTypeString, MaxLength: 64,
+			Languages: javaAgentLanguage, Description: "the charset to decode the request parameters"},
+		{Key: "security.enforce_policy", Type: GeneralConfigTypeBool, Languages: allAgentLanguages,
+			Description: "whether to block the server from starting if the security policy is violated"},
+		{Key: "sql.slowquery.min_rows", Type: GeneralConfigTypeInt, Min: int64Ptr(1), Languages: allAgentLanguages,
+			Description: "the min rows of the slow query"},
+		{Key: "lru.max_size", Type: GeneralConfigTypeInt, Min: int64Ptr(0), Languages: allAgentLanguages,
+			Description: "the max size of the lru cache of the checked results"},
+		{Key: "debug.level", Type: GeneralConfigTypeInt, Min: int64Ptr(0), Languages: javaAgentLanguage,
+			Description: "the debug level of the agent"},
+	}
+	generalConfigSchemaMap = make(map[string]*GeneralConfigSchema, len(generalConfigSchemas))
+)
+
+func init() {
+	for _, schema := range generalConfigSchemas {
+		schema.Default = DefaultGeneralConfig[schema.Key]
+		generalConfigSchemaMap[schema.Key] = schema
+	}
+}
+
+func int64Ptr(value int64) *int64 {
+	return &value
+}
+
+func GetGeneralConfigSchemas() []*GeneralConfigSchema {
+	return generalConfigSchemas
+}
+
+// ValidateGeneralConfig checks the values of the known keys with their schemas, and returns the warnings
+// of the unknown keys and the keys not supported by the agents of the language, the empty language skips the check
+func ValidateGeneralConfig(config map[string]interface{}, language string) (warnings []string, err error) {
+	warnings = make([]string, 0)
+	keys := make([]string, 0, len(config))
+	for key := range config {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := config[key]
+		if value == nil {
+			return nil, errors.New("the value of " + key + " config cannot be nil")
+		}
+		schema, ok := generalConfigSchemaMap[key]
+		if !ok {
+			if v, ok := value.(string); ok && len(v) >= maxGeneralConfigStringLength {
+				return nil, fmt.Errorf("the length of config key %s must be less than %d",
+					key, maxGeneralConfigStringLength)
+			}
+			warnings = append(warnings, "unknown config key: "+key)
+			continue
+		}
+		if err := validateGeneralConfigValue(schema, value); err != nil {
+			return nil, err
+		}
+		if language != "" && len(schema.Languages) > 0 && !containsString(schema.Languages, language) {
+			warnings = append(warnings, "the config key "+key+" is not supported by the "+language+" agent")
+		}
+	}
+	return warnings, nil
+}
+
+func validateGeneralConfigValue(schema *GeneralConfigSchema, value interface{}) error {
+	switch schema.Type {
+	case GeneralConfigTypeString:
+		v, ok := value.(string)
+		if !ok {
+			return errors.New("the value of " + schema.Key + " config must be a string")
+		}
+		maxLength := schema.MaxLength
+		if maxLength <= 0 {
+			maxLength = maxGeneralConfigStringLength - 1
+		}
+		if len(v) > maxLength {
+			return fmt.Errorf("the length of config key %s can not be greater than %d", schema.Key, maxLength)
+		}
+		return validateGeneralConfigFormat(schema, v)
+	case GeneralConfigTypeBool:
+		if _, ok := value.(bool); !ok {
+			return errors.New("the value of " + schema.Key + " config must be a boolean")
+		}
+	case GeneralConfigTypeInt:
+		v, ok := getGeneralConfigInt(value)
+		if !ok {
+			return errors.New("the value of " + schema.Key + " config must be an integer")
+		}
+		if schema.Min != nil && v < *schema.Min {
+			return fmt.Errorf("the value of %s config can not be less than %d", schema.Key, *schema.Min)
+		}
+		if schema.Max != nil && v > *schema.Max {
+			return fmt.Errorf("the value of %s config can not be greater than %d", schema.Key, *schema.Max)
+		}
+	}
+	if len(schema.Enum) > 0 {
+		for _, item := range schema.Enum {
+			if fmt.Sprint(item) == fmt.Sprint(value) {
+				return nil
+			}
+		}
+		return fmt.Errorf("the value of %s config must be one of %v", schema.Key, schema.Enum)
+	}
+	return nil
+}
+
+// getGeneralConfigInt returns the integer of the json number or the int of the default config
+func getGeneralConfigInt(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), true
+	case int64:
+		return v, true
+	case float64:
+		if v != math.Trunc(v) || math.IsInf(v, 0) || math.Abs(v) > 1<<53 {
+			return 0, false
+		}
+		return int64(v), true
+	}
+	return 0, false
+}
+
+func validateGeneralConfigFormat(schema *GeneralConfigSchema, value string) error {
+	switch schema.Format {
+	case generalConfigFormatUrl:
+		// the %request_id% placeholder is not a valid url escape
+		u, err := url.Parse(strings.Replace(value, "%request_id%", "request_id", -1))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.New("the value of " + schema.Key + " config must be a http or https url")
+		}
+	case generalConfigFormatSyslogUrl:
+		if value == "" {
+			return nil
+		}
+		u, err := url.Parse(value)
+		if err != nil || (u.Scheme != "tcp" && u.Scheme != "udp") {
+			return errors.New("the value of " + schema.Key + " config must be a tcp or udp url")
+		}
+		if _, _, err := net.SplitHostPort(u.Host); err != nil {
+			return errors.New("the value of " + schema.Key + " config must have the host and port")
+		}
+	}
+	return nil
+}
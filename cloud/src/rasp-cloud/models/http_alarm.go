@@ -0,0 +1,231 @@
+//Copyright 2017-2018 Baidu Inc.
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http: //www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+
+package models
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/httplib"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HttpReceiver is an http alarm url with the authentication and connection settings
+type HttpReceiver struct {
+	Url string `json:"url" bson:"url"`
+	// the key of the hmac-sha256 signature header, the alarm is not signed if it is empty
+	Secret string `json:"secret" bson:"secret"`
+	// the custom headers such as Authorization: Bearer xxx
+	Headers       map[string]string `json:"headers" bson:"headers"`
+	TlsSkipVerify bool              `json:"tls_skip_verify" bson:"tls_skip_verify"`
+	// the base64 sha256 pins of the subject public key info, one of the certificates must match a pin
+	TlsPins []string `json:"tls_pins" bson:"tls_pins"`
+	// the connect and read write timeout in seconds
+	Timeout int `json:"timeout" bson:"timeout"`
+}
+
+// the receiver verifies an alarm by computing the hex hmac-sha256 of "<timestamp>.<body>" with the secret,
+// and it should reject the alarms with an old timestamp or a seen delivery id to prevent replay
+const (
+	HttpAlarmSignatureHeader = "X-OpenRASP-Signature"
+	HttpAlarmTimestampHeader = "X-OpenRASP-Timestamp"
+	HttpAlarmDeliveryHeader  = "X-OpenRASP-Delivery"
+
+	defaultHttpAlarmTimeout = 10
+	maxHttpAlarmTimeout     = 60
+	maxHttpAlarmHeaders     = 32
+)
+
+func ValidateHttpReceivers(receivers []HttpReceiver) error {
+	if len(receivers) > 128 {
+		return errors.New("the count of http receivers can not be greater than 128")
+	}
+	urls := make(map[string]bool)
+	for _, receiver := range receivers {
+		if len(receiver.Url) > 1024 {
+			return errors.New("the length of http receiver url can not be greater than 1024")
+		}
+		receiverUrl, err := url.Parse(receiver.Url)
+		if err != nil || (receiverUrl.Scheme != "http" && receiverUrl.Scheme != "https") || receiverUrl.Host == "" {
+			return errors.New("invalid url of http receiver: " + receiver.Url)
+		}
+		if urls[receiver.Url] {
+			return errors.New("duplicate url of http receiver: " + receiver.Url)
+		}
+		urls[receiver.Url] = true
+		if len(receiver.Secret) > 256 {
+			return errors.New("the length of http receiver secret can not be greater than 256")
+		}
+		if len(receiver.Headers) > maxHttpAlarmHeaders {
+			return errors.New("the count of http receiver headers can not be greater than " +
+				strconv.Itoa(maxHttpAlarmHeaders))
+		}
+		for key, value := range receiver.Headers {
+			if key == "" || len(key) > 256 || len(value) > 4096 || strings.ContainsAny(key, " :\r\n") ||
+				strings.ContainsAny(value, "\r\n") {
+				return errors.New("invalid http receiver header: " + key)
+			}
+		}
+		for _, pin := range receiver.TlsPins {
+			if hash, err := base64.StdEncoding.DecodeString(pin); err != nil || len(hash) != sha256.Size {
+				return errors.New("the tls pin of http receiver must be a base64 sha256 hash: " + pin)
+			}
+		}
+		if receiver.Timeout < 0 || receiver.Timeout > maxHttpAlarmTimeout {
+			return errors.New("the timeout of http receiver must be between [0," +
+				strconv.Itoa(maxHttpAlarmTimeout) + "]")
+		}
+	}
+	return nil
+}
+
+// getHttpReceivers returns the receivers and the plain urls of recv_addr of the http alarm config
+func getHttpReceivers(conf *HttpAlarmConf) []HttpReceiver {
+	receivers := make([]HttpReceiver, 0, len(conf.Receivers)+len(conf.RecvAddr))
+	receivers = append(receivers, conf.Receivers...)
+	for _, addr := range conf.RecvAddr {
+		if findHttpReceiver(conf, addr) == nil {
+			receivers = append(receivers, HttpReceiver{Url: addr})
+		}
+	}
+	return receivers
+}
+
+func findHttpReceiver(conf *HttpAlarmConf, addr string) *HttpReceiver {
+	for i := range conf.Receivers {
+		if conf.Receivers[i].Url == addr {
+			return &conf.Receivers[i]
+		}
+	}
+	return nil
+}
+
+func signHttpAlarm(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (receiver *HttpReceiver) getTlsConfig() *tls.Config {
+	if !receiver.TlsSkipVerify && len(receiver.TlsPins) == 0 {
+		return nil
+	}
+	config := &tls.Config{InsecureSkipVerify: receiver.TlsSkipVerify}
+	if len(receiver.TlsPins) > 0 {
+		pins := receiver.TlsPins
+		config.VerifyPeerCertificate = func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+			for _, rawCert := range rawCerts {
+				cert, err := x509.ParseCertificate(rawCert)
+				if err != nil {
+					continue
+				}
+				hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+				if containsString(pins, base64.StdEncoding.EncodeToString(hash[:])) {
+					return nil
+				}
+			}
+			return errors.New("none of the certificates matches the tls pins")
+		}
+	}
+	return config
+}
+
+// postHttpReceiver posts the json body to the receiver, it returns the status code of response,
+// which is 0 if no response is received
+func postHttpReceiver(receiver *HttpReceiver, body []byte, deliveryId string) (int, error) {
+	timeout := time.Duration(defaultHttpAlarmTimeout) * time.Second
+	if receiver.Timeout > 0 {
+		timeout = time.Duration(receiver.Timeout) * time.Second
+	}
+	request := httplib.Post(receiver.Url)
+	request.SetTimeout(timeout, timeout)
+	if tlsConfig := receiver.getTlsConfig(); tlsConfig != nil {
+		request.SetTLSClientConfig(tlsConfig)
+	}
+	for key, value := range receiver.Headers {
+		request.Header(key, value)
+	}
+	request.Header("Content-Type", "application/json")
+	request.Header(HttpAlarmDeliveryHeader, deliveryId)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	request.Header(HttpAlarmTimestampHeader, timestamp)
+	if receiver.Secret != "" {
+		request.Header(HttpAlarmSignatureHeader, signHttpAlarm(receiver.Secret, timestamp, body))
+	}
+	request.Body(body)
+	response, err := request.Response()
+	if err != nil {
+		beego.Error("failed to push http alarms to: " + receiver.Url + ", with error: " + err.Error())
+		return 0, err
+	}
+	if response.StatusCode > 299 || response.StatusCode < 200 {
+		err := errors.New("failed to push http alarms to: " + receiver.Url + ", with status code: " +
+			strconv.Itoa(response.StatusCode))
+		beego.Error(err.Error())
+		return response.StatusCode, err
+	}
+	return response.StatusCode, nil
+}
+
+// maskHttpReceivers masks the secrets and header values of the http receivers
+func maskHttpReceivers(receivers []HttpReceiver) {
+	for i := range receivers {
+		if receivers[i].Secret != "" {
+			receivers[i].Secret = SecreteMask
+		}
+		if len(receivers[i].Headers) > 0 {
+			headers := make(map[string]string, len(receivers[i].Headers))
+			for key := range receivers[i].Headers {
+				headers[key] = SecreteMask
+			}
+			receivers[i].Headers = headers
+		}
+	}
+}
+
+// RestoreHttpReceivers replaces the masked secrets and header values with the ones of the old receivers
+func RestoreHttpReceivers(receivers []HttpReceiver, oldReceivers []HttpReceiver) {
+	for i := range receivers {
+		var old *HttpReceiver
+		for j := range oldReceivers {
+			if oldReceivers[j].Url == receivers[i].Url {
+				old = &oldReceivers[j]
+			}
+		}
+		if receivers[i].Secret == SecreteMask {
+			receivers[i].Secret = ""
+			if old != nil {
+				receivers[i].Secret = old.Secret
+			}
+		}
+		for key, value := range receivers[i].Headers {
+			if value == SecreteMask {
+				receivers[i].Headers[key] = ""
+				if old != nil {
+					receivers[i].Headers[key] = old.Headers[key]
+				}
+			}
+		}
+	}
+}
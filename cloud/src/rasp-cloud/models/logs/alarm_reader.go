@@ -0,0 +1,51 @@
+//Copyright 2017-2018 Baidu Inc.
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http: //www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+
+package logs
+
+import (
+	"github.com/astaxie/beego"
+	"io"
+	"rasp-cloud/models/logs/ingest"
+	"time"
+)
+
+var (
+	AlarmMaxBodySize   int64
+	stripUnknownFields bool
+)
+
+func init() {
+	maxBodySize := beego.AppConfig.DefaultInt64("AgentLogMaxBodySize", 32)
+	if maxBodySize <= 0 {
+		maxBodySize = 32
+	}
+	AlarmMaxBodySize = maxBodySize * 1024 * 1024
+	stripUnknownFields = beego.AppConfig.DefaultBool("AlarmStripUnknownFields", false)
+}
+
+// ReadAlarms reads the alarms of agent with ingest.ReadAlarms, every alarm is normalized with the schema
+// of alarm type and added as soon as it is decoded
+func ReadAlarms(body io.Reader, contentEncoding string, alarmType string, appId string,
+	add func(map[string]interface{}) error) (*ingest.Result, error) {
+	return ingest.ReadAlarms(body, contentEncoding, AlarmMaxBodySize, func(alarm map[string]interface{}) error {
+		if err := ingest.NormalizeAlarm(alarmType, appId, alarm, stripUnknownFields); err != nil {
+			incrRejectedAlarm(alarmType)
+			return err
+		}
+		// the receive time is set after the normalization, it is not provided by agent
+		alarm["@timestamp"] = time.Now().UnixNano() / 1000000
+		return add(alarm)
+	})
+}
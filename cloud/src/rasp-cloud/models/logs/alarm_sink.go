@@ -0,0 +1,166 @@
+//Copyright 2017-2018 Baidu Inc.
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http: //www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+
+package logs
+
+import (
+	"fmt"
+	"github.com/astaxie/beego"
+	"rasp-cloud/tools"
+	"strconv"
+	"strings"
+)
+
+// AlarmSink is a destination of the alarms, every configured sink receives every alarm
+type AlarmSink interface {
+	Name() string
+	// Write handles a batch of alarms with the same alarm type,
+	// it returns the count of alarms which have been written successfully
+	Write(alarmType string, alarms []map[string]interface{}) (int, error)
+}
+
+// alarmSinkWorker owns the buffers and the batching goroutines of one sink,
+// so that a slow or broken sink will never block the others
+type alarmSinkWorker struct {
+	sink      AlarmSink
+	batchSize int
+	buffers   map[string]chan map[string]interface{}
+}
+
+const (
+	AlarmSinkEs   = "es"
+	AlarmSinkFile = "file"
+)
+
+var (
+	alarmSinkFactories = map[string]func() (AlarmSink, error){
+		AlarmSinkEs:     newEsAlarmSink,
+		AlarmSinkFile:   newFileAlarmSink,
+		AlarmSinkKafka:  newKafkaAlarmSink,
+		AlarmSinkSyslog: newSyslogAlarmSink,
+	}
+	alarmSinkWorkers []*alarmSinkWorker
+)
+
+func initAlarmSinks() {
+	alarmLogMode := beego.AppConfig.DefaultString("AlarmLogMode", AlarmSinkFile)
+	defaultBufferSize := getAlarmSinkConfigInt("AlarmBufferSize", "", 300, 100)
+	defaultBatchSize := getAlarmSinkConfigInt("AlarmBatchSize", "", 200, 1)
+	for _, name := range strings.Split(alarmLogMode, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		factory, ok := alarmSinkFactories[name]
+		if !ok {
+			tools.Panic(tools.ErrCodeConfigInitFailed,
+				"Unrecognized the value of AlarmLogMode config: "+name, nil)
+		}
+		for _, worker := range alarmSinkWorkers {
+			if worker.sink.Name() == name {
+				tools.Panic(tools.ErrCodeConfigInitFailed, "duplicate alarm sink in AlarmLogMode config: "+name, nil)
+			}
+		}
+		sink, err := factory()
+		if err != nil {
+			tools.Panic(tools.ErrCodeConfigInitFailed, "failed to init alarm sink: "+name, err)
+		}
+		worker := &alarmSinkWorker{
+			sink:      sink,
+			batchSize: getAlarmSinkConfigInt("AlarmBatchSize", name, defaultBatchSize, 1),
+			buffers:   make(map[string]chan map[string]interface{}),
+		}
+		bufferSize := getAlarmSinkConfigInt("AlarmBufferSize", name, defaultBufferSize, 100)
+		for _, alarmType := range []string{AttackAlarmType, PolicyAlarmType} {
+			worker.buffers[alarmType] = make(chan map[string]interface{}, bufferSize)
+			initSinkStats(name, alarmType)
+		}
+		// the buffers are read by the goroutines, so they are started after all of the buffers are created
+		for alarmType := range worker.buffers {
+			go worker.start(alarmType)
+		}
+		alarmSinkWorkers = append(alarmSinkWorkers, worker)
+		beego.Info("alarm sink started: " + name + ", buffer size: " + strconv.Itoa(bufferSize) +
+			", batch size: " + strconv.Itoa(worker.batchSize))
+	}
+	if len(alarmSinkWorkers) == 0 {
+		tools.Panic(tools.ErrCodeConfigInitFailed, "the 'AlarmLogMode' config can not be empty", nil)
+	}
+}
+
+// getAlarmSinkConfigInt reads the sink specific config item like 'AlarmBufferSize.es' at first,
+// then falls back to the default value
+func getAlarmSinkConfigInt(key string, sinkName string, defaultValue int, minValue int) int {
+	configKey := key
+	if sinkName != "" {
+		configKey = key + "." + sinkName
+	}
+	value := beego.AppConfig.DefaultInt(configKey, defaultValue)
+	if value <= 0 {
+		tools.Panic(tools.ErrCodeConfigInitFailed, "the '"+configKey+"' config must be greater than 0", nil)
+	} else if value < minValue {
+		beego.Warning("the value of '" + configKey + "' config is less than " + strconv.Itoa(minValue) +
+			", it will be set to " + strconv.Itoa(minValue))
+		value = minValue
+	}
+	return value
+}
+
+// dispatchAlarm hands the alarm to the buffer of every sink without blocking
+func dispatchAlarm(alarmType string, alarm map[string]interface{}) error {
+	for _, worker := range alarmSinkWorkers {
+		buffer, ok := worker.buffers[alarmType]
+		if !ok {
+			beego.Error("failed to write alarm ,unrecognized alarm type: " + alarmType)
+			return nil
+		}
+		select {
+		case buffer <- alarm:
+		default:
+			getSinkStats(worker.sink.Name(), alarmType).incrDropped()
+			beego.Error("failed to write " + alarmType + " to " + worker.sink.Name() +
+				" sink ,the buffer is full: " + fmt.Sprintf("%+v", alarm))
+		}
+	}
+	return nil
+}
+
+func (worker *alarmSinkWorker) start(alarmType string) {
+	for {
+		worker.handleBatch(alarmType)
+	}
+}
+
+func (worker *alarmSinkWorker) handleBatch(alarmType string) {
+	defer func() {
+		if r := recover(); r != nil {
+			beego.Error("failed to push alarms to "+worker.sink.Name()+" sink: ", r)
+		}
+	}()
+	buffer := worker.buffers[alarmType]
+	alarm := <-buffer
+	alarms := make([]map[string]interface{}, 0, worker.batchSize)
+	alarms = append(alarms, alarm)
+	for len(buffer) > 0 && len(alarms) < worker.batchSize {
+		alarms = append(alarms, <-buffer)
+	}
+	written, err := worker.sink.Write(alarmType, alarms)
+	stats := getSinkStats(worker.sink.Name(), alarmType)
+	stats.addWritten(written)
+	stats.addFailed(len(alarms) - written)
+	if err != nil {
+		beego.Error("failed to write " + strconv.Itoa(len(alarms)-written) + " " + alarmType + " alarms to " +
+			worker.sink.Name() + " sink: " + err.Error())
+	}
+}
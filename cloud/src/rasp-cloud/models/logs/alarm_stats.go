@@ -0,0 +1,122 @@
+//Copyright 2017-2018 Baidu Inc.
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http: //www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+
+package logs
+
+import (
+	"sync/atomic"
+)
+
+// SinkStats counts what happened to the alarms of one alarm type in one sink since the process started
+type SinkStats struct {
+	Written      int64 `json:"written"`
+	Dropped      int64 `json:"dropped"`
+	Failed       int64 `json:"failed"`
+	Retried      int64 `json:"retried"`
+	DeadLettered int64 `json:"dead_lettered"`
+}
+
+type AlarmStats struct {
+	Received map[string]int64                `json:"received"`
+	Rejected map[string]int64                `json:"rejected"`
+	Sinks    map[string]map[string]SinkStats `json:"sinks"`
+}
+
+var (
+	receivedAlarmCounts = map[string]*int64{
+		AttackAlarmType: new(int64),
+		PolicyAlarmType: new(int64),
+	}
+	rejectedAlarmCounts = map[string]*int64{
+		AttackAlarmType: new(int64),
+		PolicyAlarmType: new(int64),
+	}
+	// sinkStats is only modified by the init function, so it can be read without lock
+	sinkStats        = make(map[string]map[string]*SinkStats)
+	unknownSinkStats = &SinkStats{}
+)
+
+func initSinkStats(sinkName string, alarmType string) {
+	if _, ok := sinkStats[sinkName]; !ok {
+		sinkStats[sinkName] = make(map[string]*SinkStats)
+	}
+	sinkStats[sinkName][alarmType] = &SinkStats{}
+}
+
+func getSinkStats(sinkName string, alarmType string) *SinkStats {
+	if stats, ok := sinkStats[sinkName][alarmType]; ok {
+		return stats
+	}
+	return unknownSinkStats
+}
+
+func incrReceivedAlarm(alarmType string) {
+	if count, ok := receivedAlarmCounts[alarmType]; ok {
+		atomic.AddInt64(count, 1)
+	}
+}
+
+func incrRejectedAlarm(alarmType string) {
+	if count, ok := rejectedAlarmCounts[alarmType]; ok {
+		atomic.AddInt64(count, 1)
+	}
+}
+
+// GetAlarmStats returns a snapshot of the alarm counters
+func GetAlarmStats() *AlarmStats {
+	result := &AlarmStats{
+		Received: make(map[string]int64, len(receivedAlarmCounts)),
+		Rejected: make(map[string]int64, len(rejectedAlarmCounts)),
+		Sinks:    make(map[string]map[string]SinkStats, len(sinkStats)),
+	}
+	for alarmType, count := range receivedAlarmCounts {
+		result.Received[alarmType] = atomic.LoadInt64(count)
+	}
+	for alarmType, count := range rejectedAlarmCounts {
+		result.Rejected[alarmType] = atomic.LoadInt64(count)
+	}
+	for sinkName, typeStats := range sinkStats {
+		result.Sinks[sinkName] = make(map[string]SinkStats, len(typeStats))
+		for alarmType, stats := range typeStats {
+			result.Sinks[sinkName][alarmType] = SinkStats{
+				Written:      atomic.LoadInt64(&stats.Written),
+				Dropped:      atomic.LoadInt64(&stats.Dropped),
+				Failed:       atomic.LoadInt64(&stats.Failed),
+				Retried:      atomic.LoadInt64(&stats.Retried),
+				DeadLettered: atomic.LoadInt64(&stats.DeadLettered),
+			}
+		}
+	}
+	return result
+}
+
+func (stats *SinkStats) incrDropped() {
+	atomic.AddInt64(&stats.Dropped, 1)
+}
+
+func (stats *SinkStats) addWritten(count int) {
+	atomic.AddInt64(&stats.Written, int64(count))
+}
+
+func (stats *SinkStats) addFailed(count int) {
+	atomic.AddInt64(&stats.Failed, int64(count))
+}
+
+func (stats *SinkStats) addRetried(count int) {
+	atomic.AddInt64(&stats.Retried, int64(count))
+}
+
+func (stats *SinkStats) incrDeadLettered() {
+	atomic.AddInt64(&stats.DeadLettered, 1)
+}
@@ -15,16 +15,14 @@
 package logs
 
 import (
-	"crypto/md5"
-	"fmt"
 	"rasp-cloud/es"
+	"rasp-cloud/models/logs/enrich"
+	"rasp-cloud/models/logs/ingest"
 	"github.com/olivere/elastic"
 	"time"
 	"context"
-	"github.com/oschwald/geoip2-golang"
+	"fmt"
 	"github.com/astaxie/beego"
-	"net"
-	"rasp-cloud/tools"
 	"encoding/json"
 )
 
@@ -35,210 +33,27 @@ type AttackAlarm struct {
 var (
 	AttackIndexName      = "openrasp-attack-alarm"
 	AliasAttackIndexName = "real-openrasp-attack-alarm"
-	AttackEsMapping      = `
-	{
-		"mappings": {
-			"attack-alarm": {
-				"_all": {
-					"enabled": false
-				},
-				"properties": {
-					"@timestamp":{
-                   		"type":"date"
-         			},
-					"request_method": {
-						"type": "keyword",
-						"ignore_above": 50
-					},
-					"target": {
-						"type": "keyword",
-						"ignore_above": 256
-					},
-					"server_ip": {
-						"type": "keyword",
-						"ignore_above": 256
-					},
-					"client_ip": {
-						"type": "keyword",
-						"ignore_above": 256
-					},
-					"referer": {
-						"type": "keyword",
-						"ignore_above": 256
-					},
-					"user_agent": {
-						"type": "keyword",
-						"ignore_above": 512
-					},
-					"attack_source": {
-						"type": "keyword",
-						"ignore_above": 256
-					},
-					"path": {
-						"type": "keyword",
-						"ignore_above": 256
-					},
-					"url": {
-						"type": "keyword",
-						"ignore_above": 256
-					},
-					"event_type": {
-						"type": "keyword",
-						"ignore_above": 256
-					},
-					"server_hostname": {
-						"type": "keyword",
-						"ignore_above": 256
-					},
-					"stack_md5": {
-						"type": "keyword",
-						"ignore_above": 64
-					},
-					"server_type": {
-						"type": "keyword",
-						"ignore_above": 256
-					},
-					"server_version": {
-						"type": "keyword",
-						"ignore_above": 256
-					},
-					"request_id": {
-						"type": "keyword",
-						"ignore_above": 256
-					},
-					"body": {
-						"type": "keyword"
-					},
-					"app_id": {
-						"type": "keyword",
-						"ignore_above": 256
-					},
-					"rasp_id": {
-						"type": "keyword",
-						"ignore_above": 256
-					},
-					"event_time": {
-						"type": "date"
-					},
-					"stack_trace": {
-						"type": "keyword"
-					},
-					"intercept_state": {
-						"type": "keyword",
-						"ignore_above": 64
-					},
-					"attack_type": {
-						"type": "keyword",
-						"ignore_above": 256
-					},
-					"attack_location": {
-						"type": "object",
-						"properties": {
-							"location_zh_cn":{
-								"type": "keyword",
-								"ignore_above": 256
-							},
-							"location_en":{
-								"type": "keyword",
-								"ignore_above": 256
-							},
-							"longitude":{
-								"type": "double"
-							},
-							"latitude":{
-								"type": "double"
-							}
-						}
-					},
-					"plugin_algorithm":{
-						"type": "keyword",
-						"ignore_above": 256
-					},
-					"plugin_name": {
-						"type": "keyword",
-						"ignore_above": 256
-					},
-					"plugin_confidence": {
-						"type": "short"
-					},
-					"attack_params": {
-						"type": "object",
-						"enabled":"false"
-					},
-					"plugin_message": {
-						"type": "keyword"
-					},
-					"server_nic": {
-						"type": "nested",
-						"properties": {
-							"name": {
-								"type": "keyword",
-								"ignore_above": 256
-							},
-							"ip": {
-								"type": "keyword",
-								"ignore_above": 256
-							}
-						}
-					}
-				}
-			}
-		}
-	}
-	`
-	geoIpDbPath string
-	geoIpDb     *geoip2.Reader
+	AttackEsMapping      = ingest.AttackEsMapping
 )
 
-func init() {
-	currentPath, err := tools.GetCurrentPath()
-	if err != nil {
-		tools.Panic(tools.ErrCodeLogInitFailed, "failed to get current directory path", err)
-	}
-	geoIpDbPath = currentPath + "/geoip/GeoLite2-City.mmdb"
-	geoIpDb, err = geoip2.Open(geoIpDbPath)
-	if err != nil {
-		tools.Panic(tools.ErrCodeGeoipInit, "failed to open geoip database", err)
-	}
-}
-
 func AddAttackAlarm(alarm map[string]interface{}) error {
 	defer func() {
 		if r := recover(); r != nil {
 			beego.Error("failed to add attack alarm: ", r)
 		}
 	}()
-	if stack, ok := alarm["stack_trace"]; ok && stack != nil && stack != "" {
-		_, ok = stack.(string)
-		if ok {
-			alarm["stack_md5"] = fmt.Sprintf("%x", md5.Sum([]byte(stack.(string))))
-		}
+	// the alarms are redacted before they are enriched or written to any sink
+	appId, _ := alarm["app_id"].(string)
+	if err := RedactAlarm(AttackAlarmType, appId, alarm); err != nil {
+		return err
 	}
-	setAlarmLocation(alarm)
+	enrich.Enrich(alarm)
+	setAttackDedup(alarm)
+	alarm["triage_status"] = TriageStatusNew
+	incrReceivedAlarm(AttackAlarmType)
 	return AddAlarmFunc(AttackAlarmType, alarm)
 }
 
-func setAlarmLocation(alarm map[string]interface{}) {
-	if attackSource, ok := alarm["attack_source"]; ok && attackSource != nil {
-		_, ok = attackSource.(string)
-		if ok {
-			attackIp := net.ParseIP(attackSource.(string))
-			record, err := geoIpDb.City(attackIp)
-			if err != nil {
-				beego.Error("failed to parse attack ip to location: " + err.Error())
-			}
-			if record != nil {
-				alarm["attack_location"] = map[string]interface{}{
-					"location_zh_cn": record.Country.Names["zh-CN"] + "-" + record.City.Names["zh-CN"],
-					"location_en":    record.Country.Names["en"] + "-" + record.City.Names["en"],
-					"latitude":       record.Location.Latitude,
-					"longitude":      record.Location.Longitude,
-				}
-			}
-		}
-	}
-}
-
 func AggregationAttackWithTime(startTime int64, endTime int64, interval string, timeZone string,
 	appId string) (map[string]interface{}, error) {
 	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(10*time.Second))
@@ -364,3 +179,151 @@ func AggregationAttackWithType(startTime int64, endTime int64, size int,
 	}
 	return result, nil
 }
+
+func buildAttackFilterQueries(filter map[string][]string) []elastic.Query {
+	queries := make([]elastic.Query, 0, len(filter))
+	for field, values := range filter {
+		if len(values) > 0 {
+			items := make([]interface{}, len(values))
+			for index, value := range values {
+				items[index] = value
+			}
+			queries = append(queries, elastic.NewTermsQuery(field, items...))
+		}
+	}
+	return queries
+}
+
+// AggregationAttackWithTerms counts the attack alarms by the values of field in the time range,
+// only the values whose count is not less than minCount are returned, the alarms are counted as a whole
+// with the empty key if the field is empty, a deduplicated alarm is counted as the occurrences merged into it
+func AggregationAttackWithTerms(startTime int64, endTime int64, field string, filter map[string][]string,
+	minCount int64, size int, appId string) (map[string]int64, error) {
+	return aggregationAlarmWithTerms(AliasAttackIndexName+"-"+appId, startTime, endTime, field, filter,
+		minCount, size, "count")
+}
+
+// AggregationPolicyWithTerms counts the policy alarms like AggregationAttackWithTerms
+func AggregationPolicyWithTerms(startTime int64, endTime int64, field string, filter map[string][]string,
+	minCount int64, size int, appId string) (map[string]int64, error) {
+	return aggregationAlarmWithTerms(AliasPolicyIndexName+"-"+appId, startTime, endTime, field, filter,
+		minCount, size, "")
+}
+
+// aggregationAlarmWithTerms counts the documents, or sums the countField of them if it is not empty,
+// the documents without the countField are counted as 1
+func aggregationAlarmWithTerms(index string, startTime int64, endTime int64, field string,
+	filter map[string][]string, minCount int64, size int, countField string) (map[string]int64, error) {
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(10*time.Second))
+	defer cancel()
+	queries := append(buildAttackFilterQueries(filter),
+		elastic.NewRangeQuery("event_time").Gte(startTime).Lte(endTime))
+	search := es.ElasticClient.Search(index).
+		Query(elastic.NewBoolQuery().Must(queries...)).
+		Size(0)
+	aggrName := "aggr_terms"
+	sumAggrName := "aggr_sum"
+	sumAggr := elastic.NewSumAggregation().Field(countField).Missing(1)
+	if field != "" {
+		termsAggr := elastic.NewTermsAggregation().Field(field).Size(size)
+		if countField != "" {
+			// the buckets are filtered by the sum after the search
+			termsAggr = termsAggr.MinDocCount(1).SubAggregation(sumAggrName, sumAggr).
+				OrderByAggregation(sumAggrName, false)
+		} else {
+			termsAggr = termsAggr.MinDocCount(int(minCount)).OrderByCount(false)
+		}
+		search = search.Aggregation(aggrName, termsAggr)
+	} else if countField != "" {
+		search = search.Aggregation(sumAggrName, sumAggr)
+	}
+	aggrResult, err := search.Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]int64)
+	if field == "" {
+		total := int64(0)
+		if countField != "" {
+			if aggrResult.Aggregations != nil {
+				if sum, ok := aggrResult.Aggregations.Sum(sumAggrName); ok && sum.Value != nil {
+					total = int64(*sum.Value)
+				}
+			}
+		} else if aggrResult.Hits != nil {
+			total = aggrResult.Hits.TotalHits
+		}
+		if total >= minCount {
+			result[""] = total
+		}
+		return result, nil
+	}
+	if aggrResult.Aggregations != nil {
+		if terms, ok := aggrResult.Aggregations.Terms(aggrName); ok && terms.Buckets != nil {
+			for _, item := range terms.Buckets {
+				count := item.DocCount
+				if countField != "" {
+					if sum, ok := item.Sum(sumAggrName); ok && sum.Value != nil {
+						count = int64(*sum.Value)
+					}
+				}
+				if count >= minCount {
+					result[fmt.Sprint(item.Key)] = count
+				}
+			}
+		}
+	}
+	return result, nil
+}
+
+// AggregationAttackFirstSeen returns the values of field that are never seen before startTime,
+// with the event_time they are first seen
+func AggregationAttackFirstSeen(startTime int64, endTime int64, field string, filter map[string][]string,
+	size int, appId string) (map[string]int64, error) {
+	queries := append(buildAttackFilterQueries(filter), elastic.NewRangeQuery("event_time").Lte(endTime))
+	return aggregationAlarmFirstSeen(AliasAttackIndexName+"-"+appId, queries, field, "event_time",
+		startTime, size)
+}
+
+// AggregationPolicyFirstSeen returns the values of field that are never seen before startTime,
+// with the time they are first seen, the event_time of policy alarms is updated on every report
+// so that the first_seen field is used, the alarms stored before it are treated as seen long ago
+func AggregationPolicyFirstSeen(startTime int64, endTime int64, field string, size int,
+	appId string) (map[string]int64, error) {
+	query := elastic.NewBoolQuery().
+		Should(elastic.NewRangeQuery("first_seen").Lte(endTime),
+			elastic.NewBoolQuery().MustNot(elastic.NewExistsQuery("first_seen"))).
+		MinimumNumberShouldMatch(1)
+	return aggregationAlarmFirstSeen(AliasPolicyIndexName+"-"+appId, []elastic.Query{query}, field,
+		"first_seen", startTime, size)
+}
+
+func aggregationAlarmFirstSeen(index string, queries []elastic.Query, field string, timeField string,
+	startTime int64, size int) (map[string]int64, error) {
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(10*time.Second))
+	defer cancel()
+	aggrName := "aggr_first_seen"
+	firstSeenAggr := elastic.NewTermsAggregation().Field(field).Size(size).
+		OrderByAggregation("first_seen", false).
+		SubAggregation("first_seen", elastic.NewMinAggregation().Field(timeField).Missing(0))
+	aggrResult, err := es.ElasticClient.Search(index).
+		Query(elastic.NewBoolQuery().Must(queries...)).
+		Aggregation(aggrName, firstSeenAggr).
+		Size(0).
+		Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]int64)
+	if aggrResult.Aggregations != nil {
+		if terms, ok := aggrResult.Aggregations.Terms(aggrName); ok && terms.Buckets != nil {
+			for _, item := range terms.Buckets {
+				if firstSeen, ok := item.Min("first_seen"); ok && firstSeen.Value != nil &&
+					int64(*firstSeen.Value) >= startTime {
+					result[fmt.Sprint(item.Key)] = int64(*firstSeen.Value)
+				}
+			}
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,106 @@
+//Copyright 2017-2018 Baidu Inc.
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http: //www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+
+package logs
+
+import (
+	"crypto/md5"
+	"fmt"
+	"github.com/astaxie/beego"
+	"strings"
+)
+
+var (
+	// attackDedupWindow is the window (ms) to collapse the duplicate attack alarms, 0 disables it
+	attackDedupWindow int64
+	attackDedupFields []string
+)
+
+func init() {
+	window := beego.AppConfig.DefaultInt64("AttackAlarmDedupWindow", 0)
+	if window < 0 {
+		window = 0
+	}
+	attackDedupWindow = window * 1000
+	for _, field := range strings.Split(beego.AppConfig.DefaultString("AttackAlarmDedupFields",
+		"attack_type,url,stack_md5,attack_source"), ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			attackDedupFields = append(attackDedupFields, field)
+		}
+	}
+}
+
+// setAttackDedup sets the count fields of the attack alarm, the duplicate alarms in the same window
+// share the same upsert_id and are collapsed into one doc by es, the window starts at the multiple of its size
+func setAttackDedup(alarm map[string]interface{}) {
+	timestamp, _ := alarm["@timestamp"].(int64)
+	alarm["first_seen"] = timestamp
+	alarm["last_seen"] = timestamp
+	alarm["count"] = 1
+	if attackDedupWindow <= 0 || len(attackDedupFields) == 0 {
+		return
+	}
+	idContent := fmt.Sprint(alarm["app_id"]) + "|" + fmt.Sprint(timestamp/attackDedupWindow)
+	for _, field := range attackDedupFields {
+		idContent += "|" + fmt.Sprint(alarm[field])
+	}
+	alarm["upsert_id"] = fmt.Sprintf("%x", md5.Sum([]byte(idContent)))
+}
+
+// collapseDuplicateAlarms merges the alarms with the same upsert_id in a batch before they are sent to es,
+// the alarms are shared by sinks, so the merged alarm is a copy. It returns the count of collapsed alarms.
+func collapseDuplicateAlarms(alarms []map[string]interface{}) ([]map[string]interface{}, int) {
+	result := make([]map[string]interface{}, 0, len(alarms))
+	indexes := make(map[string]int)
+	copied := make(map[int]bool)
+	for _, alarm := range alarms {
+		upsertId, ok := alarm["upsert_id"].(string)
+		if !ok {
+			result = append(result, alarm)
+			continue
+		}
+		index, ok := indexes[upsertId]
+		if !ok {
+			indexes[upsertId] = len(result)
+			result = append(result, alarm)
+			continue
+		}
+		if !copied[index] {
+			merged := make(map[string]interface{}, len(result[index]))
+			for key, value := range result[index] {
+				merged[key] = value
+			}
+			result[index] = merged
+			copied[index] = true
+		}
+		merged := result[index]
+		merged["count"] = getAlarmInt(merged, "count") + getAlarmInt(alarm, "count")
+		if getAlarmInt(alarm, "last_seen") > getAlarmInt(merged, "last_seen") {
+			merged["last_seen"] = alarm["last_seen"]
+		}
+	}
+	return result, len(alarms) - len(result)
+}
+
+func getAlarmInt(alarm map[string]interface{}, field string) int64 {
+	switch v := alarm[field].(type) {
+	case int:
+		return int64(v)
+	case int64:
+		return v
+	case float64:
+		return int64(v)
+	}
+	return 0
+}
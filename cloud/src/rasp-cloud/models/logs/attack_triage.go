@@ -0,0 +1,157 @@
+//Copyright 2017-2018 Baidu Inc.
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http: //www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+
+package logs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/olivere/elastic"
+	"rasp-cloud/es"
+	"time"
+)
+
+// AttackTriage is the triage state of the attack alarms
+type AttackTriage struct {
+	Status string `json:"status"`
+	// nil keeps the assignee unchanged, and the empty string clears it
+	Assignee *string `json:"assignee"`
+}
+
+const (
+	TriageStatusNew           = "new"
+	TriageStatusInvestigating = "investigating"
+	TriageStatusFalsePositive = "false_positive"
+	TriageStatusConfirmed     = "confirmed"
+	TriageStatusResolved      = "resolved"
+
+	attackTriageScript = "ctx._source.triage_update_time = params.update_time;" +
+		"ctx._source.triage_update_user = params.update_user;" +
+		"if (params.status != null) { ctx._source.triage_status = params.status; }" +
+		"if (params.assignee != null) { ctx._source.triage_assignee = params.assignee; }"
+)
+
+var TriageStatuses = []string{
+	TriageStatusNew,
+	TriageStatusInvestigating,
+	TriageStatusFalsePositive,
+	TriageStatusConfirmed,
+	TriageStatusResolved,
+}
+
+func ValidateAttackTriage(triage *AttackTriage) error {
+	if triage.Status == "" && triage.Assignee == nil {
+		return errors.New("the status and assignee can not be both empty")
+	}
+	if triage.Status != "" && !isTriageStatus(triage.Status) {
+		return errors.New("invalid triage status: " + triage.Status)
+	}
+	if triage.Assignee != nil && len(*triage.Assignee) > 256 {
+		return errors.New("the length of assignee can not be greater than 256")
+	}
+	return nil
+}
+
+func isTriageStatus(status string) bool {
+	for _, item := range TriageStatuses {
+		if item == status {
+			return true
+		}
+	}
+	return false
+}
+
+// buildTriageStatusQuery filters the alarms by status, the alarms stored before
+// the triage has been supported have no status and are regarded as new ones
+func buildTriageStatusQuery(value interface{}) elastic.Query {
+	statuses, ok := value.([]interface{})
+	if !ok {
+		statuses = []interface{}{value}
+	}
+	query := elastic.NewBoolQuery().Should(elastic.NewTermsQuery("triage_status", statuses...))
+	for _, status := range statuses {
+		if status == TriageStatusNew {
+			query.Should(elastic.NewBoolQuery().MustNot(elastic.NewExistsQuery("triage_status")))
+			break
+		}
+	}
+	return query.MinimumNumberShouldMatch(1)
+}
+
+// UpdateAttackTriageById updates the triage state of the attack alarm of app
+func UpdateAttackTriageById(appId string, id string, triage *AttackTriage, user string) (int64, error) {
+	return updateAttackTriage(appId, elastic.NewIdsQuery(AttackAlarmType).Ids(id), triage, user)
+}
+
+// UpdateAttackTriageByQuery updates the triage state of all the attack alarms matching the search data
+func UpdateAttackTriageByQuery(appId string, startTime int64, endTime int64, query map[string]interface{},
+	triage *AttackTriage, user string) (int64, error) {
+	return updateAttackTriage(appId,
+		elastic.NewBoolQuery().Must(buildSearchQueries(startTime, endTime, query)...), triage, user)
+}
+
+func updateAttackTriage(appId string, query elastic.Query, triage *AttackTriage, user string) (int64, error) {
+	params := map[string]interface{}{
+		"update_time": time.Now().UnixNano() / 1000000,
+		"update_user": user,
+		"status":      nil,
+		"assignee":    nil,
+	}
+	if triage.Status != "" {
+		params["status"] = triage.Status
+	}
+	if triage.Assignee != nil {
+		params["assignee"] = *triage.Assignee
+	}
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(30*time.Second))
+	defer cancel()
+	result, err := es.ElasticClient.UpdateByQuery(AliasAttackIndexName + "-" + appId).
+		Type(AttackAlarmType).
+		Query(query).
+		Script(elastic.NewScript(attackTriageScript).Lang("painless").Params(params)).
+		ProceedOnVersionConflict().
+		Refresh("true").
+		Do(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if len(result.Failures) > 0 {
+		return result.Updated, errors.New("failed to update the triage state of some alarms")
+	}
+	return result.Updated, nil
+}
+
+// GetAttackAlarmById returns the attack alarm of app with its id
+func GetAttackAlarmById(appId string, id string) (map[string]interface{}, error) {
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(10*time.Second))
+	defer cancel()
+	queryResult, err := es.ElasticClient.Search(AliasAttackIndexName + "-" + appId).
+		Query(elastic.NewIdsQuery(AttackAlarmType).Ids(id)).
+		Size(1).Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if queryResult == nil || queryResult.Hits == nil || len(queryResult.Hits.Hits) == 0 {
+		return nil, errors.New("can not find the attack alarm: " + id)
+	}
+	hit := queryResult.Hits.Hits[0]
+	var alarm map[string]interface{}
+	err = json.Unmarshal(*hit.Source, &alarm)
+	if err != nil {
+		return nil, err
+	}
+	alarm["id"] = hit.Id
+	return alarm, nil
+}
@@ -0,0 +1,85 @@
+//Copyright 2017-2018 Baidu Inc.
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http: //www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+
+package logs
+
+import (
+	"encoding/json"
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/logs"
+	"rasp-cloud/es"
+	"rasp-cloud/es/bulk"
+	"rasp-cloud/tools"
+	"time"
+)
+
+const (
+	DeadLetterModeFile = "file"
+	DeadLetterModeEs   = "es"
+	DeadLetterModeNone = "none"
+)
+
+var (
+	AliasDeadLetterIndexName = "real-openrasp-dead-letter"
+	deadLetterType           = "dead-letter"
+	deadLetterMode           string
+	deadLetterLogger         *logs.BeeLogger
+)
+
+func init() {
+	deadLetterMode = beego.AppConfig.DefaultString("AlarmDeadLetterMode", DeadLetterModeFile)
+	if deadLetterMode == DeadLetterModeFile {
+		deadLetterLogger = initAlarmFileLogger("/openrasp-logs/dead-letter", "dead-letter.log")
+	} else if deadLetterMode == DeadLetterModeEs {
+		es.RegisterTTL(24*30*time.Hour, AliasDeadLetterIndexName)
+	} else if deadLetterMode != DeadLetterModeNone {
+		tools.Panic(tools.ErrCodeConfigInitFailed, "Unrecognized the value of AlarmDeadLetterMode config", nil)
+	}
+}
+
+// addDeadLetter stores the alarm which can not be inserted into es along with the failure reason,
+// the alarm is kept as a json string so that it will never cause a mapping conflict again
+func addDeadLetter(alarmType string, failure *bulk.Failure) {
+	content, err := json.Marshal(failure.Doc)
+	if err != nil {
+		beego.Error("failed to encode dead letter alarm: " + err.Error())
+		return
+	}
+	deadLetter := map[string]interface{}{
+		"@timestamp": time.Now().UnixNano() / 1000000,
+		"alarm_type": alarmType,
+		"status":     failure.Status,
+		"reason":     failure.Reason,
+		"alarm":      string(content),
+	}
+	switch deadLetterMode {
+	case DeadLetterModeFile:
+		content, err = json.Marshal(deadLetter)
+		if err == nil {
+			_, err = deadLetterLogger.Write(content)
+		}
+	case DeadLetterModeEs:
+		err = es.Insert(AliasDeadLetterIndexName, deadLetterType, deadLetter)
+	default:
+		beego.Error("drop the failed " + alarmType + " alarm, reason: " + failure.Reason + ", alarm: " +
+			string(content))
+		return
+	}
+	if err != nil {
+		beego.Error("failed to write dead letter alarm: " + err.Error() + ", reason: " + failure.Reason +
+			", alarm: " + string(content))
+		return
+	}
+	getSinkStats(AlarmSinkEs, alarmType).incrDeadLettered()
+}
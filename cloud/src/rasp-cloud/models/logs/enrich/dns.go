@@ -0,0 +1,198 @@
+//Copyright 2017-2018 Baidu Inc.
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http: //www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+
+package enrich
+
+import (
+	"context"
+	"github.com/astaxie/beego"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// reverseDnsEnricher sets the cached hostname of ip, the uncached ips are resolved in background
+// by a fixed number of workers, so that a slow dns server never delays the ingestion of alarms.
+// Both of the hit and miss results are cached, the failed lookups are cached with a shorter ttl.
+type reverseDnsEnricher struct {
+	lookup      func(ctx context.Context, addr string) ([]string, error)
+	timeout     time.Duration
+	cache       *dnsCache
+	ttl         time.Duration
+	negativeTtl time.Duration
+	queue       chan string
+	pendingLock sync.Mutex
+	// the ips in the queue or being resolved
+	pending map[string]bool
+}
+
+type dnsCache struct {
+	lock    sync.Mutex
+	items   map[string]*dnsCacheItem
+	maxSize int
+}
+
+type dnsCacheItem struct {
+	hostname string
+	expire   time.Time
+}
+
+func newReverseDnsEnricher() (AlarmEnricher, error) {
+	timeout := beego.AppConfig.DefaultInt("AlarmReverseDnsTimeout", 200)
+	if timeout <= 0 {
+		timeout = 200
+	}
+	cacheSize := beego.AppConfig.DefaultInt("AlarmReverseDnsCacheSize", 10000)
+	if cacheSize <= 0 {
+		cacheSize = 10000
+	}
+	cacheTtl := beego.AppConfig.DefaultInt("AlarmReverseDnsCacheTtl", 3600)
+	if cacheTtl <= 0 {
+		cacheTtl = 3600
+	}
+	negativeCacheTtl := beego.AppConfig.DefaultInt("AlarmReverseDnsNegativeCacheTtl", 300)
+	if negativeCacheTtl <= 0 {
+		negativeCacheTtl = 300
+	}
+	workers := beego.AppConfig.DefaultInt("AlarmReverseDnsWorkers", 4)
+	if workers <= 0 {
+		workers = 4
+	}
+	queueSize := beego.AppConfig.DefaultInt("AlarmReverseDnsQueueSize", 1000)
+	if queueSize <= 0 {
+		queueSize = 1000
+	}
+	enricher := newReverseDnsEnricherWithLookup(net.DefaultResolver.LookupAddr,
+		time.Duration(timeout)*time.Millisecond, cacheSize, time.Duration(cacheTtl)*time.Second,
+		time.Duration(negativeCacheTtl)*time.Second, queueSize)
+	enricher.startWorkers(workers)
+	return enricher, nil
+}
+
+func newReverseDnsEnricherWithLookup(lookup func(ctx context.Context, addr string) ([]string, error),
+	timeout time.Duration, cacheSize int, ttl time.Duration, negativeTtl time.Duration,
+	queueSize int) *reverseDnsEnricher {
+	return &reverseDnsEnricher{
+		lookup:      lookup,
+		timeout:     timeout,
+		cache:       newDnsCache(cacheSize),
+		ttl:         ttl,
+		negativeTtl: negativeTtl,
+		queue:       make(chan string, queueSize),
+		pending:     make(map[string]bool),
+	}
+}
+
+func (e *reverseDnsEnricher) startWorkers(workers int) {
+	for i := 0; i < workers; i++ {
+		go func() {
+			for addr := range e.queue {
+				e.resolve(addr)
+				e.pendingLock.Lock()
+				delete(e.pending, addr)
+				e.pendingLock.Unlock()
+			}
+		}()
+	}
+}
+
+func (e *reverseDnsEnricher) Name() string {
+	return AlarmEnricherReverseDns
+}
+
+func (e *reverseDnsEnricher) Enrich(alarm map[string]interface{}) error {
+	for field, ip := range getAlarmIps(alarm) {
+		addr := ip.String()
+		hostname, ok := e.cache.get(addr)
+		if !ok {
+			e.enqueue(addr)
+			continue
+		}
+		if hostname != "" {
+			alarm[enrichFieldName(field, "hostname")] = hostname
+		}
+	}
+	return nil
+}
+
+// enqueue adds the ip to the queue of workers, the ip is skipped if it is pending or the queue is full,
+// it is resolved when the next alarm of the ip arrives
+func (e *reverseDnsEnricher) enqueue(addr string) {
+	e.pendingLock.Lock()
+	defer e.pendingLock.Unlock()
+	if e.pending[addr] {
+		return
+	}
+	select {
+	case e.queue <- addr:
+		e.pending[addr] = true
+	default:
+	}
+}
+
+func (e *reverseDnsEnricher) resolve(addr string) {
+	ctx, cancel := context.WithTimeout(context.Background(), e.timeout)
+	defer cancel()
+	names, err := e.lookup(ctx, addr)
+	if dnsErr, ok := err.(*net.DNSError); ok && !dnsErr.IsTimeout && !dnsErr.IsTemporary {
+		// the ip has no PTR record, it is not an error of enricher
+		err = nil
+	}
+	if err != nil {
+		beego.Debug("failed to resolve the hostname of " + addr + ": " + err.Error())
+		e.cache.set(addr, "", e.negativeTtl)
+		return
+	}
+	hostname := ""
+	if len(names) > 0 {
+		hostname = strings.TrimSuffix(names[0], ".")
+	}
+	e.cache.set(addr, hostname, e.ttl)
+}
+
+func newDnsCache(maxSize int) *dnsCache {
+	return &dnsCache{items: make(map[string]*dnsCacheItem), maxSize: maxSize}
+}
+
+func (c *dnsCache) get(addr string) (string, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	item, ok := c.items[addr]
+	if !ok || time.Now().After(item.expire) {
+		return "", false
+	}
+	return item.hostname, true
+}
+
+func (c *dnsCache) set(addr string, hostname string, ttl time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	now := time.Now()
+	if len(c.items) >= c.maxSize {
+		for key, item := range c.items {
+			if now.After(item.expire) {
+				delete(c.items, key)
+			}
+		}
+		// evict arbitrary items if the cache is still full
+		for key := range c.items {
+			if len(c.items) < c.maxSize {
+				break
+			}
+			delete(c.items, key)
+		}
+	}
+	c.items[addr] = &dnsCacheItem{hostname: hostname, expire: now.Add(ttl)}
+}
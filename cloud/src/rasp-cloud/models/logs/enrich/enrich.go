@@ -0,0 +1,204 @@
+//Copyright 2017-2018 Baidu Inc.
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http: //www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+
+package enrich
+
+import (
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"github.com/astaxie/beego"
+	"net"
+	"rasp-cloud/tools"
+	"strings"
+	"time"
+)
+
+// AlarmEnricher is a step of the attack alarm enrichment pipeline, the steps are configured
+// by AlarmEnrichers in order, a failed step is skipped and never blocks the alarm
+type AlarmEnricher interface {
+	Name() string
+	Enrich(alarm map[string]interface{}) error
+}
+
+const (
+	// AlarmEnricherStackMd5 is not configurable any more, the stack_md5 is always set,
+	// it is still accepted in AlarmEnrichers for the old configs
+	AlarmEnricherStackMd5       = "stack_md5"
+	AlarmEnricherGeoipCity      = "geoip_city"
+	AlarmEnricherGeoipAsn       = "geoip_asn"
+	AlarmEnricherPrivateNetwork = "private_network"
+	AlarmEnricherReverseDns     = "reverse_dns"
+	AlarmEnricherThreatIntel    = "threat_intel"
+)
+
+var (
+	alarmEnricherFactories = map[string]func() (AlarmEnricher, error){
+		AlarmEnricherGeoipCity:      newGeoIpCityEnricher,
+		AlarmEnricherGeoipAsn:       newGeoIpAsnEnricher,
+		AlarmEnricherPrivateNetwork: newPrivateNetworkEnricher,
+		AlarmEnricherReverseDns:     newReverseDnsEnricher,
+		AlarmEnricherThreatIntel:    newThreatIntelEnricher,
+	}
+	alarmEnrichers []AlarmEnricher
+	// the ip fields of attack alarm that are enriched
+	enrichIpFields         = []string{"attack_source", "client_ip"}
+	defaultPrivateNetworks = "10.0.0.0/8,172.16.0.0/12,192.168.0.0/16,127.0.0.0/8,169.254.0.0/16," +
+		"100.64.0.0/10,::1/128,fc00::/7,fe80::/10"
+)
+
+// Init starts the enrichers configured by AlarmEnrichers, it is called once by the init of logs
+func Init() {
+	config := beego.AppConfig.DefaultString("AlarmEnrichers", AlarmEnricherGeoipCity)
+	for _, name := range strings.Split(config, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || name == AlarmEnricherStackMd5 {
+			continue
+		}
+		factory, ok := alarmEnricherFactories[name]
+		if !ok {
+			tools.Panic(tools.ErrCodeConfigInitFailed,
+				"Unrecognized the value of AlarmEnrichers config: "+name, nil)
+		}
+		enricher, err := factory()
+		if err != nil {
+			// fail open, the alarms are stored without this enrichment
+			beego.Error("failed to init alarm enricher " + name + ", the enricher is skipped: " + err.Error())
+			continue
+		}
+		alarmEnrichers = append(alarmEnrichers, enricher)
+		beego.Info("alarm enricher started: " + name)
+	}
+	interval := beego.AppConfig.DefaultInt("GeoipReloadInterval", 60)
+	if interval > 0 && len(getGeoIpDatabaseList()) > 0 {
+		go startGeoIpWatcher(time.Duration(interval) * time.Second)
+	}
+}
+
+// Enrich sets the stack_md5 of the attack alarm, then runs the configured enrichers in order,
+// the stack_md5 is always set since the alarm dedup and the stack aggregations depend on it
+func Enrich(alarm map[string]interface{}) {
+	setStackMd5(alarm)
+	for _, enricher := range alarmEnrichers {
+		runAlarmEnricher(enricher, alarm)
+	}
+}
+
+func runAlarmEnricher(enricher AlarmEnricher, alarm map[string]interface{}) {
+	defer func() {
+		if r := recover(); r != nil {
+			beego.Error("alarm enricher "+enricher.Name()+" panics: ", r)
+		}
+	}()
+	if err := enricher.Enrich(alarm); err != nil {
+		beego.Warn("alarm enricher " + enricher.Name() + " failed: " + err.Error())
+	}
+}
+
+// getAlarmIps returns the valid ips in enrichIpFields of the alarm, the key is the field name
+func getAlarmIps(alarm map[string]interface{}) map[string]net.IP {
+	ips := make(map[string]net.IP, len(enrichIpFields))
+	for _, field := range enrichIpFields {
+		if value, ok := alarm[field].(string); ok && value != "" {
+			// the client ip from the forwarded header may be a list, the first one is the client
+			if index := strings.Index(value, ","); index >= 0 {
+				value = value[:index]
+			}
+			if ip := net.ParseIP(strings.TrimSpace(value)); ip != nil {
+				ips[field] = ip
+			}
+		}
+	}
+	return ips
+}
+
+func enrichFieldName(ipField string, suffix string) string {
+	return ipField + "_" + suffix
+}
+
+func parseNetworks(config string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0)
+	for _, item := range strings.Split(config, ",") {
+		network, err := parseNetwork(item)
+		if err != nil {
+			return nil, err
+		}
+		if network != nil {
+			networks = append(networks, network)
+		}
+	}
+	return networks, nil
+}
+
+// parseNetwork parses a cidr or a single ip to network, it returns nil for the empty string
+func parseNetwork(item string) (*net.IPNet, error) {
+	item = strings.TrimSpace(item)
+	if item == "" {
+		return nil, nil
+	}
+	if !strings.Contains(item, "/") {
+		ip := net.ParseIP(item)
+		if ip == nil {
+			return nil, errors.New("invalid ip: " + item)
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+	}
+	_, network, err := net.ParseCIDR(item)
+	if err != nil {
+		return nil, errors.New("invalid cidr: " + item)
+	}
+	return network, nil
+}
+
+func networksContain(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func setStackMd5(alarm map[string]interface{}) {
+	if stack, ok := alarm["stack_trace"].(string); ok && stack != "" {
+		alarm["stack_md5"] = fmt.Sprintf("%x", md5.Sum([]byte(stack)))
+	}
+}
+
+// privateNetworkEnricher tags whether the ip is in the private or internal networks
+type privateNetworkEnricher struct {
+	networks []*net.IPNet
+}
+
+func newPrivateNetworkEnricher() (AlarmEnricher, error) {
+	networks, err := parseNetworks(beego.AppConfig.DefaultString("AlarmPrivateNetworks", defaultPrivateNetworks))
+	if err != nil {
+		return nil, errors.New("failed to parse AlarmPrivateNetworks config: " + err.Error())
+	}
+	return &privateNetworkEnricher{networks: networks}, nil
+}
+
+func (e *privateNetworkEnricher) Name() string {
+	return AlarmEnricherPrivateNetwork
+}
+
+func (e *privateNetworkEnricher) Enrich(alarm map[string]interface{}) error {
+	for field, ip := range getAlarmIps(alarm) {
+		alarm[enrichFieldName(field, "internal")] = networksContain(e.networks, ip)
+	}
+	return nil
+}
@@ -0,0 +1,266 @@
+//Copyright 2017-2018 Baidu Inc.
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http: //www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+
+package enrich
+
+import (
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"github.com/oschwald/geoip2-golang"
+	"io/ioutil"
+	"net"
+	"os"
+	"rasp-cloud/models/logs/ingest"
+	"testing"
+	"time"
+)
+
+type fakeGeoIpReader struct{}
+
+func (r *fakeGeoIpReader) City(ip net.IP) (*geoip2.City, error) {
+	if ip.IsLoopback() {
+		return nil, errors.New("not found")
+	}
+	record := &geoip2.City{}
+	record.Country.Names = map[string]string{"en": "China", "zh-CN": "中国"}
+	record.City.Names = map[string]string{"en": "Beijing", "zh-CN": "北京"}
+	record.Location.Latitude = 39.9
+	record.Location.Longitude = 116.4
+	return record, nil
+}
+
+func (r *fakeGeoIpReader) ASN(ip net.IP) (*geoip2.ASN, error) {
+	return &geoip2.ASN{AutonomousSystemNumber: 4134, AutonomousSystemOrganization: "Chinanet"}, nil
+}
+
+func newTestEnrichAlarm() map[string]interface{} {
+	return map[string]interface{}{
+		"attack_type":   "sql",
+		"attack_source": "1.2.3.4",
+		"client_ip":     "5.6.7.8, 10.0.0.1",
+		"stack_trace":   "java.lang.Thread.run\n",
+	}
+}
+
+// checkEnrichedFields checks that the enriched fields exist in the es mapping of attack alarm
+func checkEnrichedFields(t *testing.T, alarm map[string]interface{}, fields ...string) {
+	schemas := ingest.Fields(ingest.AttackAlarmType)
+	for _, field := range fields {
+		value, ok := alarm[field]
+		if !ok {
+			t.Errorf("the field %s is not enriched", field)
+			continue
+		}
+		schema, ok := schemas[field]
+		if !ok {
+			t.Errorf("the enriched field %s is not in the es mapping", field)
+			continue
+		}
+		if object, ok := value.(map[string]interface{}); ok {
+			for key := range object {
+				if _, ok := schema.Properties[key]; !ok {
+					t.Errorf("the enriched field %s.%s is not in the es mapping", field, key)
+				}
+			}
+		}
+	}
+}
+
+func TestGeoIpEnrichers(t *testing.T) {
+	alarm := newTestEnrichAlarm()
+	cityEnricher := &geoIpCityEnricher{getReader: func() geoIpCityReader { return &fakeGeoIpReader{} }}
+	asnEnricher := &geoIpAsnEnricher{getReader: func() geoIpAsnReader { return &fakeGeoIpReader{} }}
+	for _, enricher := range []AlarmEnricher{cityEnricher, asnEnricher} {
+		if err := enricher.Enrich(alarm); err != nil {
+			t.Fatalf("%s failed: %v", enricher.Name(), err)
+		}
+	}
+	checkEnrichedFields(t, alarm, "attack_location", "attack_source_asn", "client_ip_location", "client_ip_asn")
+	location := alarm["client_ip_location"].(map[string]interface{})
+	if location["location_en"] != "China-Beijing" || location["country_zh_cn"] != "中国" {
+		t.Errorf("unexpected location: %v", location)
+	}
+
+	// the missing database is skipped, and the failed lookup does not stop the other fields
+	alarm = newTestEnrichAlarm()
+	alarm["client_ip"] = "127.0.0.1"
+	if err := (&geoIpCityEnricher{getReader: func() geoIpCityReader { return nil }}).Enrich(alarm); err != nil {
+		t.Errorf("the enricher without database must be skipped: %v", err)
+	}
+	if err := cityEnricher.Enrich(alarm); err == nil {
+		t.Error("expect the error of failed lookup")
+	}
+	if _, ok := alarm["attack_location"]; !ok {
+		t.Error("the attack_source must be enriched when the client_ip fails")
+	}
+}
+
+func TestAlarmIps(t *testing.T) {
+	ips := getAlarmIps(map[string]interface{}{"attack_source": "::1", "client_ip": " 5.6.7.8 , 10.0.0.1"})
+	if ips["attack_source"].String() != "::1" || ips["client_ip"].String() != "5.6.7.8" {
+		t.Errorf("unexpected ips: %v", ips)
+	}
+	if ips = getAlarmIps(map[string]interface{}{"attack_source": "unknown", "client_ip": ""}); len(ips) != 0 {
+		t.Errorf("the invalid ips must be skipped: %v", ips)
+	}
+}
+
+func TestStackMd5(t *testing.T) {
+	// the stack_md5 is set without any configured enricher
+	oldEnrichers := alarmEnrichers
+	alarmEnrichers = nil
+	defer func() { alarmEnrichers = oldEnrichers }()
+	alarm := newTestEnrichAlarm()
+	Enrich(alarm)
+	checkEnrichedFields(t, alarm, "stack_md5")
+	if alarm["stack_md5"] != fmt.Sprintf("%x", md5.Sum([]byte("java.lang.Thread.run\n"))) {
+		t.Errorf("unexpected stack_md5: %v", alarm["stack_md5"])
+	}
+	alarm = map[string]interface{}{"stack_trace": ""}
+	Enrich(alarm)
+	if _, ok := alarm["stack_md5"]; ok {
+		t.Error("the empty stack must not be hashed")
+	}
+}
+
+func TestPrivateNetworkEnricher(t *testing.T) {
+	networks, err := parseNetworks(defaultPrivateNetworks + ",5.6.7.8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	alarm := newTestEnrichAlarm()
+	(&privateNetworkEnricher{networks: networks}).Enrich(alarm)
+	checkEnrichedFields(t, alarm, "attack_source_internal", "client_ip_internal")
+	if alarm["attack_source_internal"] != false || alarm["client_ip_internal"] != true {
+		t.Errorf("unexpected internal tags: %v, %v", alarm["attack_source_internal"], alarm["client_ip_internal"])
+	}
+	if _, err := parseNetworks("10.0.0.0/33"); err == nil {
+		t.Error("expect the error of invalid cidr")
+	}
+}
+
+func TestThreatIntelEnricher(t *testing.T) {
+	file, err := ioutil.TempFile("", "threat-intel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("# tor exit nodes\n1.2.3.4\n10.0.0.0/8 internal\ninvalid\n")
+	file.Close()
+	enricher := &threatIntelEnricher{lists: []*threatIntelList{{name: "tor", path: file.Name()}}}
+	enricher.reload()
+	alarm := newTestEnrichAlarm()
+	alarm["client_ip"] = "10.1.2.3"
+	enricher.Enrich(alarm)
+	checkEnrichedFields(t, alarm, "attack_source_threat_intel", "client_ip_threat_intel")
+
+	alarm = newTestEnrichAlarm()
+	enricher.Enrich(alarm)
+	if _, ok := alarm["client_ip_threat_intel"]; ok {
+		t.Errorf("the unlisted ip must not be tagged: %v", alarm["client_ip_threat_intel"])
+	}
+}
+
+func TestReverseDnsEnricher(t *testing.T) {
+	release := make(chan bool)
+	lookups := make(chan string, 10)
+	lookup := func(ctx context.Context, addr string) ([]string, error) {
+		lookups <- addr
+		<-release
+		switch addr {
+		case "1.2.3.4":
+			return []string{"scanner.example.com."}, nil
+		case "5.6.7.8":
+			return nil, &net.DNSError{Err: "no such host", Name: addr}
+		}
+		return nil, &net.DNSError{Err: "i/o timeout", Name: addr, IsTimeout: true}
+	}
+	enricher := newReverseDnsEnricherWithLookup(lookup, time.Second, 100, time.Hour, time.Hour, 1)
+	enricher.startWorkers(1)
+
+	// the uncached ips never block the alarm, they are resolved in background
+	alarm := map[string]interface{}{"attack_source": "1.2.3.4"}
+	enricher.Enrich(alarm)
+	if _, ok := alarm["attack_source_hostname"]; ok {
+		t.Error("the uncached ip must not be resolved on the request path")
+	}
+	if addr := <-lookups; addr != "1.2.3.4" {
+		t.Fatalf("unexpected lookup of %s", addr)
+	}
+	done := make(chan bool)
+	go func() {
+		// the worker is busy with 1.2.3.4, 5.6.7.8 is queued once, and 9.9.9.9 is skipped by the full queue
+		for _, addr := range []string{"5.6.7.8", "5.6.7.8", "9.9.9.9"} {
+			enricher.Enrich(map[string]interface{}{"attack_source": addr})
+		}
+		done <- true
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the enricher is blocked by the slow dns lookup")
+	}
+	if len(enricher.queue) != 1 {
+		t.Fatalf("expect one queued ip, got %d", len(enricher.queue))
+	}
+	close(release)
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		enricher.pendingLock.Lock()
+		pending := len(enricher.pending)
+		enricher.pendingLock.Unlock()
+		if pending == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the queued ips are not resolved")
+		}
+	}
+	if len(lookups) != 1 {
+		t.Errorf("expect only one lookup of the queued ips, got %d", len(lookups))
+	}
+
+	alarm = newTestEnrichAlarm()
+	enricher.Enrich(alarm)
+	checkEnrichedFields(t, alarm, "attack_source_hostname")
+	if alarm["attack_source_hostname"] != "scanner.example.com" {
+		t.Errorf("unexpected hostname: %v", alarm["attack_source_hostname"])
+	}
+	if _, ok := alarm["client_ip_hostname"]; ok {
+		t.Errorf("the ip without PTR record must not have hostname")
+	}
+	// the miss results are cached
+	if _, ok := enricher.cache.get("5.6.7.8"); !ok {
+		t.Error("the ip without PTR record must be cached")
+	}
+	if _, ok := enricher.cache.get("9.9.9.9"); ok {
+		t.Error("the ip skipped by the full queue must not be cached")
+	}
+}
+
+func TestReverseDnsNegativeCache(t *testing.T) {
+	lookup := func(ctx context.Context, addr string) ([]string, error) {
+		return nil, &net.DNSError{Err: "i/o timeout", Name: addr, IsTimeout: true}
+	}
+	enricher := newReverseDnsEnricherWithLookup(lookup, time.Second, 100, time.Hour, time.Millisecond, 1)
+	enricher.resolve("1.2.3.4")
+	if hostname, ok := enricher.cache.get("1.2.3.4"); !ok || hostname != "" {
+		t.Fatalf("the failed lookup must be cached as a miss, got %q, %v", hostname, ok)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if _, ok := enricher.cache.get("1.2.3.4"); ok {
+		t.Error("the failed lookup must expire with the negative cache ttl")
+	}
+}
@@ -0,0 +1,152 @@
+//Copyright 2017-2018 Baidu Inc.
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http: //www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+
+package enrich
+
+import (
+	"errors"
+	"github.com/astaxie/beego"
+	"github.com/oschwald/geoip2-golang"
+	"net"
+	"path/filepath"
+	"rasp-cloud/tools"
+	"strings"
+)
+
+type geoIpCityReader interface {
+	City(ip net.IP) (*geoip2.City, error)
+}
+
+type geoIpAsnReader interface {
+	ASN(ip net.IP) (*geoip2.ASN, error)
+}
+
+// the readers are got for every alarm, so that the hot reloaded database is used,
+// the enrichment is skipped if the database is not available
+type geoIpCityEnricher struct {
+	getReader func() geoIpCityReader
+}
+
+type geoIpAsnEnricher struct {
+	getReader func() geoIpAsnReader
+}
+
+func newGeoIpCityEnricher() (AlarmEnricher, error) {
+	db, err := openGeoIpDatabase(GeoIpDbCity,
+		beego.AppConfig.DefaultString("AlarmGeoipCityDb", "geoip/GeoLite2-City.mmdb"), "City")
+	if err != nil {
+		return nil, err
+	}
+	return &geoIpCityEnricher{getReader: func() geoIpCityReader {
+		if reader := db.get(); reader != nil {
+			return reader
+		}
+		return nil
+	}}, nil
+}
+
+func (e *geoIpCityEnricher) Name() string {
+	return AlarmEnricherGeoipCity
+}
+
+func (e *geoIpCityEnricher) Enrich(alarm map[string]interface{}) error {
+	reader := e.getReader()
+	if reader == nil {
+		return nil
+	}
+	var errMsgs []string
+	for field, ip := range getAlarmIps(alarm) {
+		record, err := reader.City(ip)
+		if err != nil {
+			errMsgs = append(errMsgs, "failed to parse "+field+" to location: "+err.Error())
+			continue
+		}
+		alarm[locationFieldName(field)] = map[string]interface{}{
+			"location_zh_cn": record.Country.Names["zh-CN"] + "-" + record.City.Names["zh-CN"],
+			"location_en":    record.Country.Names["en"] + "-" + record.City.Names["en"],
+			"country_zh_cn":  record.Country.Names["zh-CN"],
+			"country_en":     record.Country.Names["en"],
+			"latitude":       record.Location.Latitude,
+			"longitude":      record.Location.Longitude,
+		}
+	}
+	if len(errMsgs) > 0 {
+		return errors.New(strings.Join(errMsgs, "; "))
+	}
+	return nil
+}
+
+// the location of attack_source keeps the field name attack_location
+func locationFieldName(ipField string) string {
+	if ipField == "attack_source" {
+		return "attack_location"
+	}
+	return enrichFieldName(ipField, "location")
+}
+
+func newGeoIpAsnEnricher() (AlarmEnricher, error) {
+	db, err := openGeoIpDatabase(GeoIpDbAsn,
+		beego.AppConfig.DefaultString("AlarmGeoipAsnDb", "geoip/GeoLite2-ASN.mmdb"), "ASN")
+	if err != nil {
+		return nil, err
+	}
+	return &geoIpAsnEnricher{getReader: func() geoIpAsnReader {
+		if reader := db.get(); reader != nil {
+			return reader
+		}
+		return nil
+	}}, nil
+}
+
+func (e *geoIpAsnEnricher) Name() string {
+	return AlarmEnricherGeoipAsn
+}
+
+func (e *geoIpAsnEnricher) Enrich(alarm map[string]interface{}) error {
+	reader := e.getReader()
+	if reader == nil {
+		return nil
+	}
+	var errMsgs []string
+	for field, ip := range getAlarmIps(alarm) {
+		record, err := reader.ASN(ip)
+		if err != nil {
+			errMsgs = append(errMsgs, "failed to parse "+field+" to asn: "+err.Error())
+			continue
+		}
+		if record.AutonomousSystemNumber == 0 {
+			continue
+		}
+		alarm[enrichFieldName(field, "asn")] = map[string]interface{}{
+			"number":       record.AutonomousSystemNumber,
+			"organization": record.AutonomousSystemOrganization,
+		}
+	}
+	if len(errMsgs) > 0 {
+		return errors.New(strings.Join(errMsgs, "; "))
+	}
+	return nil
+}
+
+// getDataFilePath resolves the relative data file path with the directory of executable file
+func getDataFilePath(path string) (string, error) {
+	if filepath.IsAbs(path) {
+		return path, nil
+	}
+	currentPath, err := tools.GetCurrentPath()
+	if err != nil {
+		return "", errors.New("failed to get current directory path: " + err.Error())
+	}
+	return filepath.Join(currentPath, path), nil
+}
@@ -0,0 +1,176 @@
+//Copyright 2017-2018 Baidu Inc.
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http: //www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+
+package enrich
+
+import (
+	"errors"
+	"github.com/astaxie/beego"
+	"github.com/oschwald/geoip2-golang"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// GeoIpDbStatus is the status of a geoip database
+type GeoIpDbStatus struct {
+	Name         string `json:"name"`
+	Path         string `json:"path"`
+	Loaded       bool   `json:"loaded"`
+	DatabaseType string `json:"database_type"`
+	BuildTime    int64  `json:"build_time"`
+	LoadTime     int64  `json:"load_time"`
+	Error        string `json:"error"`
+}
+
+// geoIpDatabase is an optional geoip database that can be swapped atomically when the file is updated,
+// the database is read into memory, so the file can be overwritten safely
+type geoIpDatabase struct {
+	reader atomic.Value
+	// lock serializes the reloads and guards the status
+	lock     sync.Mutex
+	status   GeoIpDbStatus
+	modTime  time.Time
+	typeName string
+}
+
+const (
+	GeoIpDbCity = "city"
+	GeoIpDbAsn  = "asn"
+)
+
+var (
+	geoIpDatabases     = make(map[string]*geoIpDatabase)
+	geoIpDatabasesLock sync.Mutex
+)
+
+// openGeoIpDatabase registers the geoip database and loads it, the missing database only disables the lookups
+func openGeoIpDatabase(name string, path string, typeName string) (*geoIpDatabase, error) {
+	path, err := getDataFilePath(path)
+	if err != nil {
+		return nil, err
+	}
+	geoIpDatabasesLock.Lock()
+	defer geoIpDatabasesLock.Unlock()
+	if db, ok := geoIpDatabases[name]; ok {
+		return db, nil
+	}
+	db := &geoIpDatabase{status: GeoIpDbStatus{Name: name, Path: path}, typeName: typeName}
+	if _, err := db.reload(true); err != nil {
+		beego.Warn("the geoip " + name + " database is not loaded, the lookups are skipped until it is available: " +
+			err.Error())
+	}
+	geoIpDatabases[name] = db
+	return db, nil
+}
+
+func (db *geoIpDatabase) get() *geoip2.Reader {
+	reader, _ := db.reader.Load().(*geoip2.Reader)
+	return reader
+}
+
+// reload loads the database if the file is modified or force is true, the loaded database is kept on failure
+func (db *geoIpDatabase) reload(force bool) (changed bool, err error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	defer func() {
+		if err != nil {
+			db.status.Error = err.Error()
+		}
+	}()
+	info, err := os.Stat(db.status.Path)
+	if err != nil {
+		return false, err
+	}
+	if !force && info.ModTime().Equal(db.modTime) {
+		return false, nil
+	}
+	content, err := ioutil.ReadFile(db.status.Path)
+	if err != nil {
+		return false, err
+	}
+	reader, err := geoip2.FromBytes(content)
+	if err != nil {
+		return false, err
+	}
+	metadata := reader.Metadata()
+	if !strings.Contains(metadata.DatabaseType, db.typeName) {
+		return false, errors.New("the type of geoip database " + db.status.Path + " is " + metadata.DatabaseType +
+			", expected " + db.typeName)
+	}
+	db.reader.Store(reader)
+	db.modTime = info.ModTime()
+	db.status.Loaded = true
+	db.status.DatabaseType = metadata.DatabaseType
+	db.status.BuildTime = int64(metadata.BuildEpoch)
+	db.status.LoadTime = time.Now().Unix()
+	db.status.Error = ""
+	beego.Info("geoip " + db.status.Name + " database loaded: " + db.status.Path)
+	return true, nil
+}
+
+func (db *geoIpDatabase) getStatus() *GeoIpDbStatus {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	status := db.status
+	return &status
+}
+
+func getGeoIpDatabaseList() []*geoIpDatabase {
+	geoIpDatabasesLock.Lock()
+	defer geoIpDatabasesLock.Unlock()
+	result := make([]*geoIpDatabase, 0, len(geoIpDatabases))
+	for _, db := range geoIpDatabases {
+		result = append(result, db)
+	}
+	return result
+}
+
+// startGeoIpWatcher reloads the geoip databases whose files are modified
+func startGeoIpWatcher(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	for range ticker.C {
+		for _, db := range getGeoIpDatabaseList() {
+			if _, err := db.reload(false); err != nil && db.get() != nil {
+				beego.Error("failed to reload geoip database, the loaded one is kept: " + err.Error())
+			}
+		}
+	}
+}
+
+// GetGeoIpDatabases returns the status of the geoip databases used by enrichers
+func GetGeoIpDatabases() []*GeoIpDbStatus {
+	result := make([]*GeoIpDbStatus, 0)
+	for _, db := range getGeoIpDatabaseList() {
+		result = append(result, db.getStatus())
+	}
+	return result
+}
+
+// ReloadGeoIpDatabases reloads all of the geoip databases immediately
+func ReloadGeoIpDatabases() []*GeoIpDbStatus {
+	result := make([]*GeoIpDbStatus, 0)
+	for _, db := range getGeoIpDatabaseList() {
+		_, err := db.reload(true)
+		status := db.getStatus()
+		if err != nil {
+			beego.Error("failed to reload geoip " + status.Name + " database: " + err.Error())
+		}
+		result = append(result, status)
+	}
+	return result
+}
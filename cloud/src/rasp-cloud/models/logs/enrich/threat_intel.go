@@ -0,0 +1,175 @@
+//Copyright 2017-2018 Baidu Inc.
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http: //www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+
+package enrich
+
+import (
+	"bufio"
+	"errors"
+	"github.com/astaxie/beego"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// threatIntelEnricher matches the ip with the threat intelligence lists from local files,
+// every line of the file is an ip or a cidr, the lines starting with # are comments.
+// The files are reloaded when they are modified.
+type threatIntelEnricher struct {
+	lock  sync.RWMutex
+	lists []*threatIntelList
+}
+
+type threatIntelList struct {
+	name    string
+	path    string
+	modTime time.Time
+	// the single ips are matched by map, the large ip lists are not scanned one by one
+	ips      map[string]bool
+	networks []*net.IPNet
+}
+
+func (l *threatIntelList) contains(ip net.IP) bool {
+	return l.ips[ip.String()] || networksContain(l.networks, ip)
+}
+
+func newThreatIntelEnricher() (AlarmEnricher, error) {
+	lists, err := parseThreatIntelConfig(beego.AppConfig.String("AlarmThreatIntelFiles"))
+	if err != nil {
+		return nil, err
+	}
+	enricher := &threatIntelEnricher{lists: lists}
+	enricher.reload()
+	interval := beego.AppConfig.DefaultInt("AlarmThreatIntelReloadInterval", 300)
+	if interval > 0 {
+		go enricher.startReload(time.Duration(interval) * time.Second)
+	}
+	return enricher, nil
+}
+
+// parseThreatIntelConfig parses the config with format "name:path,name:path"
+func parseThreatIntelConfig(config string) ([]*threatIntelList, error) {
+	lists := make([]*threatIntelList, 0)
+	for _, item := range strings.Split(config, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		index := strings.Index(item, ":")
+		if index <= 0 || index == len(item)-1 {
+			return nil, errors.New("the item of AlarmThreatIntelFiles must be in the format of name:path, got: " + item)
+		}
+		path, err := getDataFilePath(strings.TrimSpace(item[index+1:]))
+		if err != nil {
+			return nil, err
+		}
+		lists = append(lists, &threatIntelList{name: strings.TrimSpace(item[:index]), path: path})
+	}
+	if len(lists) == 0 {
+		return nil, errors.New("the AlarmThreatIntelFiles config can not be empty")
+	}
+	return lists, nil
+}
+
+func (e *threatIntelEnricher) Name() string {
+	return AlarmEnricherThreatIntel
+}
+
+func (e *threatIntelEnricher) Enrich(alarm map[string]interface{}) error {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	for field, ip := range getAlarmIps(alarm) {
+		matched := make([]string, 0)
+		for _, list := range e.lists {
+			if list.contains(ip) {
+				matched = append(matched, list.name)
+			}
+		}
+		if len(matched) > 0 {
+			alarm[enrichFieldName(field, "threat_intel")] = matched
+		}
+	}
+	return nil
+}
+
+func (e *threatIntelEnricher) startReload(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	for range ticker.C {
+		e.reload()
+	}
+}
+
+// reload loads the modified files, the old networks are kept if the file fails to load
+func (e *threatIntelEnricher) reload() {
+	e.lock.RLock()
+	lists := e.lists
+	e.lock.RUnlock()
+	newLists := make([]*threatIntelList, len(lists))
+	changed := false
+	for index, list := range lists {
+		newLists[index] = list
+		info, err := os.Stat(list.path)
+		if err != nil {
+			beego.Error("failed to stat threat intel file " + list.path + ": " + err.Error())
+			continue
+		}
+		if info.ModTime().Equal(list.modTime) {
+			continue
+		}
+		ips, networks, err := loadThreatIntelFile(list.path)
+		if err != nil {
+			beego.Error("failed to load threat intel file " + list.path + ": " + err.Error())
+			continue
+		}
+		newLists[index] = &threatIntelList{name: list.name, path: list.path, modTime: info.ModTime(),
+			ips: ips, networks: networks}
+		changed = true
+		beego.Info("threat intel list loaded: " + list.name + ", file: " + list.path)
+	}
+	if changed {
+		e.lock.Lock()
+		e.lists = newLists
+		e.lock.Unlock()
+	}
+}
+
+func loadThreatIntelFile(path string) (map[string]bool, []*net.IPNet, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+	ips := make(map[string]bool)
+	networks := make([]*net.IPNet, 0)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		network, err := parseNetwork(strings.Fields(line)[0])
+		if err != nil {
+			beego.Warn("invalid line in threat intel file " + path + ": " + err.Error())
+			continue
+		}
+		if ones, bits := network.Mask.Size(); ones == bits {
+			ips[network.IP.String()] = true
+		} else {
+			networks = append(networks, network)
+		}
+	}
+	return ips, networks, scanner.Err()
+}
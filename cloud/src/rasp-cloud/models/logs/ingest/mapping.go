@@ -0,0 +1,356 @@
+//Copyright 2017-2018 Baidu Inc.
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http: //www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+
+package ingest
+
+const (
+	AttackAlarmType = "attack-alarm"
+	PolicyAlarmType = "policy-alarm"
+)
+
+// the es mappings of alarms, the alarms are normalized with them on ingest
+var (
+	AttackEsMapping = `
+	{
+		"mappings": {
+			"attack-alarm": {
+				"_all": {
+					"enabled": false
+				},
+				"properties": {
+					"@timestamp":{
+                   		"type":"date"
+         			},
+					"request_method": {
+						"type": "keyword",
+						"ignore_above": 50
+					},
+					"target": {
+						"type": "keyword",
+						"ignore_above": 256
+					},
+					"server_ip": {
+						"type": "keyword",
+						"ignore_above": 256
+					},
+					"client_ip": {
+						"type": "keyword",
+						"ignore_above": 256
+					},
+					"referer": {
+						"type": "keyword",
+						"ignore_above": 256
+					},
+					"user_agent": {
+						"type": "keyword",
+						"ignore_above": 512
+					},
+					"attack_source": {
+						"type": "keyword",
+						"ignore_above": 256
+					},
+					"path": {
+						"type": "keyword",
+						"ignore_above": 256
+					},
+					"url": {
+						"type": "keyword",
+						"ignore_above": 256
+					},
+					"event_type": {
+						"type": "keyword",
+						"ignore_above": 256
+					},
+					"server_hostname": {
+						"type": "keyword",
+						"ignore_above": 256
+					},
+					"stack_md5": {
+						"type": "keyword",
+						"ignore_above": 64
+					},
+					"server_type": {
+						"type": "keyword",
+						"ignore_above": 256
+					},
+					"server_version": {
+						"type": "keyword",
+						"ignore_above": 256
+					},
+					"request_id": {
+						"type": "keyword",
+						"ignore_above": 256
+					},
+					"body": {
+						"type": "keyword"
+					},
+					"app_id": {
+						"type": "keyword",
+						"ignore_above": 256
+					},
+					"rasp_id": {
+						"type": "keyword",
+						"ignore_above": 256
+					},
+					"event_time": {
+						"type": "date"
+					},
+					"first_seen": {
+						"type": "date"
+					},
+					"last_seen": {
+						"type": "date"
+					},
+					"count": {
+						"type": "long"
+					},
+					"upsert_id": {
+						"type": "keyword",
+						"ignore_above": 64
+					},
+					"stack_trace": {
+						"type": "keyword"
+					},
+					"intercept_state": {
+						"type": "keyword",
+						"ignore_above": 64
+					},
+					"triage_status": {
+						"type": "keyword",
+						"ignore_above": 64
+					},
+					"triage_assignee": {
+						"type": "keyword",
+						"ignore_above": 256
+					},
+					"triage_update_user": {
+						"type": "keyword",
+						"ignore_above": 256
+					},
+					"triage_update_time": {
+						"type": "date"
+					},
+					"attack_type": {
+						"type": "keyword",
+						"ignore_above": 256
+					},
+					"attack_location": {
+						"type": "object",
+						"properties": {
+							"location_zh_cn":{
+								"type": "keyword",
+								"ignore_above": 256
+							},
+							"location_en":{
+								"type": "keyword",
+								"ignore_above": 256
+							},
+							"country_zh_cn":{
+								"type": "keyword",
+								"ignore_above": 256
+							},
+							"country_en":{
+								"type": "keyword",
+								"ignore_above": 256
+							},
+							"longitude":{
+								"type": "double"
+							},
+							"latitude":{
+								"type": "double"
+							}
+						}
+					},
+					"attack_source_asn": {
+						"type": "object",
+						"properties": {
+							"number": {
+								"type": "long"
+							},
+							"organization": {
+								"type": "keyword",
+								"ignore_above": 256
+							}
+						}
+					},
+					"attack_source_internal": {
+						"type": "boolean"
+					},
+					"attack_source_hostname": {
+						"type": "keyword",
+						"ignore_above": 256
+					},
+					"attack_source_threat_intel": {
+						"type": "keyword",
+						"ignore_above": 256
+					},
+					"client_ip_location": {
+						"type": "object",
+						"properties": {
+							"location_zh_cn":{
+								"type": "keyword",
+								"ignore_above": 256
+							},
+							"location_en":{
+								"type": "keyword",
+								"ignore_above": 256
+							},
+							"country_zh_cn":{
+								"type": "keyword",
+								"ignore_above": 256
+							},
+							"country_en":{
+								"type": "keyword",
+								"ignore_above": 256
+							},
+							"longitude":{
+								"type": "double"
+							},
+							"latitude":{
+								"type": "double"
+							}
+						}
+					},
+					"client_ip_asn": {
+						"type": "object",
+						"properties": {
+							"number": {
+								"type": "long"
+							},
+							"organization": {
+								"type": "keyword",
+								"ignore_above": 256
+							}
+						}
+					},
+					"client_ip_internal": {
+						"type": "boolean"
+					},
+					"client_ip_hostname": {
+						"type": "keyword",
+						"ignore_above": 256
+					},
+					"client_ip_threat_intel": {
+						"type": "keyword",
+						"ignore_above": 256
+					},
+					"plugin_algorithm":{
+						"type": "keyword",
+						"ignore_above": 256
+					},
+					"plugin_name": {
+						"type": "keyword",
+						"ignore_above": 256
+					},
+					"plugin_confidence": {
+						"type": "short"
+					},
+					"attack_params": {
+						"type": "object",
+						"enabled":"false"
+					},
+					"plugin_message": {
+						"type": "keyword"
+					},
+					"server_nic": {
+						"type": "nested",
+						"properties": {
+							"name": {
+								"type": "keyword",
+								"ignore_above": 256
+							},
+							"ip": {
+								"type": "keyword",
+								"ignore_above": 256
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+	`
+	PolicyEsMapping = `
+	{
+		"mappings": {
+			"policy-alarm": {
+				"_all": {
+					"enabled": false
+				},
+				"properties": {
+					"@timestamp":{
+						"type":"date"
+         			},
+					"event_type": {
+						"type": "keyword",
+						"ignore_above": 256
+					},
+					"server_hostname": {
+						"type": "keyword",
+						"ignore_above": 256
+					},
+					"server_type": {
+						"type": "keyword",
+						"ignore_above": 64
+					},
+					"server_nic": {
+						"type": "nested",
+						"properties": {
+							"name": {
+								"type": "keyword",
+								"ignore_above": 256
+							},
+							"ip": {
+								"type": "keyword",
+								"ignore_above": 256
+							}
+						}
+					},
+					"app_id": {
+						"type": "keyword",
+						"ignore_above": 256
+					},
+					"rasp_id": {
+						"type": "keyword",
+						"ignore_above": 256
+					},
+					"event_time": {
+						"type": "date"
+					},
+					"first_seen": {
+						"type": "date"
+					},
+					"stack_trace": {
+						"type": "keyword"
+					},
+					"policy_id": {
+						"type": "long"
+					},
+					"message": {
+						"type": "keyword"
+					},
+					"stack_md5": {
+						"type": "keyword",
+						"ignore_above": 64
+					},
+					"policy_params": {
+						"type": "object",
+						"enabled":"false"
+					}
+				}
+			}
+		}
+	}
+`
+)
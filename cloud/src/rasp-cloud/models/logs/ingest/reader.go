@@ -0,0 +1,174 @@
+//Copyright 2017-2018 Baidu Inc.
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http: //www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+
+package ingest
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+)
+
+type Rejection struct {
+	Index int    `json:"index"`
+	Error string `json:"error"`
+}
+
+type Result struct {
+	Count    int          `json:"count"`
+	Accepted int          `json:"accepted"`
+	Rejected int          `json:"rejected"`
+	Errors   []*Rejection `json:"errors"`
+	// Truncated is true if the body can not be read to the end after some alarms have been accepted,
+	// Error is the reason, the first Accepted+Rejected alarms of the body have been processed
+	Truncated bool   `json:"truncated"`
+	Error     string `json:"error,omitempty"`
+}
+
+const maxReportedRejections = 50
+
+var (
+	ErrBodyTooLarge        = errors.New("the request body is too large")
+	ErrUnsupportedEncoding = errors.New("unsupported content encoding")
+)
+
+// limitedReader returns ErrBodyTooLarge instead of io.EOF when the limit is exceeded
+type limitedReader struct {
+	reader io.Reader
+	remain int64
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	if r.remain <= 0 {
+		var b [1]byte
+		if n, _ := r.reader.Read(b[:]); n > 0 {
+			return 0, ErrBodyTooLarge
+		}
+		return 0, io.EOF
+	}
+	if int64(len(p)) > r.remain {
+		p = p[:r.remain]
+	}
+	n, err := r.reader.Read(p)
+	r.remain -= int64(n)
+	return n, err
+}
+
+// ReadAlarms streams the alarms from the request body of agent, the body can be a json array or ndjson,
+// optionally compressed with gzip, every alarm is handled as soon as it is decoded, the alarm is rejected
+// if handle returns an error.
+// If the body can not be read to the end, such as the invalid json or the body larger than maxBodySize:
+//   - before any alarm is accepted, the error is returned, and the agent can send the body again
+//   - after some alarms have been accepted, they can not be taken back, so the error is not returned,
+//     the result is marked as truncated with the error instead, the agent must not send the body again
+func ReadAlarms(body io.Reader, contentEncoding string, maxBodySize int64,
+	handle func(map[string]interface{}) error) (*Result, error) {
+	result := &Result{Errors: make([]*Rejection, 0)}
+	switch strings.ToLower(contentEncoding) {
+	case "", "identity":
+	case "gzip":
+		gzipReader, err := gzip.NewReader(body)
+		if err != nil {
+			return result, err
+		}
+		defer gzipReader.Close()
+		body = gzipReader
+	default:
+		return result, ErrUnsupportedEncoding
+	}
+	reader := bufio.NewReader(&limitedReader{reader: body, remain: maxBodySize})
+	firstByte, err := peekFirstByte(reader)
+	if err != nil && err != io.EOF {
+		return result, err
+	}
+	if firstByte == '[' {
+		err = readJsonArray(reader, result, handle)
+	} else if firstByte != 0 {
+		err = readNdjson(reader, result, handle)
+	}
+	result.Count = result.Accepted
+	if err != nil && result.Accepted > 0 {
+		result.Truncated = true
+		result.Error = err.Error()
+		return result, nil
+	}
+	return result, err
+}
+
+func peekFirstByte(reader *bufio.Reader) (byte, error) {
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if b != ' ' && b != '\t' && b != '\r' && b != '\n' {
+			return b, reader.UnreadByte()
+		}
+	}
+}
+
+func readJsonArray(reader io.Reader, result *Result, handle func(map[string]interface{}) error) error {
+	decoder := json.NewDecoder(reader)
+	if _, err := decoder.Token(); err != nil {
+		return err
+	}
+	for index := 0; decoder.More(); index++ {
+		var item json.RawMessage
+		if err := decoder.Decode(&item); err != nil {
+			return err
+		}
+		handleAlarm(index, item, result, handle)
+	}
+	_, err := decoder.Token()
+	return err
+}
+
+func readNdjson(reader *bufio.Reader, result *Result, handle func(map[string]interface{}) error) error {
+	for index := 0; ; {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			handleAlarm(index, line, result, handle)
+			index++
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func handleAlarm(index int, content []byte, result *Result, handle func(map[string]interface{}) error) {
+	var alarm map[string]interface{}
+	err := json.Unmarshal(content, &alarm)
+	if err == nil && alarm == nil {
+		err = errors.New("the alarm must be a json object")
+	}
+	if err == nil {
+		err = handle(alarm)
+	}
+	if err != nil {
+		result.Rejected++
+		if len(result.Errors) < maxReportedRejections {
+			result.Errors = append(result.Errors, &Rejection{Index: index, Error: err.Error()})
+		}
+		return
+	}
+	result.Accepted++
+}
@@ -0,0 +1,176 @@
+//Copyright 2017-2018 Baidu Inc.
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http: //www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+
+package ingest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// the alarms are reported by the java agent
+const (
+	testAttackAlarm = `{"event_type":"attack","event_time":"2018-08-10T16:57:03+0800","request_id":"f2b1b1f53d6e4b23",` +
+		`"request_method":"get","intercept_state":"block","target":"127.0.0.1","server_hostname":"host-1",` +
+		`"server_type":"tomcat","server_version":"8.5.31","server_nic":[{"name":"eth0","ip":"10.0.0.2"}],` +
+		`"rasp_id":"da3d3b4a5e0c4e4b9d0e8e1e6f5f7d9a","app_id":"spoofed","attack_source":"192.168.1.10",` +
+		`"client_ip":"","url":"http://127.0.0.1:8080/vulns/002-file-read.jsp?file=../../../../etc/passwd",` +
+		`"path":"/vulns/002-file-read.jsp","attack_type":"readFile","plugin_name":"official",` +
+		`"plugin_confidence":90,"plugin_message":"Path traversal - Downloading files specified by ` +
+		`userinput, file is ../../../../etc/passwd","plugin_algorithm":"readFile_userinput",` +
+		`"attack_params":{"path":"../../../../etc/passwd","realpath":"/etc/passwd"},` +
+		`"header":{"host":"127.0.0.1:8080","user-agent":"curl/7.54.0"},"body":"",` +
+		`"stack_trace":"java.io.FileInputStream.<init>\norg.apache.jsp._002_002dfile_002dread_jsp._jspService\n"}`
+	testPolicyAlarm = `{"event_type":"security_policy","event_time":"2018-08-10T16:57:03+0800",` +
+		`"server_hostname":"host-1","server_type":"tomcat","server_nic":[{"name":"eth0","ip":"10.0.0.2"}],` +
+		`"rasp_id":"da3d3b4a5e0c4e4b9d0e8e1e6f5f7d9a","policy_id":"3006",` +
+		`"message":"Database security - Connecting to a mysql instance with high privileged account root",` +
+		`"policy_params":{"server":"mysql","hostname":"127.0.0.1","port":"3306","username":"root"},` +
+		`"stack_trace":"com.mysql.jdbc.Driver.connect\n"}`
+)
+
+const testMaxBodySize = 1024 * 1024
+
+func readTestAlarms(t *testing.T, body []byte, contentEncoding string,
+	alarmType string) ([]map[string]interface{}, *Result) {
+	var alarms []map[string]interface{}
+	result, err := ReadAlarms(bytes.NewReader(body), contentEncoding, testMaxBodySize,
+		func(alarm map[string]interface{}) error {
+			if err := NormalizeAlarm(alarmType, "test-app", alarm, false); err != nil {
+				return err
+			}
+			alarms = append(alarms, alarm)
+			return nil
+		})
+	if err != nil {
+		t.Fatalf("failed to read alarms: %v", err)
+	}
+	return alarms, result
+}
+
+func TestReadAlarmsFormats(t *testing.T) {
+	var gzipBody bytes.Buffer
+	writer := gzip.NewWriter(&gzipBody)
+	writer.Write([]byte(testAttackAlarm + "\n" + testAttackAlarm + "\n"))
+	writer.Close()
+	cases := []struct {
+		name     string
+		body     []byte
+		encoding string
+	}{
+		{"json array", []byte("[" + testAttackAlarm + "," + testAttackAlarm + "]"), ""},
+		{"ndjson", []byte(testAttackAlarm + "\n\n" + testAttackAlarm), "identity"},
+		{"gzip ndjson", gzipBody.Bytes(), "gzip"},
+	}
+	for _, c := range cases {
+		alarms, result := readTestAlarms(t, c.body, c.encoding, AttackAlarmType)
+		if result.Accepted != 2 || result.Rejected != 0 || result.Count != 2 || len(alarms) != 2 {
+			t.Fatalf("%s: unexpected result %+v, errors: %v", c.name, result, result.Errors)
+		}
+	}
+}
+
+func TestReadAttackAlarm(t *testing.T) {
+	alarms, result := readTestAlarms(t, []byte(testAttackAlarm), "", AttackAlarmType)
+	if result.Accepted != 1 {
+		t.Fatalf("the attack alarm is rejected: %+v", result.Errors[0])
+	}
+	alarm := alarms[0]
+	if alarm["app_id"] != "test-app" {
+		t.Errorf("the app_id must be forced to the one of agent, got %v", alarm["app_id"])
+	}
+	if alarm["plugin_confidence"] != int64(90) {
+		t.Errorf("the plugin_confidence must be an integer, got %#v", alarm["plugin_confidence"])
+	}
+}
+
+func TestReadPolicyAlarm(t *testing.T) {
+	alarms, result := readTestAlarms(t, []byte(testPolicyAlarm), "", PolicyAlarmType)
+	if result.Accepted != 1 {
+		t.Fatalf("the policy alarm is rejected: %+v", result.Errors[0])
+	}
+	// the upsert_id of policy alarm depends on the string form of policy_id
+	if alarms[0]["policy_id"] != int64(3006) || fmt.Sprint(alarms[0]["policy_id"]) != "3006" {
+		t.Errorf("unexpected policy_id %#v", alarms[0]["policy_id"])
+	}
+}
+
+func TestReadAlarmsRejections(t *testing.T) {
+	body := strings.Join([]string{
+		testAttackAlarm,
+		`{"event_time":"2018-08-10T16:57:03+0800"}`,
+		`{"attack_type":"sql","event_time":true}`,
+		`[1]`,
+		`{"attack_type":"sql","event_time":"2018-08-10T16:57:03+0800","plugin_confidence":"high"}`,
+	}, "\n")
+	_, result := readTestAlarms(t, []byte(body), "", AttackAlarmType)
+	if result.Accepted != 1 || result.Rejected != 4 || len(result.Errors) != 4 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	for i, index := range []int{1, 2, 3, 4} {
+		if result.Errors[i].Index != index {
+			t.Errorf("unexpected index of rejection %+v", result.Errors[i])
+		}
+	}
+}
+
+func TestReadAlarmsErrors(t *testing.T) {
+	add := func(map[string]interface{}) error { return nil }
+	_, err := ReadAlarms(strings.NewReader(testAttackAlarm), "br", testMaxBodySize, add)
+	if err != ErrUnsupportedEncoding {
+		t.Errorf("expect the unsupported encoding error, got %v", err)
+	}
+	result, err := ReadAlarms(strings.NewReader("[{"), "", testMaxBodySize, add)
+	if err == nil || result.Truncated {
+		t.Errorf("expect the json error before any accepted alarm, got %v, %+v", err, result)
+	}
+	_, err = ReadAlarms(strings.NewReader(testAttackAlarm), "", int64(len(testAttackAlarm)-1), add)
+	if err != ErrBodyTooLarge {
+		t.Errorf("expect the body too large error, got %v", err)
+	}
+}
+
+func TestReadAlarmsTruncated(t *testing.T) {
+	add := func(map[string]interface{}) error { return nil }
+	cases := []struct {
+		name        string
+		body        string
+		maxBodySize int64
+	}{
+		{"json error", "[" + testAttackAlarm + ",{", testMaxBodySize},
+		{"body too large", testAttackAlarm + "\n" + testAttackAlarm, int64(len(testAttackAlarm))},
+	}
+	for _, c := range cases {
+		// the accepted alarm can not be taken back, so the error is reported in the result
+		result, err := ReadAlarms(strings.NewReader(c.body), "", c.maxBodySize, add)
+		if err != nil || !result.Truncated || result.Error == "" || result.Accepted != 1 {
+			t.Errorf("%s: expect the truncated result after an accepted alarm, got %v, %+v", c.name, err, result)
+		}
+	}
+}
+
+func TestNormalizeAlarmStripUnknownFields(t *testing.T) {
+	for _, strip := range []bool{false, true} {
+		alarm := map[string]interface{}{"attack_type": "sql", "event_time": "2018-08-10T16:57:03+0800", "unknown": 1}
+		if err := NormalizeAlarm(AttackAlarmType, "test-app", alarm, strip); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := alarm["unknown"]; ok == strip {
+			t.Errorf("unexpected unknown field with strip %v: %v", strip, alarm)
+		}
+	}
+}
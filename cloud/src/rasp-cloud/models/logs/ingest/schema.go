@@ -0,0 +1,264 @@
+//Copyright 2017-2018 Baidu Inc.
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http: //www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+
+package ingest
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"rasp-cloud/tools"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+// FieldSchema is the es mapping of a field, the alarms are normalized with it on ingest
+// so that the schema always matches the es mapping
+type FieldSchema struct {
+	Type        string                  `json:"type"`
+	IgnoreAbove int                     `json:"ignore_above"`
+	Properties  map[string]*FieldSchema `json:"properties"`
+}
+
+type alarmSchema struct {
+	fields   map[string]*FieldSchema
+	required []string
+}
+
+// maxKeywordBytes is the max length of a keyword term in lucene
+const maxKeywordBytes = 32766
+
+var alarmSchemas map[string]*alarmSchema
+
+func init() {
+	attackFields, err := parseSchemaFromMapping(AttackEsMapping, AttackAlarmType)
+	if err != nil {
+		tools.Panic(tools.ErrCodeConfigInitFailed, "failed to parse attack alarm schema", err)
+	}
+	policyFields, err := parseSchemaFromMapping(PolicyEsMapping, PolicyAlarmType)
+	if err != nil {
+		tools.Panic(tools.ErrCodeConfigInitFailed, "failed to parse policy alarm schema", err)
+	}
+	alarmSchemas = map[string]*alarmSchema{
+		AttackAlarmType: {fields: attackFields, required: []string{"attack_type", "event_time"}},
+		PolicyAlarmType: {fields: policyFields, required: []string{"policy_id", "event_time"}},
+	}
+}
+
+func parseSchemaFromMapping(esMapping string, docType string) (map[string]*FieldSchema, error) {
+	var mapping struct {
+		Mappings map[string]*FieldSchema `json:"mappings"`
+	}
+	if err := json.Unmarshal([]byte(esMapping), &mapping); err != nil {
+		return nil, err
+	}
+	if schema, ok := mapping.Mappings[docType]; ok && len(schema.Properties) > 0 {
+		return schema.Properties, nil
+	}
+	return nil, errors.New("can not find the properties of " + docType + " in es mapping")
+}
+
+// Fields returns the top level fields of the es mapping of alarm type
+func Fields(alarmType string) map[string]*FieldSchema {
+	if schema, ok := alarmSchemas[alarmType]; ok {
+		return schema.fields
+	}
+	return nil
+}
+
+// NormalizeAlarm validates the alarm with the schema of its alarm type, coerces the known fields to the
+// types of es mapping, truncates the overlong strings and forces the app_id to the authenticated one,
+// the fields not in the es mapping are removed if stripUnknownFields is true
+func NormalizeAlarm(alarmType string, appId string, alarm map[string]interface{}, stripUnknownFields bool) error {
+	schema, ok := alarmSchemas[alarmType]
+	if !ok {
+		return errors.New("unrecognized alarm type: " + alarmType)
+	}
+	alarm["app_id"] = appId
+	for _, field := range schema.required {
+		if value, ok := alarm[field]; !ok || value == nil || value == "" {
+			return errors.New("the alarm field '" + field + "' can not be empty")
+		}
+	}
+	for field, value := range alarm {
+		fieldSchema, ok := schema.fields[field]
+		if !ok {
+			if stripUnknownFields {
+				delete(alarm, field)
+			}
+			continue
+		}
+		if value == nil {
+			continue
+		}
+		newValue, err := normalizeField(field, fieldSchema, value)
+		if err != nil {
+			return err
+		}
+		alarm[field] = newValue
+	}
+	return nil
+}
+
+func normalizeField(path string, schema *FieldSchema, value interface{}) (interface{}, error) {
+	switch schema.Type {
+	case "keyword", "text":
+		return normalizeString(path, schema, value)
+	case "date":
+		switch v := value.(type) {
+		case string, float64, int64, int, json.Number:
+			return v, nil
+		}
+		return nil, errors.New("the alarm field '" + path + "' must be a date string or a timestamp")
+	case "long", "integer", "short", "byte":
+		return normalizeInteger(path, schema.Type, value)
+	case "double", "float":
+		number, err := toNumber(value)
+		if err != nil {
+			return nil, errors.New("the alarm field '" + path + "' must be a number")
+		}
+		return number, nil
+	case "boolean":
+		switch v := value.(type) {
+		case bool:
+			return v, nil
+		case string:
+			if b, err := strconv.ParseBool(v); err == nil {
+				return b, nil
+			}
+		}
+		return nil, errors.New("the alarm field '" + path + "' must be a boolean")
+	case "nested":
+		if items, ok := value.([]interface{}); ok {
+			for index, item := range items {
+				object, ok := item.(map[string]interface{})
+				if !ok {
+					return nil, errors.New("the alarm field '" + path + "[" + strconv.Itoa(index) +
+						"]' must be an object")
+				}
+				if err := normalizeObject(path, schema, object); err != nil {
+					return nil, err
+				}
+			}
+			return items, nil
+		}
+		fallthrough
+	case "object", "":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("the alarm field '" + path + "' must be an object")
+		}
+		return object, normalizeObject(path, schema, object)
+	}
+	return value, nil
+}
+
+func normalizeObject(path string, schema *FieldSchema, object map[string]interface{}) error {
+	for field, subSchema := range schema.Properties {
+		if value, ok := object[field]; ok && value != nil {
+			newValue, err := normalizeField(path+"."+field, subSchema, value)
+			if err != nil {
+				return err
+			}
+			object[field] = newValue
+		}
+	}
+	return nil
+}
+
+func normalizeString(path string, schema *FieldSchema, value interface{}) (interface{}, error) {
+	var str string
+	switch v := value.(type) {
+	case string:
+		str = v
+	case float64:
+		str = strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		str = strconv.FormatBool(v)
+	default:
+		return nil, errors.New("the alarm field '" + path + "' must be a string")
+	}
+	if schema.IgnoreAbove > 0 && utf8.RuneCountInString(str) > schema.IgnoreAbove {
+		str = string([]rune(str)[:schema.IgnoreAbove])
+	}
+	if len(str) > maxKeywordBytes {
+		end := maxKeywordBytes
+		for end > 0 && !utf8.RuneStart(str[end]) {
+			end--
+		}
+		str = str[:end]
+	}
+	return str, nil
+}
+
+func normalizeInteger(path string, fieldType string, value interface{}) (interface{}, error) {
+	number, err := toNumber(value)
+	if err != nil || number != math.Trunc(number) {
+		return nil, errors.New("the alarm field '" + path + "' must be an integer")
+	}
+	var min, max float64
+	switch fieldType {
+	case "byte":
+		min, max = math.MinInt8, math.MaxInt8
+	case "short":
+		min, max = math.MinInt16, math.MaxInt16
+	case "integer":
+		min, max = math.MinInt32, math.MaxInt32
+	default:
+		min, max = math.MinInt64, math.MaxInt64
+	}
+	if number < min || number > max {
+		return nil, errors.New("the alarm field '" + path + "' is out of the range of " + fieldType)
+	}
+	return int64(number), nil
+}
+
+func toNumber(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case json.Number:
+		return v.Float64()
+	case string:
+		return strconv.ParseFloat(v, 64)
+	}
+	return 0, errors.New("not a number")
+}
+
+// dateLayouts are the layouts of the date strings in the alarms of agents
+var dateLayouts = []string{"2006-01-02T15:04:05-0700", "2006-01-02T15:04:05.000-0700", time.RFC3339Nano,
+	"2006-01-02 15:04:05"}
+
+// ParseDate parses the value of a date field, which is a date string or a timestamp in milliseconds
+func ParseDate(value interface{}) (time.Time, bool) {
+	if str, ok := value.(string); ok {
+		for _, layout := range dateLayouts {
+			if t, err := time.Parse(layout, str); err == nil {
+				return t, true
+			}
+		}
+		return time.Time{}, false
+	}
+	if number, err := toNumber(value); err == nil {
+		return time.Unix(0, int64(number)*int64(time.Millisecond)), true
+	}
+	switch v := value.(type) {
+	case int64:
+		return time.Unix(0, v*int64(time.Millisecond)), true
+	case int:
+		return time.Unix(0, int64(v)*int64(time.Millisecond)), true
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,60 @@
+//Copyright 2017-2018 Baidu Inc.
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http: //www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+
+package logs
+
+import (
+	"errors"
+	"github.com/astaxie/beego"
+	"rasp-cloud/kafka"
+	"rasp-cloud/models/logs/sinks"
+	"strings"
+	"time"
+)
+
+const AlarmSinkKafka = sinks.NameKafka
+
+func newKafkaAlarmSink() (AlarmSink, error) {
+	brokers := make([]string, 0)
+	for _, broker := range strings.Split(beego.AppConfig.DefaultString("KafkaBrokers", ""), ",") {
+		if broker = strings.TrimSpace(broker); broker != "" {
+			brokers = append(brokers, broker)
+		}
+	}
+	partitionKey := beego.AppConfig.DefaultString("KafkaPartitionKey", "app_id")
+	if partitionKey != "app_id" && partitionKey != "rasp_id" && partitionKey != "" {
+		return nil, errors.New("the 'KafkaPartitionKey' config must be app_id, rasp_id or empty")
+	}
+	producer, err := kafka.NewProducer(&kafka.Config{
+		Brokers:       brokers,
+		RequiredAcks:  int16(beego.AppConfig.DefaultInt("KafkaRequiredAcks", -1)),
+		MaxRetries:    beego.AppConfig.DefaultInt("KafkaMaxRetries", 3),
+		RetryInterval: time.Duration(beego.AppConfig.DefaultInt64("KafkaRetryInterval", 500)) * time.Millisecond,
+		Timeout:       time.Duration(beego.AppConfig.DefaultInt64("KafkaTimeout", 10)) * time.Second,
+		MaxBatchBytes: beego.AppConfig.DefaultInt("KafkaMaxBatchBytes", 1000000),
+		ClientId:      "rasp-cloud",
+		TlsEnable:     beego.AppConfig.DefaultBool("KafkaTlsEnable", false),
+		TlsSkipVerify: beego.AppConfig.DefaultBool("KafkaTlsSkipVerify", false),
+		TlsCaFile:     beego.AppConfig.DefaultString("KafkaTlsCaFile", ""),
+		SaslUser:      beego.AppConfig.DefaultString("KafkaSaslUser", ""),
+		SaslPassword:  beego.AppConfig.DefaultString("KafkaSaslPassword", ""),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sinks.NewKafkaSink(producer, map[string]string{
+		AttackAlarmType: beego.AppConfig.DefaultString("KafkaAttackTopic", "openrasp-attack-alarm"),
+		PolicyAlarmType: beego.AppConfig.DefaultString("KafkaPolicyTopic", "openrasp-policy-alarm"),
+	}, partitionKey), nil
+}
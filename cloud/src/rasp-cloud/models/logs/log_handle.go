@@ -20,12 +20,15 @@ import (
 	"github.com/astaxie/beego/logs"
 	"os"
 	"rasp-cloud/es"
+	"rasp-cloud/models/logs/enrich"
+	"rasp-cloud/models/logs/ingest"
 	"time"
 	"encoding/json"
 	"github.com/olivere/elastic"
 	"context"
 	"path"
-	"fmt"
+	"strconv"
+	"errors"
 )
 
 type AggrTimeParam struct {
@@ -57,6 +60,7 @@ type SearchAttackParam struct {
 		AttackUrl    string    `json:"url,omitempty"`
 		LocalIp      string    `json:"local_ip,omitempty"`
 		AttackType   *[]string `json:"attack_type,omitempty"`
+		TriageStatus *[]string `json:"triage_status,omitempty"`
 	} `json:"data"`
 }
 
@@ -76,41 +80,34 @@ type SearchPolicyParam struct {
 }
 
 var (
-	AttackAlarmType     = "attack-alarm"
-	PolicyAlarmType     = "policy-alarm"
-	AddAlarmFunc        func(string, map[string]interface{}) error
-	esAttackAlarmBuffer chan map[string]interface{}
-	esPolicyAlarmBuffer chan map[string]interface{}
-	alarmFileLoggers    = make(map[string]*logs.BeeLogger)
+	AttackAlarmType = ingest.AttackAlarmType
+	PolicyAlarmType = ingest.PolicyAlarmType
+	AddAlarmFunc    func(string, map[string]interface{}) error
 )
 
+type esAlarmSink struct{}
+
+type fileAlarmSink struct {
+	loggers map[string]*logs.BeeLogger
+}
+
 func init() {
 	es.RegisterTTL(24*365*time.Hour, AliasAttackIndexName+"-*")
 	es.RegisterTTL(24*365*time.Hour, AliasPolicyIndexName+"-*")
-	alarmLogMode := beego.AppConfig.DefaultString("AlarmLogMode", "file")
-	if alarmLogMode == "file" {
-		AddAlarmFunc = AddLogWithFile
-		initRaspLoggers()
-	} else if alarmLogMode == "es" {
-		startEsAlarmLogPush()
-		AddAlarmFunc = AddLogWithES
-	} else {
-		tools.Panic(tools.ErrCodeConfigInitFailed, "Unrecognized the value of RaspLogMode config", nil)
-	}
-	alarmBufferSize := beego.AppConfig.DefaultInt("AlarmBufferSize", 300)
-	if alarmBufferSize <= 0{
-		tools.Panic(tools.ErrCodeMongoInitFailed, "the 'AlarmBufferSize' config must be greater than 0", nil)
-	} else if alarmBufferSize < 100 {
-		beego.Warning("the value of 'AlarmBufferSize' config is less than 100, it will be set to 100")
-		alarmBufferSize = 100
+	enrich.Init()
+	initAlarmSinks()
+	AddAlarmFunc = dispatchAlarm
+	if es.ElasticClient != nil {
+		// the new fields of mapping are added to the existing indices
+		err := es.UpdateEsMapping(AttackIndexName+"-*", AttackAlarmType, AttackEsMapping)
+		if err != nil {
+			beego.Error("failed to update the mapping of attack alarm indices: " + err.Error())
+		}
+		err = es.UpdateEsMapping(PolicyIndexName+"-*", PolicyAlarmType, PolicyEsMapping)
+		if err != nil {
+			beego.Error("failed to update the mapping of policy alarm indices: " + err.Error())
+		}
 	}
-	esAttackAlarmBuffer = make(chan map[string]interface{}, alarmBufferSize)
-	esPolicyAlarmBuffer = make(chan map[string]interface{}, alarmBufferSize)
-}
-
-func initRaspLoggers() {
-	alarmFileLoggers[AttackAlarmType] = initAlarmFileLogger("/openrasp-logs/attack-alarm", "attack.log")
-	alarmFileLoggers[PolicyAlarmType] = initAlarmFileLogger("/openrasp-logs/policy-alarm", "policy.log")
 }
 
 func initAlarmFileLogger(dirName string, fileName string) *logs.BeeLogger {
@@ -136,107 +133,69 @@ func initAlarmFileLogger(dirName string, fileName string) *logs.BeeLogger {
 	return logger
 }
 
-func startEsAlarmLogPush() {
-	go func() {
-		for {
-			handleEsLogPush()
-		}
-	}()
+func newEsAlarmSink() (AlarmSink, error) {
+	return &esAlarmSink{}, nil
 }
 
-func handleEsLogPush() {
-	defer func() {
-		if r := recover(); r != nil {
-			beego.Error("failed to push es alarm log: ", r)
-		}
-	}()
-	select {
-	case alarm := <-esAttackAlarmBuffer:
-		alarms := make([]map[string]interface{}, 0, 200)
-		alarms = append(alarms, alarm)
-		for len(esAttackAlarmBuffer) > 0 && len(alarms) < 200 {
-			alarm := <-esAttackAlarmBuffer
-			alarms = append(alarms, alarm)
-		}
-		err := es.BulkInsert(AttackAlarmType, alarms)
-		if err != nil {
-			beego.Error("failed to execute es bulk insert: " + err.Error())
-		}
-	case alarm := <-esPolicyAlarmBuffer:
-		alarms := make([]map[string]interface{}, 0, 200)
-		alarms = append(alarms, alarm)
-		for len(esPolicyAlarmBuffer) > 0 && len(alarms) < 200 {
-			alarm := <-esPolicyAlarmBuffer
-			alarms = append(alarms, alarm)
-		}
-		err := es.BulkInsert(PolicyAlarmType, alarms)
-		if err != nil {
-			beego.Error("failed to execute es bulk insert: " + err.Error())
+func (sink *esAlarmSink) Name() string {
+	return AlarmSinkEs
+}
+
+func (sink *esAlarmSink) Write(alarmType string, alarms []map[string]interface{}) (int, error) {
+	collapsed := 0
+	if alarmType == AttackAlarmType {
+		alarms, collapsed = collapseDuplicateAlarms(alarms)
+	}
+	result, err := es.BulkInsert(alarmType, alarms)
+	stats := getSinkStats(AlarmSinkEs, alarmType)
+	stats.addRetried(result.Retried)
+	if len(result.Failures) > 0 {
+		beego.Error("failed to insert " + strconv.Itoa(len(result.Failures)) + " " + alarmType +
+			" alarms into es, they will be sent to the dead letter")
+		for _, failure := range result.Failures {
+			addDeadLetter(alarmType, failure)
 		}
 	}
+	return result.Succeeded + collapsed, err
 }
 
-func AddLogWithFile(alarmType string, alarm map[string]interface{}) error {
-	if logger, ok := alarmFileLoggers[alarmType]; ok && logger != nil {
+func newFileAlarmSink() (AlarmSink, error) {
+	return &fileAlarmSink{
+		loggers: map[string]*logs.BeeLogger{
+			AttackAlarmType: initAlarmFileLogger("/openrasp-logs/attack-alarm", "attack.log"),
+			PolicyAlarmType: initAlarmFileLogger("/openrasp-logs/policy-alarm", "policy.log"),
+		},
+	}, nil
+}
+
+func (sink *fileAlarmSink) Name() string {
+	return AlarmSinkFile
+}
+
+func (sink *fileAlarmSink) Write(alarmType string, alarms []map[string]interface{}) (count int, err error) {
+	logger, ok := sink.loggers[alarmType]
+	if !ok || logger == nil {
+		return 0, errors.New("unrecognized log type: " + alarmType)
+	}
+	for _, alarm := range alarms {
 		content, err := json.Marshal(alarm)
 		if err != nil {
-			return err
+			logs.Error("failed to encode rasp log: " + err.Error())
+			continue
 		}
 		_, err = logger.Write(content)
 		if err != nil {
-			logs.Error("failed to write rasp log: " + err.Error())
-			return err
-		}
-	} else {
-		logs.Error("failed to write rasp log ,unrecognized log type: " + alarmType)
-	}
-	return nil
-}
-
-func AddLogWithES(alarmType string, alarm map[string]interface{}) error {
-	if alarmType == AttackAlarmType {
-		select {
-		case esAttackAlarmBuffer <- alarm:
-		default:
-			logs.Error("failed to write attack alarm ,the buffer is full: " + fmt.Sprintf("%+v", alarm))
-		}
-	} else if alarmType == PolicyAlarmType {
-		select {
-		case esPolicyAlarmBuffer <- alarm:
-		default:
-			logs.Error("failed to write policy alarm ,the buffer is full: " + fmt.Sprintf("%+v", alarm))
+			return count, err
 		}
+		count++
 	}
-	return nil
+	return count, nil
 }
 
 func SearchLogs(startTime int64, endTime int64, query map[string]interface{}, sortField string, page int,
 	perpage int, ascending bool, index ...string) (int64, []map[string]interface{}, error) {
 	var total int64
-	queries := make([]elastic.Query, 0, len(query)+1)
-	if query != nil {
-		for key, value := range query {
-			if key == "attack_type" {
-				if v, ok := value.([]interface{}); ok {
-					queries = append(queries, elastic.NewTermsQuery(key, v...))
-				} else {
-					queries = append(queries, elastic.NewTermQuery(key, value))
-				}
-			} else if key == "policy_id" {
-				if v, ok := value.([]interface{}); ok {
-					queries = append(queries, elastic.NewTermsQuery(key, v...))
-				} else {
-					queries = append(queries, elastic.NewTermQuery(key, value))
-				}
-			} else if key == "local_ip" {
-				queries = append(queries,
-					elastic.NewNestedQuery("server_nic", elastic.NewTermQuery("server_nic.ip", value)))
-			} else {
-				queries = append(queries, elastic.NewTermQuery(key, value))
-			}
-		}
-	}
-	queries = append(queries, elastic.NewRangeQuery("event_time").Gte(startTime).Lte(endTime))
+	queries := buildSearchQueries(startTime, endTime, query)
 	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(10*time.Second))
 	defer cancel()
 	queryResult, err := es.ElasticClient.Search(index...).
@@ -268,3 +227,34 @@ func SearchLogs(startTime int64, endTime int64, query map[string]interface{}, so
 	}
 	return total, result, nil
 }
+
+// buildSearchQueries converts the search data of the alarm search api to the es queries
+func buildSearchQueries(startTime int64, endTime int64, query map[string]interface{}) []elastic.Query {
+	queries := make([]elastic.Query, 0, len(query)+1)
+	if query != nil {
+		for key, value := range query {
+			if key == "attack_type" {
+				if v, ok := value.([]interface{}); ok {
+					queries = append(queries, elastic.NewTermsQuery(key, v...))
+				} else {
+					queries = append(queries, elastic.NewTermQuery(key, value))
+				}
+			} else if key == "policy_id" {
+				if v, ok := value.([]interface{}); ok {
+					queries = append(queries, elastic.NewTermsQuery(key, v...))
+				} else {
+					queries = append(queries, elastic.NewTermQuery(key, value))
+				}
+			} else if key == "triage_status" {
+				queries = append(queries, buildTriageStatusQuery(value))
+			} else if key == "local_ip" {
+				queries = append(queries,
+					elastic.NewNestedQuery("server_nic", elastic.NewTermQuery("server_nic.ip", value)))
+			} else {
+				queries = append(queries, elastic.NewTermQuery(key, value))
+			}
+		}
+	}
+	queries = append(queries, elastic.NewRangeQuery("event_time").Gte(startTime).Lte(endTime))
+	return queries
+}
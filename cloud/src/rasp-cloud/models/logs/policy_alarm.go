@@ -18,6 +18,7 @@ import (
 	"fmt"
 	"crypto/md5"
 	"github.com/astaxie/beego"
+	"rasp-cloud/models/logs/ingest"
 )
 
 type RaspLog struct {
@@ -27,75 +28,7 @@ type RaspLog struct {
 var (
 	PolicyIndexName      = "openrasp-policy-alarm"
 	AliasPolicyIndexName = "real-openrasp-policy-alarm"
-	PolicyEsMapping      = `
-	{
-		"mappings": {
-			"policy-alarm": {
-				"_all": {
-					"enabled": false
-				},
-				"properties": {
-					"@timestamp":{
-						"type":"date"
-         			},
-					"event_type": {
-						"type": "keyword",
-						"ignore_above": 256
-					},
-					"server_hostname": {
-						"type": "keyword",
-						"ignore_above": 256
-					},
-					"server_type": {
-						"type": "keyword",
-						"ignore_above": 64
-					},
-					"server_nic": {
-						"type": "nested",
-						"properties": {
-							"name": {
-								"type": "keyword",
-								"ignore_above": 256
-							},
-							"ip": {
-								"type": "keyword",
-								"ignore_above": 256
-							}
-						}
-					},
-					"app_id": {
-						"type": "keyword",
-						"ignore_above": 256
-					},
-					"rasp_id": {
-						"type": "keyword",
-						"ignore_above": 256
-					},
-					"event_time": {
-						"type": "date"
-					},
-					"stack_trace": {
-						"type": "keyword"
-					},
-					"policy_id": {
-						"type": "long"
-					},
-					"message": {
-						"type": "keyword"
-					},
-					"stack_md5": {
-						"type": "keyword",
-						"ignore_above": 64
-					},
-					"policy_params": {
-						"type": "object",
-						"enabled":"false"
-					}
-				}
-			}
-		}
-	}
-`
+	PolicyEsMapping      = ingest.PolicyEsMapping
 )
 
 func AddPolicyAlarm(alarm map[string]interface{}) error {
@@ -104,6 +37,10 @@ func AddPolicyAlarm(alarm map[string]interface{}) error {
 			beego.Error("failed to add policy alarm: ", r)
 		}
 	}()
+	appId, _ := alarm["app_id"].(string)
+	if err := RedactAlarm(PolicyAlarmType, appId, alarm); err != nil {
+		return err
+	}
 	if stack, ok := alarm["stack_trace"]; ok && stack != nil && stack != "" {
 		_, ok = stack.(string)
 		if ok {
@@ -114,7 +51,7 @@ func AddPolicyAlarm(alarm map[string]interface{}) error {
 	idContent += fmt.Sprint(alarm["rasp_id"])
 	idContent += fmt.Sprint(alarm["policy_id"])
 	idContent += fmt.Sprint(alarm["stack_md5"])
-	if alarm["policy_id"] == "3006" && alarm["policy_params"] != nil {
+	if fmt.Sprint(alarm["policy_id"]) == "3006" && alarm["policy_params"] != nil {
 		if policyParam, ok := alarm["policy_params"].(map[string]interface{}); ok && len(policyParam) > 0 {
 			idContent += fmt.Sprint(policyParam["connectionString"])
 			idContent += fmt.Sprint(policyParam["port"])
@@ -125,5 +62,10 @@ func AddPolicyAlarm(alarm map[string]interface{}) error {
 		}
 	}
 	alarm["upsert_id"] = fmt.Sprintf("%x", md5.Sum([]byte(idContent)))
+	// the first_seen is kept by es when the doc of upsert_id exists
+	if timestamp, ok := alarm["@timestamp"].(int64); ok {
+		alarm["first_seen"] = timestamp
+	}
+	incrReceivedAlarm(PolicyAlarmType)
 	return AddAlarmFunc(PolicyAlarmType, alarm)
 }
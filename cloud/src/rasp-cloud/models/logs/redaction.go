@@ -0,0 +1,39 @@
+//Copyright 2017-2018 Baidu Inc.
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http: //www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+
+package logs
+
+import (
+	"github.com/astaxie/beego"
+	"rasp-cloud/models/logs/redaction"
+	"time"
+)
+
+func init() {
+	ttl := beego.AppConfig.DefaultInt("AlarmRedactionCacheTtl", 60)
+	if ttl <= 0 {
+		ttl = 60
+	}
+	redaction.CacheTtl = time.Duration(ttl) * time.Second
+}
+
+// RedactAlarm applies the redaction rules of app to the alarm, the alarm is rejected if the rules
+// can not be loaded, so that the sensitive data never reaches the alarm sinks
+func RedactAlarm(alarmType string, appId string, alarm map[string]interface{}) error {
+	err := redaction.Redact(appId, alarm)
+	if err != nil {
+		incrRejectedAlarm(alarmType)
+	}
+	return err
+}
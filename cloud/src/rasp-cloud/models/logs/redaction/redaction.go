@@ -0,0 +1,314 @@
+//Copyright 2017-2018 Baidu Inc.
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http: //www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+
+package redaction
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/astaxie/beego"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rule is a rule to redact the sensitive data of alarms on ingest, it is configured per app
+//
+//	regex:      the matched text of string fields is redacted, only the first group is redacted if the
+//	            regex has capturing groups
+//	param_name: the values of the parameter in query string, form, json body and the object fields
+//	            like attack_params are redacted, the name is case insensitive
+//	json_path:  the value of the dot separated path from the root of alarm is redacted,
+//	            such as attack_params.query.password, * matches any key or array element
+type Rule struct {
+	Type    string `json:"type" bson:"type"`
+	Pattern string `json:"pattern" bson:"pattern"`
+	Action  string `json:"action" bson:"action"`
+}
+
+const (
+	TypeRegex     = "regex"
+	TypeParamName = "param_name"
+	TypeJsonPath  = "json_path"
+	ActionMask    = "mask"
+	ActionHash    = "hash"
+	Mask          = "******"
+	maxRules      = 100
+	maxPatternLen = 1024
+)
+
+type compiledRule struct {
+	action string
+	// the text patterns for regex and param_name, the value to redact is the first group if it exists
+	patterns  []*regexp.Regexp
+	paramName string
+	path      []string
+}
+
+type cacheItem struct {
+	rules   []*compiledRule
+	hashKey []byte
+	expire  time.Time
+}
+
+var (
+	// RulesLoader loads the redaction rules and the secret hash key of app, it is set by the app model
+	RulesLoader func(appId string) ([]Rule, string, error)
+	// CacheTtl is the time that the loaded rules are cached, it is set by the AlarmRedactionCacheTtl config
+	CacheTtl  = time.Minute
+	cache     = make(map[string]*cacheItem)
+	cacheLock sync.RWMutex
+	// the string fields that regex and param_name rules are applied to
+	redactTextFields = []string{"url", "path", "body", "referer", "plugin_message", "stack_trace", "message"}
+	// the object fields whose keys and string values are redacted recursively
+	redactObjectFields = []string{"attack_params", "policy_params", "header"}
+)
+
+// Validate checks the redaction rules, it returns the error of the first invalid rule
+func Validate(rules []Rule) error {
+	_, err := compileRules(rules)
+	return err
+}
+
+func compileRules(rules []Rule) ([]*compiledRule, error) {
+	if len(rules) > maxRules {
+		return nil, errors.New("the count of redaction rules can not be greater than " +
+			strconv.Itoa(maxRules))
+	}
+	result := make([]*compiledRule, 0, len(rules))
+	for index, rule := range rules {
+		compiled, err := compileRule(rule)
+		if err != nil {
+			return nil, errors.New("invalid redaction rule at index " + strconv.Itoa(index) + ": " + err.Error())
+		}
+		result = append(result, compiled)
+	}
+	return result, nil
+}
+
+func compileRule(rule Rule) (*compiledRule, error) {
+	if rule.Pattern == "" {
+		return nil, errors.New("the pattern can not be empty")
+	}
+	if len(rule.Pattern) > maxPatternLen {
+		return nil, errors.New("the length of pattern can not be greater than " + strconv.Itoa(maxPatternLen))
+	}
+	compiled := &compiledRule{action: rule.Action}
+	switch rule.Action {
+	case ActionMask, ActionHash:
+	case "":
+		compiled.action = ActionMask
+	default:
+		return nil, errors.New("the action must be mask or hash")
+	}
+	switch rule.Type {
+	case TypeRegex:
+		pattern, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		compiled.patterns = []*regexp.Regexp{pattern}
+	case TypeParamName:
+		name := regexp.QuoteMeta(rule.Pattern)
+		compiled.paramName = strings.ToLower(rule.Pattern)
+		compiled.patterns = []*regexp.Regexp{
+			regexp.MustCompile(`(?i)(?:^|[?&;\s])` + name + `=([^&;#\s]*)`),
+			regexp.MustCompile(`(?i)"` + name + `"\s*:\s*"((?:[^"\\]|\\.)*)"`),
+		}
+	case TypeJsonPath:
+		path := strings.Split(strings.TrimPrefix(rule.Pattern, "$."), ".")
+		for _, segment := range path {
+			if segment == "" {
+				return nil, errors.New("the json path can not contain empty segment")
+			}
+		}
+		compiled.path = path
+	default:
+		return nil, errors.New("the type must be regex, param_name or json_path")
+	}
+	return compiled, nil
+}
+
+// Invalidate removes the cached redaction rules of app after the rules are updated
+func Invalidate(appId string) {
+	cacheLock.Lock()
+	delete(cache, appId)
+	cacheLock.Unlock()
+}
+
+func getRules(appId string) (*cacheItem, error) {
+	cacheLock.RLock()
+	item, ok := cache[appId]
+	cacheLock.RUnlock()
+	if ok && time.Now().Before(item.expire) {
+		return item, nil
+	}
+	if RulesLoader == nil {
+		return &cacheItem{}, nil
+	}
+	rules, hashKey, err := RulesLoader(appId)
+	var compiled []*compiledRule
+	if err == nil {
+		compiled, err = compileRules(rules)
+	}
+	if err == nil && hashKey == "" {
+		err = errors.New("the hash key can not be empty")
+	}
+	if err != nil {
+		if ok {
+			// keep the stale rules rather than storing the sensitive data
+			beego.Error("failed to load redaction rules of app " + appId + ", use the cached rules: " + err.Error())
+			return item, nil
+		}
+		return nil, errors.New("failed to load redaction rules: " + err.Error())
+	}
+	item = &cacheItem{rules: compiled, hashKey: []byte(hashKey), expire: time.Now().Add(CacheTtl)}
+	cacheLock.Lock()
+	cache[appId] = item
+	cacheLock.Unlock()
+	return item, nil
+}
+
+// Redact applies the redaction rules of app to the alarm, an error is returned if the rules
+// can not be loaded, so that the alarm can be rejected before the sensitive data reaches the alarm sinks
+func Redact(appId string, alarm map[string]interface{}) error {
+	item, err := getRules(appId)
+	if err != nil {
+		return err
+	}
+	for _, rule := range item.rules {
+		rule.apply(item.hashKey, alarm)
+	}
+	return nil
+}
+
+func (r *compiledRule) apply(hashKey []byte, alarm map[string]interface{}) {
+	if r.path != nil {
+		redactPath(alarm, r.path, func(value interface{}) interface{} {
+			return r.redact(hashKey, value)
+		})
+		return
+	}
+	for _, field := range redactTextFields {
+		if text, ok := alarm[field].(string); ok && text != "" {
+			alarm[field] = r.redactText(hashKey, text)
+		}
+	}
+	for _, field := range redactObjectFields {
+		if value, ok := alarm[field]; ok && value != nil {
+			alarm[field] = r.redactObject(hashKey, value)
+		}
+	}
+}
+
+func (r *compiledRule) redactObject(hashKey []byte, value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			if r.paramName != "" && strings.ToLower(key) == r.paramName {
+				v[key] = r.redact(hashKey, item)
+			} else {
+				v[key] = r.redactObject(hashKey, item)
+			}
+		}
+		return v
+	case []interface{}:
+		for index, item := range v {
+			v[index] = r.redactObject(hashKey, item)
+		}
+		return v
+	case string:
+		return r.redactText(hashKey, v)
+	}
+	return value
+}
+
+func (r *compiledRule) redactText(hashKey []byte, text string) string {
+	for _, pattern := range r.patterns {
+		matches := pattern.FindAllStringSubmatchIndex(text, -1)
+		if len(matches) == 0 {
+			continue
+		}
+		var result strings.Builder
+		last := 0
+		for _, match := range matches {
+			start, end := match[0], match[1]
+			if len(match) >= 4 && match[2] >= 0 {
+				start, end = match[2], match[3]
+			}
+			result.WriteString(text[last:start])
+			result.WriteString(r.redact(hashKey, text[start:end]).(string))
+			last = end
+		}
+		result.WriteString(text[last:])
+		text = result.String()
+	}
+	return text
+}
+
+func (r *compiledRule) redact(hashKey []byte, value interface{}) interface{} {
+	if r.action != ActionHash {
+		return Mask
+	}
+	text, ok := value.(string)
+	if !ok {
+		content, err := json.Marshal(value)
+		if err != nil {
+			return Mask
+		}
+		text = string(content)
+	}
+	// the hash is keyed by the secret key of app, so that the same value can be correlated only in the same app,
+	// and the hashed values can not be guessed by a dictionary
+	mac := hmac.New(sha256.New, hashKey)
+	mac.Write([]byte(text))
+	return fmt.Sprintf("sha256:%x", mac.Sum(nil))
+}
+
+func redactPath(value interface{}, path []string, redact func(interface{}) interface{}) {
+	segment, last := path[0], len(path) == 1
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			if segment != "*" && key != segment {
+				continue
+			}
+			if last {
+				if item != nil {
+					v[key] = redact(item)
+				}
+			} else {
+				redactPath(item, path[1:], redact)
+			}
+		}
+	case []interface{}:
+		for index, item := range v {
+			if segment != "*" && strconv.Itoa(index) != segment {
+				continue
+			}
+			if last {
+				if item != nil {
+					v[index] = redact(item)
+				}
+			} else {
+				redactPath(item, path[1:], redact)
+			}
+		}
+	}
+}
@@ -0,0 +1,110 @@
+//Copyright 2017-2018 Baidu Inc.
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http: //www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+
+package redaction
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func setTestRules(t *testing.T, rules []Rule, hashKey string, err error) func() {
+	oldLoader := RulesLoader
+	RulesLoader = func(appId string) ([]Rule, string, error) {
+		return rules, hashKey, err
+	}
+	Invalidate("test-app")
+	return func() {
+		RulesLoader = oldLoader
+		Invalidate("test-app")
+	}
+}
+
+func TestRedact(t *testing.T) {
+	defer setTestRules(t, []Rule{
+		{Type: TypeParamName, Pattern: "password", Action: ActionHash},
+		{Type: TypeJsonPath, Pattern: "policy_params.username", Action: ActionMask},
+		{Type: TypeRegex, Pattern: `token:(\w+)`},
+	}, "secret-key", nil)()
+	attackAlarm := map[string]interface{}{
+		"app_id":         "test-app",
+		"attack_type":    "sql",
+		"url":            "http://127.0.0.1/login?user=admin&password=123456",
+		"attack_params":  map[string]interface{}{"password": "123456"},
+		"plugin_message": "token:abc is leaked",
+	}
+	policyAlarm := map[string]interface{}{
+		"app_id":        "test-app",
+		"policy_id":     int64(3006),
+		"policy_params": map[string]interface{}{"username": "root"},
+	}
+	for _, alarm := range []map[string]interface{}{attackAlarm, policyAlarm} {
+		if err := Redact("test-app", alarm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mac := hmac.New(sha256.New, []byte("secret-key"))
+	mac.Write([]byte("123456"))
+	hashed := fmt.Sprintf("sha256:%x", mac.Sum(nil))
+	if value := attackAlarm["attack_params"].(map[string]interface{})["password"]; value != hashed {
+		t.Errorf("the password must be hashed with the secret key of app, got %v", value)
+	}
+	if !strings.HasSuffix(attackAlarm["url"].(string), "password="+hashed) {
+		t.Errorf("the password in url must be hashed, got %v", attackAlarm["url"])
+	}
+	if value := attackAlarm["plugin_message"]; value != "token:"+Mask+" is leaked" {
+		t.Errorf("only the group of regex must be masked, got %v", value)
+	}
+	if value := policyAlarm["policy_params"].(map[string]interface{})["username"]; value != Mask {
+		t.Errorf("the username must be masked, got %v", value)
+	}
+}
+
+func TestRedactLoadFailure(t *testing.T) {
+	defer setTestRules(t, nil, "", errors.New("mongodb is down"))()
+	if err := Redact("test-app", map[string]interface{}{"attack_type": "sql"}); err == nil {
+		t.Error("expect the error of loading redaction rules")
+	}
+	// the rules without hash key are not used
+	RulesLoader = func(appId string) ([]Rule, string, error) {
+		return []Rule{{Type: TypeRegex, Pattern: "secret"}}, "", nil
+	}
+	if err := Redact("test-app", map[string]interface{}{"url": "secret"}); err == nil {
+		t.Error("expect the error of empty hash key")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		rule  Rule
+		valid bool
+	}{
+		{Rule{Type: TypeRegex, Pattern: `\d+`}, true},
+		{Rule{Type: TypeRegex, Pattern: `(`}, false},
+		{Rule{Type: TypeJsonPath, Pattern: "$.header.cookie", Action: ActionHash}, true},
+		{Rule{Type: TypeJsonPath, Pattern: "header..cookie"}, false},
+		{Rule{Type: TypeParamName, Pattern: ""}, false},
+		{Rule{Type: TypeParamName, Pattern: "password", Action: "drop"}, false},
+		{Rule{Type: "unknown", Pattern: "password"}, false},
+	}
+	for _, c := range cases {
+		if err := Validate([]Rule{c.rule}); (err == nil) != c.valid {
+			t.Errorf("unexpected result of %+v: %v", c.rule, err)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+//Copyright 2017-2018 Baidu Inc.
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http: //www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+
+package sinks
+
+import (
+	"encoding/json"
+	"errors"
+	"rasp-cloud/kafka"
+	"strconv"
+	"strings"
+)
+
+// KafkaProducer is satisfied by *kafka.Producer, it can be replaced by a broker stand-in in tests
+type KafkaProducer interface {
+	SendMessages(messages []*kafka.Message) (int, error)
+}
+
+// KafkaSink sends every alarm as a json message to the topic of its alarm type
+type KafkaSink struct {
+	producer KafkaProducer
+	// topics maps alarm type to topic, the '%app_id%' in topic is replaced by the app_id of alarm
+	topics       map[string]string
+	partitionKey string
+}
+
+const NameKafka = "kafka"
+
+// NewKafkaSink creates the kafka sink, the alarm field partitionKey is used as the message key if it is not empty
+func NewKafkaSink(producer KafkaProducer, topics map[string]string, partitionKey string) *KafkaSink {
+	return &KafkaSink{producer: producer, topics: topics, partitionKey: partitionKey}
+}
+
+func (sink *KafkaSink) Name() string {
+	return NameKafka
+}
+
+func (sink *KafkaSink) Write(alarmType string, alarms []map[string]interface{}) (int, error) {
+	topic, ok := sink.topics[alarmType]
+	if !ok {
+		return 0, errors.New("unrecognized alarm type: " + alarmType)
+	}
+	messages := make([]*kafka.Message, 0, len(alarms))
+	var skipErr error
+	for _, alarm := range alarms {
+		messageTopic := topic
+		if strings.Contains(topic, "%app_id%") {
+			appId, ok := alarm["app_id"].(string)
+			if !ok || appId == "" {
+				skipErr = errors.New("the app_id of alarm is required by the kafka topic " + topic)
+				continue
+			}
+			messageTopic = strings.Replace(topic, "%app_id%", appId, -1)
+		}
+		value, err := json.Marshal(alarm)
+		if err != nil {
+			skipErr = errors.New("failed to encode alarm for kafka: " + err.Error())
+			continue
+		}
+		message := &kafka.Message{Topic: messageTopic, Value: value}
+		if key, ok := alarm[sink.partitionKey].(string); ok && sink.partitionKey != "" {
+			message.Key = []byte(key)
+		}
+		messages = append(messages, message)
+	}
+	// the skipped alarms are not sent, they are reported as failed with the error
+	var sent int
+	var err error
+	if len(messages) > 0 {
+		sent, err = sink.producer.SendMessages(messages)
+	}
+	if skipped := len(alarms) - len(messages); skipped > 0 && err == nil {
+		err = errors.New(strconv.Itoa(skipped) + " alarms are skipped, the last error: " + skipErr.Error())
+	}
+	return sent, err
+}
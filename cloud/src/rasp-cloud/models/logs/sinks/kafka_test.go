@@ -0,0 +1,100 @@
+//Copyright 2017-2018 Baidu Inc.
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http: //www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+
+package sinks
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"rasp-cloud/kafka"
+	"rasp-cloud/models/logs/ingest"
+	"testing"
+)
+
+type fakeKafkaProducer struct {
+	messages []*kafka.Message
+	failed   int
+}
+
+func (producer *fakeKafkaProducer) SendMessages(messages []*kafka.Message) (int, error) {
+	producer.messages = append(producer.messages, messages...)
+	if producer.failed > 0 {
+		return len(messages) - producer.failed, errors.New("failed to send messages")
+	}
+	return len(messages), nil
+}
+
+func newTestKafkaSink(producer KafkaProducer) *KafkaSink {
+	return NewKafkaSink(producer, map[string]string{
+		ingest.AttackAlarmType: "openrasp-attack-%app_id%",
+		ingest.PolicyAlarmType: "openrasp-policy-alarm",
+	}, "rasp_id")
+}
+
+func TestKafkaSinkWrite(t *testing.T) {
+	producer := &fakeKafkaProducer{}
+	sent, err := newTestKafkaSink(producer).Write(ingest.AttackAlarmType, []map[string]interface{}{
+		{"app_id": "app1", "rasp_id": "rasp1", "attack_type": "sql"},
+		{"app_id": "app2", "attack_type": "xss"},
+	})
+	if err != nil || sent != 2 {
+		t.Fatalf("expect 2 alarms to be sent, got %d, %v", sent, err)
+	}
+	if producer.messages[0].Topic != "openrasp-attack-app1" || producer.messages[1].Topic != "openrasp-attack-app2" {
+		t.Errorf("unexpected topics: %s, %s", producer.messages[0].Topic, producer.messages[1].Topic)
+	}
+	if string(producer.messages[0].Key) != "rasp1" || producer.messages[1].Key != nil {
+		t.Errorf("unexpected partition keys: %q, %q", producer.messages[0].Key, producer.messages[1].Key)
+	}
+	var alarm map[string]interface{}
+	if err := json.Unmarshal(producer.messages[0].Value, &alarm); err != nil || alarm["attack_type"] != "sql" {
+		t.Errorf("unexpected message value: %s", producer.messages[0].Value)
+	}
+}
+
+func TestKafkaSinkSkippedAlarms(t *testing.T) {
+	producer := &fakeKafkaProducer{}
+	sink := newTestKafkaSink(producer)
+	sent, err := sink.Write(ingest.AttackAlarmType, []map[string]interface{}{
+		{"attack_type": "sql"},
+		{"app_id": "app1", "plugin_confidence": math.Inf(1)},
+		{"app_id": "app1", "attack_type": "xss"},
+	})
+	if err == nil || sent != 1 || len(producer.messages) != 1 {
+		t.Fatalf("expect the alarms without app_id or failed to encode to be failed, got %d, %v", sent, err)
+	}
+	if producer.messages[0].Topic != "openrasp-attack-app1" {
+		t.Errorf("unexpected topic: %s", producer.messages[0].Topic)
+	}
+	// the app_id is not required if the topic does not contain it
+	sent, err = sink.Write(ingest.PolicyAlarmType, []map[string]interface{}{{"policy_id": "3006"}})
+	if err != nil || sent != 1 || producer.messages[1].Topic != "openrasp-policy-alarm" {
+		t.Errorf("expect the policy alarm to be sent, got %d, %v", sent, err)
+	}
+	if sent, err = sink.Write("unknown", []map[string]interface{}{{}}); err == nil || sent != 0 {
+		t.Errorf("expect the error of unrecognized alarm type, got %d, %v", sent, err)
+	}
+}
+
+func TestKafkaSinkSendFailure(t *testing.T) {
+	producer := &fakeKafkaProducer{failed: 1}
+	sent, err := newTestKafkaSink(producer).Write(ingest.AttackAlarmType, []map[string]interface{}{
+		{"app_id": "app1", "attack_type": "sql"},
+		{"app_id": "app1", "attack_type": "xss"},
+	})
+	if err == nil || sent != 1 {
+		t.Errorf("expect 1 alarm to be sent with the error of producer, got %d, %v", sent, err)
+	}
+}
@@ -0,0 +1,362 @@
+//Copyright 2017-2018 Baidu Inc.
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http: //www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+
+package sinks
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"rasp-cloud/models/logs/ingest"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SyslogConfig is the config of syslog sink, see the Syslog* items in app.conf
+type SyslogConfig struct {
+	// Network is udp, tcp or tls
+	Network   string
+	Addr      string
+	TlsConfig *tls.Config
+	Format    string
+	Facility  int
+	Tag       string
+	// FieldMapping is like 'attack_source:src,url:request', the default mapping of format is used if it is empty
+	FieldMapping string
+	// Version is the product version in the cef and leef header
+	Version string
+}
+
+// SyslogSink forwards alarms over udp, tcp or tls syslog (RFC 5424),
+// the message body can be RFC 5424 structured data, ArcSight CEF or IBM LEEF
+type SyslogSink struct {
+	network   string
+	addr      string
+	tlsConfig *tls.Config
+	format    string
+	facility  int
+	tag       string
+	version   string
+	// fieldMapping maps the alarm field to the output key
+	fieldMapping map[string]string
+	fieldOrder   []string
+	hostname     string
+	mutex        sync.Mutex
+	conn         net.Conn
+}
+
+const (
+	NameSyslog = "syslog"
+
+	SyslogFormatRfc5424 = "rfc5424"
+	SyslogFormatCef     = "cef"
+	SyslogFormatLeef    = "leef"
+
+	syslogSdId = "openrasp@32473"
+)
+
+var (
+	defaultSyslogFieldMappings = map[string]string{
+		SyslogFormatRfc5424: "app_id:app_id,rasp_id:rasp_id,attack_type:attack_type,attack_source:attack_source," +
+			"client_ip:client_ip,server_ip:server_ip,server_hostname:server_hostname,url:url," +
+			"request_method:request_method,intercept_state:intercept_state,plugin_confidence:plugin_confidence," +
+			"plugin_algorithm:plugin_algorithm,request_id:request_id,stack_md5:stack_md5,policy_id:policy_id",
+		SyslogFormatCef: "attack_source:src,server_ip:dst,server_hostname:dhost,url:request," +
+			"request_method:requestMethod,user_agent:requestClientApplication,referer:requestContext," +
+			"attack_type:cat,intercept_state:act,request_id:externalId,plugin_message:msg,message:msg," +
+			"app_id:cs1,rasp_id:cs2,plugin_algorithm:cs3,stack_md5:cs4,policy_id:cs5,plugin_confidence:cn1",
+		SyslogFormatLeef: "attack_source:src,server_ip:dst,attack_type:cat,url:url,request_method:request_method," +
+			"user_agent:user_agent,server_hostname:server_hostname,app_id:app_id,rasp_id:rasp_id," +
+			"intercept_state:intercept_state,plugin_confidence:plugin_confidence,plugin_message:plugin_message," +
+			"policy_id:policy_id,message:message",
+	}
+	cefLabelKeyRegex    = regexp.MustCompile(`^(cs|cn|cfp|c6a|flexString|flexNumber|flexDate)\d$`)
+	sdParamNameRegex    = regexp.MustCompile(`^[\x21-\x7e]{1,32}$`)
+	cefHeaderEscaper    = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\r\n", " ", "\n", " ", "\r", " ")
+	cefExtensionEscaper = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\r\n", `\n`, "\n", `\n`, "\r", `\r`)
+	leefValueEscaper    = strings.NewReplacer("\t", " ", "\r", " ", "\n", " ")
+	sdValueEscaper      = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+)
+
+// NewSyslogSink checks the config and creates the syslog sink, the connection is opened on the first write
+func NewSyslogSink(config *SyslogConfig) (*SyslogSink, error) {
+	sink := &SyslogSink{
+		network:   config.Network,
+		addr:      config.Addr,
+		tlsConfig: config.TlsConfig,
+		format:    config.Format,
+		facility:  config.Facility,
+		tag:       config.Tag,
+		version:   config.Version,
+	}
+	if sink.network != "udp" && sink.network != "tcp" && sink.network != "tls" {
+		return nil, errors.New("unsupported syslog network: " + sink.network)
+	}
+	if sink.facility < 0 || sink.facility > 23 {
+		return nil, errors.New("the 'SyslogFacility' config must be between [0,23]")
+	}
+	if sink.tag == "" || len(sink.tag) > 48 || sink.tag != syslogHeaderValue(sink.tag) {
+		return nil, errors.New("the 'SyslogTag' config must be 1 to 48 printable ascii characters without space")
+	}
+	defaultMapping, ok := defaultSyslogFieldMappings[sink.format]
+	if !ok {
+		return nil, errors.New("the 'SyslogFormat' config must be rfc5424, cef or leef")
+	}
+	if config.FieldMapping == "" {
+		config.FieldMapping = defaultMapping
+	}
+	var err error
+	sink.fieldMapping, sink.fieldOrder, err = parseSyslogFieldMapping(config.FieldMapping, sink.format)
+	if err != nil {
+		return nil, err
+	}
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		sink.hostname = syslogHeaderValue(hostname)
+	} else {
+		sink.hostname = "-"
+	}
+	return sink, nil
+}
+
+// parseSyslogFieldMapping parses the mapping like 'attack_source:src,url:request',
+// every field must be a property of the attack or policy alarm es mapping
+func parseSyslogFieldMapping(config string, format string) (map[string]string, []string, error) {
+	knownFields := make(map[string]bool)
+	for _, alarmType := range []string{ingest.AttackAlarmType, ingest.PolicyAlarmType} {
+		for field := range ingest.Fields(alarmType) {
+			knownFields[field] = true
+		}
+	}
+	mapping := make(map[string]string)
+	order := make([]string, 0)
+	for _, item := range strings.Split(config, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		parts := strings.SplitN(item, ":", 2)
+		field := strings.TrimSpace(parts[0])
+		key := field
+		if len(parts) == 2 {
+			key = strings.TrimSpace(parts[1])
+		}
+		if !knownFields[field] {
+			return nil, nil, errors.New("the field in 'SyslogFieldMapping' config is not an alarm field: " + field)
+		}
+		if !sdParamNameRegex.MatchString(key) || strings.ContainsAny(key, `= ]"`) {
+			return nil, nil, errors.New("invalid output key in 'SyslogFieldMapping' config: " + key)
+		}
+		if _, ok := mapping[field]; !ok {
+			order = append(order, field)
+		}
+		mapping[field] = key
+	}
+	if len(mapping) == 0 {
+		return nil, nil, errors.New("the 'SyslogFieldMapping' config can not be empty for format: " + format)
+	}
+	return mapping, order, nil
+}
+
+func (sink *SyslogSink) Name() string {
+	return NameSyslog
+}
+
+func (sink *SyslogSink) Write(alarmType string, alarms []map[string]interface{}) (count int, err error) {
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+	for _, alarm := range alarms {
+		message := sink.formatMessage(alarmType, alarm)
+		if err = sink.send(message); err != nil {
+			// reconnect once, the server may have closed the idle connection
+			sink.closeConn()
+			if err = sink.send(message); err != nil {
+				sink.closeConn()
+				return count, err
+			}
+		}
+		count++
+	}
+	return count, nil
+}
+
+func (sink *SyslogSink) send(message string) error {
+	if sink.conn == nil {
+		dialer := &net.Dialer{Timeout: 10 * time.Second}
+		var err error
+		if sink.network == "tls" {
+			sink.conn, err = tls.DialWithDialer(dialer, "tcp", sink.addr, sink.tlsConfig)
+		} else {
+			sink.conn, err = dialer.Dial(sink.network, sink.addr)
+		}
+		if err != nil {
+			sink.conn = nil
+			return err
+		}
+	}
+	if sink.network != "udp" {
+		// octet counting framing of RFC 6587 and RFC 5425
+		message = strconv.Itoa(len(message)) + " " + message
+	}
+	sink.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	_, err := sink.conn.Write([]byte(message))
+	return err
+}
+
+func (sink *SyslogSink) closeConn() {
+	if sink.conn != nil {
+		sink.conn.Close()
+		sink.conn = nil
+	}
+}
+
+// formatMessage builds the RFC 5424 message, the cef and leef events are carried as the msg part,
+// the timestamp is the event_time of alarm, so that the delayed alarms keep the time they happened
+func (sink *SyslogSink) formatMessage(alarmType string, alarm map[string]interface{}) string {
+	severity := getSyslogSeverity(alarmType, alarm)
+	hostname := sink.hostname
+	if serverHostname, ok := alarm["server_hostname"].(string); ok && serverHostname != "" {
+		hostname = syslogHeaderValue(serverHostname)
+	}
+	if len(hostname) > 255 {
+		hostname = hostname[:255]
+	}
+	eventTime, ok := ingest.ParseDate(alarm["event_time"])
+	if !ok {
+		eventTime = time.Now()
+	}
+	header := "<" + strconv.Itoa(sink.facility*8+severity) + ">1 " +
+		eventTime.Format("2006-01-02T15:04:05.000Z07:00") + " " + hostname + " " + sink.tag + " - " +
+		alarmType + " "
+	switch sink.format {
+	case SyslogFormatCef:
+		return header + "- " + sink.formatCef(alarmType, alarm, severity)
+	case SyslogFormatLeef:
+		return header + "- " + sink.formatLeef(alarmType, alarm, severity)
+	default:
+		return header + sink.formatStructuredData(alarm) + " " + syslogAlarmValue(getAlarmMessage(alarm))
+	}
+}
+
+func (sink *SyslogSink) formatStructuredData(alarm map[string]interface{}) string {
+	params := make([]string, 0, len(sink.fieldOrder))
+	for _, field := range sink.fieldOrder {
+		if value, ok := alarm[field]; ok && value != nil {
+			params = append(params, sink.fieldMapping[field]+`="`+sdValueEscaper.Replace(syslogAlarmValue(value))+`"`)
+		}
+	}
+	if len(params) == 0 {
+		return "-"
+	}
+	return "[" + syslogSdId + " " + strings.Join(params, " ") + "]"
+}
+
+func (sink *SyslogSink) formatCef(alarmType string, alarm map[string]interface{}, severity int) string {
+	extensions := make([]string, 0, len(sink.fieldOrder))
+	for _, field := range sink.fieldOrder {
+		if value, ok := alarm[field]; ok && value != nil {
+			key := sink.fieldMapping[field]
+			extensions = append(extensions, key+"="+cefExtensionEscaper.Replace(syslogAlarmValue(value)))
+			if cefLabelKeyRegex.MatchString(key) {
+				extensions = append(extensions, key+"Label="+cefExtensionEscaper.Replace(field))
+			}
+		}
+	}
+	return "CEF:0|Baidu|OpenRASP|" + cefHeaderEscaper.Replace(sink.version) + "|" +
+		cefHeaderEscaper.Replace(getAlarmSignature(alarmType, alarm)) + "|" +
+		cefHeaderEscaper.Replace(getAlarmMessage(alarm)) + "|" + strconv.Itoa(getCefSeverity(severity)) + "|" +
+		strings.Join(extensions, " ")
+}
+
+func (sink *SyslogSink) formatLeef(alarmType string, alarm map[string]interface{}, severity int) string {
+	attributes := []string{"sev=" + strconv.Itoa(getCefSeverity(severity))}
+	for _, field := range sink.fieldOrder {
+		if value, ok := alarm[field]; ok && value != nil {
+			attributes = append(attributes, sink.fieldMapping[field]+"="+leefValueEscaper.Replace(syslogAlarmValue(value)))
+		}
+	}
+	return "LEEF:1.0|Baidu|OpenRASP|" + cefHeaderEscaper.Replace(sink.version) + "|" +
+		cefHeaderEscaper.Replace(getAlarmSignature(alarmType, alarm)) + "|" + strings.Join(attributes, "\t")
+}
+
+// getSyslogSeverity maps the blocked attack to critical, other attacks to warning and policy alarms to notice
+func getSyslogSeverity(alarmType string, alarm map[string]interface{}) int {
+	if alarmType == ingest.AttackAlarmType {
+		if alarm["intercept_state"] == "block" {
+			return 2
+		}
+		return 4
+	}
+	return 5
+}
+
+func getCefSeverity(syslogSeverity int) int {
+	switch syslogSeverity {
+	case 2:
+		return 9
+	case 4:
+		return 6
+	default:
+		return 3
+	}
+}
+
+func getAlarmSignature(alarmType string, alarm map[string]interface{}) string {
+	if alarmType == ingest.AttackAlarmType {
+		return fmt.Sprint(alarm["attack_type"])
+	}
+	return fmt.Sprint(alarm["policy_id"])
+}
+
+func getAlarmMessage(alarm map[string]interface{}) string {
+	if message, ok := alarm["plugin_message"].(string); ok && message != "" {
+		return message
+	}
+	if message, ok := alarm["message"].(string); ok && message != "" {
+		return message
+	}
+	return "OpenRASP alarm"
+}
+
+// syslogHeaderValue replaces the characters that are not allowed in the syslog header fields with '_'
+func syslogHeaderValue(value string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x21 || r > 0x7e {
+			return '_'
+		}
+		return r
+	}, value)
+}
+
+func syslogAlarmValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case map[string]interface{}, []interface{}:
+		content, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(content)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
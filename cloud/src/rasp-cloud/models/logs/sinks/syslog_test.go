@@ -0,0 +1,165 @@
+//Copyright 2017-2018 Baidu Inc.
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http: //www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+
+package sinks
+
+import (
+	"rasp-cloud/models/logs/ingest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestSyslogSink(t *testing.T, format string, fieldMapping string) *SyslogSink {
+	sink, err := NewSyslogSink(&SyslogConfig{
+		Network:      "udp",
+		Addr:         "127.0.0.1:514",
+		Format:       format,
+		Facility:     1,
+		Tag:          "OpenRASP",
+		FieldMapping: fieldMapping,
+		Version:      "1.0",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink.hostname = "panel"
+	return sink
+}
+
+func TestSyslogFormatMessage(t *testing.T) {
+	attackAlarm := map[string]interface{}{
+		"event_time":        "2018-08-10T16:57:03+0800",
+		"server_hostname":   "host 1\n",
+		"attack_type":       "sql|inject",
+		"intercept_state":   "block",
+		"url":               `http://a/b?x=1|2&y="3"]\z` + "\nnext",
+		"plugin_message":    `SQL|injection\ = "x"` + "\nline2",
+		"plugin_confidence": float64(90),
+	}
+	policyTime := time.Unix(1533891423, 0)
+	policyAlarm := map[string]interface{}{
+		"event_time": float64(1533891423000),
+		"policy_id":  "3006",
+		"message":    "weak\tpassword",
+		"rasp_id":    "rasp\t1",
+	}
+	const attackHeader = "<10>1 2018-08-10T16:57:03.000+08:00 host_1_ OpenRASP - attack-alarm "
+	policyHeader := "<13>1 " + policyTime.Format("2006-01-02T15:04:05.000Z07:00") + " panel OpenRASP - policy-alarm "
+	cases := []struct {
+		name         string
+		format       string
+		fieldMapping string
+		alarmType    string
+		alarm        map[string]interface{}
+		expected     string
+	}{
+		{
+			name:         "rfc5424 structured data",
+			format:       SyslogFormatRfc5424,
+			fieldMapping: "url:url,plugin_confidence:confidence,policy_id:policy_id",
+			alarmType:    ingest.AttackAlarmType,
+			alarm:        attackAlarm,
+			expected: attackHeader + `[openrasp@32473 url="http://a/b?x=1|2&y=\"3\"\]\\z` + "\nnext" +
+				`" confidence="90"] SQL|injection\ = "x"` + "\nline2",
+		},
+		{
+			name:         "rfc5424 without structured data",
+			format:       SyslogFormatRfc5424,
+			fieldMapping: "url:url",
+			alarmType:    ingest.PolicyAlarmType,
+			alarm:        policyAlarm,
+			expected:     policyHeader + "- weak\tpassword",
+		},
+		{
+			name:         "cef",
+			format:       SyslogFormatCef,
+			fieldMapping: "url:request,plugin_confidence:cn1",
+			alarmType:    ingest.AttackAlarmType,
+			alarm:        attackAlarm,
+			expected: attackHeader + `- CEF:0|Baidu|OpenRASP|1.0|sql\|inject|SQL\|injection\\ = "x" line2|9|` +
+				`request=http://a/b?x\=1|2&y\="3"]\\z\nnext cn1=90 cn1Label=plugin_confidence`,
+		},
+		{
+			name:         "cef policy alarm",
+			format:       SyslogFormatCef,
+			fieldMapping: "rasp_id:cs2,message:msg",
+			alarmType:    ingest.PolicyAlarmType,
+			alarm:        policyAlarm,
+			expected: policyHeader + "- CEF:0|Baidu|OpenRASP|1.0|3006|weak\tpassword|3|" +
+				"cs2=rasp\t1 cs2Label=rasp_id msg=weak\tpassword",
+		},
+		{
+			name:         "leef",
+			format:       SyslogFormatLeef,
+			fieldMapping: "url:url,plugin_confidence:confidence",
+			alarmType:    ingest.AttackAlarmType,
+			alarm:        attackAlarm,
+			expected: attackHeader + "- LEEF:1.0|Baidu|OpenRASP|1.0|sql\\|inject|sev=9\t" +
+				`url=http://a/b?x=1|2&y="3"]\z next` + "\tconfidence=90",
+		},
+		{
+			name:         "leef policy alarm",
+			format:       SyslogFormatLeef,
+			fieldMapping: "rasp_id:rasp_id,message:message",
+			alarmType:    ingest.PolicyAlarmType,
+			alarm:        policyAlarm,
+			expected:     policyHeader + "- LEEF:1.0|Baidu|OpenRASP|1.0|3006|sev=3\trasp_id=rasp 1\tmessage=weak password",
+		},
+	}
+	for _, c := range cases {
+		message := newTestSyslogSink(t, c.format, c.fieldMapping).formatMessage(c.alarmType, c.alarm)
+		if message != c.expected {
+			t.Errorf("%s: unexpected message\n got: %q\nwant: %q", c.name, message, c.expected)
+		}
+	}
+}
+
+func TestSyslogMessageTime(t *testing.T) {
+	sink := newTestSyslogSink(t, SyslogFormatRfc5424, "")
+	for _, eventTime := range []interface{}{nil, "invalid"} {
+		start := time.Now().Add(-time.Second)
+		message := sink.formatMessage(ingest.AttackAlarmType, map[string]interface{}{"event_time": eventTime})
+		fields := strings.Split(message, " ")
+		messageTime, err := time.Parse("2006-01-02T15:04:05.000Z07:00", fields[1])
+		if err != nil || messageTime.Before(start) {
+			t.Errorf("the alarm without valid event_time must be sent with the current time, got %s", message)
+		}
+	}
+}
+
+func TestNewSyslogSink(t *testing.T) {
+	cases := []struct {
+		name   string
+		config SyslogConfig
+	}{
+		{"network", SyslogConfig{Network: "http", Format: SyslogFormatCef, Tag: "OpenRASP"}},
+		{"facility", SyslogConfig{Network: "udp", Format: SyslogFormatCef, Tag: "OpenRASP", Facility: 24}},
+		{"tag", SyslogConfig{Network: "udp", Format: SyslogFormatCef, Tag: "Open RASP"}},
+		{"format", SyslogConfig{Network: "udp", Format: "json", Tag: "OpenRASP"}},
+		{"unknown field", SyslogConfig{Network: "udp", Format: SyslogFormatCef, Tag: "OpenRASP",
+			FieldMapping: "password:cs1"}},
+		{"invalid key", SyslogConfig{Network: "udp", Format: SyslogFormatCef, Tag: "OpenRASP",
+			FieldMapping: "url:re=quest"}},
+	}
+	for _, c := range cases {
+		if _, err := NewSyslogSink(&c.config); err == nil {
+			t.Errorf("expect the error of invalid %s", c.name)
+		}
+	}
+	// every default field mapping only contains the alarm fields
+	for _, format := range []string{SyslogFormatRfc5424, SyslogFormatCef, SyslogFormatLeef} {
+		newTestSyslogSink(t, format, "")
+	}
+}
@@ -0,0 +1,60 @@
+//Copyright 2017-2018 Baidu Inc.
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http: //www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+
+package logs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"github.com/astaxie/beego"
+	"io/ioutil"
+	"net/url"
+	"rasp-cloud/environment"
+	"rasp-cloud/models/logs/sinks"
+)
+
+const AlarmSinkSyslog = sinks.NameSyslog
+
+func newSyslogAlarmSink() (AlarmSink, error) {
+	syslogUrl := beego.AppConfig.DefaultString("SyslogUrl", "")
+	parsedUrl, err := url.Parse(syslogUrl)
+	if err != nil || parsedUrl.Host == "" {
+		return nil, errors.New("the 'SyslogUrl' config must be like udp://host:port, tcp://host:port or tls://host:port")
+	}
+	config := &sinks.SyslogConfig{
+		Network:      parsedUrl.Scheme,
+		Addr:         parsedUrl.Host,
+		Format:       beego.AppConfig.DefaultString("SyslogFormat", sinks.SyslogFormatRfc5424),
+		Facility:     beego.AppConfig.DefaultInt("SyslogFacility", 1),
+		Tag:          beego.AppConfig.DefaultString("SyslogTag", "OpenRASP"),
+		FieldMapping: beego.AppConfig.DefaultString("SyslogFieldMapping", ""),
+		Version:      environment.Version,
+	}
+	if config.Network == "tls" {
+		config.TlsConfig = &tls.Config{InsecureSkipVerify: beego.AppConfig.DefaultBool("SyslogTlsSkipVerify", false)}
+		if caFile := beego.AppConfig.DefaultString("SyslogTlsCaFile", ""); caFile != "" {
+			content, err := ioutil.ReadFile(caFile)
+			if err != nil {
+				return nil, errors.New("failed to read syslog tls ca file: " + err.Error())
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(content) {
+				return nil, errors.New("failed to parse syslog tls ca file: " + caFile)
+			}
+			config.TlsConfig.RootCAs = pool
+		}
+	}
+	return sinks.NewSyslogSink(config)
+}
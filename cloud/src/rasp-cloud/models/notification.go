@@ -0,0 +1,331 @@
+//Copyright 2017-2018 Baidu Inc.
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http: //www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+
+package models
+
+import (
+	"errors"
+	"fmt"
+	"github.com/astaxie/beego"
+	"path"
+	"rasp-cloud/models/logs"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// NotificationRule routes the matched attack alarms of app to the channels of actions,
+// the alarms are pushed to all of the enabled channels if the app has no rule
+type NotificationRule struct {
+	Name      string                `json:"name" bson:"name"`
+	Enable    bool                  `json:"enable" bson:"enable"`
+	Condition NotificationCondition `json:"condition" bson:"condition"`
+	Actions   []NotificationAction  `json:"actions" bson:"actions"`
+}
+
+// NotificationCondition matches an alarm if all of the non-empty conditions are met
+type NotificationCondition struct {
+	AttackType     []string `json:"attack_type" bson:"attack_type"`
+	InterceptState []string `json:"intercept_state" bson:"intercept_state"`
+	MinConfidence  int      `json:"min_confidence" bson:"min_confidence"`
+	// the glob patterns of server_hostname, such as prod-*
+	Hostname   []string `json:"hostname" bson:"hostname"`
+	UrlPattern string   `json:"url_pattern" bson:"url_pattern"`
+}
+
+// NotificationAction pushes the matched alarms to a channel, the recipients override the receivers of
+// the channel config if they are not empty, the alarms in the throttle seconds are merged to the next push
+type NotificationAction struct {
+	Channel    string   `json:"channel" bson:"channel"`
+	Recipients []string `json:"recipients" bson:"recipients"`
+	Throttle   int64    `json:"throttle" bson:"throttle"`
+}
+
+type compiledNotificationRule struct {
+	*NotificationRule
+	urlPattern *regexp.Regexp
+}
+
+type notificationThrottle struct {
+	lastPushTime int64
+	pending      int64
+}
+
+const (
+	NotificationChannelEmail = "email"
+	NotificationChannelDing  = "ding"
+	NotificationChannelHttp  = "http"
+	NotificationChannelChat  = "chat"
+	maxNotificationRules     = 50
+	maxNotificationAlarms    = 10
+	// maxNotificationWindow is the default max_result_window of es, the alarms behind it can not be paged
+	maxNotificationWindow = 10000
+)
+
+var (
+	// notificationFetchSize is the max count of alarms of an app matched with the rules in an alarm check
+	notificationFetchSize     = 500
+	notificationThrottles     = make(map[string]*notificationThrottle)
+	notificationThrottlesLock sync.Mutex
+)
+
+func init() {
+	if size := beego.AppConfig.DefaultInt("AlarmNotifyFetchSize", 500); size > 0 {
+		notificationFetchSize = size
+	}
+}
+
+func ValidateNotificationRules(rules []NotificationRule) error {
+	if len(rules) > maxNotificationRules {
+		return errors.New("the count of notification rules can not be greater than " +
+			strconv.Itoa(maxNotificationRules))
+	}
+	names := make(map[string]bool)
+	for _, rule := range rules {
+		if rule.Name == "" || len(rule.Name) > 128 {
+			return errors.New("the length of notification rule name must be between [1,128]")
+		}
+		if names[rule.Name] {
+			return errors.New("duplicate notification rule name: " + rule.Name)
+		}
+		names[rule.Name] = true
+		if _, err := compileNotificationRule(&rule); err != nil {
+			return errors.New("invalid notification rule " + rule.Name + ": " + err.Error())
+		}
+		if len(rule.Actions) == 0 {
+			return errors.New("the actions of notification rule " + rule.Name + " can not be empty")
+		}
+		for _, action := range rule.Actions {
+			switch action.Channel {
+			case NotificationChannelEmail, NotificationChannelDing, NotificationChannelHttp, NotificationChannelChat:
+			default:
+				return errors.New("unsupported notification channel: " + action.Channel)
+			}
+			if action.Throttle < 0 {
+				return errors.New("the throttle of notification action can not be less than 0")
+			}
+			if len(action.Recipients) > 128 {
+				return errors.New("the count of notification recipients can not be greater than 128")
+			}
+		}
+	}
+	return nil
+}
+
+func compileNotificationRule(rule *NotificationRule) (*compiledNotificationRule, error) {
+	compiled := &compiledNotificationRule{NotificationRule: rule}
+	if rule.Condition.MinConfidence < 0 || rule.Condition.MinConfidence > 100 {
+		return nil, errors.New("the min_confidence must be between [0,100]")
+	}
+	for _, pattern := range rule.Condition.Hostname {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, errors.New("invalid hostname pattern: " + pattern)
+		}
+	}
+	if rule.Condition.UrlPattern != "" {
+		urlPattern, err := regexp.Compile(rule.Condition.UrlPattern)
+		if err != nil {
+			return nil, errors.New("invalid url pattern: " + err.Error())
+		}
+		compiled.urlPattern = urlPattern
+	}
+	return compiled, nil
+}
+
+func (rule *compiledNotificationRule) match(alarm map[string]interface{}) bool {
+	condition := rule.Condition
+	if len(condition.AttackType) > 0 && !containsString(condition.AttackType, fmt.Sprint(alarm["attack_type"])) {
+		return false
+	}
+	if len(condition.InterceptState) > 0 &&
+		!containsString(condition.InterceptState, fmt.Sprint(alarm["intercept_state"])) {
+		return false
+	}
+	if condition.MinConfidence > 0 {
+		confidence, ok := alarm["plugin_confidence"].(float64)
+		if !ok || confidence < float64(condition.MinConfidence) {
+			return false
+		}
+	}
+	if len(condition.Hostname) > 0 {
+		hostname, _ := alarm["server_hostname"].(string)
+		matched := false
+		for _, pattern := range condition.Hostname {
+			if ok, _ := path.Match(pattern, hostname); ok {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if rule.urlPattern != nil {
+		url, _ := alarm["url"].(string)
+		if !rule.urlPattern.MatchString(url) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsString(items []string, value string) bool {
+	for _, item := range items {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// routeAttackAlarm pushes the alarms with the notification rules of app,
+// the alarms are sorted by event_time in descending order
+func routeAttackAlarm(app *App, total int64, alarms []map[string]interface{}) {
+	if len(app.NotificationRules) == 0 {
+		PushAttackAlarm(app, total, limitAlarms(alarms), false)
+		return
+	}
+	for index := range app.NotificationRules {
+		rule, err := compileNotificationRule(&app.NotificationRules[index])
+		if err != nil {
+			beego.Error("invalid notification rule " + app.NotificationRules[index].Name + " of app " +
+				app.Id + ": " + err.Error())
+			continue
+		}
+		if !rule.Enable {
+			continue
+		}
+		matched := make([]map[string]interface{}, 0)
+		for _, alarm := range alarms {
+			if rule.match(alarm) {
+				matched = append(matched, alarm)
+			}
+		}
+		if len(matched) == 0 {
+			continue
+		}
+		for actionIndex, action := range rule.Actions {
+			throttleKey := app.Id + "|" + rule.Name + "|" + strconv.Itoa(actionIndex)
+			count, ok := checkNotificationThrottle(throttleKey, action.Throttle, int64(len(matched)))
+			if ok {
+				pushNotificationAction(app, &action, count, limitAlarms(matched))
+			}
+		}
+	}
+}
+
+// checkNotificationThrottle returns the count of alarms to push and whether the action can push now,
+// the alarms in the throttle duration are accumulated to the next push
+func checkNotificationThrottle(key string, throttle int64, count int64) (int64, bool) {
+	notificationThrottlesLock.Lock()
+	defer notificationThrottlesLock.Unlock()
+	state, ok := notificationThrottles[key]
+	if !ok {
+		state = &notificationThrottle{}
+		notificationThrottles[key] = state
+	}
+	now := time.Now().Unix()
+	if throttle > 0 && now-state.lastPushTime < throttle {
+		state.pending += count
+		return 0, false
+	}
+	count += state.pending
+	state.pending = 0
+	state.lastPushTime = now
+	return count, true
+}
+
+func pushNotificationAction(app *App, action *NotificationAction, total int64, alarms []map[string]interface{}) {
+	switch action.Channel {
+	case NotificationChannelEmail:
+		if !app.EmailAlarmConf.Enable {
+			return
+		}
+	case NotificationChannelDing:
+		if !app.DingAlarmConf.Enable {
+			return
+		}
+	case NotificationChannelHttp:
+		if !app.HttpAlarmConf.Enable {
+			return
+		}
+	case NotificationChannelChat:
+		if !app.ChatAlarmConf.Enable {
+			return
+		}
+	}
+	queueAttackAlarm(app, action.Channel, action.Recipients, total, alarms, false)
+}
+
+// fetchNotificationAlarms pages through the attack alarms of app between startTime and endTime in the order
+// of event_time, the returned watermark is where the next check starts, it stops at the event time of
+// the last returned alarm if the alarms can not be paged to the end
+func fetchNotificationAlarms(appId string, startTime int64, endTime int64) (
+	alarms []map[string]interface{}, watermark int64, err error) {
+	alarms = make([]map[string]interface{}, 0)
+	for page := 1; ; page++ {
+		total, result, err := logs.SearchLogs(startTime, endTime, nil, "event_time",
+			page, notificationFetchSize, true, logs.AliasAttackIndexName+"-"+appId)
+		if err != nil {
+			return nil, 0, err
+		}
+		alarms = append(alarms, result...)
+		if int64(len(alarms)) >= total || len(result) == 0 {
+			return alarms, endTime + 1, nil
+		}
+		if (page+1)*notificationFetchSize > maxNotificationWindow {
+			break
+		}
+	}
+	// the alarms at the last event time are checked again from the watermark, as some of them are not returned
+	lastTime, ok := parseAlarmTime(alarms[len(alarms)-1]["event_time"])
+	if ok {
+		count := len(alarms)
+		for count > 0 {
+			if eventTime, ok := parseAlarmTime(alarms[count-1]["event_time"]); !ok || eventTime != lastTime {
+				break
+			}
+			count--
+		}
+		if count > 0 {
+			return alarms[:count], lastTime, nil
+		}
+	}
+	beego.Warn("too many attack alarms of app " + appId + " to be checked, some of them are skipped")
+	return alarms, endTime + 1, nil
+}
+
+// parseAlarmTime returns the milliseconds of event_time, which is a date string or a timestamp of alarm
+func parseAlarmTime(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return int64(v), true
+	case string:
+		for _, layout := range []string{"2006-01-02T15:04:05-0700", "2006-01-02T15:04:05.000-0700",
+			time.RFC3339Nano, "2006-01-02 15:04:05"} {
+			if t, err := time.Parse(layout, v); err == nil {
+				return t.UnixNano() / 1000000, true
+			}
+		}
+	}
+	return 0, false
+}
+
+func limitAlarms(alarms []map[string]interface{}) []map[string]interface{} {
+	if len(alarms) > maxNotificationAlarms {
+		return alarms[:maxNotificationAlarms]
+	}
+	return alarms
+}
@@ -0,0 +1,464 @@
+//Copyright 2017-2018 Baidu Inc.
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http: //www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+
+package models
+
+import (
+	"errors"
+	"github.com/astaxie/beego"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"net/http"
+	"rasp-cloud/environment"
+	"rasp-cloud/mongo"
+	"rasp-cloud/tools"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NotificationDelivery is a notification pushed to one channel of app, it is kept in the queue
+// collection until it is delivered or runs out of attempts, and its result is recorded in the
+// history collection with the same id
+type NotificationDelivery struct {
+	Id      string `json:"id" bson:"_id"`
+	AppId   string `json:"app_id" bson:"app_id"`
+	Channel string `json:"channel" bson:"channel"`
+	// the source of notification, attack_alarm or alert
+	Source string `json:"source" bson:"source"`
+	// the receivers overriding the alarm config of app, the http delivery always has one url
+	Recipients []string `json:"recipients" bson:"recipients"`
+	Subject    string   `json:"subject" bson:"subject"`
+	// the html of email, the text of ding ding or the json body of http
+	Content         string `json:"content" bson:"content"`
+	Status          string `json:"status" bson:"status"`
+	Attempts        int    `json:"attempts" bson:"attempts"`
+	MaxAttempts     int    `json:"max_attempts" bson:"max_attempts"`
+	ResponseCode    int    `json:"response_code" bson:"response_code"`
+	Error           string `json:"error" bson:"error"`
+	NextAttemptTime int64  `json:"next_attempt_time" bson:"next_attempt_time"`
+	LastAttemptTime int64  `json:"last_attempt_time" bson:"last_attempt_time"`
+	// the id of the failed delivery that this one is resent from
+	ResendFrom string `json:"resend_from" bson:"resend_from"`
+	CreateTime int64  `json:"create_time" bson:"create_time"`
+	UpdateTime int64  `json:"update_time" bson:"update_time"`
+}
+
+type notificationQueueItem struct {
+	NotificationDelivery `bson:",inline"`
+	// the time that a worker claimed the item, the item can be claimed again after the lock timeout
+	LockTime int64 `bson:"lock_time"`
+}
+
+type notificationRetryPolicy struct {
+	MaxAttempts int
+	// the seconds to wait before the first retry, it is doubled for each of the following retries
+	Backoff int64
+}
+
+const (
+	notificationQueueCollectionName   = "notification_queue"
+	notificationHistoryCollectionName = "notification_history"
+
+	NotificationStatusPending = "pending"
+	NotificationStatusSuccess = "success"
+	NotificationStatusFailed  = "failed"
+
+	NotificationSourceAttackAlarm = "attack_alarm"
+	NotificationSourceAlert       = "alert"
+
+	notificationLockTimeout      = 300
+	notificationQueueBatchSize   = 100
+	notificationHistoryCleanTime = 3600
+)
+
+var (
+	notificationRetryPolicies map[string]notificationRetryPolicy
+	notificationMaxBackoff    int64
+	notificationHistoryDays   int64
+	lastNotificationCleanTime int64
+)
+
+func init() {
+	indexes := map[string]*mgo.Index{
+		notificationQueueCollectionName: {
+			Key:        []string{"next_attempt_time"},
+			Unique:     false,
+			Background: true,
+			Name:       "next_attempt_time",
+		},
+		notificationHistoryCollectionName: {
+			Key:        []string{"app_id", "-create_time"},
+			Unique:     false,
+			Background: true,
+			Name:       "app_id_create_time",
+		},
+	}
+	for collection, index := range indexes {
+		err := mongo.CreateIndex(collection, index)
+		if err != nil {
+			tools.Panic(tools.ErrCodeMongoInitFailed, "failed to create index for "+collection+" collection", err)
+		}
+	}
+	notificationRetryPolicies = map[string]notificationRetryPolicy{
+		NotificationChannelEmail: {
+			MaxAttempts: beego.AppConfig.DefaultInt("NotificationEmailMaxAttempts", 5),
+			Backoff:     beego.AppConfig.DefaultInt64("NotificationEmailRetryBackoff", 60),
+		},
+		NotificationChannelDing: {
+			MaxAttempts: beego.AppConfig.DefaultInt("NotificationDingMaxAttempts", 5),
+			Backoff:     beego.AppConfig.DefaultInt64("NotificationDingRetryBackoff", 30),
+		},
+		NotificationChannelHttp: {
+			MaxAttempts: beego.AppConfig.DefaultInt("NotificationHttpMaxAttempts", 8),
+			Backoff:     beego.AppConfig.DefaultInt64("NotificationHttpRetryBackoff", 10),
+		},
+		NotificationChannelChat: {
+			MaxAttempts: beego.AppConfig.DefaultInt("NotificationChatMaxAttempts", 5),
+			Backoff:     beego.AppConfig.DefaultInt64("NotificationChatRetryBackoff", 10),
+		},
+	}
+	for channel, policy := range notificationRetryPolicies {
+		if policy.MaxAttempts <= 0 || policy.Backoff <= 0 {
+			tools.Panic(tools.ErrCodeConfigInitFailed,
+				"the max attempts and retry backoff of "+channel+" notification must be greater than 0", nil)
+		}
+	}
+	notificationMaxBackoff = beego.AppConfig.DefaultInt64("NotificationRetryMaxBackoff", 3600)
+	notificationHistoryDays = beego.AppConfig.DefaultInt64("NotificationHistoryDays", 30)
+	queueInterval := beego.AppConfig.DefaultInt64("NotificationQueueInterval", 5)
+	if queueInterval <= 0 {
+		tools.Panic(tools.ErrCodeConfigInitFailed, "the 'NotificationQueueInterval' config must be greater than 0", nil)
+	}
+	if *environment.StartFlag.StartType == environment.StartTypeDefault ||
+		*environment.StartFlag.StartType == environment.StartTypeForeground {
+		go startNotificationWorker(time.Second * time.Duration(queueInterval))
+	}
+}
+
+func (policy notificationRetryPolicy) getBackoff(attempts int) int64 {
+	backoff := policy.Backoff
+	for i := 1; i < attempts && backoff < notificationMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > notificationMaxBackoff {
+		backoff = notificationMaxBackoff
+	}
+	return backoff
+}
+
+func newNotificationDelivery(app *App, source string, channel string, recipients []string,
+	subject string, content string) *NotificationDelivery {
+	now := time.Now().Unix()
+	return &NotificationDelivery{
+		Id:              mongo.GenerateObjectId(),
+		AppId:           app.Id,
+		Channel:         channel,
+		Source:          source,
+		Recipients:      recipients,
+		Subject:         subject,
+		Content:         content,
+		Status:          NotificationStatusPending,
+		MaxAttempts:     notificationRetryPolicies[channel].MaxAttempts,
+		NextAttemptTime: now,
+		CreateTime:      now,
+		UpdateTime:      now,
+	}
+}
+
+// queueNotification records the delivery in the history and puts it into the queue
+func queueNotification(delivery *NotificationDelivery) error {
+	err := mongo.Insert(notificationHistoryCollectionName, delivery)
+	if err != nil {
+		beego.Error("failed to add notification history for app " + delivery.AppId + ": " + err.Error())
+		return err
+	}
+	err = mongo.Insert(notificationQueueCollectionName, &notificationQueueItem{NotificationDelivery: *delivery})
+	if err != nil {
+		beego.Error("failed to queue " + delivery.Channel + " notification for app " + delivery.AppId + ": " +
+			err.Error())
+		mongo.UpdateId(notificationHistoryCollectionName, delivery.Id, bson.M{
+			"status": NotificationStatusFailed, "error": "failed to queue notification: " + err.Error()})
+		return err
+	}
+	return nil
+}
+
+// queueAttackAlarm queues the attack alarms to the channel, the http alarm is queued for each of the urls
+func queueAttackAlarm(app *App, channel string, recipients []string, total int64,
+	alarms []map[string]interface{}, isTest bool) {
+	subject, content, err := renderAttackAlarm(app, channel, total, alarms, isTest)
+	if err != nil {
+		return
+	}
+	var deliveries []*NotificationDelivery
+	switch channel {
+	case NotificationChannelEmail, NotificationChannelDing:
+		deliveries = append(deliveries, newNotificationDelivery(app, NotificationSourceAttackAlarm,
+			channel, recipients, subject, content))
+	case NotificationChannelHttp:
+		deliveries = newHttpNotificationDeliveries(app, NotificationSourceAttackAlarm, recipients, content)
+	case NotificationChannelChat:
+		deliveries = newChatNotificationDeliveries(app, NotificationSourceAttackAlarm, recipients, subject, content)
+	}
+	for _, delivery := range deliveries {
+		queueNotification(delivery)
+	}
+}
+
+func newHttpNotificationDeliveries(app *App, source string, recipients []string,
+	content string) []*NotificationDelivery {
+	if len(recipients) == 0 {
+		for _, receiver := range getHttpReceivers(&app.HttpAlarmConf) {
+			recipients = append(recipients, receiver.Url)
+		}
+	}
+	deliveries := make([]*NotificationDelivery, 0, len(recipients))
+	for _, addr := range recipients {
+		deliveries = append(deliveries,
+			newNotificationDelivery(app, source, NotificationChannelHttp, []string{addr}, "", content))
+	}
+	return deliveries
+}
+
+// newChatNotificationDeliveries queues the message for each of the robots selected by the recipients
+func newChatNotificationDeliveries(app *App, source string, recipients []string, subject string,
+	content string) []*NotificationDelivery {
+	robots := getChatRobots(app, recipients)
+	deliveries := make([]*NotificationDelivery, 0, len(robots))
+	for _, robot := range robots {
+		deliveries = append(deliveries,
+			newNotificationDelivery(app, source, NotificationChannelChat, []string{robot.Name}, subject, content))
+	}
+	return deliveries
+}
+
+func startNotificationWorker(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	for {
+		select {
+		case <-ticker.C:
+			handleNotificationQueue()
+		}
+	}
+}
+
+func handleNotificationQueue() {
+	defer func() {
+		if r := recover(); r != nil {
+			beego.Error("failed to handle notification queue: ", r)
+		}
+	}()
+	for i := 0; i < notificationQueueBatchSize; i++ {
+		item, err := claimNotification(time.Now().Unix())
+		if err != nil {
+			if err != mgo.ErrNotFound {
+				beego.Error("failed to get notification from queue: " + err.Error())
+			}
+			break
+		}
+		processNotification(&item.NotificationDelivery)
+	}
+	cleanNotificationHistory()
+}
+
+// claimNotification locks the earliest due item of queue so that it is only delivered by one worker
+func claimNotification(now int64) (item *notificationQueueItem, err error) {
+	newSession := mongo.NewSession()
+	defer newSession.Close()
+	_, err = newSession.DB(mongo.DbName).C(notificationQueueCollectionName).Find(bson.M{
+		"next_attempt_time": bson.M{"$lte": now},
+		"lock_time":         bson.M{"$lt": now - notificationLockTimeout},
+	}).Sort("next_attempt_time").Apply(mgo.Change{
+		Update:    bson.M{"$set": bson.M{"lock_time": now}},
+		ReturnNew: true,
+	}, &item)
+	return
+}
+
+func processNotification(delivery *NotificationDelivery) {
+	code, retry, err := deliverNotification(delivery)
+	now := time.Now().Unix()
+	delivery.Attempts++
+	delivery.ResponseCode = code
+	delivery.LastAttemptTime = now
+	delivery.UpdateTime = now
+	delivery.NextAttemptTime = 0
+	if err == nil {
+		delivery.Status = NotificationStatusSuccess
+		delivery.Error = ""
+	} else {
+		delivery.Error = err.Error()
+		if retry && delivery.Attempts < delivery.MaxAttempts {
+			delivery.Status = NotificationStatusPending
+			delivery.NextAttemptTime = now + notificationRetryPolicies[delivery.Channel].getBackoff(delivery.Attempts)
+		} else {
+			delivery.Status = NotificationStatusFailed
+		}
+		beego.Error("failed to deliver " + delivery.Channel + " notification " + delivery.Id + " of app " +
+			delivery.AppId + ", attempts: " + strconv.Itoa(delivery.Attempts) + ", error: " + delivery.Error)
+	}
+	result := bson.M{
+		"status":            delivery.Status,
+		"attempts":          delivery.Attempts,
+		"response_code":     delivery.ResponseCode,
+		"error":             delivery.Error,
+		"next_attempt_time": delivery.NextAttemptTime,
+		"last_attempt_time": delivery.LastAttemptTime,
+		"update_time":       delivery.UpdateTime,
+	}
+	err = mongo.UpdateId(notificationHistoryCollectionName, delivery.Id, result)
+	if err != nil {
+		beego.Error("failed to update notification history " + delivery.Id + ": " + err.Error())
+	}
+	if delivery.Status == NotificationStatusPending {
+		result["lock_time"] = 0
+		err = mongo.UpdateId(notificationQueueCollectionName, delivery.Id, result)
+	} else {
+		err = mongo.RemoveId(notificationQueueCollectionName, delivery.Id)
+	}
+	if err != nil {
+		beego.Error("failed to update notification queue " + delivery.Id + ": " + err.Error())
+	}
+}
+
+// deliverNotification pushes the notification with the current alarm config of app,
+// the failure is not retried if it can not be fixed by retrying
+func deliverNotification(delivery *NotificationDelivery) (code int, retry bool, err error) {
+	app, err := GetAppByIdWithoutMask(delivery.AppId)
+	if err != nil {
+		return 0, err != mgo.ErrNotFound, errors.New("failed to get app: " + err.Error())
+	}
+	switch delivery.Channel {
+	case NotificationChannelEmail:
+		emailConf := app.EmailAlarmConf
+		if !emailConf.Enable {
+			return 0, false, errors.New("the email alarm is disabled")
+		}
+		if len(delivery.Recipients) > 0 {
+			emailConf.RecvAddr = delivery.Recipients
+		}
+		if len(emailConf.RecvAddr) == 0 || emailConf.ServerAddr == "" {
+			return 0, false, errors.New("the email receiving address and email server address can not be empty")
+		}
+		return 0, true, sendEmail(emailConf, delivery.Subject, delivery.Content)
+	case NotificationChannelDing:
+		dingConf := app.DingAlarmConf
+		if !dingConf.Enable {
+			return 0, false, errors.New("the ding ding alarm is disabled")
+		}
+		if len(delivery.Recipients) > 0 {
+			dingConf.RecvUser = delivery.Recipients
+			dingConf.RecvParty = nil
+		}
+		code, err = sendDingText(dingConf, delivery.Content)
+		return code, true, err
+	case NotificationChannelHttp:
+		if !app.HttpAlarmConf.Enable {
+			return 0, false, errors.New("the http alarm is disabled")
+		}
+		if len(delivery.Recipients) == 0 {
+			return 0, false, errors.New("the http receiving address can not be empty")
+		}
+		// the recipient of notification rule can be an url out of the http alarm config
+		receiver := findHttpReceiver(&app.HttpAlarmConf, delivery.Recipients[0])
+		if receiver == nil {
+			receiver = &HttpReceiver{Url: delivery.Recipients[0]}
+		}
+		code, err = postHttpReceiver(receiver, []byte(delivery.Content), delivery.Id)
+		// the client errors except timeout and rate limit are not retried
+		retry = code == 0 || code >= 500 || code == http.StatusRequestTimeout || code == http.StatusTooManyRequests
+		return code, retry, err
+	case NotificationChannelChat:
+		if !app.ChatAlarmConf.Enable {
+			return 0, false, errors.New("the chat alarm is disabled")
+		}
+		robots := getChatRobots(app, delivery.Recipients)
+		if len(delivery.Recipients) == 0 || len(robots) == 0 {
+			return 0, false, errors.New("the chat robot is not found: " + strings.Join(delivery.Recipients, ","))
+		}
+		code, err = sendChatRobotText(robots[0], delivery.Subject, delivery.Content)
+		// the robots respond with 200 and an error code in json when they are rate limited
+		retry = code < 400 || code >= 500 || code == http.StatusRequestTimeout || code == http.StatusTooManyRequests
+		return code, retry, err
+	}
+	return 0, false, errors.New("unknown notification channel: " + delivery.Channel)
+}
+
+func cleanNotificationHistory() {
+	now := time.Now().Unix()
+	if notificationHistoryDays <= 0 || now-lastNotificationCleanTime < notificationHistoryCleanTime {
+		return
+	}
+	lastNotificationCleanTime = now
+	err := mongo.RemoveAll(notificationHistoryCollectionName, bson.M{
+		"create_time": bson.M{"$lt": now - notificationHistoryDays*24*3600},
+		"status":      bson.M{"$ne": NotificationStatusPending},
+	})
+	if err != nil {
+		beego.Error("failed to clean notification history: " + err.Error())
+	}
+}
+
+func GetNotificationHistory(appId string, channel string, status string, page int,
+	perpage int) (count int, result []*NotificationDelivery, err error) {
+	query := bson.M{"app_id": appId}
+	if channel != "" {
+		query["channel"] = channel
+	}
+	if status != "" {
+		query["status"] = status
+	}
+	count, err = mongo.FindAll(notificationHistoryCollectionName, query, &result,
+		perpage*(page-1), perpage, "-create_time")
+	if err == nil && result == nil {
+		result = make([]*NotificationDelivery, 0)
+	}
+	return
+}
+
+func GetNotificationById(id string) (delivery *NotificationDelivery, err error) {
+	err = mongo.FindId(notificationHistoryCollectionName, id, &delivery)
+	return
+}
+
+// ResendNotification queues a new delivery with the content of the finished one
+func ResendNotification(id string) (*NotificationDelivery, error) {
+	old, err := GetNotificationById(id)
+	if err != nil {
+		return nil, err
+	}
+	if old.Status == NotificationStatusPending {
+		return nil, errors.New("the notification is still pending")
+	}
+	app, err := GetAppByIdWithoutMask(old.AppId)
+	if err != nil {
+		return nil, errors.New("failed to get app: " + err.Error())
+	}
+	delivery := newNotificationDelivery(app, old.Source, old.Channel, old.Recipients, old.Subject, old.Content)
+	delivery.ResendFrom = old.Id
+	err = queueNotification(delivery)
+	if err != nil {
+		return nil, err
+	}
+	return delivery, nil
+}
+
+func RemoveNotificationsByAppId(appId string) error {
+	err := mongo.RemoveAll(notificationQueueCollectionName, bson.M{"app_id": appId})
+	if err != nil {
+		return err
+	}
+	return mongo.RemoveAll(notificationHistoryCollectionName, bson.M{"app_id": appId})
+}
@@ -0,0 +1,368 @@
+//Copyright 2017-2018 Baidu Inc.
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http: //www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/astaxie/beego"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	htmltemplate "html/template"
+	"io/ioutil"
+	"rasp-cloud/mongo"
+	"rasp-cloud/tools"
+	"strconv"
+	"text/template"
+	"time"
+)
+
+// NotificationTemplate customizes the attack alarm notification of app for a channel,
+// the default template of the language is used if the body is empty
+type NotificationTemplate struct {
+	Id       string `json:"id" bson:"_id"`
+	AppId    string `json:"app_id" bson:"app_id"`
+	Channel  string `json:"channel" bson:"channel"`
+	Language string `json:"language" bson:"language"`
+	// the subject of email or the title of chat message, it is also a template
+	Subject    string `json:"subject" bson:"subject"`
+	Body       string `json:"body" bson:"body"`
+	UpdateTime int64  `json:"update_time" bson:"update_time"`
+}
+
+// NotificationTemplateParam is the variable set of the notification templates:
+//
+//	.App     the id, name, language and description of app
+//	.Alarms  the alarms to notify, each one is a map of the alarm fields, such as {{.attack_type}}
+//	.Total   the total count of the alarms, it can be greater than the count of .Alarms
+//	.More    the count of the alarms that are not in .Alarms
+//	.Links   .Links.Panel is the url of panel, .Links.Events is the url of the alarms of app,
+//	         and the url of an alarm is {{$.Links.Events}}/{{.id}}
+//	.Time    the time of notification in RFC3339
+//	.IsTest  whether it is a test notification
+//
+// the json function encodes a value to json, such as {{json .Alarms}} in the http body template
+type NotificationTemplateParam struct {
+	App    NotificationTemplateApp
+	Alarms []map[string]interface{}
+	Total  int64
+	More   int64
+	Links  NotificationTemplateLinks
+	Time   string
+	IsTest bool
+}
+
+type NotificationTemplateApp struct {
+	Id          string
+	Name        string
+	Language    string
+	Description string
+}
+
+type NotificationTemplateLinks struct {
+	Panel  string
+	Events string
+}
+
+const (
+	notificationTemplateCollectionName = "notification_template"
+
+	NotificationTemplateLanguageZh = "zh"
+	NotificationTemplateLanguageEn = "en"
+
+	maxNotificationTemplateLength = 64 * 1024
+)
+
+var (
+	defaultNotificationTemplateLanguage string
+	// the email defaults are the views/email*.tpl files
+	defaultEmailTemplateFiles = map[string]string{
+		NotificationTemplateLanguageZh: "views/email.tpl",
+		NotificationTemplateLanguageEn: "views/email_en.tpl",
+	}
+	testSubjectPrefixes = map[string]string{
+		NotificationTemplateLanguageZh: "【测试邮件】",
+		NotificationTemplateLanguageEn: "[Test] ",
+	}
+	defaultNotificationSubjects = map[string]string{
+		NotificationTemplateLanguageZh: "{{if .IsTest}}" + testSubjectPrefixes[NotificationTemplateLanguageZh] +
+			"{{end}}OpenRASP alarm",
+		NotificationTemplateLanguageEn: "{{if .IsTest}}" + testSubjectPrefixes[NotificationTemplateLanguageEn] +
+			"{{end}}OpenRASP alarm",
+	}
+	defaultTextTemplates = map[string]string{
+		NotificationTemplateLanguageZh: "{{if .IsTest}}OpenRASP 测试消息，来自 APP：{{.App.Name}}，时间：{{.Time}}{{else}}" +
+			"时间：{{.Time}}， 来自 OpenRASP 的报警\n共有 {{.Total}} 条报警信息来自 APP：{{.App.Name}}，" +
+			"详细信息：{{.Links.Events}}{{end}}",
+		NotificationTemplateLanguageEn: "{{if .IsTest}}OpenRASP test message from app: {{.App.Name}}, " +
+			"time: {{.Time}}{{else}}Time: {{.Time}}, OpenRASP alarm\n{{.Total}} alarms from app: {{.App.Name}}, " +
+			"detail: {{.Links.Events}}{{end}}",
+	}
+	defaultHttpTemplate = `{"app_id": {{json .App.Id}}, "data": {{json .Alarms}}}`
+	templateFuncs       = map[string]interface{}{
+		"json": func(v interface{}) (string, error) {
+			content, err := json.Marshal(v)
+			return string(content), err
+		},
+	}
+)
+
+func init() {
+	err := mongo.CreateIndex(notificationTemplateCollectionName, &mgo.Index{
+		Key:        []string{"app_id"},
+		Unique:     false,
+		Background: true,
+		Name:       "app_id",
+	})
+	if err != nil {
+		tools.Panic(tools.ErrCodeMongoInitFailed, "failed to create app_id index for notification_template collection", err)
+	}
+	defaultNotificationTemplateLanguage = beego.AppConfig.DefaultString("NotificationTemplateLanguage",
+		NotificationTemplateLanguageZh)
+	if _, ok := defaultNotificationSubjects[defaultNotificationTemplateLanguage]; !ok {
+		tools.Panic(tools.ErrCodeConfigInitFailed,
+			"the 'NotificationTemplateLanguage' config must be zh or en", nil)
+	}
+}
+
+func getNotificationTemplateId(appId string, channel string) string {
+	return appId + "-" + channel
+}
+
+// GetDefaultNotificationTemplate returns the built-in template of the channel in the language
+func GetDefaultNotificationTemplate(appId string, channel string, language string) (*NotificationTemplate, error) {
+	if language == "" {
+		language = defaultNotificationTemplateLanguage
+	}
+	if _, ok := defaultNotificationSubjects[language]; !ok {
+		return nil, errors.New("unsupported notification template language: " + language)
+	}
+	notificationTemplate := &NotificationTemplate{
+		Id:       getNotificationTemplateId(appId, channel),
+		AppId:    appId,
+		Channel:  channel,
+		Language: language,
+	}
+	switch channel {
+	case NotificationChannelEmail:
+		body, err := readDefaultEmailTemplate(language)
+		if err != nil {
+			return nil, err
+		}
+		notificationTemplate.Subject = defaultNotificationSubjects[language]
+		notificationTemplate.Body = body
+	case NotificationChannelDing:
+		notificationTemplate.Body = defaultTextTemplates[language]
+	case NotificationChannelChat:
+		notificationTemplate.Subject = defaultNotificationSubjects[language]
+		notificationTemplate.Body = defaultTextTemplates[language]
+	case NotificationChannelHttp:
+		notificationTemplate.Body = defaultHttpTemplate
+	default:
+		return nil, errors.New("unsupported notification channel: " + channel)
+	}
+	return notificationTemplate, nil
+}
+
+func readDefaultEmailTemplate(language string) (string, error) {
+	content, err := ioutil.ReadFile(defaultEmailTemplateFiles[language])
+	if err != nil {
+		beego.Error("failed to read email template: " + err.Error())
+		return "", err
+	}
+	return string(content), nil
+}
+
+// mergeNotificationTemplate fills the empty subject and body of the template with the default of its language
+func mergeNotificationTemplate(notificationTemplate *NotificationTemplate) (*NotificationTemplate, error) {
+	result, err := GetDefaultNotificationTemplate(notificationTemplate.AppId, notificationTemplate.Channel,
+		notificationTemplate.Language)
+	if err != nil {
+		return nil, err
+	}
+	if notificationTemplate.Subject != "" {
+		result.Subject = notificationTemplate.Subject
+	}
+	if notificationTemplate.Body != "" {
+		result.Body = notificationTemplate.Body
+	}
+	result.UpdateTime = notificationTemplate.UpdateTime
+	return result, nil
+}
+
+// GetNotificationTemplate returns the template of app for the channel, it is the default template
+// if the app has not customized it
+func GetNotificationTemplate(appId string, channel string) (*NotificationTemplate, error) {
+	var stored *NotificationTemplate
+	err := mongo.FindId(notificationTemplateCollectionName, getNotificationTemplateId(appId, channel), &stored)
+	if err == mgo.ErrNotFound {
+		stored = &NotificationTemplate{AppId: appId, Channel: channel}
+	} else if err != nil {
+		return nil, err
+	}
+	return mergeNotificationTemplate(stored)
+}
+
+func GetNotificationTemplates(appId string) ([]*NotificationTemplate, error) {
+	channels := []string{NotificationChannelEmail, NotificationChannelDing, NotificationChannelHttp,
+		NotificationChannelChat}
+	result := make([]*NotificationTemplate, 0, len(channels))
+	for _, channel := range channels {
+		notificationTemplate, err := GetNotificationTemplate(appId, channel)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, notificationTemplate)
+	}
+	return result, nil
+}
+
+// ValidateNotificationTemplate checks the template by rendering it with the test alarm data
+func ValidateNotificationTemplate(app *App, notificationTemplate *NotificationTemplate) error {
+	if len(notificationTemplate.Subject) > 1024 {
+		return errors.New("the length of notification template subject can not be greater than 1024")
+	}
+	if len(notificationTemplate.Body) > maxNotificationTemplateLength {
+		return errors.New("the length of notification template body can not be greater than " +
+			strconv.Itoa(maxNotificationTemplateLength))
+	}
+	_, _, err := PreviewNotificationTemplate(app, notificationTemplate)
+	return err
+}
+
+func UpdateNotificationTemplate(notificationTemplate *NotificationTemplate) (*NotificationTemplate, error) {
+	notificationTemplate.Id = getNotificationTemplateId(notificationTemplate.AppId, notificationTemplate.Channel)
+	notificationTemplate.UpdateTime = time.Now().Unix()
+	err := mongo.UpsertId(notificationTemplateCollectionName, notificationTemplate.Id, notificationTemplate)
+	if err != nil {
+		return nil, err
+	}
+	return GetNotificationTemplate(notificationTemplate.AppId, notificationTemplate.Channel)
+}
+
+// RemoveNotificationTemplate resets the template of app for the channel to the default
+func RemoveNotificationTemplate(appId string, channel string) error {
+	return mongo.RemoveAll(notificationTemplateCollectionName,
+		bson.M{"_id": getNotificationTemplateId(appId, channel)})
+}
+
+func RemoveNotificationTemplatesByAppId(appId string) error {
+	return mongo.RemoveAll(notificationTemplateCollectionName, bson.M{"app_id": appId})
+}
+
+func newNotificationTemplateParam(app *App, total int64, alarms []map[string]interface{},
+	isTest bool) *NotificationTemplateParam {
+	if isTest {
+		alarms = TestAlarmData
+		total = int64(len(TestAlarmData))
+	}
+	if alarms == nil {
+		alarms = make([]map[string]interface{}, 0)
+	}
+	return &NotificationTemplateParam{
+		App: NotificationTemplateApp{
+			Id:          app.Id,
+			Name:        app.Name,
+			Language:    app.Language,
+			Description: app.Description,
+		},
+		Alarms: alarms,
+		Total:  total,
+		More:   total - int64(len(alarms)),
+		Links: NotificationTemplateLinks{
+			Panel:  panelServerURL,
+			Events: panelServerURL + "/#/events/" + app.Id,
+		},
+		Time:   time.Now().Format(time.RFC3339),
+		IsTest: isTest,
+	}
+}
+
+// RenderNotificationTemplate renders the subject and body of the template with the param,
+// the email body is rendered as html and the http body must be a valid json
+func RenderNotificationTemplate(notificationTemplate *NotificationTemplate,
+	param *NotificationTemplateParam) (subject string, content string, err error) {
+	subject, err = executeTextTemplate("subject", notificationTemplate.Subject, param)
+	if err != nil {
+		return
+	}
+	if notificationTemplate.Channel == NotificationChannelEmail {
+		t, err := htmltemplate.New("body").Funcs(templateFuncs).Parse(notificationTemplate.Body)
+		if err != nil {
+			return "", "", errors.New("failed to parse the body template: " + err.Error())
+		}
+		body := new(bytes.Buffer)
+		if err = t.Execute(body, param); err != nil {
+			return "", "", errors.New("failed to execute the body template: " + err.Error())
+		}
+		return subject, body.String(), nil
+	}
+	content, err = executeTextTemplate("body", notificationTemplate.Body, param)
+	if err != nil {
+		return
+	}
+	if notificationTemplate.Channel == NotificationChannelHttp && !json.Valid([]byte(content)) {
+		return "", "", errors.New("the rendered http body is not a valid json")
+	}
+	return
+}
+
+func executeTextTemplate(name string, text string, param *NotificationTemplateParam) (string, error) {
+	t, err := template.New(name).Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return "", errors.New("failed to parse the " + name + " template: " + err.Error())
+	}
+	result := new(bytes.Buffer)
+	if err = t.Execute(result, param); err != nil {
+		return "", errors.New("failed to execute the " + name + " template: " + err.Error())
+	}
+	return result.String(), nil
+}
+
+// renderAttackAlarm renders the attack alarms with the template of app for the channel
+func renderAttackAlarm(app *App, channel string, total int64, alarms []map[string]interface{},
+	isTest bool) (subject string, content string, err error) {
+	notificationTemplate, err := GetNotificationTemplate(app.Id, channel)
+	if err != nil {
+		beego.Error("failed to get " + channel + " notification template of app " + app.Id + ": " + err.Error())
+		return
+	}
+	subject, content, err = RenderNotificationTemplate(notificationTemplate,
+		newNotificationTemplateParam(app, total, alarms, isTest))
+	if err != nil {
+		beego.Error("failed to render " + channel + " notification of app " + app.Id + ": " + err.Error())
+		return
+	}
+	// the subject of the email alarm config is used unless the subject template is customized
+	if channel == NotificationChannelEmail && app.EmailAlarmConf.Subject != "" &&
+		notificationTemplate.Subject == defaultNotificationSubjects[notificationTemplate.Language] {
+		subject = app.EmailAlarmConf.Subject
+		if isTest {
+			subject = testSubjectPrefixes[notificationTemplate.Language] + subject
+		}
+	}
+	return
+}
+
+// PreviewNotificationTemplate renders the template with the test alarm data,
+// the empty subject and body are rendered with the default of its language
+func PreviewNotificationTemplate(app *App, notificationTemplate *NotificationTemplate) (string, string, error) {
+	merged, err := mergeNotificationTemplate(notificationTemplate)
+	if err != nil {
+		return "", "", err
+	}
+	return RenderNotificationTemplate(merged, newNotificationTemplateParam(app, 0, nil, true))
+}
@@ -55,6 +55,20 @@ const (
 	OperationTypeDeleteApp
 	OperationTypeEditApp
 	OperationTypeRestorePlugin
+	OperationTypeUpdateRedactionConfig
+	OperationTypeUpdateNotificationRules
+	OperationTypeAddAlertCondition
+	OperationTypeEditAlertCondition
+	OperationTypeDeleteAlertCondition
+	OperationTypeAddSilence
+	OperationTypeEditSilence
+	OperationTypeDeleteSilence
+	OperationTypeResendNotification
+	OperationTypeUpdateNotificationTemplate
+	OperationTypeResetNotificationTemplate
+	OperationTypeUpdateDigestConfig
+	OperationTypeUpdateAlarmTriage
+	OperationTypeRollbackConfig
 )
 
 func init() {
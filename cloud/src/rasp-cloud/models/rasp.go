@@ -145,6 +145,18 @@ func HandleRasp(rasp *Rasp) {
 	rasp.Online = &online
 }
 
+// CountRaspByAppId returns the count of online and offline rasps of the app
+func CountRaspByAppId(appId string) (online int, offline int, err error) {
+	isOnline := true
+	online, _, err = FindRasp(&Rasp{AppId: appId, Online: &isOnline}, 1, 1)
+	if err != nil {
+		return
+	}
+	isOffline := false
+	offline, _, err = FindRasp(&Rasp{AppId: appId, Online: &isOffline}, 1, 1)
+	return
+}
+
 func RemoveRaspById(id string) (err error) {
 	return mongo.RemoveId(raspCollectionName, id)
 }
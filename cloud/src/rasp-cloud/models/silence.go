@@ -0,0 +1,347 @@
+//Copyright 2017-2018 Baidu Inc.
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http: //www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+
+package models
+
+import (
+	"errors"
+	"fmt"
+	"github.com/astaxie/beego"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"net"
+	"path"
+	"rasp-cloud/mongo"
+	"rasp-cloud/tools"
+	"strings"
+	"time"
+)
+
+// Silence suppresses the notifications of the matched alarms and alerts of app in the time range,
+// all of the non-empty matchers must match, and a silence without matchers silences the whole app
+type Silence struct {
+	Id       string         `json:"id" bson:"_id"`
+	AppId    string         `json:"app_id" bson:"app_id"`
+	Matchers SilenceMatcher `json:"matchers" bson:"matchers"`
+	// the unix time range of silence, the end time of a recurrent silence can be 0 to never end
+	StartTime  int64              `json:"start_time" bson:"start_time"`
+	EndTime    int64              `json:"end_time" bson:"end_time"`
+	Recurrence *SilenceRecurrence `json:"recurrence" bson:"recurrence"`
+	Creator    string             `json:"creator" bson:"creator"`
+	Reason     string             `json:"reason" bson:"reason"`
+	CreateTime int64              `json:"create_time" bson:"create_time"`
+}
+
+type SilenceMatcher struct {
+	// the ips or cidrs of attack_source
+	AttackSource []string `json:"attack_source" bson:"attack_source"`
+	AttackType   []string `json:"attack_type" bson:"attack_type"`
+	// the glob patterns of server_hostname
+	Hostname []string `json:"hostname" bson:"hostname"`
+}
+
+// SilenceRecurrence is a weekly maintenance window, the silence is only active in the minutes of
+// the weekdays (0 is sunday), the window crosses midnight if the end minute is less than the start minute
+type SilenceRecurrence struct {
+	Weekdays    []int  `json:"weekdays" bson:"weekdays"`
+	StartMinute int    `json:"start_minute" bson:"start_minute"`
+	EndMinute   int    `json:"end_minute" bson:"end_minute"`
+	Timezone    string `json:"timezone" bson:"timezone"`
+}
+
+type compiledSilence struct {
+	*Silence
+	networks []*net.IPNet
+}
+
+const (
+	silenceCollectionName = "silence"
+	maxSilenceMatchers    = 128
+)
+
+func init() {
+	err := mongo.CreateIndex(silenceCollectionName, &mgo.Index{
+		Key:        []string{"app_id", "end_time"},
+		Unique:     false,
+		Background: true,
+		Name:       "app_id_end_time",
+	})
+	if err != nil {
+		tools.Panic(tools.ErrCodeMongoInitFailed, "failed to create app_id index for silence collection", err)
+	}
+}
+
+func ValidateSilence(silence *Silence) error {
+	if silence.StartTime <= 0 {
+		return errors.New("the start_time of silence must be greater than 0")
+	}
+	if silence.EndTime != 0 && silence.EndTime <= silence.StartTime {
+		return errors.New("the end_time of silence must be greater than start_time")
+	}
+	if silence.EndTime == 0 && silence.Recurrence == nil {
+		return errors.New("the end_time of silence can only be 0 for the recurrent silence")
+	}
+	if len(silence.Reason) > 1024 {
+		return errors.New("the length of silence reason can not be greater than 1024")
+	}
+	matchers := silence.Matchers
+	if len(matchers.AttackSource) > maxSilenceMatchers || len(matchers.AttackType) > maxSilenceMatchers ||
+		len(matchers.Hostname) > maxSilenceMatchers {
+		return errors.New("the count of silence matchers can not be greater than 128")
+	}
+	if _, err := compileSilence(silence); err != nil {
+		return err
+	}
+	if recurrence := silence.Recurrence; recurrence != nil {
+		if len(recurrence.Weekdays) == 0 {
+			return errors.New("the weekdays of silence recurrence can not be empty")
+		}
+		for _, weekday := range recurrence.Weekdays {
+			if weekday < 0 || weekday > 6 {
+				return errors.New("the weekday of silence recurrence must be between [0,6]")
+			}
+		}
+		if recurrence.StartMinute < 0 || recurrence.StartMinute >= 1440 ||
+			recurrence.EndMinute < 0 || recurrence.EndMinute > 1440 || recurrence.StartMinute == recurrence.EndMinute {
+			return errors.New("the minutes of silence recurrence must be between [0,1440] and can not be equal")
+		}
+		if _, err := time.LoadLocation(recurrence.Timezone); err != nil {
+			return errors.New("invalid timezone of silence recurrence: " + recurrence.Timezone)
+		}
+	}
+	return nil
+}
+
+func compileSilence(silence *Silence) (*compiledSilence, error) {
+	compiled := &compiledSilence{Silence: silence}
+	for _, source := range silence.Matchers.AttackSource {
+		network, err := parseSilenceNetwork(source)
+		if err != nil {
+			return nil, err
+		}
+		compiled.networks = append(compiled.networks, network)
+	}
+	for _, pattern := range silence.Matchers.Hostname {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, errors.New("invalid hostname pattern: " + pattern)
+		}
+	}
+	return compiled, nil
+}
+
+func parseSilenceNetwork(source string) (*net.IPNet, error) {
+	source = strings.TrimSpace(source)
+	if !strings.Contains(source, "/") {
+		ip := net.ParseIP(source)
+		if ip == nil {
+			return nil, errors.New("invalid attack_source ip: " + source)
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+	}
+	_, network, err := net.ParseCIDR(source)
+	if err != nil {
+		return nil, errors.New("invalid attack_source cidr: " + source)
+	}
+	return network, nil
+}
+
+func GetSilences(appId string, activeOnly bool, page int, perpage int) (count int, result []*Silence,
+	err error) {
+	query := bson.M{"app_id": appId}
+	if activeOnly {
+		query["$or"] = []bson.M{{"end_time": 0}, {"end_time": bson.M{"$gte": time.Now().Unix()}}}
+	}
+	count, err = mongo.FindAll(silenceCollectionName, query, &result, perpage*(page-1), perpage, "-create_time")
+	if err == nil && result == nil {
+		result = make([]*Silence, 0)
+	}
+	return
+}
+
+func GetSilenceById(id string) (silence *Silence, err error) {
+	err = mongo.FindId(silenceCollectionName, id, &silence)
+	return
+}
+
+func AddSilence(silence *Silence) (*Silence, error) {
+	silence.Id = mongo.GenerateObjectId()
+	silence.CreateTime = time.Now().Unix()
+	creator, err := GetLoginUserName()
+	if err != nil {
+		return nil, errors.New("failed to get the creator of silence: " + err.Error())
+	}
+	silence.Creator = creator
+	err = mongo.Insert(silenceCollectionName, silence)
+	if err != nil {
+		return nil, err
+	}
+	return silence, nil
+}
+
+func UpdateSilence(silence *Silence) (*Silence, error) {
+	oldSilence, err := GetSilenceById(silence.Id)
+	if err != nil {
+		return nil, err
+	}
+	silence.AppId = oldSilence.AppId
+	silence.Creator = oldSilence.Creator
+	silence.CreateTime = oldSilence.CreateTime
+	return silence, mongo.UpsertId(silenceCollectionName, silence.Id, silence)
+}
+
+func RemoveSilence(id string) (silence *Silence, err error) {
+	silence, err = GetSilenceById(id)
+	if err != nil {
+		return
+	}
+	return silence, mongo.RemoveId(silenceCollectionName, id)
+}
+
+func RemoveSilencesByAppId(appId string) error {
+	return mongo.RemoveAll(silenceCollectionName, bson.M{"app_id": appId})
+}
+
+// getActiveSilences returns the silences of app that are active at the time
+func getActiveSilences(appId string, now time.Time) []*compiledSilence {
+	var silences []*Silence
+	_, err := mongo.FindAll(silenceCollectionName, bson.M{
+		"app_id":     appId,
+		"start_time": bson.M{"$lte": now.Unix()},
+		"$or":        []bson.M{{"end_time": 0}, {"end_time": bson.M{"$gte": now.Unix()}}},
+	}, &silences, 0, 0)
+	if err != nil {
+		beego.Error("failed to get silences of app " + appId + ": " + err.Error())
+		return nil
+	}
+	result := make([]*compiledSilence, 0, len(silences))
+	for _, silence := range silences {
+		compiled, err := compileSilence(silence)
+		if err != nil {
+			beego.Error("invalid silence " + silence.Id + " of app " + appId + ": " + err.Error())
+			continue
+		}
+		if compiled.isActive(now) {
+			result = append(result, compiled)
+		}
+	}
+	return result
+}
+
+func (silence *compiledSilence) isActive(now time.Time) bool {
+	if now.Unix() < silence.StartTime || (silence.EndTime != 0 && now.Unix() > silence.EndTime) {
+		return false
+	}
+	recurrence := silence.Recurrence
+	if recurrence == nil {
+		return true
+	}
+	location, err := time.LoadLocation(recurrence.Timezone)
+	if err != nil {
+		location = time.Local
+	}
+	now = now.In(location)
+	minute := now.Hour()*60 + now.Minute()
+	weekday := int(now.Weekday())
+	if recurrence.StartMinute < recurrence.EndMinute {
+		return minute >= recurrence.StartMinute && minute < recurrence.EndMinute &&
+			containsInt(recurrence.Weekdays, weekday)
+	}
+	// the window crosses midnight, it belongs to the weekday it starts
+	if minute >= recurrence.StartMinute {
+		return containsInt(recurrence.Weekdays, weekday)
+	}
+	return minute < recurrence.EndMinute && containsInt(recurrence.Weekdays, (weekday+6)%7)
+}
+
+// match returns whether the silence matches the fields, the matchers of the missing fields never match
+func (silence *compiledSilence) match(fields map[string]interface{}) bool {
+	matchers := silence.Matchers
+	if len(silence.networks) > 0 {
+		source, _ := fields["attack_source"].(string)
+		ip := net.ParseIP(strings.TrimSpace(source))
+		if ip == nil {
+			return false
+		}
+		matched := false
+		for _, network := range silence.networks {
+			if network.Contains(ip) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if len(matchers.AttackType) > 0 {
+		attackType, ok := fields["attack_type"]
+		if !ok || !containsString(matchers.AttackType, fmt.Sprint(attackType)) {
+			return false
+		}
+	}
+	if len(matchers.Hostname) > 0 {
+		hostname, ok := fields["server_hostname"].(string)
+		if !ok {
+			return false
+		}
+		matched := false
+		for _, pattern := range matchers.Hostname {
+			if ok, _ := path.Match(pattern, hostname); ok {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+func isSilenced(silences []*compiledSilence, fields map[string]interface{}) bool {
+	for _, silence := range silences {
+		if silence.match(fields) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterSilencedAlarms removes the alarms matched by the silences, it returns the remaining alarms
+// and the count of silenced alarms
+func filterSilencedAlarms(silences []*compiledSilence, alarms []map[string]interface{}) (
+	[]map[string]interface{}, int64) {
+	if len(silences) == 0 {
+		return alarms, 0
+	}
+	result := make([]map[string]interface{}, 0, len(alarms))
+	for _, alarm := range alarms {
+		if !isSilenced(silences, alarm) {
+			result = append(result, alarm)
+		}
+	}
+	return result, int64(len(alarms) - len(result))
+}
+
+func containsInt(items []int, value int) bool {
+	for _, item := range items {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
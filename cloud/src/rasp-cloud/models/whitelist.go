@@ -0,0 +1,215 @@
+//Copyright 2017-2018 Baidu Inc.
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http: //www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+
+package models
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	whitelistHookAll = "all"
+	// the host of entry matching all of the hosts
+	whitelistHostAll = "*"
+)
+
+// NormalizeWhitelistConfig fills the matchers of the legacy url entries, keeps the url in sync with
+// the host and path, and validates the entries, the duplicate and overlapping entries are rejected
+func NormalizeWhitelistConfig(config []WhitelistConfigItem) error {
+	now := time.Now().Unix()
+	for i := range config {
+		if err := normalizeWhitelistItem(&config[i], now); err != nil {
+			return errors.New("invalid whitelist config item " + strconv.Itoa(i) + ": " + err.Error())
+		}
+	}
+	for i := range config {
+		for j := range config {
+			if i == j {
+				continue
+			}
+			if i < j && sameWhitelistMatcher(&config[i], &config[j]) {
+				return errors.New("whitelist config item " + strconv.Itoa(j) + " duplicates item " +
+					strconv.Itoa(i) + ": " + config[i].Url)
+			}
+			if !sameWhitelistMatcher(&config[i], &config[j]) && whitelistCovers(&config[i], &config[j]) {
+				if hooks := getCoveredHooks(&config[i], &config[j]); len(hooks) > 0 {
+					return errors.New("the hooks [" + strings.Join(hooks, ",") + "] of whitelist config item " +
+						strconv.Itoa(j) + " overlap with item " + strconv.Itoa(i) + ": " + config[i].Url)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func normalizeWhitelistItem(item *WhitelistConfigItem, now int64) error {
+	if item.Host == "" && item.Path == "" {
+		if item.Url == "" {
+			return errors.New("the host and path can not be both empty")
+		}
+		normalizeLegacyWhitelistItem(item)
+	}
+	if item.Host == "" {
+		return errors.New("the host can not be empty, use * to match all of the hosts")
+	}
+	if strings.HasPrefix(item.Host, "http://") || strings.HasPrefix(item.Host, "https://") {
+		return errors.New("the host can not start with http:// or https://")
+	}
+	if strings.Contains(item.Host, "/") {
+		return errors.New("the host can not contain '/'")
+	}
+	if item.Host == whitelistHostAll && item.Path != "" {
+		return errors.New("the path must be empty when the host is *")
+	}
+	if item.Path != "" && !strings.HasPrefix(item.Path, "/") {
+		return errors.New("the path must start with '/'")
+	}
+	// the agents match the url prefix of host and path
+	item.Url = item.Host + item.Path
+	if len(item.Url) > 200 {
+		return errors.New("the length of host and path can not be greater than 200")
+	}
+	if len(item.Hook) == 0 {
+		return errors.New("the hook can not be empty")
+	}
+	for key := range item.Hook {
+		if len(key) > 128 {
+			return errors.New("the length of hook's type can not be greater 128")
+		}
+	}
+	if len(getWhitelistHooks(item)) == 0 {
+		return errors.New("at least one hook must be enabled")
+	}
+	if item.ExpireTime < 0 {
+		return errors.New("the expire_time can not be less than 0")
+	}
+	if item.ExpireTime > 0 && item.ExpireTime <= now {
+		return errors.New("the expire_time must be in the future")
+	}
+	if len(item.Owner) > 256 {
+		return errors.New("the length of owner can not be greater than 256")
+	}
+	if len(item.Reason) > 1024 {
+		return errors.New("the length of reason can not be greater than 1024")
+	}
+	return nil
+}
+
+func uniqueSortedStrings(values []string) []string {
+	if len(values) == 0 {
+		return values
+	}
+	result := make([]string, 0, len(values))
+	exists := make(map[string]bool, len(values))
+	for _, value := range values {
+		if !exists[value] {
+			exists[value] = true
+			result = append(result, value)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+func getWhitelistHooks(item *WhitelistConfigItem) []string {
+	hooks := make([]string, 0, len(item.Hook))
+	for hook, isWhite := range item.Hook {
+		if isWhite {
+			hooks = append(hooks, hook)
+		}
+	}
+	sort.Strings(hooks)
+	return hooks
+}
+
+func sameWhitelistMatcher(a *WhitelistConfigItem, b *WhitelistConfigItem) bool {
+	return a.Host == b.Host && a.Path == b.Path
+}
+
+// whitelistCovers checks whether all the requests matched by b are matched by a during the lifetime of b,
+// the agents match the entries as the prefix of the url without scheme, e.g. www.example.com:8080/admin
+func whitelistCovers(a *WhitelistConfigItem, b *WhitelistConfigItem) bool {
+	if a.Host != whitelistHostAll && !strings.HasPrefix(b.Host+b.Path, a.Host+a.Path) {
+		return false
+	}
+	if a.ExpireTime > 0 && (b.ExpireTime == 0 || b.ExpireTime > a.ExpireTime) {
+		return false
+	}
+	return true
+}
+
+func getCoveredHooks(a *WhitelistConfigItem, b *WhitelistConfigItem) []string {
+	if a.Hook[whitelistHookAll] {
+		return getWhitelistHooks(b)
+	}
+	hooks := make([]string, 0)
+	for _, hook := range getWhitelistHooks(b) {
+		if a.Hook[hook] {
+			hooks = append(hooks, hook)
+		}
+	}
+	return hooks
+}
+
+// GetWhitelistHeartbeatConfig returns the unexpired entries in the hook.white config, and the config time
+// which is increased by the expired entries to make the agents reload the config
+func GetWhitelistHeartbeatConfig(app *App) (white map[string]interface{}, configTime int64) {
+	now := time.Now().Unix()
+	configTime = app.ConfigTime
+	white = make(map[string]interface{})
+	for url, hooks := range getActiveWhitelist(app.WhitelistConfig, now) {
+		white[url] = hooks
+	}
+	for _, item := range app.WhitelistConfig {
+		if item.ExpireTime > 0 && item.ExpireTime <= now {
+			if expireTime := item.ExpireTime * int64(time.Second); expireTime > configTime {
+				configTime = expireTime
+			}
+		}
+	}
+	return
+}
+
+// getActiveWhitelist returns the hooks of the unexpired entries by the url prefix, as the agents receive them
+func getActiveWhitelist(config []WhitelistConfigItem, now int64) map[string][]string {
+	white := make(map[string][]string)
+	for i := range config {
+		item := config[i]
+		if item.ExpireTime > 0 && item.ExpireTime <= now {
+			continue
+		}
+		if item.Host == "" && item.Path == "" {
+			normalizeLegacyWhitelistItem(&item)
+		}
+		if item.Host == "" {
+			continue
+		}
+		url := item.Host + item.Path
+		white[url] = uniqueSortedStrings(append(white[url], getWhitelistHooks(&item)...))
+	}
+	return white
+}
+
+// normalizeLegacyWhitelistItem fills the matchers of the stored entries saved before the matchers are supported
+func normalizeLegacyWhitelistItem(item *WhitelistConfigItem) {
+	if index := strings.Index(item.Url, "/"); index >= 0 {
+		item.Host, item.Path = item.Url[:index], item.Url[index:]
+	} else {
+		item.Host = item.Url
+	}
+}
@@ -0,0 +1,120 @@
+//Copyright 2017-2018 Baidu Inc.
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http: //www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+
+package mongo
+
+import (
+	"github.com/astaxie/beego"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"os"
+	"rasp-cloud/environment"
+	"rasp-cloud/tools"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// the leader lease is a document held by one panel instance until it expires,
+// only the leader runs the periodic tasks such as the alarm ticker and the es ttl cleanup
+const (
+	leaseCollectionName = "lease"
+	leaderLeaseName     = "leader"
+)
+
+var (
+	InstanceId     string
+	leaderLeaseTtl time.Duration
+	isLeader       int32
+	leaderOnce     sync.Once
+)
+
+func init() {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	InstanceId = hostname + "-" + GenerateObjectId()[:8]
+	ttl := beego.AppConfig.DefaultInt64("LeaderLeaseTtl", 30)
+	if ttl < 3 {
+		tools.Panic(tools.ErrCodeConfigInitFailed, "the 'LeaderLeaseTtl' config can not be less than 3", nil)
+	}
+	leaderLeaseTtl = time.Duration(ttl) * time.Second
+}
+
+// IsLeader returns whether the instance holds the leader lease, the election starts at the first call,
+// only the instances serving the panel take part in the election since the alarm ticker runs on them
+func IsLeader() bool {
+	if *environment.StartFlag.StartType != environment.StartTypeDefault &&
+		*environment.StartFlag.StartType != environment.StartTypeForeground {
+		return false
+	}
+	leaderOnce.Do(startLeaderElection)
+	return atomic.LoadInt32(&isLeader) == 1
+}
+
+func startLeaderElection() {
+	renewLeaderLease()
+	go func() {
+		ticker := time.NewTicker(leaderLeaseTtl / 3)
+		for range ticker.C {
+			renewLeaderLease()
+		}
+	}()
+}
+
+func renewLeaderLease() {
+	acquired, err := AcquireLease(leaderLeaseName, leaderLeaseTtl)
+	if err != nil {
+		beego.Error("failed to acquire the leader lease: " + err.Error())
+	}
+	var leader int32
+	if acquired {
+		leader = 1
+	}
+	if atomic.SwapInt32(&isLeader, leader) != leader {
+		if acquired {
+			beego.Info("the instance " + InstanceId + " becomes the leader")
+		} else {
+			beego.Info("the instance " + InstanceId + " is no longer the leader")
+		}
+	}
+}
+
+// AcquireLease acquires or renews the lease with the name for the instance,
+// it fails if the lease is held by another instance and has not expired
+func AcquireLease(name string, ttl time.Duration) (bool, error) {
+	newSession := NewSession()
+	defer newSession.Close()
+	now := time.Now()
+	_, err := newSession.DB(DbName).C(leaseCollectionName).Upsert(bson.M{
+		"_id": name,
+		"$or": []bson.M{
+			{"owner": InstanceId},
+			{"expire_time": bson.M{"$lt": now.UnixNano() / 1000000}},
+		},
+	}, bson.M{"$set": bson.M{
+		"owner":       InstanceId,
+		"renew_time":  now.UnixNano() / 1000000,
+		"expire_time": now.Add(ttl).UnixNano() / 1000000,
+	}})
+	if err != nil {
+		// the lease is held by another instance, so the upsert tries to insert the duplicate id
+		if mgo.IsDup(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
@@ -16,6 +16,42 @@ func init() {
             Filters: nil,
             Params: nil})
 
+    beego.GlobalControllerRouter["rasp-cloud/controllers/api:AlertController"] = append(beego.GlobalControllerRouter["rasp-cloud/controllers/api:AlertController"],
+        beego.ControllerComments{
+            Method: "GetConditions",
+            Router: `/condition/get`,
+            AllowHTTPMethods: []string{"post"},
+            MethodParams: param.Make(),
+            Filters: nil,
+            Params: nil})
+
+    beego.GlobalControllerRouter["rasp-cloud/controllers/api:AlertController"] = append(beego.GlobalControllerRouter["rasp-cloud/controllers/api:AlertController"],
+        beego.ControllerComments{
+            Method: "PostCondition",
+            Router: `/condition`,
+            AllowHTTPMethods: []string{"post"},
+            MethodParams: param.Make(),
+            Filters: nil,
+            Params: nil})
+
+    beego.GlobalControllerRouter["rasp-cloud/controllers/api:AlertController"] = append(beego.GlobalControllerRouter["rasp-cloud/controllers/api:AlertController"],
+        beego.ControllerComments{
+            Method: "DeleteCondition",
+            Router: `/condition/delete`,
+            AllowHTTPMethods: []string{"post"},
+            MethodParams: param.Make(),
+            Filters: nil,
+            Params: nil})
+
+    beego.GlobalControllerRouter["rasp-cloud/controllers/api:AlertController"] = append(beego.GlobalControllerRouter["rasp-cloud/controllers/api:AlertController"],
+        beego.ControllerComments{
+            Method: "GetStates",
+            Router: `/state/get`,
+            AllowHTTPMethods: []string{"post"},
+            MethodParams: param.Make(),
+            Filters: nil,
+            Params: nil})
+
     beego.GlobalControllerRouter["rasp-cloud/controllers/api:AppController"] = append(beego.GlobalControllerRouter["rasp-cloud/controllers/api:AppController"],
         beego.ControllerComments{
             Method: "Post",
@@ -43,6 +79,15 @@ func init() {
             Filters: nil,
             Params: nil})
 
+    beego.GlobalControllerRouter["rasp-cloud/controllers/api:AppController"] = append(beego.GlobalControllerRouter["rasp-cloud/controllers/api:AppController"],
+        beego.ControllerComments{
+            Method: "PreviewConfig",
+            Router: `/config/preview`,
+            AllowHTTPMethods: []string{"post"},
+            MethodParams: param.Make(),
+            Filters: nil,
+            Params: nil})
+
     beego.GlobalControllerRouter["rasp-cloud/controllers/api:AppController"] = append(beego.GlobalControllerRouter["rasp-cloud/controllers/api:AppController"],
         beego.ControllerComments{
             Method: "Delete",
@@ -81,6 +126,24 @@ func init() {
             Filters: nil,
             Params: nil})
 
+    beego.GlobalControllerRouter["rasp-cloud/controllers/api:AppController"] = append(beego.GlobalControllerRouter["rasp-cloud/controllers/api:AppController"],
+        beego.ControllerComments{
+            Method: "GetGeneralConfigSchema",
+            Router: `/general/config/schema`,
+            AllowHTTPMethods: []string{"post"},
+            MethodParams: param.Make(),
+            Filters: nil,
+            Params: nil})
+
+    beego.GlobalControllerRouter["rasp-cloud/controllers/api:AppController"] = append(beego.GlobalControllerRouter["rasp-cloud/controllers/api:AppController"],
+        beego.ControllerComments{
+            Method: "ValidateGeneralConfig",
+            Router: `/general/config/validate`,
+            AllowHTTPMethods: []string{"post"},
+            MethodParams: param.Make(),
+            Filters: nil,
+            Params: nil})
+
     beego.GlobalControllerRouter["rasp-cloud/controllers/api:AppController"] = append(beego.GlobalControllerRouter["rasp-cloud/controllers/api:AppController"],
         beego.ControllerComments{
             Method: "GetApp",
@@ -101,6 +164,15 @@ func init() {
             Filters: nil,
             Params: nil})
 
+    beego.GlobalControllerRouter["rasp-cloud/controllers/api:AppController"] = append(beego.GlobalControllerRouter["rasp-cloud/controllers/api:AppController"],
+        beego.ControllerComments{
+            Method: "TestChat",
+            Router: `/chat/test`,
+            AllowHTTPMethods: []string{"post"},
+            MethodParams: param.Make(),
+            Filters: nil,
+            Params: nil})
+
     beego.GlobalControllerRouter["rasp-cloud/controllers/api:AppController"] = append(beego.GlobalControllerRouter["rasp-cloud/controllers/api:AppController"],
         beego.ControllerComments{
             Method: "GetPlugins",
@@ -164,6 +236,168 @@ func init() {
             Filters: nil,
             Params: nil})
 
+    beego.GlobalControllerRouter["rasp-cloud/controllers/api:AppController"] = append(beego.GlobalControllerRouter["rasp-cloud/controllers/api:AppController"],
+        beego.ControllerComments{
+            Method: "ProposeWhitelist",
+            Router: `/whitelist/propose`,
+            AllowHTTPMethods: []string{"post"},
+            MethodParams: param.Make(),
+            Filters: nil,
+            Params: nil})
+
+    beego.GlobalControllerRouter["rasp-cloud/controllers/api:AppController"] = append(beego.GlobalControllerRouter["rasp-cloud/controllers/api:AppController"],
+        beego.ControllerComments{
+            Method: "ApplyWhitelist",
+            Router: `/whitelist/apply`,
+            AllowHTTPMethods: []string{"post"},
+            MethodParams: param.Make(),
+            Filters: nil,
+            Params: nil})
+
+    beego.GlobalControllerRouter["rasp-cloud/controllers/api:AppController"] = append(beego.GlobalControllerRouter["rasp-cloud/controllers/api:AppController"],
+        beego.ControllerComments{
+            Method: "UpdateAppRedactionConfig",
+            Router: `/redaction/config`,
+            AllowHTTPMethods: []string{"post"},
+            MethodParams: param.Make(),
+            Filters: nil,
+            Params: nil})
+
+    beego.GlobalControllerRouter["rasp-cloud/controllers/api:AppController"] = append(beego.GlobalControllerRouter["rasp-cloud/controllers/api:AppController"],
+        beego.ControllerComments{
+            Method: "UpdateAppNotificationRules",
+            Router: `/notification/config`,
+            AllowHTTPMethods: []string{"post"},
+            MethodParams: param.Make(),
+            Filters: nil,
+            Params: nil})
+
+    beego.GlobalControllerRouter["rasp-cloud/controllers/api:AppController"] = append(beego.GlobalControllerRouter["rasp-cloud/controllers/api:AppController"],
+        beego.ControllerComments{
+            Method: "UpdateAppDigestConfig",
+            Router: `/digest/config`,
+            AllowHTTPMethods: []string{"post"},
+            MethodParams: param.Make(),
+            Filters: nil,
+            Params: nil})
+
+    beego.GlobalControllerRouter["rasp-cloud/controllers/api:ConfigVersionController"] = append(beego.GlobalControllerRouter["rasp-cloud/controllers/api:ConfigVersionController"],
+        beego.ControllerComments{
+            Method: "Get",
+            Router: `/get`,
+            AllowHTTPMethods: []string{"post"},
+            MethodParams: param.Make(),
+            Filters: nil,
+            Params: nil})
+
+    beego.GlobalControllerRouter["rasp-cloud/controllers/api:ConfigVersionController"] = append(beego.GlobalControllerRouter["rasp-cloud/controllers/api:ConfigVersionController"],
+        beego.ControllerComments{
+            Method: "Detail",
+            Router: `/detail`,
+            AllowHTTPMethods: []string{"post"},
+            MethodParams: param.Make(),
+            Filters: nil,
+            Params: nil})
+
+    beego.GlobalControllerRouter["rasp-cloud/controllers/api:ConfigVersionController"] = append(beego.GlobalControllerRouter["rasp-cloud/controllers/api:ConfigVersionController"],
+        beego.ControllerComments{
+            Method: "Diff",
+            Router: `/diff`,
+            AllowHTTPMethods: []string{"post"},
+            MethodParams: param.Make(),
+            Filters: nil,
+            Params: nil})
+
+    beego.GlobalControllerRouter["rasp-cloud/controllers/api:ConfigVersionController"] = append(beego.GlobalControllerRouter["rasp-cloud/controllers/api:ConfigVersionController"],
+        beego.ControllerComments{
+            Method: "Rollback",
+            Router: `/rollback`,
+            AllowHTTPMethods: []string{"post"},
+            MethodParams: param.Make(),
+            Filters: nil,
+            Params: nil})
+
+    beego.GlobalControllerRouter["rasp-cloud/controllers/api:DigestController"] = append(beego.GlobalControllerRouter["rasp-cloud/controllers/api:DigestController"],
+        beego.ControllerComments{
+            Method: "Get",
+            Router: `/get`,
+            AllowHTTPMethods: []string{"post"},
+            MethodParams: param.Make(),
+            Filters: nil,
+            Params: nil})
+
+    beego.GlobalControllerRouter["rasp-cloud/controllers/api:DigestController"] = append(beego.GlobalControllerRouter["rasp-cloud/controllers/api:DigestController"],
+        beego.ControllerComments{
+            Method: "Detail",
+            Router: `/detail`,
+            AllowHTTPMethods: []string{"post"},
+            MethodParams: param.Make(),
+            Filters: nil,
+            Params: nil})
+
+    beego.GlobalControllerRouter["rasp-cloud/controllers/api:DigestController"] = append(beego.GlobalControllerRouter["rasp-cloud/controllers/api:DigestController"],
+        beego.ControllerComments{
+            Method: "Generate",
+            Router: `/generate`,
+            AllowHTTPMethods: []string{"post"},
+            MethodParams: param.Make(),
+            Filters: nil,
+            Params: nil})
+
+    beego.GlobalControllerRouter["rasp-cloud/controllers/api:NotificationController"] = append(beego.GlobalControllerRouter["rasp-cloud/controllers/api:NotificationController"],
+        beego.ControllerComments{
+            Method: "GetHistory",
+            Router: `/history/get`,
+            AllowHTTPMethods: []string{"post"},
+            MethodParams: param.Make(),
+            Filters: nil,
+            Params: nil})
+
+    beego.GlobalControllerRouter["rasp-cloud/controllers/api:NotificationController"] = append(beego.GlobalControllerRouter["rasp-cloud/controllers/api:NotificationController"],
+        beego.ControllerComments{
+            Method: "Resend",
+            Router: `/resend`,
+            AllowHTTPMethods: []string{"post"},
+            MethodParams: param.Make(),
+            Filters: nil,
+            Params: nil})
+
+    beego.GlobalControllerRouter["rasp-cloud/controllers/api:NotificationController"] = append(beego.GlobalControllerRouter["rasp-cloud/controllers/api:NotificationController"],
+        beego.ControllerComments{
+            Method: "GetTemplates",
+            Router: `/template/get`,
+            AllowHTTPMethods: []string{"post"},
+            MethodParams: param.Make(),
+            Filters: nil,
+            Params: nil})
+
+    beego.GlobalControllerRouter["rasp-cloud/controllers/api:NotificationController"] = append(beego.GlobalControllerRouter["rasp-cloud/controllers/api:NotificationController"],
+        beego.ControllerComments{
+            Method: "UpdateTemplate",
+            Router: `/template`,
+            AllowHTTPMethods: []string{"post"},
+            MethodParams: param.Make(),
+            Filters: nil,
+            Params: nil})
+
+    beego.GlobalControllerRouter["rasp-cloud/controllers/api:NotificationController"] = append(beego.GlobalControllerRouter["rasp-cloud/controllers/api:NotificationController"],
+        beego.ControllerComments{
+            Method: "ResetTemplate",
+            Router: `/template/delete`,
+            AllowHTTPMethods: []string{"post"},
+            MethodParams: param.Make(),
+            Filters: nil,
+            Params: nil})
+
+    beego.GlobalControllerRouter["rasp-cloud/controllers/api:NotificationController"] = append(beego.GlobalControllerRouter["rasp-cloud/controllers/api:NotificationController"],
+        beego.ControllerComments{
+            Method: "PreviewTemplate",
+            Router: `/template/preview`,
+            AllowHTTPMethods: []string{"post"},
+            MethodParams: param.Make(),
+            Filters: nil,
+            Params: nil})
+
     beego.GlobalControllerRouter["rasp-cloud/controllers/api:OperationController"] = append(beego.GlobalControllerRouter["rasp-cloud/controllers/api:OperationController"],
         beego.ControllerComments{
             Method: "Search",
@@ -254,6 +488,33 @@ func init() {
             Filters: nil,
             Params: nil})
 
+    beego.GlobalControllerRouter["rasp-cloud/controllers/api:SilenceController"] = append(beego.GlobalControllerRouter["rasp-cloud/controllers/api:SilenceController"],
+        beego.ControllerComments{
+            Method: "Get",
+            Router: `/get`,
+            AllowHTTPMethods: []string{"post"},
+            MethodParams: param.Make(),
+            Filters: nil,
+            Params: nil})
+
+    beego.GlobalControllerRouter["rasp-cloud/controllers/api:SilenceController"] = append(beego.GlobalControllerRouter["rasp-cloud/controllers/api:SilenceController"],
+        beego.ControllerComments{
+            Method: "Post",
+            Router: `/`,
+            AllowHTTPMethods: []string{"post"},
+            MethodParams: param.Make(),
+            Filters: nil,
+            Params: nil})
+
+    beego.GlobalControllerRouter["rasp-cloud/controllers/api:SilenceController"] = append(beego.GlobalControllerRouter["rasp-cloud/controllers/api:SilenceController"],
+        beego.ControllerComments{
+            Method: "Delete",
+            Router: `/delete`,
+            AllowHTTPMethods: []string{"post"},
+            MethodParams: param.Make(),
+            Filters: nil,
+            Params: nil})
+
     beego.GlobalControllerRouter["rasp-cloud/controllers/api:TokenController"] = append(beego.GlobalControllerRouter["rasp-cloud/controllers/api:TokenController"],
         beego.ControllerComments{
             Method: "Post",
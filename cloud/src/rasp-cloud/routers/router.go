@@ -72,6 +72,16 @@ func InitRouter() {
 					&fore_logs.PolicyAlarmController{},
 				),
 			),
+			beego.NSNamespace("/stats",
+				beego.NSInclude(
+					&fore_logs.AlarmStatsController{},
+				),
+			),
+			beego.NSNamespace("/geoip",
+				beego.NSInclude(
+					&fore_logs.GeoipController{},
+				),
+			),
 		),
 		beego.NSNamespace("/app",
 			beego.NSInclude(
@@ -93,6 +103,31 @@ func InitRouter() {
 				&api.ReportController{},
 			),
 		),
+		beego.NSNamespace("/alert",
+			beego.NSInclude(
+				&api.AlertController{},
+			),
+		),
+		beego.NSNamespace("/silence",
+			beego.NSInclude(
+				&api.SilenceController{},
+			),
+		),
+		beego.NSNamespace("/notification",
+			beego.NSInclude(
+				&api.NotificationController{},
+			),
+		),
+		beego.NSNamespace("/digest",
+			beego.NSInclude(
+				&api.DigestController{},
+			),
+		),
+		beego.NSNamespace("/configversion",
+			beego.NSInclude(
+				&api.ConfigVersionController{},
+			),
+		),
 		beego.NSNamespace("/operation",
 			beego.NSInclude(
 				&api.OperationController{},
@@ -0,0 +1,39 @@
+<h3>OpenRASP 安全报告 ({{.Digest.Period}}): {{.App.Name}}</h3>
+<p>{{.StartTime}} ~ {{.EndTime}}</p>
+<table border="1" cellspacing="0" cellpadding="5">
+    <tbody>
+        <tr><td>攻击报警</td><td>{{.Digest.Summary.AttackTotal}}</td></tr>
+        <tr><td>基线报警</td><td>{{.Digest.Summary.PolicyTotal}}</td></tr>
+        <tr><td>请求数</td><td>{{.Digest.Summary.RequestSum}}</td></tr>
+        <tr><td>在线主机</td><td>{{.Digest.Summary.RaspOnline}}</td></tr>
+        <tr><td>离线主机</td><td>{{.Digest.Summary.RaspOffline}}</td></tr>
+    </tbody>
+</table>
+{{define "items"}}
+    <table border="1" cellspacing="0" cellpadding="5">
+        <tbody>
+            {{range .}}
+                <tr><td>{{.Key}}</td><td>{{.Count}}</td></tr>
+            {{else}}
+                <tr><td>-</td><td>0</td></tr>
+            {{end}}
+        </tbody>
+    </table>
+{{end}}
+<h4>攻击类型分布</h4>
+{{template "items" .Digest.Summary.AttackByType}}
+<h4>拦截状态分布</h4>
+{{template "items" .Digest.Summary.AttackByInterceptState}}
+<h4>攻击来源 Top 10</h4>
+{{template "items" .Digest.Summary.TopAttackSources}}
+<h4>攻击地域 Top 10</h4>
+{{template "items" .Digest.Summary.TopCountries}}
+<h4>被攻击 URL Top 10</h4>
+{{template "items" .Digest.Summary.TopUrls}}
+<h4>新增基线报警</h4>
+{{template "items" .Digest.Summary.NewPolicyById}}
+<h4>基线报警分布</h4>
+{{template "items" .Digest.Summary.PolicyById}}
+<br>
+
+要查看 <b>{{.App.Name}}</b> 的报警，请访问 <a href="{{.Links.Events}}">{{.Links.Events}}</a>
@@ -0,0 +1,39 @@
+<h3>OpenRASP {{.Digest.Period}} report of "{{.App.Name}}"</h3>
+<p>{{.StartTime}} ~ {{.EndTime}}</p>
+<table border="1" cellspacing="0" cellpadding="5">
+    <tbody>
+        <tr><td>Attack alarms</td><td>{{.Digest.Summary.AttackTotal}}</td></tr>
+        <tr><td>Policy alarms</td><td>{{.Digest.Summary.PolicyTotal}}</td></tr>
+        <tr><td>Requests</td><td>{{.Digest.Summary.RequestSum}}</td></tr>
+        <tr><td>Online agents</td><td>{{.Digest.Summary.RaspOnline}}</td></tr>
+        <tr><td>Offline agents</td><td>{{.Digest.Summary.RaspOffline}}</td></tr>
+    </tbody>
+</table>
+{{define "items"}}
+    <table border="1" cellspacing="0" cellpadding="5">
+        <tbody>
+            {{range .}}
+                <tr><td>{{.Key}}</td><td>{{.Count}}</td></tr>
+            {{else}}
+                <tr><td>-</td><td>0</td></tr>
+            {{end}}
+        </tbody>
+    </table>
+{{end}}
+<h4>Attacks by type</h4>
+{{template "items" .Digest.Summary.AttackByType}}
+<h4>Attacks by action</h4>
+{{template "items" .Digest.Summary.AttackByInterceptState}}
+<h4>Top attack sources</h4>
+{{template "items" .Digest.Summary.TopAttackSources}}
+<h4>Top countries</h4>
+{{template "items" .Digest.Summary.TopCountries}}
+<h4>Top URLs</h4>
+{{template "items" .Digest.Summary.TopUrls}}
+<h4>New policy alarms</h4>
+{{template "items" .Digest.Summary.NewPolicyById}}
+<h4>Policy alarms by id</h4>
+{{template "items" .Digest.Summary.PolicyById}}
+<br>
+
+To view the alarms of "<b>{{.App.Name}}</b>", please visit <a href="{{.Links.Events}}">{{.Links.Events}}</a>
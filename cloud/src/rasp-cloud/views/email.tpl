@@ -17,11 +17,11 @@
                 <td>{{.attack_source}}</td>
                 <td>{{.target}}</td>
                 <td>{{.intercept_state}}</td>
-                <td><a href="{{$.DetailedLink}}/{{.id}}">detail</a></td>
+                <td><a href="{{$.Links.Events}}/{{.id}}">detail</a></td>
             </tr>
         {{end}}
     </tbody>
 </table>
 <br>
 
-若要查看更多 "<b>{{.AppName}}</b>" 的报警，请点击这里 <a href="{{.DetailedLink}}">{{.DetailedLink}}</a>
+若要查看更多 "<b>{{.App.Name}}</b>" 的报警，请点击这里 <a href="{{.Links.Events}}">{{.Links.Events}}</a>
@@ -0,0 +1,27 @@
+<table border="1" cellspacing="0" cellpadding="5">
+    <thead>
+        <tr>
+            <th>Time</th>
+            <th>Type</th>
+            <th>Source</th>
+            <th>Target</th>
+            <th>Action</th>
+            <th>Detail</th>
+        </tr>
+    </thead>
+    <tbody>
+        {{range .Alarms}}
+            <tr>
+                <td>{{.event_time}}</td>
+                <td>{{.attack_type}}</td>
+                <td>{{.attack_source}}</td>
+                <td>{{.target}}</td>
+                <td>{{.intercept_state}}</td>
+                <td><a href="{{$.Links.Events}}/{{.id}}">detail</a></td>
+            </tr>
+        {{end}}
+    </tbody>
+</table>
+<br>
+
+To view more alarms of "<b>{{.App.Name}}</b>", please visit <a href="{{.Links.Events}}">{{.Links.Events}}</a>